@@ -2,6 +2,7 @@ package azbfs
 
 import (
 	"context"
+	"encoding/base64"
 	"net/url"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -57,13 +58,32 @@ func (f FileURL) Create(ctx context.Context) (*CreatePathResponse, error) {
 		nil)
 }
 
+// FileAccessConditions identifies the ETag conditions which the file must satisfy for a request to succeed.
+// An empty condition is not sent; a request whose condition isn't satisfied fails with 412 (Precondition Failed).
+type FileAccessConditions struct {
+	IfMatch     string
+	IfNoneMatch string
+}
+
+// pointers is for internal infrastructure. It returns the fields as pointers.
+func (ac FileAccessConditions) pointers() (ifMatch *string, ifNoneMatch *string) {
+	if ac.IfMatch != "" {
+		ifMatch = &ac.IfMatch
+	}
+	if ac.IfNoneMatch != "" {
+		ifNoneMatch = &ac.IfNoneMatch
+	}
+	return
+}
+
 // Download downloads count bytes of data from the start offset. If count is CountToEnd (0), then data is read from specified offset to the end.
-// The response includes all of the file’s properties. However, passing true for rangeGetContentMD5 returns the range’s MD5 in the ContentMD5
-// response header/property if the range is <= 4MB; the HTTP request fails with 400 (Bad Request) if the requested range is greater than 4MB.
+// The response includes all of the file’s properties. Pass the file's ETag as IfMatch in ac to make sure that the data
+// downloaded belongs to that version of the file.
 // For more information, see https://docs.microsoft.com/rest/api/storageservices/get-file.
-func (f FileURL) Download(ctx context.Context, offset int64, count int64) (*DownloadResponse, error) {
+func (f FileURL) Download(ctx context.Context, offset int64, count int64, ac FileAccessConditions) (*DownloadResponse, error) {
+	ifMatch, ifNoneMatch := ac.pointers()
 	dr, err := f.fileClient.ReadPath(ctx, f.fileSystemName, f.path, (&httpRange{offset: offset, count: count}).pointers(),
-		nil, nil, nil, nil, nil, nil, nil)
+		ifMatch, ifNoneMatch, nil, nil, nil, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +92,7 @@ func (f FileURL) Download(ctx context.Context, offset int64, count int64) (*Down
 		f:    f,
 		dr:   dr,
 		ctx:  ctx,
-		info: HTTPGetterInfo{Offset: offset, Count: count, ETag: dr.ETag()}, // TODO: Note conditional header is not currently supported in Azure File.
+		info: HTTPGetterInfo{Offset: offset, Count: count, ETag: dr.ETag()},
 	}, err
 }
 
@@ -89,7 +109,8 @@ func (dr *DownloadResponse) Body(o RetryReaderOptions) io.ReadCloser {
 		dr.info,
 		o,
 		func(ctx context.Context, info HTTPGetterInfo) (*http.Response, error) {
-			resp, err := dr.f.Download(ctx, info.Offset, info.Count)
+			// the retries must read the same version of the file as the initial response
+			resp, err := dr.f.Download(ctx, info.Offset, info.Count, FileAccessConditions{IfMatch: info.ETag})
 			if resp == nil {
 				return nil, err
 			}
@@ -97,6 +118,16 @@ func (dr *DownloadResponse) Body(o RetryReaderOptions) io.ReadCloser {
 		})
 }
 
+// FileContentMD5 returns the value for header x-ms-content-md5, the MD5 hash of the whole file decoded from base64.
+// It is nil if the file has no MD5 hash.
+func (dr DownloadResponse) FileContentMD5() []byte {
+	b, err := base64.StdEncoding.DecodeString(dr.Response().Header.Get("x-ms-content-md5"))
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
 // Delete immediately removes the file from the storage account.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/delete-file2.
 func (f FileURL) Delete(ctx context.Context) (*DeletePathResponse, error) {
@@ -152,7 +152,7 @@ func (s *FileURLSuite) TestFileGetProperties(c *chk.C) {
 //
 //	// Get with rangeGetContentMD5 enabled.
 //	// Partial data, check status code 206.
-//	resp, err := fileURL.Download(context.Background(), 0, 1024)
+//	resp, err := fileURL.Download(context.Background(), 0, 1024, azbfs.FileAccessConditions{})
 //	c.Assert(err, chk.IsNil)
 //	c.Assert(resp.StatusCode(), chk.Equals, http.StatusPartialContent)
 //	c.Assert(resp.ContentLength(), chk.Equals, "1024")
@@ -203,7 +203,7 @@ func (s *FileURLSuite) TestUploadDownloadRoundTrip(c *chk.C) {
 	c.Assert(fResp.Date(), chk.Not(chk.Equals), "")
 
 	// Get Partial data, check status code 206.
-	resp, err := fileURL.Download(context.Background(), 0, 1024)
+	resp, err := fileURL.Download(context.Background(), 0, 1024, azbfs.FileAccessConditions{})
 	c.Assert(err, chk.IsNil)
 	c.Assert(resp.StatusCode(), chk.Equals, http.StatusPartialContent)
 	c.Assert(resp.ContentLength(), chk.Equals, "1024")
@@ -216,7 +216,7 @@ func (s *FileURLSuite) TestUploadDownloadRoundTrip(c *chk.C) {
 	c.Assert(download, chk.DeepEquals, contentD1[:1024])
 
 	// Get entire fileURL, check status code 200.
-	resp, err = fileURL.Download(context.Background(), 0, 0)
+	resp, err = fileURL.Download(context.Background(), 0, 0, azbfs.FileAccessConditions{})
 	c.Assert(err, chk.IsNil)
 	c.Assert(resp.StatusCode(), chk.Equals, http.StatusOK)
 	c.Assert(resp.ContentLength(), chk.Equals, "4096")
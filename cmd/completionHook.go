@@ -0,0 +1,66 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+// the help of the flags which ask for a completion hook, shared by the commands which start a job
+const (
+	onCompleteExecFlagHelp = "command to run once the job finishes, it is given the job summary as JSON on its standard input. " +
+		"It is run by the shell of the platform, given 30 seconds and retried up to 3 times; the job is reported finished once it is done, or after 2 minutes"
+	onCompleteURLFlagHelp = "http(s) URL of a local webhook to POST the job summary to as JSON once the job finishes, its host must be localhost or a loopback address. " +
+		"Each POST is given 30 seconds and retried up to 3 times; the job is reported finished once it is done, or after 2 minutes"
+)
+
+// cookCompletionHook validates the completion hook asked for by the --on-complete-exec and --on-complete-url flags
+func cookCompletionHook(exec string, rawURL string) (common.JobCompletionHook, error) {
+	if rawURL != "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return common.JobCompletionHook{}, fmt.Errorf("cannot parse the on-complete-url %q: %v", rawURL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return common.JobCompletionHook{}, fmt.Errorf("the on-complete-url %q must be an absolute http or https URL", rawURL)
+		}
+		// the job summary lists the source and destination of the job, it isn't sent off the machine
+		if !isLoopbackHost(u.Hostname()) {
+			return common.JobCompletionHook{}, fmt.Errorf("the on-complete-url %q must point at localhost or a loopback address", rawURL)
+		}
+	}
+	return common.JobCompletionHook{Exec: exec, URL: rawURL}, nil
+}
+
+// isLoopbackHost tells whether the host of a URL is localhost or a loopback IP address; it isn't resolved,
+// since what it resolves to when the job finishes may be different
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
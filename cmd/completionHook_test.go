@@ -0,0 +1,42 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	chk "gopkg.in/check.v1"
+)
+
+type completionHookTestSuite struct{}
+
+var _ = chk.Suite(&completionHookTestSuite{})
+
+func (s *completionHookTestSuite) TestCookCompletionHookURL(c *chk.C) {
+	for _, rawURL := range []string{"", "http://localhost:8080/done", "https://LOCALHOST/done", "http://127.0.0.1:8080", "http://[::1]:8080/done"} {
+		hook, err := cookCompletionHook("", rawURL)
+		c.Assert(err, chk.IsNil)
+		c.Assert(hook.URL, chk.Equals, rawURL)
+	}
+
+	for _, rawURL := range []string{"localhost:8080", "ftp://localhost/done", "http://example.com/done", "http://10.0.0.1/done", "http://localhost.example.com/done"} {
+		_, err := cookCompletionHook("", rawURL)
+		c.Assert(err, chk.NotNil, chk.Commentf("%s", rawURL))
+	}
+}
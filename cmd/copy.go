@@ -24,6 +24,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -90,6 +91,12 @@ type rawCopyCmdArgs struct {
 	acl                      string
 	logVerbosity             string
 	stdInEnable              bool
+	capMbps                  uint32
+	priority                 string
+	checkMd5                 string
+	blobType                 string
+	onCompleteExec           string
+	onCompleteURL            string
 	// oauth options
 	useInteractiveOAuthUserCredential bool
 	tenantID                          string
@@ -170,6 +177,48 @@ func (raw rawCopyCmdArgs) cook() (cookedCopyCmdArgs, error) {
 	cooked.output.Parse(raw.output)
 	cooked.acl = raw.acl
 
+	// the bandwidth cap falls back to the environment variable if the flag is not given
+	cooked.capMbps = raw.capMbps
+	if cooked.capMbps == 0 {
+		cooked.capMbps, err = common.GetCapMbpsFromEnv()
+		if err != nil {
+			return cooked, err
+		}
+	}
+
+	err = cooked.priority.Parse(raw.priority)
+	if err != nil {
+		return cooked, err
+	}
+
+	cooked.completionHook, err = cookCompletionHook(raw.onCompleteExec, raw.onCompleteURL)
+	if err != nil {
+		return cooked, err
+	}
+
+	cooked.planTTL, err = common.GetJobPlanTTLFromEnv()
+	if err != nil {
+		return cooked, err
+	}
+
+	err = cooked.md5ValidationOption.Parse(raw.checkMd5)
+	if err != nil {
+		return cooked, err
+	}
+
+	err = cooked.blobType.Parse(raw.blobType)
+	if err != nil {
+		return cooked, err
+	}
+	// the blob type is only chosen when uploading files, a copy from blobs keeps the type of the source blobs
+	if cooked.blobType != common.EBlobType.Detect() && cooked.fromTo != common.EFromTo.LocalBlob() {
+		return cooked, fmt.Errorf("the blob-type %s can only be chosen when uploading from the local file system to Blob storage", cooked.blobType)
+	}
+	// page blobs are written in pages of 512 bytes, which each block must consist of
+	if cooked.blobType == common.EBlobType.PageBlob() && cooked.blockSize%azblob.PageBlobPageBytes != 0 {
+		return cooked, fmt.Errorf("the block-size %d is not a multiple of %d bytes, the page size of page blobs", cooked.blockSize, azblob.PageBlobPageBytes)
+	}
+
 	// cook oauth parameters
 	cooked.useInteractiveOAuthUserCredential = raw.useInteractiveOAuthUserCredential
 	cooked.tenantID = raw.tenantID
@@ -209,6 +258,18 @@ type cookedCopyCmdArgs struct {
 	output                   common.OutputFormat
 	acl                      string
 	logVerbosity             common.LogLevel
+	// capMbps caps the bandwidth in megabits per second, 0 means no cap
+	capMbps uint32
+	// priority determines the job's share of the engine's workers when other jobs are running at the same time
+	priority common.JobPriority
+	// completionHook notifies the caller once the job finishes
+	completionHook common.JobCompletionHook
+	// planTTL is how long the plan files of the job are kept once it finished, in seconds; 0 keeps them
+	planTTL uint32
+	// md5ValidationOption determines how strictly the downloaded data is validated against the content MD5 of its source
+	md5ValidationOption common.HashValidationOption
+	// blobType is the type of the blobs created by an upload, Detect uploads fixed size VHD files as page blobs and other files as block blobs
+	blobType common.BlobType
 	// oauth options
 	useInteractiveOAuthUserCredential bool
 	tenantID                          string
@@ -298,7 +359,8 @@ func (cca *cookedCopyCmdArgs) processRedirectionDownload(blobUrl string) error {
 		// todo:???
 	}
 
-	blobBody := blobStream.Body(azblob.RetryReaderOptions{MaxRetryRequests: downloadMaxTries})
+	// the body is read through the pacer, so that it cannot be downloaded faster than the bandwidth cap
+	blobBody := ste.NewPacedReader(blobStream.Body(azblob.RetryReaderOptions{MaxRetryRequests: downloadMaxTries}), cca.capMbps)
 	defer blobBody.Close()
 
 	// step 4: pipe everything into Stdout
@@ -311,6 +373,10 @@ func (cca *cookedCopyCmdArgs) processRedirectionDownload(blobUrl string) error {
 }
 
 func (cca *cookedCopyCmdArgs) processRedirectionUpload(blobUrl string, blockSize uint32) error {
+	// the size of the data piped in isn't known up front, so no block size can be planned from it
+	if blockSize == 0 {
+		blockSize = common.DefaultBlockSize
+	}
 	type uploadTask struct {
 		buffer        []byte
 		blockSize     int
@@ -338,7 +404,9 @@ func (cca *cookedCopyCmdArgs) processRedirectionUpload(blobUrl string, blockSize
 	}
 
 	// step 2: set up source (stdin) and destination (block blob)
-	stdInReader := bufio.NewReader(os.Stdin)
+	// stdin is read through the pacer, so that the blocks cannot be uploaded faster than the bandwidth cap
+	stdInReader := ste.NewPacedReader(bufio.NewReader(os.Stdin), cca.capMbps)
+	defer stdInReader.Close()
 	blockBlobUrl := azblob.NewBlockBlobURL(*u, p)
 
 	// step 3: set up channels which are used to sync up go routines for parallel upload
@@ -350,6 +418,7 @@ func (cca *cookedCopyCmdArgs) processRedirectionUpload(blobUrl string, blockSize
 	total := uint32(0)                                              // used to keep track of total number of chunks, it is incremented as more data is read in from stdin
 	blockIdList := []string{}
 	isReadComplete := false
+	contentMD5 := md5.New() // stdin is read in order, so the MD5 hash of the blob is computed as it is read
 
 	// step 4: prep empty buffers and dispatch upload workers
 	for i := 0; i < numOfSimultaneousUploads; i++ {
@@ -393,7 +462,7 @@ func (cca *cookedCopyCmdArgs) processRedirectionUpload(blobUrl string, blockSize
 			if isReadComplete {
 				if atomic.LoadUint32(&finishedChunkCount) == total {
 					close(fullChannel)
-					_, err := blockBlobUrl.CommitBlockList(uploadContext, blockIdList, azblob.BlobHTTPHeaders{}, azblob.Metadata{}, azblob.BlobAccessConditions{})
+					_, err := blockBlobUrl.CommitBlockList(uploadContext, blockIdList, azblob.BlobHTTPHeaders{ContentMD5: contentMD5.Sum(nil)}, azblob.Metadata{}, azblob.BlobAccessConditions{})
 
 					if err != nil {
 						return err
@@ -414,6 +483,7 @@ func (cca *cookedCopyCmdArgs) processRedirectionUpload(blobUrl string, blockSize
 
 					if err == nil || err == io.ErrUnexpectedEOF { // read in data successfully
 						// prep buffer for workers
+						contentMD5.Write(empty.buffer[:n])
 						empty.blockSize = n
 						empty.blockIdBase64 = copyHandlerUtil{}.blockIDIntToBase64(int(total))
 						// keep track of the block IDs in sequence
@@ -497,14 +567,18 @@ func (cca cookedCopyCmdArgs) getCredentialType() (credentialType common.Credenti
 func (cca *cookedCopyCmdArgs) processCopyJobPartOrders() (err error) {
 	// initialize the fields that are constant across all job part orders
 	jobPartOrder := common.CopyJobPartOrderRequest{
-		JobID:      cca.jobID,
-		FromTo:     cca.fromTo,
-		ForceWrite: cca.forceWrite,
-		Priority:   common.EJobPriority.Normal(),
-		LogLevel:   cca.logVerbosity,
-		Include:    cca.include,
-		Exclude:    cca.exclude,
+		JobID:              cca.jobID,
+		FromTo:             cca.fromTo,
+		ForceWrite:         cca.forceWrite,
+		Priority:           cca.priority,
+		LogLevel:           cca.logVerbosity,
+		Include:            cca.include,
+		Exclude:            cca.exclude,
+		CapMbps:            cca.capMbps,
+		CompletionHook:     cca.completionHook,
+		TTLAfterCompletion: cca.planTTL,
 		BlobAttributes: common.BlobTransferAttributes{
+			BlobType:                 cca.blobType,
 			BlockSizeInBytes:         cca.blockSize,
 			ContentType:              cca.contentType,
 			ContentEncoding:          cca.contentEncoding,
@@ -513,6 +587,7 @@ func (cca *cookedCopyCmdArgs) processCopyJobPartOrders() (err error) {
 			Metadata:                 cca.metadata,
 			NoGuessMimeType:          cca.noGuessMimeType,
 			PreserveLastModifiedTime: cca.preserveLastModifiedTime,
+			MD5ValidationOption:      cca.md5ValidationOption,
 		},
 		// source sas is stripped from the source given by the user and it will not be stored in the part plan file.
 		SourceSAS: cca.sourceSAS,
@@ -741,13 +816,16 @@ func (cca *cookedCopyCmdArgs) PrintJobProgressStatus() {
 		duration := time.Now().Sub(cca.jobStartTime) // report the total run time of the job
 
 		glcm.ExitWithSuccess(fmt.Sprintf(
-			"\n\nJob %s summary\nElapsed Time (Minutes): %v\nTotal Number Of Transfers: %v\nNumber of Transfers Completed: %v\nNumber of Transfers Failed: %v\nFinal Job Status: %v\n",
+			"\n\nJob %s summary\nElapsed Time (Minutes): %v\nTotal Number Of Transfers: %v\nNumber of Transfers Completed: %v\nNumber of Transfers Failed: %v\n%sFinal Job Status: %v\n%s%s",
 			summary.JobID.String(),
 			ste.ToFixed(duration.Minutes(), 4),
 			summary.TotalTransfers,
 			summary.TransfersCompleted,
 			summary.TransfersFailed,
-			summary.JobStatus), common.EExitCode.Success())
+			gCopyUtil.failureSummary(summary),
+			summary.JobStatus,
+			gCopyUtil.bytesTransferredSummary(summary),
+			gCopyUtil.throttlingSummary(summary)), common.EExitCode.Success())
 	}
 
 	// if json is not needed, and job is not done, then we generate a message that goes nicely on the same line
@@ -793,7 +871,6 @@ func isStdinPipeIn() (bool, error) {
 	return info.Mode()&os.ModeNamedPipe != 0, nil
 }
 
-// TODO check file size, max is 4.75TB
 func init() {
 	raw := rawCopyCmdArgs{}
 
@@ -871,11 +948,19 @@ Download an entire directory:
 
 	// define the flags relevant to the cp command
 	// Visible flags
-	cpCmd.PersistentFlags().Uint32Var(&raw.blockSize, "block-size", 8*1024*1024, "use this block(chunk) size when uploading/downloading to/from Azure Storage")
+	cpCmd.PersistentFlags().Uint32Var(&raw.blockSize, "block-size", 0, "use this block(chunk) size when uploading/downloading to/from Azure Storage. "+
+		"0 picks a block size for each file from its size, which is 8 MB unless the file would need more blocks than a blob can be made of")
 	cpCmd.PersistentFlags().BoolVar(&raw.forceWrite, "overwrite", true, "overwrite the conflicting files/blobs at the destination if this flag is set to true")
 	cpCmd.PersistentFlags().StringVar(&raw.logVerbosity, "log-level", "INFO", "define the log verbosity for the log file, available levels: DEBUG, INFO, WARNING, ERROR, PANIC, and FATAL")
 	cpCmd.PersistentFlags().BoolVar(&raw.recursive, "recursive", false, "look into sub-directories recursively when uploading from local file system")
 	cpCmd.PersistentFlags().StringVar(&raw.output, "output", "text", "format of the command's output, the choices include: text, json")
+	cpCmd.PersistentFlags().Uint32Var(&raw.capMbps, "cap-mbps", 0, "caps the transfer rate, in megabits per second. 0 means no cap, unless the environment variable "+common.EnvVarCapMbps+" is set. "+
+		"The cap is shared by all the jobs running in the transfer engine, and goes back to the default one once this job completes, is cancelled or paused")
+	cpCmd.PersistentFlags().StringVar(&raw.onCompleteExec, "on-complete-exec", "", onCompleteExecFlagHelp)
+	cpCmd.PersistentFlags().StringVar(&raw.onCompleteURL, "on-complete-url", "", onCompleteURLFlagHelp)
+	cpCmd.PersistentFlags().StringVar(&raw.priority, "priority", "Normal", "the job's priority, which determines its share of the transfer engine when other jobs run at the same time, available priorities: Normal, Low")
+	cpCmd.PersistentFlags().StringVar(&raw.blobType, "blob-type", "Detect", "the type of the blobs created when uploading to Blob storage, available types: BlockBlob, PageBlob, AppendBlob, Detect. Detect uploads fixed size VHD files as page blobs and other files as block blobs")
+	cpCmd.PersistentFlags().StringVar(&raw.checkMd5, "check-md5", "NoCheck", "how strictly to validate the MD5 hash of downloaded data against the content MD5 of the source, available options: NoCheck, LogOnly, FailIfDifferent, FailIfDifferentOrMissing")
 
 	// hidden filters
 	cpCmd.PersistentFlags().StringVar(&raw.include, "include", "", "Filter: only include these files when copying. "+
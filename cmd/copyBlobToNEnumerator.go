@@ -266,9 +266,9 @@ func (e *copyBlobToNEnumerator) enumerateBlobsInContainer(ctx context.Context, s
 
 func (e *copyBlobToNEnumerator) addTransferInternal(srcURL, destURL url.URL, properties *azblob.BlobProperties, metadata azblob.Metadata,
 	cca *cookedCopyCmdArgs) error {
-	if properties.BlobType != azblob.BlobBlockBlob {
+	if properties.BlobType != azblob.BlobBlockBlob && properties.BlobType != azblob.BlobAppendBlob {
 		return fmt.Errorf(
-			"invalid blob type %q found in Service to Service copy, only block blob is supported now when source is Blob",
+			"invalid blob type %q found in Service to Service copy, only block blobs and append blobs are supported now when source is Blob",
 			properties.BlobType)
 	}
 
@@ -289,6 +289,7 @@ func (e *copyBlobToNEnumerator) addTransferInternal(srcURL, destURL url.URL, pro
 		ContentLanguage:    *properties.ContentLanguage,
 		CacheControl:       *properties.CacheControl,
 		ContentMD5:         md5DecodedBytes,
+		BlobType:           common.EBlobType.FromAzBlobType(properties.BlobType),
 		Metadata:           common.FromAzBlobMetadataToCommonMetadata(metadata)},
 		//BlobTier:           string(properties.AccessTier)}, // TODO: blob tier setting correctly
 		cca)
@@ -296,9 +297,9 @@ func (e *copyBlobToNEnumerator) addTransferInternal(srcURL, destURL url.URL, pro
 
 func (e *copyBlobToNEnumerator) addTransferInternal2(srcURL, destURL url.URL, properties *azblob.BlobGetPropertiesResponse,
 	cca *cookedCopyCmdArgs) error {
-	if properties.BlobType() != azblob.BlobBlockBlob {
+	if properties.BlobType() != azblob.BlobBlockBlob && properties.BlobType() != azblob.BlobAppendBlob {
 		return fmt.Errorf(
-			"invalid blob type %q found in Service to Service copy, only block blob is supported now when source is Blob",
+			"invalid blob type %q found in Service to Service copy, only block blobs and append blobs are supported now when source is Blob",
 			properties.BlobType())
 	}
 
@@ -313,12 +314,21 @@ func (e *copyBlobToNEnumerator) addTransferInternal2(srcURL, destURL url.URL, pr
 		ContentLanguage:    properties.ContentLanguage(),
 		CacheControl:       properties.CacheControl(),
 		ContentMD5:         properties.ContentMD5(),
+		BlobType:           common.EBlobType.FromAzBlobType(properties.BlobType()),
 		Metadata:           common.FromAzBlobMetadataToCommonMetadata(properties.NewMetadata())},
 		//BlobTier:           properties.AccessTier()}, // TODO: blob tier setting correctly
 		cca)
 }
 
 func (e *copyBlobToNEnumerator) addTransfer(transfer common.CopyTransfer, cca *cookedCopyCmdArgs) error {
+	// the destination blob keeps the type of the source blob, its block size is planned from the size of the source
+	if e.FromTo == common.EFromTo.BlobBlob() {
+		blockSize, err := common.PlanBlockSize(transfer.BlobType, transfer.SourceSize, e.BlobAttributes.BlockSizeInBytes)
+		if err != nil {
+			return fmt.Errorf("cannot copy %s: %v", transfer.Source, err)
+		}
+		transfer.BlockSize = blockSize
+	}
 	return addTransfer((*common.CopyJobPartOrderRequest)(e), transfer, cca)
 }
 
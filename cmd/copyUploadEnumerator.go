@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
 )
 
 type copyUploadEnumerator common.CopyJobPartOrderRequest
@@ -190,6 +191,31 @@ func (e *copyUploadEnumerator) enumerate(cca *cookedCopyCmdArgs) error {
 }
 
 func (e *copyUploadEnumerator) addTransfer(transfer common.CopyTransfer, cca *cookedCopyCmdArgs) error {
+	// page blobs are written in pages of 512 bytes, so a file can only become a page blob if its size is a multiple of 512
+	if e.BlobAttributes.BlobType == common.EBlobType.PageBlob() && transfer.SourceSize%azblob.PageBlobPageBytes != 0 {
+		return fmt.Errorf("cannot upload %s as a page blob, its size %d is not a multiple of %d bytes",
+			transfer.Source, transfer.SourceSize, azblob.PageBlobPageBytes)
+	}
+	// the block size of each blob is planned from the size of its file, so that it isn't made of too many blocks
+	if e.FromTo == common.EFromTo.LocalBlob() {
+		blobType := e.BlobAttributes.BlobType
+		// a fixed size VHD detected to be a page blob isn't limited to the size of a block blob, so it's planned as a page blob;
+		// only the footer of the files too large for a block blob is read, the others are planned alike either way
+		if blobType == common.EBlobType.Detect() && transfer.SourceSize > common.MaxBlockBlobBlockSize*common.MaxNumberOfBlocksPerBlob {
+			isVHD, err := common.IsFixedVHDFile(transfer.Source, transfer.SourceSize)
+			if err != nil {
+				return fmt.Errorf("cannot upload %s: %v", transfer.Source, err)
+			}
+			if isVHD {
+				blobType = common.EBlobType.PageBlob()
+			}
+		}
+		blockSize, err := common.PlanBlockSize(blobType, transfer.SourceSize, e.BlobAttributes.BlockSizeInBytes)
+		if err != nil {
+			return fmt.Errorf("cannot upload %s: %v", transfer.Source, err)
+		}
+		transfer.BlockSize = blockSize
+	}
 	return addTransfer((*common.CopyJobPartOrderRequest)(e), transfer, cca)
 }
 
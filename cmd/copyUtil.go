@@ -28,6 +28,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -844,3 +845,50 @@ func (parts fileURLPartsExtension) getDirURLAndSearchPrefixFromFileURL(p pipelin
 	dirURL = azfile.NewDirectoryURL(parts.URL(), p)
 	return
 }
+
+// bytesTransferredSummary reports the bytes actually transferred against the logical size of the job, when some of them didn't have
+// to be transferred since the destination reads them back as zeros anyway, e.g. the empty pages of a sparse page blob, whether it's
+// uploaded or downloaded. It is empty otherwise.
+func (copyHandlerUtil) bytesTransferredSummary(summary common.ListJobSummaryResponse) string {
+	if summary.TotalBytesSkipped == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Bytes Transferred: %v of %v (%v zero bytes skipped)\n",
+		summary.TotalBytesTransferred-summary.TotalBytesSkipped,
+		summary.TotalBytesEnumerated,
+		summary.TotalBytesSkipped)
+}
+
+// failureSummary breaks the failed transfers down by their failure status, so that the failures can be triaged
+// without reading the logs. It is empty if no transfer failed.
+func (copyHandlerUtil) failureSummary(summary common.ListJobSummaryResponse) string {
+	statuses := make([]string, 0, len(summary.TransfersFailedByStatus))
+	for status := range summary.TransfersFailedByStatus {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	result := ""
+	for _, status := range statuses {
+		result += fmt.Sprintf("Number of Transfers Failed (%s): %v\n", status, summary.TransfersFailedByStatus[status])
+	}
+	return result
+}
+
+// transferErrorCode describes the HTTP status and the service error code a transfer failed with, if any
+func (copyHandlerUtil) transferErrorCode(transfer common.TransferDetail) string {
+	if transfer.ErrorCode == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" error %d %s", transfer.ErrorCode, transfer.ServiceErrorCode)
+}
+
+// throttlingSummary reports how often the service throttled the transfer engine and how long the engine backed off
+// because of it. It is empty if the service never throttled.
+func (copyHandlerUtil) throttlingSummary(summary common.ListJobSummaryResponse) string {
+	if summary.ThrottleEvents == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Throttle Events: %v\nTime Spent Backing Off (Seconds): %v\n",
+		summary.ThrottleEvents,
+		summary.BackoffTimeInSeconds)
+}
@@ -113,10 +113,10 @@ func getBlobCredentialType(ctx context.Context, blobResourceURL string, isSource
 			azblob.PipelineOptions{
 				Retry: azblob.RetryOptions{
 					Policy:        azblob.RetryPolicyExponential,
-					MaxTries:      ste.UploadMaxTries,
-					TryTimeout:    ste.UploadTryTimeout,
-					RetryDelay:    ste.UploadRetryDelay,
-					MaxRetryDelay: ste.UploadMaxRetryDelay,
+					MaxTries:      ste.GetXferRetryOptions().MaxTries,
+					TryTimeout:    ste.GetXferRetryOptions().TryTimeout,
+					RetryDelay:    ste.GetXferRetryOptions().RetryDelay,
+					MaxRetryDelay: ste.GetXferRetryOptions().MaxRetryDelay,
 				},
 			})
 
@@ -183,13 +183,7 @@ func createBlobPipeline(ctx context.Context, credInfo common.CredentialInfo) (pi
 				Value: common.UserAgent,
 			},
 		},
-		ste.XferRetryOptions{
-			Policy:        0,
-			MaxTries:      ste.UploadMaxTries,
-			TryTimeout:    ste.UploadTryTimeout,
-			RetryDelay:    ste.UploadRetryDelay,
-			MaxRetryDelay: ste.UploadMaxRetryDelay,
-		},
+		ste.GetXferRetryOptions(),
 		nil), nil
 
 	return azblob.NewPipeline(
@@ -197,10 +191,10 @@ func createBlobPipeline(ctx context.Context, credInfo common.CredentialInfo) (pi
 		azblob.PipelineOptions{
 			Retry: azblob.RetryOptions{
 				Policy:        azblob.RetryPolicyExponential,
-				MaxTries:      ste.UploadMaxTries,
-				TryTimeout:    ste.UploadTryTimeout,
-				RetryDelay:    ste.UploadRetryDelay,
-				MaxRetryDelay: ste.UploadMaxRetryDelay,
+				MaxTries:      ste.GetXferRetryOptions().MaxTries,
+				TryTimeout:    ste.GetXferRetryOptions().TryTimeout,
+				RetryDelay:    ste.GetXferRetryOptions().RetryDelay,
+				MaxRetryDelay: ste.GetXferRetryOptions().MaxRetryDelay,
 			},
 		}), nil
 }
@@ -268,10 +262,10 @@ func createBlobFSPipeline(ctx context.Context, credInfo common.CredentialInfo) (
 		azbfs.PipelineOptions{
 			Retry: azbfs.RetryOptions{
 				Policy:        azbfs.RetryPolicyExponential,
-				MaxTries:      ste.UploadMaxTries,
-				TryTimeout:    ste.UploadTryTimeout,
-				RetryDelay:    ste.UploadRetryDelay,
-				MaxRetryDelay: ste.UploadMaxRetryDelay,
+				MaxTries:      ste.GetXferRetryOptions().MaxTries,
+				TryTimeout:    ste.GetXferRetryOptions().TryTimeout,
+				RetryDelay:    ste.GetXferRetryOptions().RetryDelay,
+				MaxRetryDelay: ste.GetXferRetryOptions().MaxRetryDelay,
 			},
 			Telemetry: azbfs.TelemetryOptions{
 				Value: common.UserAgent,
@@ -315,10 +309,10 @@ func createFilePipeline(ctx context.Context, credInfo common.CredentialInfo) (pi
 		azfile.PipelineOptions{
 			Retry: azfile.RetryOptions{
 				Policy:        azfile.RetryPolicyExponential,
-				MaxTries:      ste.UploadMaxTries,
-				TryTimeout:    ste.UploadTryTimeout,
-				RetryDelay:    ste.UploadRetryDelay,
-				MaxRetryDelay: ste.UploadMaxRetryDelay,
+				MaxTries:      ste.GetXferRetryOptions().MaxTries,
+				TryTimeout:    ste.GetXferRetryOptions().TryTimeout,
+				RetryDelay:    ste.GetXferRetryOptions().RetryDelay,
+				MaxRetryDelay: ste.GetXferRetryOptions().MaxRetryDelay,
 			},
 			Telemetry: azfile.TelemetryOptions{
 				Value: common.UserAgent,
@@ -0,0 +1,278 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/spf13/cobra"
+)
+
+// jobsCmd groups the commands which manage the jobs known to azcopy
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Sub-commands related to managing jobs",
+	Long: `
+Sub-commands related to managing the jobs known to azcopy, whose plans are kept in the azcopy folder.`,
+}
+
+func init() {
+	var jobID common.JobID
+
+	// migrateCmd represents the jobs migrate command
+	migrateCmd := &cobra.Command{
+		Use:   "migrate [jobID]",
+		Short: "Migrate the plans of jobs ordered by prior releases of azcopy",
+		Long: `
+Migrate the plans of jobs ordered by prior releases of azcopy, so that this release can resume them.
+If a job ID is given, only the plan of this job is migrated; otherwise the plans of all the jobs are.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("migrate accepts at most the JobID")
+			}
+			if len(args) == 1 {
+				var err error
+				if jobID, err = common.ParseJobID(args[0]); err != nil {
+					return errors.New("invalid jobId given " + args[0])
+				}
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			err := HandleMigrateJobsCommand(jobID)
+			if err == nil {
+				glcm.ExitWithSuccess("", common.EExitCode.Success())
+			} else {
+				glcm.ExitWithError(err.Error(), common.EExitCode.Error())
+			}
+		},
+	}
+
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(migrateCmd)
+
+	var cleanJobID common.JobID
+	var rawOlderThan, rawStatus string
+
+	// cleanCmd represents the jobs clean command
+	cleanCmd := &cobra.Command{
+		Use:   "clean [jobID]",
+		Short: "Delete the plan files and the logs of finished jobs",
+		Long: `
+Delete the plan files and the logs of the jobs which completed or were cancelled, so that they don't accumulate
+in the azcopy folder. Cleaned up jobs can no longer be listed, shown or resumed.
+If a job ID is given, only this job is cleaned up.
+The plan files of the jobs can also be cleaned up automatically, some time after the jobs finished, by setting the
+environment variable ` + common.EnvVarJobPlanTTL + ` to how long they should be kept, e.g. 168h, when ordering the jobs.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("clean accepts at most the JobID")
+			}
+			if len(args) == 1 {
+				var err error
+				if cleanJobID, err = common.ParseJobID(args[0]); err != nil {
+					return errors.New("invalid jobId given " + args[0])
+				}
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			req, err := cookCleanJobsRequest(cleanJobID, rawOlderThan, rawStatus)
+			if err == nil {
+				err = HandleCleanJobsCommand(req)
+			}
+			if err == nil {
+				glcm.ExitWithSuccess("", common.EExitCode.Success())
+			} else {
+				glcm.ExitWithError(err.Error(), common.EExitCode.Error())
+			}
+		},
+	}
+
+	jobsCmd.AddCommand(cleanCmd)
+	cleanCmd.PersistentFlags().StringVar(&rawOlderThan, "older-than", "", "only clean up the jobs which finished at least this long ago, e.g. 72h")
+	cleanCmd.PersistentFlags().StringVar(&rawStatus, "status", "", "only clean up the jobs with this status: Completed, Cancelled or Paused. "+
+		"By default, the jobs which completed or were cancelled are cleaned up")
+
+	var verifyJobID common.JobID
+	var repair bool
+
+	// verifyCmd represents the jobs verify command
+	verifyCmd := &cobra.Command{
+		Use:   "verify [jobID]",
+		Short: "Verify the integrity of the plan files of a job",
+		Long: `
+Verify the integrity of the plan files of a job, e.g. after the machine crashed while the job was ordered or running,
+and report the job parts whose plan is corrupt. A job whose plan is corrupt can't be resumed or shown.
+With the repair flag, the plan of a job part whose transfers are only partly corrupt is rebuilt from the intact ones,
+so that the job can be resumed; the corrupt transfers are left out, and their files must be transferred again by another job.
+The plans of a job which another instance of azcopy is running must not be repaired.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("verify requires the JobID")
+			}
+			var err error
+			if verifyJobID, err = common.ParseJobID(args[0]); err != nil {
+				return errors.New("invalid jobId given " + args[0])
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			err := HandleVerifyJobCommand(common.VerifyJobRequest{JobID: verifyJobID, Repair: repair})
+			if err == nil {
+				glcm.ExitWithSuccess("", common.EExitCode.Success())
+			} else {
+				glcm.ExitWithError(err.Error(), common.EExitCode.Error())
+			}
+		},
+	}
+
+	jobsCmd.AddCommand(verifyCmd)
+	verifyCmd.PersistentFlags().BoolVar(&repair, "repair", false, "rebuild the corrupt plans from their intact transfers, dropping the corrupt transfers listed")
+}
+
+// cookCleanJobsRequest validates the arguments of the jobs clean command.
+// The jobs which are in progress aren't cleaned up, since they may be running in another instance of azcopy.
+func cookCleanJobsRequest(jobID common.JobID, rawOlderThan string, rawStatus string) (common.CleanJobsRequest, error) {
+	req := common.CleanJobsRequest{JobID: jobID}
+	if rawOlderThan != "" {
+		olderThan, err := time.ParseDuration(rawOlderThan)
+		if err != nil || olderThan < 0 {
+			return req, fmt.Errorf("invalid older-than %q, it must be a duration such as 72h", rawOlderThan)
+		}
+		req.OlderThan = olderThan
+	}
+	if rawStatus != "" {
+		var status common.JobStatus
+		if err := status.Parse(rawStatus); err != nil {
+			return req, fmt.Errorf("invalid status %q, it must be Completed, Cancelled or Paused", rawStatus)
+		}
+		switch status {
+		case common.EJobStatus.Completed(), common.EJobStatus.Cancelled(), common.EJobStatus.Paused():
+			req.Statuses = []common.JobStatus{status}
+		default:
+			return req, fmt.Errorf("the jobs which are %s can't be cleaned up, only the ones which are Completed, Cancelled or Paused", strings.ToLower(status.String()))
+		}
+	}
+	return req, nil
+}
+
+// HandleCleanJobsCommand sends the CleanJobs request to transfer engine
+// and prints the jobs it cleaned up
+func HandleCleanJobsCommand(req common.CleanJobsRequest) error {
+	resp := common.CleanJobsResponse{}
+	Rpc(common.ERpcCmd.CleanJobs(), &req, &resp)
+
+	if len(resp.CleanedJobs) == 0 {
+		glcm.Info("No job needs to be cleaned up")
+		return nil
+	}
+	failed := 0
+	for _, j := range resp.CleanedJobs {
+		if j.ErrorMsg != "" {
+			failed++
+			glcm.Info(fmt.Sprintf("Cannot clean up job %s: %s", j.JobID, j.ErrorMsg))
+		} else {
+			glcm.Info(fmt.Sprintf("Cleaned up job %s, which was %s", j.JobID, j.Status))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the %d jobs could not be cleaned up", failed, len(resp.CleanedJobs))
+	}
+	return nil
+}
+
+// HandleVerifyJobCommand sends the VerifyJob request to transfer engine
+// and prints the integrity of the plan of each part of the job
+func HandleVerifyJobCommand(req common.VerifyJobRequest) error {
+	resp := common.VerifyJobResponse{}
+	Rpc(common.ERpcCmd.VerifyJob(), &req, &resp)
+	if resp.ErrorMsg != "" {
+		return fmt.Errorf("request failed with following error message: %s", resp.ErrorMsg)
+	}
+
+	unrepaired := 0
+	for _, p := range resp.Parts {
+		if len(p.CorruptTransfers) > 0 {
+			glcm.Info(fmt.Sprintf("Part %d of job %s: %d of its %d transfers are corrupt:\n%s",
+				p.PartNum, req.JobID, len(p.CorruptTransfers), p.NumTransfers, corruptTransfersList(p)))
+		}
+		switch {
+		case p.ErrorMsg != "":
+			unrepaired++
+			glcm.Info(fmt.Sprintf("Part %d of job %s cannot be verified or rebuilt: %s", p.PartNum, req.JobID, p.ErrorMsg))
+		case p.Repaired:
+			glcm.Info(fmt.Sprintf("Part %d of job %s was rebuilt from its %d intact transfers, the %d corrupt transfers above were dropped and won't be resumed",
+				p.PartNum, req.JobID, int(p.NumTransfers)-len(p.CorruptTransfers), len(p.CorruptTransfers)))
+		case len(p.CorruptTransfers) > 0:
+			unrepaired++
+			glcm.Info(fmt.Sprintf("Part %d of job %s can be rebuilt from its intact transfers with --repair", p.PartNum, req.JobID))
+		default:
+			glcm.Info(fmt.Sprintf("Part %d of job %s is intact, with %d transfers", p.PartNum, req.JobID, p.NumTransfers))
+		}
+	}
+	if unrepaired > 0 {
+		return fmt.Errorf("the plans of %d of the %d parts of job %s are corrupt", unrepaired, len(resp.Parts), req.JobID)
+	}
+	return nil
+}
+
+// corruptTransfersList lists the corrupt transfers of a job part, one per line, with their source when it can still be read
+func corruptTransfersList(p common.JobPartVerification) string {
+	lines := make([]string, len(p.CorruptTransfers))
+	for i, t := range p.CorruptTransfers {
+		source := "source unreadable"
+		if i < len(p.CorruptTransferSources) && p.CorruptTransferSources[i] != "" {
+			source = p.CorruptTransferSources[i]
+		}
+		lines[i] = fmt.Sprintf("  transfer %d: %s", t, source)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HandleMigrateJobsCommand sends the MigrateJobs request to transfer engine
+// and prints the plans it migrated
+func HandleMigrateJobsCommand(jobID common.JobID) error {
+	resp := common.MigrateJobsResponse{}
+	Rpc(common.ERpcCmd.MigrateJobs(), &common.MigrateJobsRequest{JobID: jobID}, &resp)
+
+	if len(resp.Migrations) == 0 {
+		glcm.Info("No job plan needs to be migrated")
+		return nil
+	}
+	failed := 0
+	for _, m := range resp.Migrations {
+		if m.ErrorMsg != "" {
+			failed++
+			glcm.Info(fmt.Sprintf("Cannot migrate part %d of job %s from data schema version %d: %s", m.PartNum, m.JobID, m.FromVersion, m.ErrorMsg))
+		} else {
+			glcm.Info(fmt.Sprintf("Migrated part %d of job %s from data schema version %d to %d", m.PartNum, m.JobID, m.FromVersion, m.ToVersion))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the %d job plans could not be migrated", failed, len(resp.Migrations))
+	}
+	return nil
+}
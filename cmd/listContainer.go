@@ -92,12 +92,7 @@ func HandleListContainerCommand(source string, outputFormat common.OutputFormat)
 			Value: common.UserAgent,
 		},
 	},
-		ste.XferRetryOptions{
-			Policy:        0,
-			MaxTries:      ste.UploadMaxTries,
-			TryTimeout:    ste.UploadTryTimeout,
-			RetryDelay:    ste.UploadRetryDelay,
-			MaxRetryDelay: ste.UploadMaxRetryDelay},
+		ste.GetXferRetryOptions(),
 		nil)
 
 	ctx := context.WithValue(context.Background(), ste.ServiceAPIVersionOverride, ste.DefaultServiceApiVersion)
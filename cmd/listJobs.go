@@ -22,6 +22,9 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
+	"time"
+
 	"github.com/Azure/azure-storage-azcopy/common"
 	"github.com/spf13/cobra"
 )
@@ -30,7 +33,45 @@ type ListResponse struct {
 	ErrorMsg string
 }
 
+// the orders listJobs can sort the jobs in
+const (
+	listJobsSortByStartTime = "start-time"
+	listJobsSortByStatus    = "status"
+)
+
+type rawListJobsCmdArgs struct {
+	withStatus string
+	sortBy     string
+}
+
+type cookedListJobsCmdArgs struct {
+	filterByStatus bool
+	withStatus     common.JobStatus
+	sortBy         string
+}
+
+func (raw rawListJobsCmdArgs) cook() (cookedListJobsCmdArgs, error) {
+	cooked := cookedListJobsCmdArgs{}
+	if raw.withStatus != "" {
+		if err := cooked.withStatus.Parse(raw.withStatus); err != nil {
+			return cooked, fmt.Errorf("invalid with-status %q, it must be InProgress, Paused, Cancelling, Cancelled or Completed", raw.withStatus)
+		}
+		cooked.filterByStatus = true
+	}
+	switch raw.sortBy {
+	case "", listJobsSortByStartTime:
+		cooked.sortBy = listJobsSortByStartTime
+	case listJobsSortByStatus:
+		cooked.sortBy = listJobsSortByStatus
+	default:
+		return cooked, fmt.Errorf("invalid sort-by %q, it must be %s or %s", raw.sortBy, listJobsSortByStartTime, listJobsSortByStatus)
+	}
+	return cooked, nil
+}
+
 func init() {
+	raw := rawListJobsCmdArgs{}
+
 	// lsCmd represents the listJob command
 	lsCmd := &cobra.Command{
 		Use:        "listJobs",
@@ -38,7 +79,8 @@ func init() {
 		SuggestFor: []string{"lsJbs", "lsJob", "lsobs"},
 		Short:      "Display information on all jobs",
 		Long: `
-Display information on all jobs.`,
+Display information on all jobs whose plans are in the azcopy folder, including the ones ordered by other azcopy processes.
+The jobs are listed from the most recent to the oldest, unless they are sorted by status: in progress, paused, cancelling, cancelled, then completed.`,
 		Args: func(cmd *cobra.Command, args []string) error {
 
 			// if there is any argument passed
@@ -49,7 +91,11 @@ Display information on all jobs.`,
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			err := HandleListJobsCommand()
+			cooked, err := raw.cook()
+			if err != nil {
+				glcm.ExitWithError("failed to parse user input due to error: "+err.Error(), common.EExitCode.Error())
+			}
+			err = HandleListJobsCommand(cooked)
 			if err == nil {
 				glcm.ExitWithSuccess("", common.EExitCode.Success())
 			} else {
@@ -59,25 +105,82 @@ Display information on all jobs.`,
 	}
 
 	rootCmd.AddCommand(lsCmd)
+
+	lsCmd.PersistentFlags().StringVar(&raw.withStatus, "with-status", "", "only list the jobs with this status: InProgress, Paused, Cancelling, Cancelled or Completed")
+	lsCmd.PersistentFlags().StringVar(&raw.sortBy, "sort-by", listJobsSortByStartTime, "sort the jobs by "+listJobsSortByStartTime+", from the most recent, or by "+listJobsSortByStatus)
 }
 
 // HandleListJobsCommand sends the ListJobs request to transfer engine
 // Print the Jobs in the history of Azcopy
-func HandleListJobsCommand() error {
+func HandleListJobsCommand(cooked cookedListJobsCmdArgs) error {
 	resp := common.ListJobsResponse{}
 	Rpc(common.ERpcCmd.ListJobs(), nil, &resp)
-	return PrintExistingJobIds(resp)
+	if resp.ErrorMessage == "" {
+		resp.Jobs = filterAndSortJobs(resp.Jobs, cooked)
+	}
+	return PrintExistingJobs(resp)
+}
+
+// filterAndSortJobs keeps the jobs with the requested status, if any, in the requested order.
+// The jobs whose start time is unknown are listed after the others.
+func filterAndSortJobs(jobs []common.JobDetail, cooked cookedListJobsCmdArgs) []common.JobDetail {
+	filtered := make([]common.JobDetail, 0, len(jobs))
+	for _, job := range jobs {
+		if !cooked.filterByStatus || job.JobStatus == cooked.withStatus {
+			filtered = append(filtered, job)
+		}
+	}
+
+	mostRecent := func(i, j int) bool {
+		if filtered[i].StartTime.Equal(filtered[j].StartTime) {
+			return filtered[i].JobID.String() < filtered[j].JobID.String()
+		}
+		return filtered[i].StartTime.After(filtered[j].StartTime)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		if cooked.sortBy == listJobsSortByStatus && filtered[i].JobStatus != filtered[j].JobStatus {
+			return filtered[i].JobStatus < filtered[j].JobStatus
+		}
+		return mostRecent(i, j)
+	})
+	return filtered
 }
 
-// PrintExistingJobIds prints the response of listOrder command when listOrder command requested the list of existing jobs
-func PrintExistingJobIds(listJobResponse common.ListJobsResponse) error {
+// PrintExistingJobs prints the response of listOrder command when listOrder command requested the list of existing jobs
+func PrintExistingJobs(listJobResponse common.ListJobsResponse) error {
 	if listJobResponse.ErrorMessage != "" {
 		return fmt.Errorf("request failed with following error message: %s", listJobResponse.ErrorMessage)
 	}
+	if len(listJobResponse.Jobs) == 0 {
+		glcm.Info("No job has the requested status")
+		return nil
+	}
 
 	glcm.Info("Existing Jobs ")
-	for index := 0; index < len(listJobResponse.JobIDs); index++ {
-		glcm.Info(listJobResponse.JobIDs[index].String())
+	for _, job := range listJobResponse.Jobs {
+		startTime := "unknown"
+		if !job.StartTime.IsZero() {
+			startTime = job.StartTime.Local().Format(time.RFC1123)
+		}
+		summary := fmt.Sprintf(
+			"\nJob %s\nStart Time: %s\nStatus: %v\nCommand: %s\nTotal Number Of Transfers: %v\nNumber of Transfers Completed: %v\nNumber of Transfers Failed: %v\nBytes Transferred: %v of %v",
+			job.JobID.String(),
+			startTime,
+			job.JobStatus,
+			job.CommandString,
+			job.TotalTransfers,
+			job.TransfersCompleted,
+			job.TransfersFailed,
+			job.TotalBytesTransferred,
+			job.TotalBytesEnumerated,
+		)
+		if !job.CompleteJobOrdered {
+			summary += "\nThe job wasn't completely ordered"
+		}
+		if job.ErrorMsg != "" {
+			summary += "\nCannot read the plans of the job: " + job.ErrorMsg
+		}
+		glcm.Info(summary)
 	}
 	return nil
 }
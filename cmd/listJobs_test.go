@@ -0,0 +1,58 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type listJobsTestSuite struct{}
+
+var _ = chk.Suite(&listJobsTestSuite{})
+
+func (s *listJobsTestSuite) TestFilterAndSortJobs(c *chk.C) {
+	now := time.Now()
+	completed := common.JobDetail{JobID: common.NewJobID(), JobStatus: common.EJobStatus.Completed(), StartTime: now.Add(-time.Hour)}
+	paused := common.JobDetail{JobID: common.NewJobID(), JobStatus: common.EJobStatus.Paused(), StartTime: now.Add(-2 * time.Hour)}
+	recent := common.JobDetail{JobID: common.NewJobID(), JobStatus: common.EJobStatus.Completed(), StartTime: now}
+	unknown := common.JobDetail{JobID: common.NewJobID(), JobStatus: common.EJobStatus.Completed()} // planned by a release which didn't record the start time
+	jobs := []common.JobDetail{unknown, completed, paused, recent}
+
+	cooked, err := rawListJobsCmdArgs{}.cook()
+	c.Assert(err, chk.IsNil)
+	c.Assert(filterAndSortJobs(jobs, cooked), chk.DeepEquals, []common.JobDetail{recent, completed, paused, unknown})
+
+	cooked, err = rawListJobsCmdArgs{sortBy: "status"}.cook()
+	c.Assert(err, chk.IsNil)
+	c.Assert(filterAndSortJobs(jobs, cooked), chk.DeepEquals, []common.JobDetail{paused, recent, completed, unknown})
+
+	cooked, err = rawListJobsCmdArgs{withStatus: "Completed"}.cook()
+	c.Assert(err, chk.IsNil)
+	c.Assert(filterAndSortJobs(jobs, cooked), chk.DeepEquals, []common.JobDetail{recent, completed, unknown})
+
+	_, err = rawListJobsCmdArgs{withStatus: "Done"}.cook()
+	c.Assert(err, chk.NotNil)
+	_, err = rawListJobsCmdArgs{sortBy: "size"}.cook()
+	c.Assert(err, chk.NotNil)
+}
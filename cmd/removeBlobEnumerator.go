@@ -22,13 +22,7 @@ func (e *removeBlobEnumerator) enumerate(cca *cookedCopyCmdArgs) error {
 		azblob.PipelineOptions{
 			Telemetry: azblob.TelemetryOptions{Value: "azcopy-V2"},
 		},
-		ste.XferRetryOptions{
-			Policy:        0,
-			MaxTries:      ste.UploadMaxTries,
-			TryTimeout:    ste.UploadTryTimeout,
-			RetryDelay:    ste.UploadRetryDelay,
-			MaxRetryDelay: ste.UploadMaxRetryDelay,
-		}, nil)
+		ste.GetXferRetryOptions(), nil)
 
 	// attempt to parse the source url
 	sourceUrl, err := url.Parse(cca.source)
@@ -29,10 +29,10 @@ func (e *removeFileEnumerator) enumerate(cca *cookedCopyCmdArgs) error {
 		azfile.PipelineOptions{
 			Retry: azfile.RetryOptions{
 				Policy:        azfile.RetryPolicyExponential,
-				MaxTries:      ste.UploadMaxTries,
-				TryTimeout:    ste.UploadTryTimeout,
-				RetryDelay:    ste.UploadRetryDelay,
-				MaxRetryDelay: ste.UploadMaxRetryDelay,
+				MaxTries:      ste.GetXferRetryOptions().MaxTries,
+				TryTimeout:    ste.GetXferRetryOptions().TryTimeout,
+				RetryDelay:    ste.GetXferRetryOptions().RetryDelay,
+				MaxRetryDelay: ste.GetXferRetryOptions().MaxRetryDelay,
 			},
 			Telemetry: azfile.TelemetryOptions{
 				Value: common.UserAgent,
@@ -76,13 +76,16 @@ func (cca *resumeJobController) PrintJobProgressStatus() {
 		duration := time.Now().Sub(cca.jobStartTime) // report the total run time of the job
 
 		glcm.ExitWithSuccess(fmt.Sprintf(
-			"\n\nJob %s summary\nElapsed Time (Minutes): %v\nTotal Number Of Transfers: %v\nNumber of Transfers Completed: %v\nNumber of Transfers Failed: %v\nFinal Job Status: %v\n",
+			"\n\nJob %s summary\nElapsed Time (Minutes): %v\nTotal Number Of Transfers: %v\nNumber of Transfers Completed: %v\nNumber of Transfers Failed: %v\n%sFinal Job Status: %v\n%s%s",
 			summary.JobID.String(),
 			ste.ToFixed(duration.Minutes(), 4),
 			summary.TotalTransfers,
 			summary.TransfersCompleted,
 			summary.TransfersFailed,
-			summary.JobStatus), common.EExitCode.Success())
+			gCopyUtil.failureSummary(summary),
+			summary.JobStatus,
+			gCopyUtil.bytesTransferredSummary(summary),
+			gCopyUtil.throttlingSummary(summary)), common.EExitCode.Success())
 	}
 
 	// if json is not needed, and job is not done, then we generate a message that goes nicely on the same line
@@ -155,6 +158,10 @@ Resume the existing job with the given job ID.`,
 	resumeCmd.PersistentFlags().StringVar(&resumeCmdArgs.aadEndpoint, "aad-endpoint", common.DefaultActiveDirectoryEndpoint, "Azure active directory endpoint to use for OAuth user interactive login.")
 	resumeCmd.PersistentFlags().StringVar(&resumeCmdArgs.SourceSAS, "source-sas", "", "source sas of the source for given JobId")
 	resumeCmd.PersistentFlags().StringVar(&resumeCmdArgs.DestinationSAS, "destination-sas", "", "destination sas of the destination for given JobId")
+	resumeCmd.PersistentFlags().Uint32Var(&resumeCmdArgs.capMbps, "cap-mbps", 0, "caps the transfer rate, in megabits per second. 0 means no cap, unless the environment variable "+common.EnvVarCapMbps+" is set. "+
+		"The cap is shared by all the jobs running in the transfer engine, and goes back to the default one once this job completes, is cancelled or paused")
+	resumeCmd.PersistentFlags().StringVar(&resumeCmdArgs.onCompleteExec, "on-complete-exec", "", onCompleteExecFlagHelp)
+	resumeCmd.PersistentFlags().StringVar(&resumeCmdArgs.onCompleteURL, "on-complete-url", "", onCompleteURLFlagHelp)
 }
 
 type resumeCmdArgs struct {
@@ -169,6 +176,13 @@ type resumeCmdArgs struct {
 
 	SourceSAS      string
 	DestinationSAS string
+
+	// capMbps caps the bandwidth in megabits per second, 0 keeps the engine's default
+	capMbps uint32
+
+	// completion hook
+	onCompleteExec string
+	onCompleteURL  string
 }
 
 // processes the resume command,
@@ -181,6 +195,20 @@ func (rca resumeCmdArgs) process() error {
 		return fmt.Errorf("error parsing the jobId %s. Failed with error %s", rca.jobID, err.Error())
 	}
 
+	completionHook, err := cookCompletionHook(rca.onCompleteExec, rca.onCompleteURL)
+	if err != nil {
+		return err
+	}
+
+	// the bandwidth cap falls back to the environment variable if the flag is not given
+	capMbps := rca.capMbps
+	if capMbps == 0 {
+		capMbps, err = common.GetCapMbpsFromEnv()
+		if err != nil {
+			return err
+		}
+	}
+
 	includeTransfer := make(map[string]int)
 	excludeTransfer := make(map[string]int)
 
@@ -264,6 +292,8 @@ func (rca resumeCmdArgs) process() error {
 			CredentialInfo:  credentialInfo,
 			IncludeTransfer: includeTransfer,
 			ExcludeTransfer: excludeTransfer,
+			CapMbps:         capMbps,
+			CompletionHook:  completionHook,
 		},
 		&resumeJobResponse)
 
@@ -0,0 +1,136 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-azcopy/ste"
+	"github.com/spf13/cobra"
+)
+
+// rawRetryArgs holds the retry settings given to any command, which apply to all the requests it sends
+type rawRetryArgs struct {
+	maxTries         int32
+	tryTimeout       time.Duration
+	retryDelay       time.Duration
+	maxRetryDelay    time.Duration
+	retryStatusCodes string
+}
+
+var rawRetry = rawRetryArgs{}
+
+// each retry setting falls back to its environment variable when its flag isn't given
+var retryFlagsEnvVars = map[string]string{
+	"max-tries":          common.EnvVarMaxTries,
+	"try-timeout":        common.EnvVarTryTimeout,
+	"retry-delay":        common.EnvVarRetryDelay,
+	"max-retry-delay":    common.EnvVarMaxRetryDelay,
+	"retry-status-codes": common.EnvVarRetryStatusCodes,
+}
+
+// applyRetryOptions validates the retry settings of the command, and makes them the retry options of every pipeline it creates
+func applyRetryOptions(cmd *cobra.Command) error {
+	for flagName, envVarName := range retryFlagsEnvVars {
+		value := os.Getenv(envVarName)
+		if value == "" || cmd.Flags().Changed(flagName) {
+			continue
+		}
+		if err := cmd.Flags().Set(flagName, value); err != nil {
+			return fmt.Errorf("error parsing the env %s %v. Failed with error %s", envVarName, value, err.Error())
+		}
+	}
+
+	if rawRetry.maxTries < 1 {
+		return fmt.Errorf("the max-tries %d must be at least 1", rawRetry.maxTries)
+	}
+	if rawRetry.tryTimeout <= 0 {
+		return fmt.Errorf("the try-timeout %v must be positive", rawRetry.tryTimeout)
+	}
+	if rawRetry.retryDelay <= 0 || rawRetry.retryDelay > rawRetry.maxRetryDelay {
+		return fmt.Errorf("the retry-delay %v must be positive and no larger than the max-retry-delay %v",
+			rawRetry.retryDelay, rawRetry.maxRetryDelay)
+	}
+	statusCodeRetryClasses, err := parseStatusCodeRetryClasses(rawRetry.retryStatusCodes)
+	if err != nil {
+		return err
+	}
+
+	ste.SetXferRetryOptions(ste.XferRetryOptions{
+		Policy:                 ste.RetryPolicyExponential,
+		MaxTries:               rawRetry.maxTries,
+		TryTimeout:             rawRetry.tryTimeout,
+		RetryDelay:             rawRetry.retryDelay,
+		MaxRetryDelay:          rawRetry.maxRetryDelay,
+		StatusCodeRetryClasses: statusCodeRetryClasses,
+	})
+	return nil
+}
+
+// parseStatusCodeRetryClasses parses the overrides of how the status codes are retried,
+// e.g. "404=Retryable;503=Fatal". The entries are separated by ';'.
+func parseStatusCodeRetryClasses(s string) (map[int]common.RetryClass, error) {
+	statusCodeRetryClasses := make(map[int]common.RetryClass)
+	for _, entry := range strings.Split(s, ";") {
+		// If split of the string leads to an empty entry, skip it
+		if len(strings.TrimSpace(entry)) == 0 {
+			continue
+		}
+		statusCodeAndClass := strings.SplitN(entry, "=", 2)
+		if len(statusCodeAndClass) != 2 {
+			return nil, fmt.Errorf("invalid retry-status-codes entry %q, expected a status code and a retry class separated by '='", entry)
+		}
+		statusCode, err := strconv.Atoi(strings.TrimSpace(statusCodeAndClass[0]))
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("invalid retry-status-codes entry %q, %q is not an HTTP status code", entry, statusCodeAndClass[0])
+		}
+		var retryClass common.RetryClass
+		if err = retryClass.Parse(strings.TrimSpace(statusCodeAndClass[1])); err != nil {
+			return nil, fmt.Errorf("invalid retry-status-codes entry %q, the retry classes are Retryable, Throttling and Fatal", entry)
+		}
+		statusCodeRetryClasses[statusCode] = retryClass
+	}
+	return statusCodeRetryClasses, nil
+}
+
+func init() {
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return applyRetryOptions(cmd)
+	}
+
+	rootCmd.PersistentFlags().Int32Var(&rawRetry.maxTries, "max-tries", ste.UploadMaxTries,
+		"the number of times a request is tried before it fails, or the environment variable "+common.EnvVarMaxTries+" if not given")
+	rootCmd.PersistentFlags().DurationVar(&rawRetry.tryTimeout, "try-timeout", ste.UploadTryTimeout,
+		"the maximum time a single try of a request may take, or the environment variable "+common.EnvVarTryTimeout+" if not given")
+	rootCmd.PersistentFlags().DurationVar(&rawRetry.retryDelay, "retry-delay", ste.UploadRetryDelay,
+		"the delay before the first retry of a request, doubled at each retry and randomized by up to half, or the environment variable "+common.EnvVarRetryDelay+" if not given")
+	rootCmd.PersistentFlags().DurationVar(&rawRetry.maxRetryDelay, "max-retry-delay", ste.UploadMaxRetryDelay,
+		"the maximum delay before a retry of a request, or the environment variable "+common.EnvVarMaxRetryDelay+" if not given")
+	rootCmd.PersistentFlags().StringVar(&rawRetry.retryStatusCodes, "retry-status-codes", "",
+		"overrides how the requests failing with the given HTTP status codes are retried, e.g. \"404=Retryable;503=Fatal\". "+
+			"The retry classes are Retryable, Throttling and Fatal. By default 408, 500, 502 and 504 are retryable, 429 and 503 are throttling, the others are fatal. "+
+			"Or the environment variable "+common.EnvVarRetryStatusCodes+" if not given")
+}
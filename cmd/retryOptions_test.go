@@ -0,0 +1,51 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type retryOptionsTestSuite struct{}
+
+var _ = chk.Suite(&retryOptionsTestSuite{})
+
+func (s *retryOptionsTestSuite) TestParseStatusCodeRetryClasses(c *chk.C) {
+	classes, err := parseStatusCodeRetryClasses("")
+	c.Assert(err, chk.IsNil)
+	c.Assert(classes, chk.HasLen, 0)
+
+	classes, err = parseStatusCodeRetryClasses("404=Retryable; 503 = fatal;;429=Throttling")
+	c.Assert(err, chk.IsNil)
+	c.Assert(classes, chk.DeepEquals, map[int]common.RetryClass{
+		404: common.ERetryClass.Retryable(),
+		503: common.ERetryClass.Fatal(),
+		429: common.ERetryClass.Throttling(),
+	})
+
+	_, err = parseStatusCodeRetryClasses("404")
+	c.Assert(err, chk.NotNil)
+	_, err = parseStatusCodeRetryClasses("4040=Retryable")
+	c.Assert(err, chk.NotNil)
+	_, err = parseStatusCodeRetryClasses("404=Sometimes")
+	c.Assert(err, chk.NotNil)
+}
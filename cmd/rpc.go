@@ -53,6 +53,15 @@ func inprocSend(rpcCmd common.RpcCmd, requestData interface{}, responseData inte
 	case common.ERpcCmd.ResumeJob():
 		*(responseData.(*common.CancelPauseResumeResponse)) = ste.ResumeJobOrder(*requestData.(*common.ResumeJobRequest))
 
+	case common.ERpcCmd.MigrateJobs():
+		*(responseData.(*common.MigrateJobsResponse)) = ste.MigrateJobs(*requestData.(*common.MigrateJobsRequest))
+
+	case common.ERpcCmd.CleanJobs():
+		*(responseData.(*common.CleanJobsResponse)) = ste.CleanJobs(*requestData.(*common.CleanJobsRequest))
+
+	case common.ERpcCmd.VerifyJob():
+		*(responseData.(*common.VerifyJobResponse)) = ste.VerifyJob(*requestData.(*common.VerifyJobRequest))
+
 	default:
 		panic(fmt.Errorf("Unrecognized RpcCmd: %q", rpcCmd.String()))
 	}
@@ -72,7 +72,9 @@ If the with-status flag is set, then the list of transfers in the job with the g
 	rootCmd.AddCommand(shJob)
 
 	// filters
-	shJob.PersistentFlags().StringVar(&commandLineInput.OfStatus, "with-status", "", "only list the transfers of job with this status, available values: NotStarted, Started, Success, Failed")
+	shJob.PersistentFlags().StringVar(&commandLineInput.OfStatus, "with-status", "", "only list the transfers of job with this status, available values: NotStarted, Started, Success, "+
+		"Failed (which lists every failed transfer), or one of the failure statuses: BlobTierFailure, BlobAlreadyExistsFailure, FileAlreadyExistsFailure, "+
+		"SourceChangedFailure, MD5MismatchFailure, AuthFailure, NotFoundFailure, ThrottledFailure, LocalIOFailure, QuotaExceededFailure")
 }
 
 // handles the list command
@@ -111,7 +113,8 @@ func PrintJobTransfers(listTransfersResponse common.ListJobTransfersResponse) {
 	glcm.Info("----------- Transfers for JobId " + listTransfersResponse.JobID.String() + " -----------")
 	for index := 0; index < len(listTransfersResponse.Details); index++ {
 		glcm.Info("transfer--> source: " + listTransfersResponse.Details[index].Src + " destination: " +
-			listTransfersResponse.Details[index].Dst + " status " + listTransfersResponse.Details[index].TransferStatus.String())
+			listTransfersResponse.Details[index].Dst + " status " + listTransfersResponse.Details[index].TransferStatus.String() +
+			gCopyUtil.transferErrorCode(listTransfersResponse.Details[index]))
 	}
 }
 
@@ -122,16 +125,28 @@ func PrintJobProgressSummary(summary common.ListJobSummaryResponse) {
 	}
 
 	glcm.Info(fmt.Sprintf(
-		"\nJob %s summary\nTotal Number Of Transfers: %v\nNumber of Transfers Completed: %v\nNumber of Transfers Failed: %v\nFinal Job Status: %v\n",
+		"\nJob %s summary\nTotal Number Of Transfers: %v\nNumber of Transfers Completed: %v\nNumber of Transfers Failed: %v\n%sFinal Job Status: %v\n",
 		summary.JobID.String(),
 		summary.TotalTransfers,
 		summary.TransfersCompleted,
 		summary.TransfersFailed,
+		gCopyUtil.failureSummary(summary),
 		summary.JobStatus,
 	))
 
+	if summary.EffectiveCapInMbps > 0 {
+		glcm.Info(fmt.Sprintf("Effective Bandwidth Cap (Mb/s): %v", summary.EffectiveCapInMbps))
+	}
+	if bytesTransferred := gCopyUtil.bytesTransferredSummary(summary); bytesTransferred != "" {
+		glcm.Info(bytesTransferred)
+	}
+	if throttling := gCopyUtil.throttlingSummary(summary); throttling != "" {
+		glcm.Info(throttling)
+	}
+
 	// send each message separately so that the printing is smooth
 	for index := 0; index < len(summary.FailedTransfers); index++ {
-		glcm.Info(fmt.Sprintf("transfer-%d	source: %s	destination: %s", index, summary.FailedTransfers[index].Src, summary.FailedTransfers[index].Dst))
+		glcm.Info(fmt.Sprintf("transfer-%d	source: %s	destination: %s	status: %s%s", index, summary.FailedTransfers[index].Src, summary.FailedTransfers[index].Dst,
+			summary.FailedTransfers[index].TransferStatus, gCopyUtil.transferErrorCode(summary.FailedTransfers[index])))
 	}
 }
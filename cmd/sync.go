@@ -46,6 +46,12 @@ type syncCommandArguments struct {
 	include      string
 	exclude      string
 	output       string
+	capMbps      uint32
+	priority     string
+	checkMd5     string
+	// completion hook
+	onCompleteExec string
+	onCompleteURL  string
 	// commandString hold the user given command which is logged to the Job log file
 	commandString string
 }
@@ -105,6 +111,32 @@ func (raw syncCommandArguments) cook() (cookedSyncCmdArgs, error) {
 
 	cooked.recursive = raw.recursive
 	cooked.output.Parse(raw.output)
+
+	// the bandwidth cap falls back to the environment variable if the flag is not given
+	cooked.capMbps = raw.capMbps
+	if cooked.capMbps == 0 {
+		cooked.capMbps, err = common.GetCapMbpsFromEnv()
+		if err != nil {
+			return cooked, err
+		}
+	}
+
+	err = cooked.priority.Parse(raw.priority)
+	if err != nil {
+		return cooked, err
+	}
+	err = cooked.md5ValidationOption.Parse(raw.checkMd5)
+	if err != nil {
+		return cooked, err
+	}
+	cooked.completionHook, err = cookCompletionHook(raw.onCompleteExec, raw.onCompleteURL)
+	if err != nil {
+		return cooked, err
+	}
+	cooked.planTTL, err = common.GetJobPlanTTLFromEnv()
+	if err != nil {
+		return cooked, err
+	}
 	cooked.jobID = common.NewJobID()
 	return cooked, nil
 }
@@ -123,6 +155,16 @@ type cookedSyncCmdArgs struct {
 	blockSize    uint32
 	logVerbosity common.LogLevel
 	output       common.OutputFormat
+	// capMbps caps the bandwidth in megabits per second, 0 keeps the engine's default
+	capMbps uint32
+	// priority determines the job's share of the engine's workers when other jobs are running at the same time
+	priority common.JobPriority
+	// md5ValidationOption determines how strictly the downloaded data is validated against the content MD5 of its source
+	md5ValidationOption common.HashValidationOption
+	// completionHook notifies the caller once the job finishes
+	completionHook common.JobCompletionHook
+	// planTTL is how long the plan files of the job are kept once it finished, in seconds; 0 keeps them
+	planTTL uint32
 	// commandString hold the user given command which is logged to the Job log file
 	commandString string
 
@@ -220,15 +262,20 @@ func (cca *cookedSyncCmdArgs) PrintJobProgressStatus() {
 func (cca *cookedSyncCmdArgs) process() (err error) {
 	// initialize the fields that are constant across all job part orders
 	jobPartOrder := common.SyncJobPartOrderRequest{
-		JobID:            cca.jobID,
-		FromTo:           cca.fromTo,
-		LogLevel:         cca.logVerbosity,
-		BlockSizeInBytes: cca.blockSize,
-		Include:          cca.include,
-		Exclude:          cca.exclude,
-		CommandString:    cca.commandString,
-		SourceSAS:        cca.sourceSAS,
-		DestinationSAS:   cca.destinationSAS,
+		JobID:               cca.jobID,
+		FromTo:              cca.fromTo,
+		LogLevel:            cca.logVerbosity,
+		BlockSizeInBytes:    cca.blockSize,
+		Include:             cca.include,
+		Exclude:             cca.exclude,
+		CommandString:       cca.commandString,
+		SourceSAS:           cca.sourceSAS,
+		DestinationSAS:      cca.destinationSAS,
+		CapMbps:             cca.capMbps,
+		CompletionHook:      cca.completionHook,
+		TTLAfterCompletion:  cca.planTTL,
+		Priority:            cca.priority,
+		MD5ValidationOption: cca.md5ValidationOption,
 	}
 
 	from := cca.fromTo.From()
@@ -354,11 +401,18 @@ func init() {
 
 	rootCmd.AddCommand(syncCmd)
 	syncCmd.PersistentFlags().BoolVar(&raw.recursive, "recursive", false, "Filter: Look into sub-directories recursively when syncing destination to source.")
-	syncCmd.PersistentFlags().Uint32Var(&raw.blockSize, "block-size", 8*1024*1024, "Use this block size when source to Azure Storage or from Azure Storage.")
+	syncCmd.PersistentFlags().Uint32Var(&raw.blockSize, "block-size", 0, "Use this block size when source to Azure Storage or from Azure Storage. "+
+		"0 picks a block size for each file from its size, which is 8 MB unless the file would need more blocks than a blob can be made of.")
 	// hidden filters
 	syncCmd.PersistentFlags().StringVar(&raw.include, "include", "", "Filter: only include these files when copying. "+
 		"Support use of *. More than one file are separated by ';'")
 	syncCmd.PersistentFlags().StringVar(&raw.exclude, "exclude", "", "Filter: Exclude these files when copying. Support use of *.")
 	syncCmd.PersistentFlags().StringVar(&raw.output, "output", "text", "format of the command's output, the choices include: text, json")
 	syncCmd.PersistentFlags().StringVar(&raw.logVerbosity, "log-level", "WARNING", "defines the log verbosity to be saved to log file")
+	syncCmd.PersistentFlags().Uint32Var(&raw.capMbps, "cap-mbps", 0, "caps the transfer rate, in megabits per second. 0 means no cap, unless the environment variable "+common.EnvVarCapMbps+" is set. "+
+		"The cap is shared by all the jobs running in the transfer engine, and goes back to the default one once this job completes, is cancelled or paused")
+	syncCmd.PersistentFlags().StringVar(&raw.onCompleteExec, "on-complete-exec", "", onCompleteExecFlagHelp)
+	syncCmd.PersistentFlags().StringVar(&raw.onCompleteURL, "on-complete-url", "", onCompleteURLFlagHelp)
+	syncCmd.PersistentFlags().StringVar(&raw.priority, "priority", "Normal", "the job's priority, which determines its share of the transfer engine when other jobs run at the same time, available priorities: Normal, Low")
+	syncCmd.PersistentFlags().StringVar(&raw.checkMd5, "check-md5", "NoCheck", "how strictly to validate the MD5 hash of downloaded data against the content MD5 of the source, available options: NoCheck, LogOnly, FailIfDifferent, FailIfDifferentOrMissing")
 }
@@ -452,12 +452,7 @@ func (e *syncDownloadEnumerator) enumerate(cca *cookedSyncCmdArgs) error {
 			Value: common.UserAgent,
 		},
 	},
-		ste.XferRetryOptions{
-			Policy:        0,
-			MaxTries:      ste.UploadMaxTries,
-			TryTimeout:    ste.UploadTryTimeout,
-			RetryDelay:    ste.UploadRetryDelay,
-			MaxRetryDelay: ste.UploadMaxRetryDelay},
+		ste.GetXferRetryOptions(),
 		nil)
 
 	// Copying the JobId of sync job to individual copyJobRequest
@@ -474,6 +469,9 @@ func (e *syncDownloadEnumerator) enumerate(cca *cookedSyncCmdArgs) error {
 	// Set the preserve-last-modified-time to true in CopyJobRequest
 	e.CopyJobRequest.BlobAttributes.PreserveLastModifiedTime = true
 
+	// Set how strictly the downloaded blobs are validated against their content MD5
+	e.CopyJobRequest.BlobAttributes.MD5ValidationOption = e.MD5ValidationOption
+
 	// Copying the JobId of sync job to individual deleteJobRequest.
 	e.DeleteJobRequest.JobID = e.JobID
 	// FromTo of DeleteJobRequest will be BlobTrash.
@@ -495,6 +493,21 @@ func (e *syncDownloadEnumerator) enumerate(cca *cookedSyncCmdArgs) error {
 	e.CopyJobRequest.LogLevel = e.LogLevel
 	e.DeleteJobRequest.LogLevel = e.LogLevel
 
+	// Set the bandwidth cap of the copy transfers
+	e.CopyJobRequest.CapMbps = e.CapMbps
+
+	// Set the completion hook of the job to both the copy and the delete transfers
+	e.CopyJobRequest.CompletionHook = e.CompletionHook
+	e.DeleteJobRequest.CompletionHook = e.CompletionHook
+
+	// Set how long the plan files of the job are kept once it finished to both the copy and the delete transfers
+	e.CopyJobRequest.TTLAfterCompletion = e.TTLAfterCompletion
+	e.DeleteJobRequest.TTLAfterCompletion = e.TTLAfterCompletion
+
+	// Set the priority of the job to both the copy and the delete transfers
+	e.CopyJobRequest.Priority = e.Priority
+	e.DeleteJobRequest.Priority = e.Priority
+
 	// Copy the sync Command String to the CopyJobPartRequest and DeleteJobRequest
 	e.CopyJobRequest.CommandString = e.CommandString
 	e.DeleteJobRequest.CommandString = e.CommandString
@@ -42,6 +42,12 @@ func (e *syncUploadEnumerator) addTransferToDelete(transfer common.CopyTransfer,
 
 // accept a new transfer, if the threshold is reached, dispatch a job part order
 func (e *syncUploadEnumerator) addTransferToUpload(transfer common.CopyTransfer, cca *cookedSyncCmdArgs) error {
+	// the block size of each blob is planned from the size of its file, so that it isn't made of too many blocks
+	blockSize, err := common.PlanBlockSize(common.EBlobType.BlockBlob(), transfer.SourceSize, cca.blockSize)
+	if err != nil {
+		return fmt.Errorf("cannot upload %s: %v", transfer.Source, err)
+	}
+	transfer.BlockSize = blockSize
 
 	if len(e.CopyJobRequest.Transfers) == NumOfFilesPerDispatchJobPart {
 		resp := common.CopyJobPartOrderResponse{}
@@ -280,12 +286,15 @@ func (e *syncUploadEnumerator) compareLocalAgainstRemote(cca *cookedSyncCmdArgs,
 		// If the modified time of file local is later than that of blob
 		// sync needs to happen. The transfer is queued
 		if isSourceASingleFile.ModTime().After(bProperties.LastModified()) {
-			e.addTransferToUpload(common.CopyTransfer{
+			err := e.addTransferToUpload(common.CopyTransfer{
 				Source:           cca.source,
 				Destination:      util.stripSASFromBlobUrl(*destinationUrl).String(),
 				SourceSize:       isSourceASingleFile.Size(),
 				LastModifiedTime: isSourceASingleFile.ModTime(),
 			}, cca)
+			if err != nil {
+				return err, true
+			}
 		}
 		return nil, true
 	}
@@ -307,12 +316,15 @@ func (e *syncUploadEnumerator) compareLocalAgainstRemote(cca *cookedSyncCmdArgs,
 		if err == nil && !isSourceASingleFile.ModTime().After(bProperties.LastModified()) {
 			return fmt.Errorf("sync is not required since the source %s modified time is before the destinaton %s modified time ", cca.source, filedestinationUrl.String()), true
 		}
-		e.addTransferToUpload(common.CopyTransfer{
+		err = e.addTransferToUpload(common.CopyTransfer{
 			Source:           cca.source,
 			Destination:      util.stripSASFromBlobUrl(filedestinationUrl).String(),
 			LastModifiedTime: isSourceASingleFile.ModTime(),
 			SourceSize:       isSourceASingleFile.Size(),
 		}, cca)
+		if err != nil {
+			return err, true
+		}
 		return nil, true
 	}
 
@@ -452,12 +464,7 @@ func (e *syncUploadEnumerator) enumerate(cca *cookedSyncCmdArgs) error {
 			Value: common.UserAgent,
 		},
 	},
-		ste.XferRetryOptions{
-			Policy:        0,
-			MaxTries:      ste.UploadMaxTries,
-			TryTimeout:    ste.UploadTryTimeout,
-			RetryDelay:    ste.UploadRetryDelay,
-			MaxRetryDelay: ste.UploadMaxRetryDelay},
+		ste.GetXferRetryOptions(),
 		nil)
 
 	// Copying the JobId of sync job to individual copyJobRequest
@@ -490,6 +497,21 @@ func (e *syncUploadEnumerator) enumerate(cca *cookedSyncCmdArgs) error {
 	// Set the force flag to true
 	e.CopyJobRequest.ForceWrite = true
 
+	// Set the bandwidth cap of the copy transfers
+	e.CopyJobRequest.CapMbps = e.CapMbps
+
+	// Set the completion hook of the job to both the copy and the delete transfers
+	e.CopyJobRequest.CompletionHook = e.CompletionHook
+	e.DeleteJobRequest.CompletionHook = e.CompletionHook
+
+	// Set how long the plan files of the job are kept once it finished to both the copy and the delete transfers
+	e.CopyJobRequest.TTLAfterCompletion = e.TTLAfterCompletion
+	e.DeleteJobRequest.TTLAfterCompletion = e.TTLAfterCompletion
+
+	// Set the priority of the job to both the copy and the delete transfers
+	e.CopyJobRequest.Priority = e.Priority
+	e.DeleteJobRequest.Priority = e.Priority
+
 	// Copy the sync Command String to the CopyJobPartRequest and DeleteJobRequest
 	e.CopyJobRequest.CommandString = e.CommandString
 	e.DeleteJobRequest.CommandString = e.CommandString
@@ -0,0 +1,88 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import "fmt"
+
+const (
+	// DefaultBlockSize is the block size of a transfer whose source isn't too large for it, unless the user chooses one
+	DefaultBlockSize = 8 * 1024 * 1024
+
+	// MaxNumberOfBlocksPerBlob is the number of blocks a block blob or an append blob can be made of
+	MaxNumberOfBlocksPerBlob = 50000
+
+	// MaxBlockBlobBlockSize is the size of the largest block of a block blob
+	MaxBlockBlobBlockSize = 100 * 1024 * 1024
+
+	// the block sizes picked for sources too large for the default block size are rounded up to whole MBs
+	blockSizeGranularity = 1024 * 1024
+)
+
+// PlanBlockSize returns the block size of a transfer of a source of the given size to a blob of the given type.
+// A block size of 0 lets the planner pick one: the default block size, unless the source would need more blocks
+// than a blob can be made of, in which case the smallest block size keeping it under that limit, rounded up to a whole MB.
+// A block size chosen by the user is kept as long as the source fits in a blob made of such blocks.
+// An error is returned if the source can't fit in a blob of that type at all.
+// A detected blob type is planned as a block blob, so a source which is detected to be a page blob has to be planned as one.
+func PlanBlockSize(blobType BlobType, sourceSize int64, blockSize uint32) (uint32, error) {
+	// page blobs are written in pages of up to 4 MB, and aren't limited in the number of writes
+	if blobType == EBlobType.PageBlob() {
+		return Iffuint32(blockSize == 0, DefaultBlockSize, blockSize), nil
+	}
+
+	// a detected blob type is planned as a block blob, the enumeration plans the fixed size VHDs as page blobs
+	blobTypeName := "block blob"
+	maxBlockSize := int64(MaxBlockBlobBlockSize)
+	if blobType == EBlobType.AppendBlob() {
+		blobTypeName = "append blob"
+		maxBlockSize = DefaultAppendBlobBlockSize
+	}
+	maxBlobSize := maxBlockSize * MaxNumberOfBlocksPerBlob
+	if sourceSize > maxBlobSize {
+		return 0, fmt.Errorf("the size %d is larger than %d bytes, the maximum size of a %s made of %d blocks of %d bytes",
+			sourceSize, maxBlobSize, blobTypeName, MaxNumberOfBlocksPerBlob, maxBlockSize)
+	}
+
+	// the smallest block size which splits the source in no more than the maximum number of blocks
+	minBlockSize := (sourceSize + MaxNumberOfBlocksPerBlob - 1) / MaxNumberOfBlocksPerBlob
+
+	if blockSize == 0 {
+		if minBlockSize <= DefaultBlockSize {
+			return uint32(Iffint64(DefaultBlockSize > maxBlockSize, maxBlockSize, DefaultBlockSize)), nil
+		}
+		// since the source fits in a blob, rounding up doesn't go past the maximum block size, a whole number of MBs
+		return uint32((minBlockSize + blockSizeGranularity - 1) / blockSizeGranularity * blockSizeGranularity), nil
+	}
+
+	// the blocks of append blobs are always at most 4 MB, appending larger chunks isn't an option
+	if blobType == EBlobType.AppendBlob() && int64(blockSize) > maxBlockSize {
+		blockSize = uint32(maxBlockSize)
+	}
+	if int64(blockSize) > maxBlockSize {
+		return 0, fmt.Errorf("the block-size %d is larger than %d bytes, the maximum size of a block of a %s",
+			blockSize, maxBlockSize, blobTypeName)
+	}
+	if int64(blockSize) < minBlockSize {
+		return 0, fmt.Errorf("the block-size %d would split the %d bytes into more than %d blocks, the maximum number of blocks of a %s; "+
+			"use a block-size of at least %d bytes, or let it be picked automatically", blockSize, sourceSize, MaxNumberOfBlocksPerBlob, blobTypeName, minBlockSize)
+	}
+	return blockSize, nil
+}
@@ -0,0 +1,74 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvVarCapMbps caps the bandwidth used by AzCopy, in megabits per second, when --cap-mbps is not given.
+const EnvVarCapMbps = "AZCOPY_CAP_MBPS"
+
+// GetCapMbpsFromEnv returns the bandwidth cap set through the environment variable AZCOPY_CAP_MBPS.
+// 0 is returned if the environment variable is not set, which means there is no cap.
+func GetCapMbpsFromEnv() (uint32, error) {
+	capMbps := os.Getenv(EnvVarCapMbps)
+	if capMbps == "" {
+		return 0, nil
+	}
+	val, err := strconv.ParseUint(capMbps, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing the env %s %v. Failed with error %s", EnvVarCapMbps, capMbps, err.Error())
+	}
+	return uint32(val), nil
+}
+
+// The environment variables giving the retry settings of AzCopy's requests, when the corresponding flags are not given.
+const (
+	EnvVarMaxTries         = "AZCOPY_MAX_TRIES"
+	EnvVarTryTimeout       = "AZCOPY_TRY_TIMEOUT"
+	EnvVarRetryDelay       = "AZCOPY_RETRY_DELAY"
+	EnvVarMaxRetryDelay    = "AZCOPY_MAX_RETRY_DELAY"
+	EnvVarRetryStatusCodes = "AZCOPY_RETRY_STATUS_CODES"
+)
+
+// EnvVarJobPlanTTL sets how long the plan files and the logs of a job are kept once it finished, e.g. "168h".
+// The jobs whose TTL expired are cleaned up the next time AzCopy starts.
+const EnvVarJobPlanTTL = "AZCOPY_JOB_PLAN_TTL"
+
+// GetJobPlanTTLFromEnv returns the TTL of the plan files of the jobs, in seconds, set through the environment
+// variable AZCOPY_JOB_PLAN_TTL. 0 is returned if the environment variable is not set, which keeps the plan files
+// until they're cleaned up by 'azcopy jobs clean'.
+func GetJobPlanTTLFromEnv() (uint32, error) {
+	ttl := os.Getenv(EnvVarJobPlanTTL)
+	if ttl == "" {
+		return 0, nil
+	}
+	val, err := time.ParseDuration(ttl)
+	if err != nil || val < time.Second || val.Seconds() > math.MaxUint32 {
+		return 0, fmt.Errorf("error parsing the env %s %v. It must be a duration of at least one second, e.g. 168h", EnvVarJobPlanTTL, ttl)
+	}
+	return uint32(val.Seconds()), nil
+}
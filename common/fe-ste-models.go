@@ -169,12 +169,46 @@ type JobPriority uint8
 
 func (JobPriority) Normal() JobPriority { return JobPriority(0) }
 func (JobPriority) Low() JobPriority    { return JobPriority(1) }
+func (jp *JobPriority) Parse(s string) error {
+	val, err := enum.ParseInt(reflect.TypeOf(jp), s, true, true)
+	if err == nil {
+		*jp = val.(JobPriority)
+	}
+	return err
+}
 func (jp JobPriority) String() string {
 	return enum.StringInt(uint8(jp), reflect.TypeOf(jp))
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+var ERetryClass = RetryClass(0)
+
+// RetryClass tells how a request which failed with a given HTTP status code is retried
+type RetryClass uint8
+
+// Fatal failures aren't retried
+func (RetryClass) Fatal() RetryClass { return RetryClass(0) }
+
+// Retryable failures are retried after the delay of the retry policy
+func (RetryClass) Retryable() RetryClass { return RetryClass(1) }
+
+// Throttling failures mean the service is busy, they are retried as well
+func (RetryClass) Throttling() RetryClass { return RetryClass(2) }
+
+func (rc *RetryClass) Parse(s string) error {
+	val, err := enum.ParseInt(reflect.TypeOf(rc), s, true, true)
+	if err == nil {
+		*rc = val.(RetryClass)
+	}
+	return err
+}
+func (rc RetryClass) String() string {
+	return enum.StringInt(uint8(rc), reflect.TypeOf(rc))
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
 var EJobStatus = JobStatus(0)
 
 // JobStatus indicates the status of a Job; the default is InProgress.
@@ -326,6 +360,28 @@ func (TransferStatus) BlobAlreadyExistsFailure() TransferStatus { return Transfe
 
 func (TransferStatus) FileAlreadyExistsFailure() TransferStatus { return TransferStatus(-4) }
 
+// Transfer failed because its source was modified while it was being transferred.
+func (TransferStatus) SourceChangedFailure() TransferStatus { return TransferStatus(-5) }
+
+// Transfer failed because the MD5 hash of the downloaded data doesn't match the one of its source,
+// or because the source has no MD5 hash and the job requires one.
+func (TransferStatus) MD5MismatchFailure() TransferStatus { return TransferStatus(-6) }
+
+// Transfer failed because the service refused the credentials, e.g. an expired SAS or a missing permission.
+func (TransferStatus) AuthFailure() TransferStatus { return TransferStatus(-7) }
+
+// Transfer failed because its source, or the container/share/filesystem of its destination, doesn't exist.
+func (TransferStatus) NotFoundFailure() TransferStatus { return TransferStatus(-8) }
+
+// Transfer failed because the service kept throttling it until the retries ran out.
+func (TransferStatus) ThrottledFailure() TransferStatus { return TransferStatus(-9) }
+
+// Transfer failed because of an error of the local file system, e.g. a file that cannot be opened or created.
+func (TransferStatus) LocalIOFailure() TransferStatus { return TransferStatus(-10) }
+
+// Transfer failed because a size or count limit was reached, e.g. a full share or local disk, or too many blocks.
+func (TransferStatus) QuotaExceededFailure() TransferStatus { return TransferStatus(-11) }
+
 func (ts TransferStatus) ShouldTransfer() bool {
 	return ts == ETransferStatus.NotStarted() || ts == ETransferStatus.Started()
 }
@@ -353,6 +409,37 @@ func (ts *TransferStatus) AtomicStore(newTransferStatus TransferStatus) {
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+var EHashValidationOption = HashValidationOption(0)
+
+// HashValidationOption defines how strictly a download validates the MD5 hash of the downloaded data
+// against the content MD5 of its source.
+type HashValidationOption uint8
+
+// The MD5 hash isn't computed nor validated.
+func (HashValidationOption) NoCheck() HashValidationOption { return HashValidationOption(0) }
+
+// A mismatch is logged, but the transfer still succeeds.
+func (HashValidationOption) LogOnly() HashValidationOption { return HashValidationOption(1) }
+
+// A mismatch fails the transfer; a source without a content MD5 isn't validated.
+func (HashValidationOption) FailIfDifferent() HashValidationOption { return HashValidationOption(2) }
+
+// A mismatch, or a source without a content MD5, fails the transfer.
+func (HashValidationOption) FailIfDifferentOrMissing() HashValidationOption { return HashValidationOption(3) }
+
+func (hvo HashValidationOption) String() string {
+	return enum.StringInt(hvo, reflect.TypeOf(hvo))
+}
+func (hvo *HashValidationOption) Parse(s string) error {
+	val, err := enum.ParseInt(reflect.TypeOf(hvo), s, true, true)
+	if err == nil {
+		*hvo = val.(HashValidationOption)
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
 var EBlockBlobTier = BlockBlobTier(0)
 
 type BlockBlobTier uint8
@@ -438,6 +525,57 @@ func (pbt *PageBlobTier) UnmarshalJSON(b []byte) error {
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+var EBlobType = BlobType(0)
+
+// BlobType is the type of the blobs a job creates; Detect lets the engine pick it for each transfer
+type BlobType uint8
+
+func (BlobType) Detect() BlobType     { return BlobType(0) }
+func (BlobType) BlockBlob() BlobType  { return BlobType(1) }
+func (BlobType) PageBlob() BlobType   { return BlobType(2) }
+func (BlobType) AppendBlob() BlobType { return BlobType(3) }
+
+func (bt BlobType) String() string {
+	return enum.StringInt(bt, reflect.TypeOf(bt))
+}
+
+func (bt *BlobType) Parse(s string) error {
+	val, err := enum.ParseInt(reflect.TypeOf(bt), s, true, true)
+	if err == nil {
+		*bt = val.(BlobType)
+	}
+	return err
+}
+
+// FromAzBlobType returns the BlobType of an existing blob
+func (BlobType) FromAzBlobType(blobType azblob.BlobType) BlobType {
+	switch blobType {
+	case azblob.BlobBlockBlob:
+		return EBlobType.BlockBlob()
+	case azblob.BlobPageBlob:
+		return EBlobType.PageBlob()
+	case azblob.BlobAppendBlob:
+		return EBlobType.AppendBlob()
+	default:
+		return EBlobType.Detect()
+	}
+}
+
+func (bt BlobType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bt.String())
+}
+
+// Implementing UnmarshalJSON() method for type BlobType.
+func (bt *BlobType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return bt.Parse(s)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
 var ECredentialType = CredentialType(0)
 
 // CredentialType defines the different types of credentials
@@ -461,10 +599,10 @@ func (ct *CredentialType) Parse(s string) error {
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 const (
-	DefaultBlockBlobBlockSize = 100 * 1024 * 1024
-	//DefaultAppendBlobBlockSize = 4 * 1024 * 1024
-	DefaultPageBlobChunkSize  = 4 * 1024 * 1024
-	DefaultAzureFileChunkSize = 4 * 1024 * 1024
+	DefaultBlockBlobBlockSize  = 100 * 1024 * 1024
+	DefaultAppendBlobBlockSize = 4 * 1024 * 1024
+	DefaultPageBlobChunkSize   = 4 * 1024 * 1024
+	DefaultAzureFileChunkSize  = 4 * 1024 * 1024
 )
 
 // This struct represent a single transfer entry with source and destination details
@@ -482,6 +620,8 @@ type CopyTransfer struct {
 	CacheControl       string
 	ContentMD5         []byte
 	Metadata           Metadata
+	BlobType           BlobType // the type of the source blob, which the destination blob keeps
+	BlockSize          uint32   // the block size planned for the transfer, 0 if it uses the block size of the job
 	//BlobTier           string //TODO
 }
 
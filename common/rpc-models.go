@@ -20,6 +20,9 @@ func (RpcCmd) ListJobTransfers() RpcCmd { return RpcCmd("ListJobTransfers") }
 func (RpcCmd) CancelJob() RpcCmd        { return RpcCmd("CancelJob") }
 func (RpcCmd) PauseJob() RpcCmd         { return RpcCmd("PauseJob") }
 func (RpcCmd) ResumeJob() RpcCmd        { return RpcCmd("ResumeJob") }
+func (RpcCmd) MigrateJobs() RpcCmd      { return RpcCmd("MigrateJobs") }
+func (RpcCmd) CleanJobs() RpcCmd        { return RpcCmd("CleanJobs") }
+func (RpcCmd) VerifyJob() RpcCmd        { return RpcCmd("VerifyJob") }
 
 func (c RpcCmd) String() string {
 	return enum.String(c, reflect.TypeOf(c))
@@ -55,6 +58,20 @@ type CopyJobPartOrderRequest struct {
 	// commandString hold the user given command which is logged to the Job log file
 	CommandString  string
 	CredentialInfo CredentialInfo
+	// CapMbps caps the bandwidth used by the transfer engine in megabits per second until the job is done, 0 keeps the engine's default
+	CapMbps uint32
+	// CompletionHook notifies the caller once the job finishes
+	CompletionHook JobCompletionHook
+	// TTLAfterCompletion is how long the plan files of the job are kept once it finished, in seconds; 0 keeps them
+	TTLAfterCompletion uint32
+}
+
+// JobCompletionHook tells the transfer engine how to notify the caller that a job finished, i.e. completed or got cancelled.
+// The job summary, including the list of failed transfers, is delivered as JSON on the standard input of the Exec command,
+// and/or as the body of a POST to the URL. An empty field delivers nothing.
+type JobCompletionHook struct {
+	Exec string
+	URL  string
 }
 
 // CredentialInfo contains essential credential info which need be transited between modules,
@@ -82,6 +99,16 @@ type SyncJobPartOrderRequest struct {
 	FilesDeletedLocally int
 	// commandString hold the user given command which is logged to the Job log file
 	CommandString string
+	// CapMbps caps the bandwidth used by the transfer engine in megabits per second until the job is done, 0 keeps the engine's default
+	CapMbps uint32
+	// CompletionHook notifies the caller once the job finishes
+	CompletionHook JobCompletionHook
+	// TTLAfterCompletion is how long the plan files of the job are kept once it finished, in seconds; 0 keeps them
+	TTLAfterCompletion uint32
+	// Priority determines the job's share of the transfer engine when other jobs are running
+	Priority JobPriority
+	// MD5ValidationOption determines how strictly downloads validate the MD5 hash of the data against the source's
+	MD5ValidationOption HashValidationOption
 }
 
 type CopyJobPartOrderResponse struct {
@@ -97,21 +124,40 @@ type ListRequest struct {
 
 // This struct represents the optional attribute for blob request header
 type BlobTransferAttributes struct {
-	//BlobType                 BlobType // The type of a blob - BlockBlob, PageBlob, AppendBlob
-	ContentType              string        //The content type specified for the blob.
-	ContentEncoding          string        //Specifies which content encodings have been applied to the blob.
-	BlockBlobTier            BlockBlobTier // Specifies the tier to set on the block blobs.
-	PageBlobTier             PageBlobTier  // Specifies the tier to set on the page blobs.
-	Metadata                 string        //User-defined name-value pairs associated with the blob
-	NoGuessMimeType          bool          // represents user decision to interpret the content-encoding from source file
-	PreserveLastModifiedTime bool          // when downloading, tell engine to set file's timestamp to timestamp of blob
+	BlobType                 BlobType             // The type of the blobs to create - BlockBlob, PageBlob, AppendBlob, or Detect
+	ContentType              string               //The content type specified for the blob.
+	ContentEncoding          string               //Specifies which content encodings have been applied to the blob.
+	BlockBlobTier            BlockBlobTier        // Specifies the tier to set on the block blobs.
+	PageBlobTier             PageBlobTier         // Specifies the tier to set on the page blobs.
+	Metadata                 string               //User-defined name-value pairs associated with the blob
+	NoGuessMimeType          bool                 // represents user decision to interpret the content-encoding from source file
+	PreserveLastModifiedTime bool                 // when downloading, tell engine to set file's timestamp to timestamp of blob
+	MD5ValidationOption      HashValidationOption // when downloading, how strictly to validate the MD5 hash of the data against the source's
 	BlockSizeInBytes         uint32
 }
 
 // ListJobsResponse represent the Job with JobId and
 type ListJobsResponse struct {
 	ErrorMessage string
-	JobIDs       []JobID
+	Jobs         []JobDetail
+}
+
+// JobDetail represents a job whose plan files are in the azcopy folder, as recorded by its plans
+type JobDetail struct {
+	JobID JobID
+	// ErrorMsg is set when the plans of the job cannot be read, the other fields may then be incomplete
+	ErrorMsg string
+	// StartTime is the time the job was ordered, the zero time if its plans were written by a release which didn't record it
+	StartTime          time.Time
+	CommandString      string
+	CompleteJobOrdered bool
+	JobStatus          JobStatus
+	TotalTransfers     uint32
+	TransfersCompleted uint32
+	TransfersFailed    uint32
+	// TotalBytesEnumerated is the size of all the transfers of the job, and TotalBytesTransferred the size of the ones which succeeded
+	TotalBytesEnumerated  uint64
+	TotalBytesTransferred uint64
 }
 
 // ListContainerResponse represents the list of blobs within the container.
@@ -127,14 +173,30 @@ type ListJobSummaryResponse struct {
 	// TODO: added for debugging purpose. remove later
 	ActiveConnections int64
 	// CompleteJobOrdered determines whether the Job has been completely ordered or not
-	CompleteJobOrdered    bool
-	JobStatus             JobStatus
-	TotalTransfers        uint32
-	TransfersCompleted    uint32
-	TransfersFailed       uint32
-	JobProgressPercentage float64
-	BytesOverWire         uint64
-	FailedTransfers       []TransferDetail
+	CompleteJobOrdered bool
+	JobStatus          JobStatus
+	TotalTransfers     uint32
+	TransfersCompleted uint32
+	TransfersFailed    uint32
+	// TransfersFailedByStatus counts the failed transfers by their failure status, e.g. AuthFailure or NotFoundFailure
+	TransfersFailedByStatus map[string]uint32
+	JobProgressPercentage   float64
+	BytesOverWire           uint64
+	// TotalBytesEnumerated is the logical size of all the transfers of the job, and TotalBytesTransferred the part of it which is done
+	TotalBytesEnumerated  uint64
+	TotalBytesTransferred uint64
+	// TotalBytesSkipped is the part of TotalBytesTransferred which wasn't sent since the destination reads it back as zeros anyway,
+	// e.g. the empty pages of a sparse page blob
+	TotalBytesSkipped uint64
+	// EffectiveCapInMbps is the bandwidth cap currently enforced by the transfer engine, 0 means there is no cap
+	// it can be lower than the cap requested by the user while the service is pushing back
+	EffectiveCapInMbps float64
+	// ThrottleEvents is the number of throttling responses (e.g. 503 Server Busy) the transfer engine got, and
+	// BackoffTimeInSeconds the total time it held back every request because of them
+	// the transfer engine is shared by all jobs, so both count since it started rather than per job
+	ThrottleEvents       int64
+	BackoffTimeInSeconds float64
+	FailedTransfers      []TransferDetail
 }
 
 type ListJobTransfersRequest struct {
@@ -149,6 +211,71 @@ type ResumeJobRequest struct {
 	IncludeTransfer map[string]int
 	ExcludeTransfer map[string]int
 	CredentialInfo  CredentialInfo
+	// CapMbps caps the bandwidth used by the transfer engine in megabits per second until the job is done, 0 keeps the engine's default
+	CapMbps uint32
+	// CompletionHook notifies the caller once the resumed job finishes
+	CompletionHook JobCompletionHook
+}
+
+// MigrateJobsRequest asks the engine to write the plans of the jobs ordered by prior releases of azcopy
+// in its data schema version, so that they can be resumed. The zero JobID asks for every job.
+type MigrateJobsRequest struct {
+	JobID JobID
+}
+
+// JobPartMigration reports the migration of the plan of a job part
+type JobPartMigration struct {
+	JobID       JobID
+	PartNum     PartNumber
+	FromVersion Version
+	ToVersion   Version
+	ErrorMsg    string // empty if the plan was migrated
+}
+
+type MigrateJobsResponse struct {
+	Migrations []JobPartMigration
+}
+
+// CleanJobsRequest asks the engine to delete the plan files and the logs of the jobs matching all its criteria
+type CleanJobsRequest struct {
+	JobID     JobID         // the zero JobID matches every job
+	Statuses  []JobStatus   // the statuses of the jobs to clean; Completed and Cancelled if empty
+	OlderThan time.Duration // how long ago the plans of the jobs to clean must have last changed, e.g. when they finished
+}
+
+// CleanedJob reports the clean up of a job
+type CleanedJob struct {
+	JobID    JobID
+	Status   JobStatus
+	ErrorMsg string // empty if the job was cleaned up
+}
+
+type CleanJobsResponse struct {
+	CleanedJobs []CleanedJob
+}
+
+// VerifyJobRequest asks the engine to verify the integrity of the plans of a job,
+// and to rebuild the corrupt ones from their intact transfers if Repair is set
+type VerifyJobRequest struct {
+	JobID  JobID
+	Repair bool
+}
+
+// JobPartVerification reports the integrity of the plan of a job part
+type JobPartVerification struct {
+	PartNum PartNumber
+	// ErrorMsg is set if the plan can't be verified or rebuilt at all, e.g. when its header is corrupt
+	ErrorMsg         string
+	NumTransfers     uint32
+	CorruptTransfers []uint32 // the indexes of the corrupt transfers of the plan
+	// CorruptTransferSources holds the source of each corrupt transfer, or "" if it can't be read anymore
+	CorruptTransferSources []string
+	Repaired               bool // true if the plan was rebuilt from its intact transfers, without the corrupt ones
+}
+
+type VerifyJobResponse struct {
+	ErrorMsg string
+	Parts    []JobPartVerification
 }
 
 // represents the Details and details of a single transfer
@@ -156,6 +283,10 @@ type TransferDetail struct {
 	Src            string
 	Dst            string
 	TransferStatus TransferStatus
+	// ErrorCode and ServiceErrorCode are the HTTP status and the storage service error code
+	// which a failed transfer failed with, if the failure came from the service
+	ErrorCode        int32
+	ServiceErrorCode string
 }
 
 type CancelPauseResumeResponse struct {
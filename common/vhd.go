@@ -0,0 +1,60 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+)
+
+// VHDFooterSize is the size of the footer a VHD disk image ends with
+const VHDFooterSize = 512
+
+// IsFixedVHD tells whether the data is a fixed size VHD disk image, the only kind of VHD a page blob can hold.
+// A VHD ends with a footer of 512 bytes which starts with the "conectix" cookie, and which holds the disk type
+// at offset 60, 2 being a fixed disk.
+func IsFixedVHD(data []byte) bool {
+	if len(data) < VHDFooterSize || len(data)%VHDFooterSize != 0 {
+		return false
+	}
+	footer := data[len(data)-VHDFooterSize:]
+	return bytes.HasPrefix(footer, []byte("conectix")) && binary.BigEndian.Uint32(footer[60:64]) == 2
+}
+
+// IsFixedVHDFile tells whether the file of the given size at path is a fixed size VHD disk image;
+// only its footer is read.
+func IsFixedVHDFile(path string, size int64) (bool, error) {
+	if size < VHDFooterSize || size%VHDFooterSize != 0 {
+		return false, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	footer := make([]byte, VHDFooterSize)
+	if _, err := file.ReadAt(footer, size-VHDFooterSize); err != nil {
+		return false, err
+	}
+	return IsFixedVHD(footer), nil
+}
@@ -0,0 +1,77 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	chk "gopkg.in/check.v1"
+)
+
+type blockSizeTestSuite struct{}
+
+var _ = chk.Suite(&blockSizeTestSuite{})
+
+func (s *blockSizeTestSuite) TestPlanBlockSizePicksDefaultForSmallSources(c *chk.C) {
+	blockSize, err := PlanBlockSize(EBlobType.Detect(), 1024*1024*1024, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(blockSize, chk.Equals, uint32(DefaultBlockSize))
+
+	blockSize, err = PlanBlockSize(EBlobType.AppendBlob(), 1024*1024*1024, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(blockSize, chk.Equals, uint32(DefaultAppendBlobBlockSize))
+}
+
+func (s *blockSizeTestSuite) TestPlanBlockSizeGrowsForLargeSources(c *chk.C) {
+	// 500 GB would need more than 61,000 blocks of 8 MB
+	sourceSize := int64(500 * 1024 * 1024 * 1024)
+	blockSize, err := PlanBlockSize(EBlobType.BlockBlob(), sourceSize, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(blockSize%blockSizeGranularity, chk.Equals, uint32(0))
+	c.Assert((sourceSize+int64(blockSize)-1)/int64(blockSize) <= MaxNumberOfBlocksPerBlob, chk.Equals, true)
+	c.Assert(blockSize, chk.Equals, uint32(11*1024*1024))
+}
+
+func (s *blockSizeTestSuite) TestPlanBlockSizeValidatesUserBlockSize(c *chk.C) {
+	sourceSize := int64(500 * 1024 * 1024 * 1024)
+
+	// the user's block size is kept when it fits
+	blockSize, err := PlanBlockSize(EBlobType.BlockBlob(), sourceSize, 64*1024*1024)
+	c.Assert(err, chk.IsNil)
+	c.Assert(blockSize, chk.Equals, uint32(64*1024*1024))
+
+	// too many blocks
+	_, err = PlanBlockSize(EBlobType.BlockBlob(), sourceSize, 8*1024*1024)
+	c.Assert(err, chk.NotNil)
+
+	// too large a block
+	_, err = PlanBlockSize(EBlobType.BlockBlob(), 1024, MaxBlockBlobBlockSize+1)
+	c.Assert(err, chk.NotNil)
+
+	// too large a blob, whatever the block size
+	_, err = PlanBlockSize(EBlobType.BlockBlob(), MaxBlockBlobBlockSize*MaxNumberOfBlocksPerBlob+1, 0)
+	c.Assert(err, chk.NotNil)
+	_, err = PlanBlockSize(EBlobType.AppendBlob(), DefaultAppendBlobBlockSize*MaxNumberOfBlocksPerBlob+1, 0)
+	c.Assert(err, chk.NotNil)
+
+	// page blobs aren't limited in their number of writes
+	blockSize, err = PlanBlockSize(EBlobType.PageBlob(), sourceSize, 512)
+	c.Assert(err, chk.IsNil)
+	c.Assert(blockSize, chk.Equals, uint32(512))
+}
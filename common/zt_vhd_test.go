@@ -0,0 +1,69 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+
+	chk "gopkg.in/check.v1"
+)
+
+type vhdTestSuite struct{}
+
+var _ = chk.Suite(&vhdTestSuite{})
+
+// vhdData returns a disk image of the given number of sectors, ending with a VHD footer of the given disk type
+func vhdData(sectors int, diskType uint32) []byte {
+	data := make([]byte, sectors*VHDFooterSize)
+	footer := data[len(data)-VHDFooterSize:]
+	copy(footer, "conectix")
+	binary.BigEndian.PutUint32(footer[60:64], diskType)
+	return data
+}
+
+func (s *vhdTestSuite) TestIsFixedVHD(c *chk.C) {
+	c.Assert(IsFixedVHD(vhdData(4, 2)), chk.Equals, true)
+	// a dynamic disk can't be a page blob
+	c.Assert(IsFixedVHD(vhdData(4, 3)), chk.Equals, false)
+	c.Assert(IsFixedVHD(make([]byte, 4*VHDFooterSize)), chk.Equals, false)
+	c.Assert(IsFixedVHD(append(vhdData(4, 2), 0)), chk.Equals, false)
+}
+
+func (s *vhdTestSuite) TestIsFixedVHDFileReadsTheFooter(c *chk.C) {
+	file, err := ioutil.TempFile("", "vhdTest")
+	c.Assert(err, chk.IsNil)
+	defer os.Remove(file.Name())
+	data := vhdData(8, 2)
+	_, err = file.Write(data)
+	c.Assert(err, chk.IsNil)
+	c.Assert(file.Close(), chk.IsNil)
+
+	isVHD, err := IsFixedVHDFile(file.Name(), int64(len(data)))
+	c.Assert(err, chk.IsNil)
+	c.Assert(isVHD, chk.Equals, true)
+
+	// a size which isn't a whole number of sectors isn't read at all
+	isVHD, err = IsFixedVHDFile(file.Name()+".missing", int64(len(data))+1)
+	c.Assert(err, chk.IsNil)
+	c.Assert(isVHD, chk.Equals, false)
+}
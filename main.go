@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-storage-azcopy/cmd"
 	"github.com/Azure/azure-storage-azcopy/common"
@@ -48,10 +49,14 @@ func main() {
 	// Get the value of environment variable AZCOPY_CONCURRENCY_VALUE
 	// If the environment variable is set, it defines the number of concurrent connections
 	// transfer engine will spawn. If not set, transfer engine will spawn the default number
-	// of concurrent connections
+	// of concurrent connections. If set to AUTO, transfer engine will grow and shrink the number
+	// of concurrent connections based on the observed throughput, latency and throttling.
 	defaultConcurrentConnections := 300
+	autoTuneConcurrency := false
 	concurrencyValue := os.Getenv("AZCOPY_CONCURRENCY_VALUE")
-	if concurrencyValue != "" {
+	if strings.EqualFold(concurrencyValue, "AUTO") {
+		autoTuneConcurrency = true
+	} else if concurrencyValue != "" {
 		val, err := strconv.ParseInt(concurrencyValue, 10, 64)
 		if err != nil {
 			panic(fmt.Sprintf("error parsing the env azcopy_concurency_value %v. "+
@@ -59,7 +64,14 @@ func main() {
 		}
 		defaultConcurrentConnections = int(val)
 	}
-	go ste.MainSTE(defaultConcurrentConnections, 2400, azcopyAppPathFolder)
+	// Get the value of environment variable AZCOPY_CAP_MBPS
+	// If the environment variable is set, it caps the bandwidth used by the transfer engine
+	// in megabits per second, unless the job is given its own cap through --cap-mbps.
+	capMbps, err := common.GetCapMbpsFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	go ste.MainSTE(defaultConcurrentConnections, autoTuneConcurrency, capMbps, azcopyAppPathFolder)
 
 	cmd.Execute(azcopyAppPathFolder)
 	glcm.ExitWithSuccess("", common.EExitCode.Success())
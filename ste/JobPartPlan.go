@@ -3,6 +3,7 @@ package ste
 import (
 	"errors"
 	"reflect"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/Azure/azure-storage-azcopy/common"
@@ -11,14 +12,17 @@ import (
 
 // dataSchemaVersion defines the data schema version of JobPart order files supported by
 // current version of azcopy
-// To be Incremented every time when we release azcopy with changed dataSchema
-const DataSchemaVersion common.Version = 0
+// To be Incremented every time when we release azcopy with changed dataSchema; the plan files of the prior
+// versions are read by jobPartPlanReaders, so that 'azcopy jobs migrate' can bring them to the current version
+const DataSchemaVersion common.Version = 1
 
 const (
-	ContentTypeMaxBytes     = 256  // If > 65536, then jobPartPlanBlobData's ContentTypeLength's type  field must change
-	ContentEncodingMaxBytes = 256  // If > 65536, then jobPartPlanBlobData's ContentEncodingLength's type  field must change
-	MetadataMaxBytes        = 1000 // If > 65536, then jobPartPlanBlobData's MetadataLength field's type must change
-	BlobTierMaxBytes        = 10
+	ContentTypeMaxBytes      = 256  // If > 65536, then jobPartPlanBlobData's ContentTypeLength's type  field must change
+	ContentEncodingMaxBytes  = 256  // If > 65536, then jobPartPlanBlobData's ContentEncodingLength's type  field must change
+	MetadataMaxBytes         = 1000 // If > 65536, then jobPartPlanBlobData's MetadataLength field's type must change
+	BlobTierMaxBytes         = 10
+	ETagMaxBytes             = 64 // If > 255, then JobPartPlanTransfer's srcETagLength field's type must change
+	ServiceErrorCodeMaxBytes = 64 // If > 255, then JobPartPlanTransfer's serviceErrorCodeLength field's type must change
 )
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -37,19 +41,23 @@ func (mmf *JobPartPlanMMF) Unmap() { (*common.MMF)(mmf).Unmap() }
 // JobPartPlanHeader represents the header of Job Part's memory-mapped file
 type JobPartPlanHeader struct {
 	// Once set, the following fields are constants; they should never be modified
+	// Version must remain the first field of the header in every data schema version
 	Version             common.Version     // The version of data schema format of header; see the dataSchemaVersion constant
 	JobID               common.JobID       // Job Part's JobID
 	PartNum             common.PartNumber  // Job Part's part number (0+)
 	IsFinalPart         bool               // True if this is the Job's last part; else false
 	ForceWrite          bool               // True if the existing blobs needs to be overwritten.
 	Priority            common.JobPriority // The Job Part's priority
-	TTLAfterCompletion  uint32             // Time to live after completion is used to persists the file on disk of specified time after the completion of JobPartOrder
+	TTLAfterCompletion  uint32             // Time to live after completion is used to persists the file on disk of specified time after the completion of JobPartOrder, in seconds; 0 keeps it
+	StartTime           int64              // The time the job part was ordered as nanoseconds since the Unix epoch; part 0's is the start time of the job
 	FromTo              common.FromTo      // The location of the transfer's source & destination
 	CommandStringLength uint32
 	NumTransfers        uint32              // The number of transfers in the Job part
 	LogLevel            common.LogLevel     // This Job Part's minimal log level
 	DstBlobData         JobPartPlanDstBlob  // Additional data for blob destinations
 	DstLocalData        JobPartPlanDstLocal // Additional data for local destinations
+	// Checksum is the checksum of the fields above and of the command string, see checkJobPartPlanHeader
+	Checksum uint32
 
 	// Any fields below this comment are NOT constants; they may change over as the job part is processed.
 	// Care must be taken to read/write to these fields in a thread-safe way!
@@ -154,12 +162,39 @@ func (jpph *JobPartPlanHeader) TransferSrcHTTPHeadersAndMetadata(transferIndex u
 	return
 }
 
+// TransferChunkRecord returns the completed-chunk record of a transfer at given transferIndex in JobPartOrder
+// The record is memory mapped: its words must be accessed atomically, as many chunks of the transfer update it concurrently
+func (jpph *JobPartPlanHeader) TransferChunkRecord(transferIndex uint32) []uint32 {
+	jppt := jpph.Transfer(transferIndex)
+
+	record := []uint32{}
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&record))
+	sh.Data = uintptr(unsafe.Pointer(jpph)) + uintptr(jppt.ChunkRecordOffset) // Address of Job Part Plan + this transfer's chunk record offset
+	sh.Len = int(jppt.ChunkRecordLength / 4)
+	sh.Cap = sh.Len
+
+	return record
+}
+
+// chunkRecordLength returns the length in bytes of the completed-chunk record of a transfer of the given size
+// It is rounded up to whole 32-bit words, so that the bits can be set atomically
+func chunkRecordLength(sourceSize int64, blockSize uint32) int32 {
+	if sourceSize <= 0 || blockSize == 0 {
+		return 0
+	}
+	numChunks := (sourceSize + int64(blockSize) - 1) / int64(blockSize)
+	return int32((numChunks+31)/32) * 4
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // JobPartPlanDstBlob holds additional settings required when the destination is a blob
 type JobPartPlanDstBlob struct {
 	// Once set, the following fields are constants; they should never be modified
 
+	// Specifies the type of the blobs created by an upload
+	BlobType common.BlobType
+
 	// represents user decision to interpret the content-encoding from source file
 	NoGuessMimeType bool
 
@@ -182,7 +217,7 @@ type JobPartPlanDstBlob struct {
 	MetadataLength uint16
 	Metadata       [MetadataMaxBytes]byte
 
-	// Specifies the maximum size of block which determines the number of chunks and chunk size of a transfer
+	// Specifies the block size of the transfers whose block size wasn't planned from the size of their source
 	BlockSize uint32
 }
 
@@ -194,6 +229,9 @@ type JobPartPlanDstLocal struct {
 
 	// Specifies whether the timestamp of destination file has to be set to the modified time of source file
 	PreserveLastModifiedTime bool
+
+	// Specifies how strictly the MD5 hash of the downloaded data is validated against the content MD5 of the source
+	MD5ValidationOption common.HashValidationOption
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -226,14 +264,100 @@ type JobPartPlanTransfer struct {
 	SrcCacheControlLength       int16
 	SrcContentMD5Length         int16
 	SrcMetadataLength           int16
+	// SrcBlobType is the type of the source blob of a blob to blob copy, which the destination blob keeps
+	SrcBlobType common.BlobType
+	// BlockSize is the size of the chunks the transfer is split into, planned from the size of its source
+	// when the job was ordered, so that a resumed transfer splits it the same way
+	BlockSize uint32
 	//SrcBlobTierLength           int16
 
+	// ChunkRecordOffset represents the offset of the transfer's completed-chunk record written in JobPartOrder file
+	// The record is a bitmap with one bit per chunk of the transfer's BlockSize bytes, set once the chunk has been transferred,
+	// so that a resumed transfer only transfers the chunks that are missing
+	ChunkRecordOffset int64
+	// ChunkRecordLength represents the length of the completed-chunk record in bytes; it is a multiple of 4
+	ChunkRecordLength int32
+	// Checksum is the checksum of the fields above and of the transfer's strings, see checkJobPartPlanTransfer
+	Checksum uint32
+
 	// Any fields below this comment are NOT constants; they may change over as the transfer is processed.
 	// Care must be taken to read/write to these fields in a thread-safe way!
 
 	// transferStatus_doNotUse represents the status of current transfer (TransferInProgress, TransferFailed or TransfersCompleted)
 	// transferStatus_doNotUse should not be directly accessed anywhere except by transferStatus and setTransferStatus
 	atomicTransferStatus common.TransferStatus
+
+	// srcETag represents the ETag of the source when the transfer started downloading it, so that a resumed transfer
+	// can tell whether the chunks it downloaded before still belong to the same content.
+	// It is only set by the transfer's prologue, before any of its chunks is scheduled.
+	srcETagLength uint8
+	srcETag       [ETagMaxBytes]byte
+
+	// errorCode and serviceErrorCode represent the HTTP status and the storage service error code of the error
+	// which failed the transfer, if the failure came from the service, so that failures can be triaged from the plan.
+	// They are only set by the call which failed the transfer, right after its status.
+	errorCode              int32
+	serviceErrorCodeLength uint8
+	serviceErrorCode       [ServiceErrorCodeMaxBytes]byte
+
+	// atomicBytesSkipped is the part of the source which the transfer counted as done without sending it, see jobPartMgr.bytesSkipped.
+	// It's recorded in the plan, so that the summary of a resumed job still counts the bytes skipped by the previous attempts.
+	atomicBytesSkipped int64
+}
+
+// SourceETag returns the ETag recorded for the transfer's source, if any
+func (jppt *JobPartPlanTransfer) SourceETag() string {
+	return string(jppt.srcETag[:jppt.srcETagLength])
+}
+
+// SetSourceETag records the ETag of the transfer's source; an ETag too long to be recorded is ignored
+func (jppt *JobPartPlanTransfer) SetSourceETag(etag string) {
+	if len(etag) > len(jppt.srcETag) {
+		etag = ""
+	}
+	jppt.srcETagLength = uint8(copy(jppt.srcETag[:], etag))
+}
+
+// BytesSkipped returns the bytes the transfer counted as done without sending them
+func (jppt *JobPartPlanTransfer) BytesSkipped() int64 {
+	return atomic.LoadInt64(&jppt.atomicBytesSkipped)
+}
+
+// AddToBytesSkipped records bytes the transfer counted as done without sending them
+func (jppt *JobPartPlanTransfer) AddToBytesSkipped(value int64) int64 {
+	return atomic.AddInt64(&jppt.atomicBytesSkipped, value)
+}
+
+// ResetBytesSkipped forgets the bytes the transfer skipped before, and returns them
+func (jppt *JobPartPlanTransfer) ResetBytesSkipped() int64 {
+	return atomic.SwapInt64(&jppt.atomicBytesSkipped, 0)
+}
+
+// ErrorCode returns the HTTP status and the service error code recorded for the transfer's failure, if any
+func (jppt *JobPartPlanTransfer) ErrorCode() (int32, string) {
+	return atomic.LoadInt32(&jppt.errorCode), string(jppt.serviceErrorCode[:jppt.serviceErrorCodeLength])
+}
+
+// SetTransferFailure fails the transfer with the given status, unless it has failed already,
+// and records the HTTP status and the service error code of the failure; a service error code
+// too long to be recorded is truncated
+func (jppt *JobPartPlanTransfer) SetTransferFailure(status common.TransferStatus, errorCode int32, serviceErrorCode string) {
+	failed := common.AtomicMorphInt32((*int32)(&jppt.atomicTransferStatus),
+		func(startVal int32) (val int32, morphResult interface{}) {
+			// the first failure wins, like for SetTransferStatus
+			return common.Iffint32(startVal < 0, startVal, int32(status)), startVal >= 0
+		}).(bool)
+	if !failed {
+		return
+	}
+	jppt.serviceErrorCodeLength = uint8(copy(jppt.serviceErrorCode[:], serviceErrorCode))
+	atomic.StoreInt32(&jppt.errorCode, errorCode)
+}
+
+// resetTransferFailure clears the error code of a failed transfer which is about to be retried
+func (jppt *JobPartPlanTransfer) resetTransferFailure() {
+	atomic.StoreInt32(&jppt.errorCode, 0)
+	jppt.serviceErrorCodeLength = 0
 }
 
 // TransferStatus returns the transfer's status
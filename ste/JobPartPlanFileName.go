@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"reflect"
 	"strings"
 	"time"
 	"unsafe"
@@ -22,29 +21,31 @@ func (jppfn *JobPartPlanFileName) GetJobPartPlanPath() string {
 
 const jobPartPlanFileNameFormat = "%v--%05d.steV%d"
 
-// TODO: This needs testing
+// Parse returns the job ID and the part number of a plan file of the current data schema version
 func (jpfn JobPartPlanFileName) Parse() (jobID common.JobID, partNumber common.PartNumber, err error) {
-	var dataSchemaVersion common.Version
-	//n, err := fmt.Sscanf(string(jpfn), jobPartPlanFileNameFormat, &jobID, &partNumber, &dataSchemaVersion)
-	//if err != nil || n != 3 {
-	//	panic(err)
-	//}
-	//if dataSchemaVersion != DataSchemaVersion {
-	//	err = fmt.Errorf("job part Plan file's data schema version ('%d') doesn't match whatthis app requires ('%d')", dataSchemaVersion, DataSchemaVersion)
-	//}
-	//TODO: confirm the alternative approach. fmt.Sscanf not working for reading back string into struct JobId.
+	jobID, partNumber, dataSchemaVersion, err := jpfn.parseWithVersion()
+	if err == nil && dataSchemaVersion != DataSchemaVersion {
+		err = fmt.Errorf("job part Plan file's data schema version ('%d') doesn't match what this app requires ('%d')", dataSchemaVersion, DataSchemaVersion)
+	}
+	return
+}
+
+// parseWithVersion returns the job ID, the part number and the data schema version of a plan file, whichever its version
+func (jpfn JobPartPlanFileName) parseWithVersion() (jobID common.JobID, partNumber common.PartNumber, dataSchemaVersion common.Version, err error) {
+	// fmt.Sscanf can't read the JobID back, so the name is split around the "--" separating it from the rest
 	jpfnSplit := strings.Split(string(jpfn), "--")
-	jobId, err := common.ParseJobID(jpfnSplit[0])
+	if len(jpfnSplit) != 2 {
+		err = fmt.Errorf("%s isn't the name of a JobPartPlanFile", string(jpfn))
+		return
+	}
+	jobID, err = common.ParseJobID(jpfnSplit[0])
 	if err != nil {
 		err = fmt.Errorf("failed to parse the JobId from JobPartFileName %s. Failed with error %s", string(jpfn), err.Error())
+		return
 	}
-	jobID = jobId
 	n, err := fmt.Sscanf(jpfnSplit[1], "%05d.steV%d", &partNumber, &dataSchemaVersion)
 	if err != nil || n != 2 {
-		panic(err)
-	}
-	if dataSchemaVersion != DataSchemaVersion {
-		err = fmt.Errorf("job part Plan file's data schema version ('%d') doesn't match whatthis app requires ('%d')", dataSchemaVersion, DataSchemaVersion)
+		err = fmt.Errorf("failed to parse the part number and the data schema version from JobPartFileName %s", string(jpfn))
 	}
 	return
 }
@@ -53,28 +54,34 @@ func (jpfn JobPartPlanFileName) Delete() error {
 	return os.Remove(string(jpfn))
 }
 
-func (jpfn JobPartPlanFileName) Map() *JobPartPlanMMF {
+func (jpfn JobPartPlanFileName) Map() (*JobPartPlanMMF, error) {
 	// opening the file with given filename
 	file, err := os.OpenFile(jpfn.GetJobPartPlanPath(), os.O_RDWR, 0644) // TODO: Check this permission
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	// Ensure the file gets closed (although we can continue to use the MMF)
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	mmf, err := common.NewMMF(file, true, 0, fileInfo.Size())
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return (*JobPartPlanMMF)(mmf)
+	// a plan cut short while it was created, or corrupted since, mustn't be used: its offsets may point past its end
+	if err = checkJobPartPlan(mmf.Slice()); err != nil {
+		mmf.Unmap()
+		return nil, fmt.Errorf("the job part plan file %s is corrupt, 'azcopy jobs verify --repair' can rebuild it from its intact transfers: %v", jpfn, err)
+	}
+	return (*JobPartPlanMMF)(mmf), nil
 }
 
 // createJobPartPlanFile creates the memory map JobPartPlanHeader using the given JobPartOrder and JobPartPlanBlobData
-func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
+// startTime is the time the job part was ordered; it's checksummed with the rest of the header, so it can't be set afterwards
+func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest, startTime time.Time) {
 	// Validate that the passed-in strings can fit in their respective fields
 	if len(order.BlobAttributes.ContentType) > len(JobPartPlanDstBlob{}.ContentType) {
 		panic(fmt.Errorf("content type string it too large: %q", order.BlobAttributes.ContentType))
@@ -88,15 +95,12 @@ func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
 
 	// This nested function writes a structure value to an io.Writer & returns the number of bytes written
 	writeValue := func(writer io.Writer, v interface{}) int64 {
-		rv := reflect.ValueOf(v)
-		structSize := reflect.TypeOf(v).Elem().Size()
-		slice := reflect.SliceHeader{Data: rv.Pointer(), Len: int(structSize), Cap: int(structSize)}
-		byteSlice := *(*[]byte)(unsafe.Pointer(&slice))
+		byteSlice := structBytes(v)
 		err := binary.Write(writer, binary.LittleEndian, byteSlice)
 		if err != nil {
 			panic(err)
 		}
-		return int64(structSize)
+		return int64(len(byteSlice))
 	}
 
 	eof := int64(0)
@@ -119,19 +123,10 @@ func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
 	defer file.Close()
 
 	// if block size from the front-end is set to 0, block size is set to default block size
+	// the transfers whose block size was planned from the size of their source use theirs instead
 	blockSize := order.BlobAttributes.BlockSizeInBytes
-	if blockSize == 0 { // TODO: Fix below
-		blockSize = common.DefaultBlockBlobBlockSize
-		/*switch order.BlobAttributes.BlobType {
-		case common.BlobType{}.Block():
-			blockSize = common.DefaultBlockBlobBlockSize
-		case common.BlobType{}.Append():
-			blockSize = common.DefaultAppendBlobBlockSize
-		case common.BlobType{}.Page():
-			blockSize = common.DefaultPageBlobChunkSize
-		default:
-			panic(errors.New("unrecognized blob type"))
-		}*/
+	if blockSize == 0 {
+		blockSize = common.DefaultBlockSize
 	}
 	// Initialize the Job Part's Plan header
 	jpph := JobPartPlanHeader{
@@ -141,13 +136,13 @@ func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
 		IsFinalPart:         order.IsFinalPart,
 		ForceWrite:          order.ForceWrite,
 		Priority:            order.Priority,
-		TTLAfterCompletion:  uint32(time.Time{}.Nanosecond()),
+		TTLAfterCompletion:  order.TTLAfterCompletion,
 		FromTo:              order.FromTo,
 		CommandStringLength: uint32(len(order.CommandString)),
 		NumTransfers:        uint32(len(order.Transfers)),
 		LogLevel:            order.LogLevel,
 		DstBlobData: JobPartPlanDstBlob{
-			//BlobType:              order.OptionalAttributes.BlobType,
+			BlobType:              order.BlobAttributes.BlobType,
 			NoGuessMimeType:       order.BlobAttributes.NoGuessMimeType,
 			ContentTypeLength:     uint16(len(order.BlobAttributes.ContentType)),
 			ContentEncodingLength: uint16(len(order.BlobAttributes.ContentEncoding)),
@@ -158,14 +153,20 @@ func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
 		},
 		DstLocalData: JobPartPlanDstLocal{
 			PreserveLastModifiedTime: order.BlobAttributes.PreserveLastModifiedTime,
+			MD5ValidationOption:      order.BlobAttributes.MD5ValidationOption,
 		},
 		atomicJobStatus: common.EJobStatus.InProgress(), // We default to InProgress
 	}
 
+	if !startTime.IsZero() {
+		jpph.StartTime = startTime.UnixNano()
+	}
+
 	// Copy any strings into their respective fields
 	copy(jpph.DstBlobData.ContentType[:], order.BlobAttributes.ContentType)
 	copy(jpph.DstBlobData.ContentEncoding[:], order.BlobAttributes.ContentEncoding)
 	copy(jpph.DstBlobData.Metadata[:], order.BlobAttributes.Metadata)
+	jpph.Checksum = planChecksum(structBytes(&jpph)[:unsafe.Offsetof(jpph.Checksum)], []byte(order.CommandString))
 
 	eof += writeValue(file, &jpph)
 
@@ -178,6 +179,9 @@ func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
 
 	// srcDstStringsOffset points to after the header & all the transfers; this is where the src/dst strings go for each transfer
 	srcDstStringsOffset := make([]int64, jpph.NumTransfers)
+	// each transfer's completed-chunk record comes after its strings, aligned so that its words can be accessed atomically
+	chunkRecordOffset := make([]int64, jpph.NumTransfers)
+	chunkRecordLengths := make([]int32, jpph.NumTransfers)
 
 	// Initialize the offset for the 1st transfer's src/dst strings
 	currentSrcStringOffset := eof + int64(unsafe.Sizeof(JobPartPlanTransfer{}))*int64(jpph.NumTransfers)
@@ -187,14 +191,15 @@ func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
 		// Prepare info for JobPartPlanTransfer
 		// Sending Metadata type to Transfer could ensure strong type validation.
 		// TODO: discuss the performance drop of marshaling metadata twice
-		srcMetadataLength := 0
+		metadataStr := ""
 		if order.Transfers[t].Metadata != nil {
-			metadataStr, err := order.Transfers[t].Metadata.Marshal()
+			var err error
+			metadataStr, err = order.Transfers[t].Metadata.Marshal()
 			if err != nil {
 				panic(err)
 			}
-			srcMetadataLength = len(metadataStr)
 		}
+		srcMetadataLength := len(metadataStr)
 		// Create & initialize this transfer's Job Part Plan Transfer
 		jppt := JobPartPlanTransfer{
 			SrcOffset:      currentSrcStringOffset, // SrcOffset of the src string
@@ -211,20 +216,34 @@ func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
 			SrcCacheControlLength:       int16(len(order.Transfers[t].CacheControl)),
 			SrcContentMD5Length:         int16(len(order.Transfers[t].ContentMD5)),
 			SrcMetadataLength:           int16(srcMetadataLength),
+			SrcBlobType:                 order.Transfers[t].BlobType,
+			BlockSize:                   common.Iffuint32(order.Transfers[t].BlockSize == 0, blockSize, order.Transfers[t].BlockSize),
 			// SrcBlobTierLength:           uint16(len(order.Transfers[t].BlobTier)),
 			// TODO: + Metadata
 
 			atomicTransferStatus: common.ETransferStatus.NotStarted(), // Default
 			//ChunkNum:                getNumChunks(uint64(order.Transfers[t].SourceSize), uint64(data.BlockSize)),
 		}
+		// The completed-chunk record starts at the first 4-byte boundary after the src/dst strings
+		stringsEnd := currentSrcStringOffset + int64(jppt.SrcLength+jppt.DstLength+jppt.SrcContentTypeLength+
+			jppt.SrcContentEncodingLength+jppt.SrcContentLanguageLength+jppt.SrcContentDispositionLength+
+			jppt.SrcCacheControlLength+jppt.SrcContentMD5Length+jppt.SrcMetadataLength)
+		jppt.ChunkRecordOffset = (stringsEnd + 3) &^ 3
+		jppt.ChunkRecordLength = chunkRecordLength(jppt.SourceSize, jppt.BlockSize)
+		// The checksum covers the strings written below, in the same order
+		jppt.Checksum = planChecksum(structBytes(&jppt)[:unsafe.Offsetof(jppt.Checksum)],
+			[]byte(order.Transfers[t].Source), []byte(order.Transfers[t].Destination), []byte(order.Transfers[t].ContentType),
+			[]byte(order.Transfers[t].ContentEncoding), []byte(order.Transfers[t].ContentLanguage), []byte(order.Transfers[t].ContentDisposition),
+			[]byte(order.Transfers[t].CacheControl), order.Transfers[t].ContentMD5, []byte(metadataStr))
+
 		eof += writeValue(file, &jppt) // Write the transfer entry
 
-		// The NEXT transfer's src/dst string come after THIS transfer's src/dst strings
+		// The NEXT transfer's src/dst string come after THIS transfer's src/dst strings and completed-chunk record
 		srcDstStringsOffset[t] = currentSrcStringOffset
+		chunkRecordOffset[t] = jppt.ChunkRecordOffset
+		chunkRecordLengths[t] = jppt.ChunkRecordLength
 
-		currentSrcStringOffset += int64(jppt.SrcLength + jppt.DstLength + jppt.SrcContentTypeLength +
-			jppt.SrcContentEncodingLength + jppt.SrcContentLanguageLength + jppt.SrcContentDispositionLength +
-			jppt.SrcCacheControlLength + jppt.SrcContentMD5Length + jppt.SrcMetadataLength)
+		currentSrcStringOffset = jppt.ChunkRecordOffset + int64(jppt.ChunkRecordLength)
 	}
 
 	// All the transfers were written; now write each each transfer's src/dst strings
@@ -303,6 +322,14 @@ func (jpfn JobPartPlanFileName) Create(order common.CopyJobPartOrderRequest) {
 			}
 			eof += int64(bytesWritten)
 		}
+
+		// Write the padding and the empty completed-chunk record; no chunk has been transferred yet
+		recordEnd := chunkRecordOffset[t] + int64(chunkRecordLengths[t])
+		bytesWritten, err = file.Write(make([]byte, recordEnd-eof))
+		if err != nil {
+			panic(err)
+		}
+		eof += int64(bytesWritten)
 		// if len(order.Transfers[t].BlobTier) != 0 {
 		// 	bytesWritten, err = file.WriteString(order.Transfers[t].BlobTier)
 		// 	if err != nil {
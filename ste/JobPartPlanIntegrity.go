@@ -0,0 +1,142 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"unsafe"
+)
+
+// The constant fields of a plan are checksummed when the plan is created, so that a plan which was cut short
+// while it was written, or corrupted since, is detected before it's used. The fields which change as the job
+// part is processed, which follow the checksum in the header and in each transfer, can't be checksummed;
+// the lengths among them are only checked to be within their fields.
+
+// structBytes returns the bytes of the structure v points to, as they're written to the plan file
+func structBytes(v interface{}) []byte {
+	rv := reflect.ValueOf(v)
+	size := int(rv.Elem().Type().Size())
+	structSlice := []byte{}
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&structSlice))
+	sh.Data = rv.Pointer()
+	sh.Len = size
+	sh.Cap = sh.Len
+	return structSlice
+}
+
+// planChecksum returns the checksum of the given bytes of a plan, taken one after the other
+func planChecksum(parts ...[]byte) uint32 {
+	checksum := uint32(0)
+	for _, part := range parts {
+		checksum = crc32.Update(checksum, crc32.IEEETable, part)
+	}
+	return checksum
+}
+
+// checkJobPartPlanHeader verifies the header of a plan of the current data schema version, and returns it: its checksum,
+// which also covers the command string, must match, and its transfer table must fit in the plan
+func checkJobPartPlanHeader(plan []byte) (h JobPartPlanHeader, err error) {
+	if err = copyFromPlan(plan, 0, &h); err != nil {
+		return
+	}
+	if h.Version != DataSchemaVersion {
+		return h, fmt.Errorf("the plan has data schema version %d, not %d", h.Version, DataSchemaVersion)
+	}
+	headerSize := int64(unsafe.Sizeof(h))
+	commandStringEnd := headerSize + int64(h.CommandStringLength)
+	if commandStringEnd > int64(len(plan)) {
+		return h, fmt.Errorf("the command string of %d bytes is past the end of the plan, %d bytes long", h.CommandStringLength, len(plan))
+	}
+	if checksum := planChecksum(plan[:unsafe.Offsetof(h.Checksum)], plan[headerSize:commandStringEnd]); checksum != h.Checksum {
+		return h, fmt.Errorf("the header is corrupt, its checksum is %08x rather than %08x", checksum, h.Checksum)
+	}
+	return h, checkTransferTable(plan, commandStringEnd, h.NumTransfers, unsafe.Sizeof(JobPartPlanTransfer{}))
+}
+
+// checkJobPartPlanTransfer verifies the transfer at the given offset of a plan of the current data schema version:
+// its strings and its completed-chunk record must fit in the plan, and its checksum, which also covers its strings, must match
+func checkJobPartPlanTransfer(plan []byte, offset int64) error {
+	var t JobPartPlanTransfer
+	if err := copyFromPlan(plan, offset, &t); err != nil {
+		return err
+	}
+	stringsLength := int64(0)
+	for _, length := range []int16{t.SrcLength, t.DstLength, t.SrcContentTypeLength, t.SrcContentEncodingLength, t.SrcContentLanguageLength,
+		t.SrcContentDispositionLength, t.SrcCacheControlLength, t.SrcContentMD5Length, t.SrcMetadataLength} {
+		if length < 0 {
+			return fmt.Errorf("a string of the transfer has the negative length %d", length)
+		}
+		stringsLength += int64(length)
+	}
+	if t.SrcOffset < 0 || t.SrcOffset+stringsLength > int64(len(plan)) {
+		return fmt.Errorf("the strings of %d bytes at offset %d are past the end of the plan, %d bytes long", stringsLength, t.SrcOffset, len(plan))
+	}
+	if t.ChunkRecordOffset < 0 || t.ChunkRecordOffset%4 != 0 || t.ChunkRecordLength < 0 || t.ChunkRecordLength%4 != 0 ||
+		t.ChunkRecordOffset+int64(t.ChunkRecordLength) > int64(len(plan)) {
+		return fmt.Errorf("the completed-chunk record of %d bytes at offset %d doesn't fit in the plan, %d bytes long",
+			t.ChunkRecordLength, t.ChunkRecordOffset, len(plan))
+	}
+	if checksum := planChecksum(plan[offset:offset+int64(unsafe.Offsetof(t.Checksum))], plan[t.SrcOffset:t.SrcOffset+stringsLength]); checksum != t.Checksum {
+		return fmt.Errorf("the transfer is corrupt, its checksum is %08x rather than %08x", checksum, t.Checksum)
+	}
+	if int(t.srcETagLength) > len(t.srcETag) || int(t.serviceErrorCodeLength) > len(t.serviceErrorCode) {
+		return fmt.Errorf("the recorded ETag or service error code of the transfer is longer than its field")
+	}
+	return nil
+}
+
+// verifyJobPartPlan verifies a plan of the current data schema version. It returns an error if the header of the plan is corrupt,
+// in which case none of its transfers can be trusted, otherwise the indexes of the transfers which are corrupt, if any
+func verifyJobPartPlan(plan []byte) (corruptTransfers []uint32, err error) {
+	h, err := checkJobPartPlanHeader(plan)
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(unsafe.Sizeof(h)) + int64(h.CommandStringLength)
+	for t := uint32(0); t < h.NumTransfers; t++ {
+		if checkJobPartPlanTransfer(plan, offset) != nil {
+			corruptTransfers = append(corruptTransfers, t)
+		}
+		offset += int64(unsafe.Sizeof(JobPartPlanTransfer{}))
+	}
+	return corruptTransfers, nil
+}
+
+// checkJobPartPlan returns an error if any part of a plan of the current data schema version is corrupt
+func checkJobPartPlan(plan []byte) error {
+	corruptTransfers, err := verifyJobPartPlan(plan)
+	if err != nil || len(corruptTransfers) == 0 {
+		return err
+	}
+	return corruptTransfersError(corruptTransfers)
+}
+
+// corruptTransfersError lists the indexes of the corrupt transfers of a plan
+func corruptTransfersError(corruptTransfers []uint32) error {
+	indexes := make([]string, len(corruptTransfers))
+	for i, t := range corruptTransfers {
+		indexes[i] = fmt.Sprint(t)
+	}
+	return fmt.Errorf("%d of the transfers are corrupt: %s", len(corruptTransfers), strings.Join(indexes, ", "))
+}
@@ -0,0 +1,428 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+// jobPartPlanContents is what a job part plan holds, independently of the layout of the plan file,
+// so that a plan written by a prior release of azcopy can be written again in the current data schema version
+type jobPartPlanContents struct {
+	order            common.CopyJobPartOrderRequest // the order the plan was created from
+	startTime        time.Time                      // the zero time if the data schema version didn't record it
+	jobStatus        common.JobStatus
+	transferStatuses []common.TransferStatus
+}
+
+// jobPartPlanReaders reads the plan files of each data schema version released so far, the current one included.
+// Whenever the layout of the plan changes, DataSchemaVersion must be incremented, the layout of the previous version
+// frozen below along with a reader for it, and golden plan files of the new version added to the tests.
+var jobPartPlanReaders = map[common.Version]func(plan []byte) (jobPartPlanContents, error){
+	0: readJobPartPlanV0,
+	1: readJobPartPlanV1,
+}
+
+// migrateJobPartPlan writes the plan file of a prior data schema version again in the current version,
+// then deletes the prior one; the plan file keeps its job ID and part number
+func migrateJobPartPlan(jpfn JobPartPlanFileName) error {
+	jobID, partNum, version, err := jpfn.parseWithVersion()
+	if err != nil {
+		return err
+	}
+	contents, err := readJobPartPlanFile(jpfn.GetJobPartPlanPath(), version)
+	if err != nil {
+		return err
+	}
+	if contents.order.JobID != jobID || contents.order.PartNum != partNum {
+		return fmt.Errorf("the plan %s is the plan of part %d of job %s", jpfn, contents.order.PartNum, contents.order.JobID)
+	}
+
+	if err = writeJobPartPlan(JobsAdmin.NewJobPartPlanFileName(jobID, partNum), contents); err != nil {
+		return err
+	}
+	return os.Remove(jpfn.GetJobPartPlanPath())
+}
+
+// readJobPartPlanFile reads the plan file at the given path, which was written in the given data schema version
+func readJobPartPlanFile(path string, version common.Version) (jobPartPlanContents, error) {
+	read, found := jobPartPlanReaders[version]
+	if !found {
+		if version > DataSchemaVersion {
+			return jobPartPlanContents{}, fmt.Errorf("the plan was written by a newer release of azcopy, data schema version %d, than this one, data schema version %d", version, DataSchemaVersion)
+		}
+		return jobPartPlanContents{}, fmt.Errorf("data schema version %d is unknown", version)
+	}
+
+	plan, err := ioutil.ReadFile(path)
+	if err != nil {
+		return jobPartPlanContents{}, err
+	}
+	contents, err := read(plan)
+	if err != nil {
+		return contents, fmt.Errorf("cannot read the plan %s: %v", filepath.Base(path), err)
+	}
+	return contents, nil
+}
+
+// writeJobPartPlan writes the contents of a plan in the current data schema version
+func writeJobPartPlan(jpfn JobPartPlanFileName, contents jobPartPlanContents) error {
+	if err := createJobPartPlanFile(jpfn, contents.order, contents.startTime); err != nil {
+		return err
+	}
+	// only the fields which aren't checksummed are set once the plan is created
+	mmf, err := jpfn.Map()
+	if err != nil {
+		return err
+	}
+	defer mmf.Unmap()
+	plan := mmf.Plan()
+	plan.SetJobStatus(contents.jobStatus)
+	for t, status := range contents.transferStatuses {
+		plan.Transfer(uint32(t)).SetTransferStatus(status, true)
+	}
+	return nil
+}
+
+// walkJobPartPlanFiles calls visit with each plan file of the plan folder, whichever its data schema version, in the order of their names
+func walkJobPartPlanFiles(planDir string, visit func(jpfn JobPartPlanFileName, jobID common.JobID, partNum common.PartNumber, version common.Version)) {
+	files, err := ioutil.ReadDir(planDir)
+	if err != nil {
+		return
+	}
+	for _, fileInfo := range files {
+		if fileInfo.IsDir() {
+			continue
+		}
+		jpfn := JobPartPlanFileName(fileInfo.Name())
+		// the other files of the folder, e.g. the logs, aren't named like plan files
+		if jobID, partNum, version, err := jpfn.parseWithVersion(); err == nil {
+			visit(jpfn, jobID, partNum, version)
+		}
+	}
+}
+
+// otherJobPlanVersions returns the data schema versions other than the current one of the plan files of the given job
+func otherJobPlanVersions(jobID common.JobID) []common.Version {
+	var versions []common.Version
+	walkJobPartPlanFiles(JobsAdmin.AppPathFolder(), func(_ JobPartPlanFileName, id common.JobID, _ common.PartNumber, version common.Version) {
+		if id == jobID && version != DataSchemaVersion {
+			versions = append(versions, version)
+		}
+	})
+	return versions
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// copyFromPlan copies the bytes of the plan at the given offset into the structure v points to,
+// so that the structure can be read whatever the alignment of the offset
+func copyFromPlan(plan []byte, offset int64, v interface{}) error {
+	dst := structBytes(v)
+	size := int64(len(dst))
+	if offset < 0 || offset+size > int64(len(plan)) {
+		return fmt.Errorf("the %d bytes at offset %d are past the end of the plan, %d bytes long", size, offset, len(plan))
+	}
+	copy(dst, plan[offset:offset+size])
+	return nil
+}
+
+// stringFromPlan returns the string of the given length at the given offset of the plan
+func stringFromPlan(plan []byte, offset int64, length int64) (string, error) {
+	if offset < 0 || length < 0 || offset+length > int64(len(plan)) {
+		return "", fmt.Errorf("the string of %d bytes at offset %d is past the end of the plan, %d bytes long", length, offset, len(plan))
+	}
+	return string(plan[offset : offset+length]), nil
+}
+
+// stringFromField returns the string of the given length held by a fixed-size field of the plan
+func stringFromField(field []byte, length uint16) (string, error) {
+	if int(length) > len(field) {
+		return "", fmt.Errorf("the string of %d bytes doesn't fit in its field of %d bytes", length, len(field))
+	}
+	return string(field[:length]), nil
+}
+
+// readPlanTransferStrings reads the strings of a transfer, which every version of the plan writes one after the other
+// from the transfer's SrcOffset: the source, the destination, then the properties of the source in the order of lengths
+func readPlanTransferStrings(plan []byte, offset int64, lengths [9]int16, t *common.CopyTransfer) error {
+	var contentMD5, metadata string
+	fields := [9]*string{&t.Source, &t.Destination, &t.ContentType, &t.ContentEncoding, &t.ContentLanguage,
+		&t.ContentDisposition, &t.CacheControl, &contentMD5, &metadata}
+	for i, length := range lengths {
+		s, err := stringFromPlan(plan, offset, int64(length))
+		if err != nil {
+			return err
+		}
+		*fields[i] = s
+		offset += int64(length)
+	}
+
+	if contentMD5 != "" {
+		t.ContentMD5 = []byte(contentMD5)
+	}
+	if metadata != "" {
+		var err error
+		if t.Metadata, err = common.UnMarshalToCommonMetadata(metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTransferTable verifies that the transfer table of a plan, which starts at the given offset, fits in the plan
+func checkTransferTable(plan []byte, offset int64, numTransfers uint32, transferSize uintptr) error {
+	if end := offset + int64(numTransfers)*int64(transferSize); end > int64(len(plan)) {
+		return fmt.Errorf("the %d transfers end at offset %d, past the end of the plan, %d bytes long", numTransfers, end, len(plan))
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// readJobPartPlanV1 reads a plan file of data schema version 1, the current one
+func readJobPartPlanV1(plan []byte) (c jobPartPlanContents, err error) {
+	c, corruptTransfers, err := readIntactJobPartPlan(plan)
+	if err == nil && len(corruptTransfers) > 0 {
+		err = corruptTransfersError(corruptTransfers)
+	}
+	return
+}
+
+// readIntactJobPartPlan reads a plan file of the current data schema version, leaving out the transfers which are corrupt;
+// it returns their indexes in the plan. It fails if the header of the plan is corrupt.
+func readIntactJobPartPlan(plan []byte) (c jobPartPlanContents, corruptTransfers []uint32, err error) {
+	h, err := checkJobPartPlanHeader(plan)
+	if err != nil {
+		return
+	}
+	if c, err = orderFromPlanHeader(plan, &h); err != nil {
+		return
+	}
+	offset := int64(unsafe.Sizeof(h)) + int64(h.CommandStringLength)
+
+	c.order.Transfers = make([]common.CopyTransfer, 0, h.NumTransfers)
+	c.transferStatuses = make([]common.TransferStatus, 0, h.NumTransfers)
+	for i := uint32(0); i < h.NumTransfers; i++ {
+		transferOffset := offset
+		offset += int64(unsafe.Sizeof(JobPartPlanTransfer{}))
+		if checkJobPartPlanTransfer(plan, transferOffset) != nil {
+			corruptTransfers = append(corruptTransfers, i)
+			continue
+		}
+		var t JobPartPlanTransfer
+		if err = copyFromPlan(plan, transferOffset, &t); err != nil {
+			return
+		}
+
+		transfer := common.CopyTransfer{
+			LastModifiedTime: time.Unix(0, t.ModifiedTime),
+			SourceSize:       t.SourceSize,
+			BlobType:         t.SrcBlobType,
+			BlockSize:        t.BlockSize,
+		}
+		if err = readPlanTransferStrings(plan, t.SrcOffset, [9]int16{t.SrcLength, t.DstLength, t.SrcContentTypeLength,
+			t.SrcContentEncodingLength, t.SrcContentLanguageLength, t.SrcContentDispositionLength, t.SrcCacheControlLength,
+			t.SrcContentMD5Length, t.SrcMetadataLength}, &transfer); err != nil {
+			return c, corruptTransfers, fmt.Errorf("transfer %d: %v", i, err)
+		}
+		c.order.Transfers = append(c.order.Transfers, transfer)
+		c.transferStatuses = append(c.transferStatuses, t.atomicTransferStatus)
+	}
+	return
+}
+
+// orderFromPlanHeader reads the order of a plan, but its transfers, from its header, which has been read into h and verified.
+// The command string comes right after the header.
+func orderFromPlanHeader(plan []byte, h *JobPartPlanHeader) (c jobPartPlanContents, err error) {
+	c.order = common.CopyJobPartOrderRequest{
+		JobID:              h.JobID,
+		PartNum:            h.PartNum,
+		IsFinalPart:        h.IsFinalPart,
+		ForceWrite:         h.ForceWrite,
+		Priority:           h.Priority,
+		FromTo:             h.FromTo,
+		LogLevel:           h.LogLevel,
+		TTLAfterCompletion: h.TTLAfterCompletion,
+		BlobAttributes: common.BlobTransferAttributes{
+			BlobType:                 h.DstBlobData.BlobType,
+			BlockBlobTier:            h.DstBlobData.BlockBlobTier,
+			PageBlobTier:             h.DstBlobData.PageBlobTier,
+			NoGuessMimeType:          h.DstBlobData.NoGuessMimeType,
+			PreserveLastModifiedTime: h.DstLocalData.PreserveLastModifiedTime,
+			MD5ValidationOption:      h.DstLocalData.MD5ValidationOption,
+			BlockSizeInBytes:         h.DstBlobData.BlockSize,
+		},
+	}
+	if h.StartTime != 0 {
+		c.startTime = time.Unix(0, h.StartTime)
+	}
+	c.jobStatus = h.atomicJobStatus
+	if c.order.BlobAttributes.ContentType, err = stringFromField(h.DstBlobData.ContentType[:], h.DstBlobData.ContentTypeLength); err != nil {
+		return
+	}
+	if c.order.BlobAttributes.ContentEncoding, err = stringFromField(h.DstBlobData.ContentEncoding[:], h.DstBlobData.ContentEncodingLength); err != nil {
+		return
+	}
+	if c.order.BlobAttributes.Metadata, err = stringFromField(h.DstBlobData.Metadata[:], h.DstBlobData.MetadataLength); err != nil {
+		return
+	}
+
+	c.order.CommandString, err = stringFromPlan(plan, int64(unsafe.Sizeof(*h)), int64(h.CommandStringLength))
+	return
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// The layout of the plan files of data schema version 0, as written by the releases before the completed-chunk record,
+// the planned block sizes and the recorded failures were added to the plan. These structures must never change.
+
+type jobPartPlanHeaderV0 struct {
+	Version             common.Version
+	JobID               common.JobID
+	PartNum             common.PartNumber
+	IsFinalPart         bool
+	ForceWrite          bool
+	Priority            common.JobPriority
+	TTLAfterCompletion  uint32
+	FromTo              common.FromTo
+	CommandStringLength uint32
+	NumTransfers        uint32
+	LogLevel            common.LogLevel
+	DstBlobData         jobPartPlanDstBlobV0
+	DstLocalData        jobPartPlanDstLocalV0
+	atomicJobStatus     common.JobStatus
+}
+
+type jobPartPlanDstBlobV0 struct {
+	NoGuessMimeType       bool
+	ContentTypeLength     uint16
+	ContentType           [ContentTypeMaxBytes]byte
+	ContentEncodingLength uint16
+	ContentEncoding       [ContentEncodingMaxBytes]byte
+	BlockBlobTier         common.BlockBlobTier
+	PageBlobTier          common.PageBlobTier
+	MetadataLength        uint16
+	Metadata              [MetadataMaxBytes]byte
+	BlockSize             uint32
+}
+
+type jobPartPlanDstLocalV0 struct {
+	PreserveLastModifiedTime bool
+}
+
+type jobPartPlanTransferV0 struct {
+	SrcOffset                   int64
+	SrcLength                   int16
+	DstLength                   int16
+	ModifiedTime                int64
+	SourceSize                  int64
+	CompletionTime              uint64
+	SrcContentTypeLength        int16
+	SrcContentEncodingLength    int16
+	SrcContentLanguageLength    int16
+	SrcContentDispositionLength int16
+	SrcCacheControlLength       int16
+	SrcContentMD5Length         int16
+	SrcMetadataLength           int16
+	atomicTransferStatus        common.TransferStatus
+}
+
+// readJobPartPlanV0 reads a plan file of data schema version 0.
+// Its transfers keep splitting their source in chunks of the block size of the job, as they were planned to.
+// The blobs created by an upload are detected, which is how version 0 picked them.
+func readJobPartPlanV0(plan []byte) (c jobPartPlanContents, err error) {
+	var h jobPartPlanHeaderV0
+	if err = copyFromPlan(plan, 0, &h); err != nil {
+		return
+	}
+	if h.Version != 0 {
+		return c, fmt.Errorf("the plan has data schema version %d, not 0", h.Version)
+	}
+
+	c.order = common.CopyJobPartOrderRequest{
+		JobID:              h.JobID,
+		PartNum:            h.PartNum,
+		IsFinalPart:        h.IsFinalPart,
+		ForceWrite:         h.ForceWrite,
+		Priority:           h.Priority,
+		FromTo:             h.FromTo,
+		LogLevel:           h.LogLevel,
+		TTLAfterCompletion: h.TTLAfterCompletion,
+		BlobAttributes: common.BlobTransferAttributes{
+			BlobType:                 common.EBlobType.Detect(),
+			BlockBlobTier:            h.DstBlobData.BlockBlobTier,
+			PageBlobTier:             h.DstBlobData.PageBlobTier,
+			NoGuessMimeType:          h.DstBlobData.NoGuessMimeType,
+			PreserveLastModifiedTime: h.DstLocalData.PreserveLastModifiedTime,
+			BlockSizeInBytes:         h.DstBlobData.BlockSize,
+		},
+	}
+	c.jobStatus = h.atomicJobStatus
+	if c.order.BlobAttributes.ContentType, err = stringFromField(h.DstBlobData.ContentType[:], h.DstBlobData.ContentTypeLength); err != nil {
+		return
+	}
+	if c.order.BlobAttributes.ContentEncoding, err = stringFromField(h.DstBlobData.ContentEncoding[:], h.DstBlobData.ContentEncodingLength); err != nil {
+		return
+	}
+	if c.order.BlobAttributes.Metadata, err = stringFromField(h.DstBlobData.Metadata[:], h.DstBlobData.MetadataLength); err != nil {
+		return
+	}
+
+	// the command string comes right after the header, then the transfer table
+	offset := int64(unsafe.Sizeof(h))
+	if c.order.CommandString, err = stringFromPlan(plan, offset, int64(h.CommandStringLength)); err != nil {
+		return
+	}
+	offset += int64(h.CommandStringLength)
+	if err = checkTransferTable(plan, offset, h.NumTransfers, unsafe.Sizeof(jobPartPlanTransferV0{})); err != nil {
+		return
+	}
+
+	c.order.Transfers = make([]common.CopyTransfer, h.NumTransfers)
+	c.transferStatuses = make([]common.TransferStatus, h.NumTransfers)
+	for i := range c.order.Transfers {
+		var t jobPartPlanTransferV0
+		if err = copyFromPlan(plan, offset, &t); err != nil {
+			return
+		}
+		offset += int64(unsafe.Sizeof(t))
+
+		c.order.Transfers[i] = common.CopyTransfer{
+			LastModifiedTime: time.Unix(0, t.ModifiedTime),
+			SourceSize:       t.SourceSize,
+		}
+		if err = readPlanTransferStrings(plan, t.SrcOffset, [9]int16{t.SrcLength, t.DstLength, t.SrcContentTypeLength,
+			t.SrcContentEncodingLength, t.SrcContentLanguageLength, t.SrcContentDispositionLength, t.SrcCacheControlLength,
+			t.SrcContentMD5Length, t.SrcMetadataLength}, &c.order.Transfers[i]); err != nil {
+			return c, fmt.Errorf("transfer %d: %v", i, err)
+		}
+		c.transferStatuses[i] = t.atomicTransferStatus
+	}
+	return
+}
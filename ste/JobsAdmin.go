@@ -76,12 +76,29 @@ var JobsAdmin interface {
 	// AddJobPartMgr associates the specified JobPartMgr with the Jobs Administrator
 	//AddJobPartMgr(appContext context.Context, planFile JobPartPlanFileName) IJobPartMgr
 	/*ScheduleTransfer(jptm IJobPartTransferMgr)*/
-	ScheduleChunk(priority common.JobPriority, chunkFunc chunkFunc)
+	ScheduleChunk(jobID common.JobID, priority common.JobPriority, chunkFunc chunkFunc)
 
 	ResurrectJob(jobId common.JobID, sourceSAS string, destinationSAS string) bool
 
 	ResurrectJobParts()
 
+	// JobHistory returns the jobs whose plan files are in the plan folder, as recorded by their plans,
+	// without resurrecting them
+	JobHistory() []common.JobDetail
+
+	// MigrateJobParts writes the plan files of prior data schema versions again in the current version,
+	// so that their jobs can be resumed. The zero JobID migrates the plan files of every job.
+	MigrateJobParts(jobID common.JobID) []common.JobPartMigration
+
+	// CleanJobs deletes the plan files and the logs of the jobs matching the request
+	CleanJobs(req common.CleanJobsRequest) []common.CleanedJob
+
+	// VerifyJobParts verifies the integrity of the plan files of a job, and rebuilds the corrupt ones from their intact transfers if asked to
+	VerifyJobParts(req common.VerifyJobRequest) common.VerifyJobResponse
+
+	// CleanExpiredJobs deletes the plan files and the logs of the jobs which finished longer ago than the TTL of their plan
+	CleanExpiredJobs()
+
 	QueueJobParts(jpm IJobPartMgr)
 
 	// AppPathFolder returns the Azcopy application path folder.
@@ -91,11 +108,26 @@ var JobsAdmin interface {
 	// returns the current value of bytesOverWire.
 	BytesOverWire() int64
 
+	// SetBandwidthCap caps the bandwidth shared by all jobs, in megabits per second, while the given job runs. 0 removes the cap.
+	SetBandwidthCap(jobID common.JobID, capMbps uint32)
+
+	// ResetBandwidthCap puts the engine's default bandwidth cap back, if the given job is the one which set the current cap.
+	ResetBandwidthCap(jobID common.JobID)
+
+	// EffectiveCapInMbps returns the bandwidth cap currently enforced, which can be lower than the
+	// user's cap while the service is pushing back. 0 means there is no cap.
+	EffectiveCapInMbps() float64
+
+	// ThrottleStats returns the number of throttling responses seen by the engine,
+	// and the total time it spent backing off because of them.
+	ThrottleStats() (throttleEvents int64, backoffTime time.Duration)
+
 	//DeleteJob(jobID common.JobID)
 	common.ILoggerCloser
 }
 
-func initJobsAdmin(appCtx context.Context, concurrentConnections int, targetRateInMBps int64, azcopyAppPathFolder string) {
+// initJobsAdmin starts concurrentConnections workers, or lets the concurrency auto-tuner size the pool if autoTuneConcurrency is set
+func initJobsAdmin(appCtx context.Context, concurrentConnections int, autoTuneConcurrency bool, capMbps uint32, azcopyAppPathFolder string) {
 	if JobsAdmin != nil {
 		panic("initJobsAdmin was already called once")
 	}
@@ -114,31 +146,33 @@ func initJobsAdmin(appCtx context.Context, concurrentConnections int, targetRate
 	// from which each part is picked up one by one
 	// and transfers of that JobPart are scheduled
 	partsCh := make(chan IJobPartMgr, PartsChannelSize)
-	// Create normal & low transfer/chunk channels
-	normalTransferCh, normalChunkCh := make(chan IJobPartTransferMgr, channelSize), make(chan chunkFunc, channelSize)
-	lowTransferCh, lowChunkCh := make(chan IJobPartTransferMgr, channelSize), make(chan chunkFunc, channelSize)
+	// Create the work notification channel, which gets one token per transfer/chunk put into the work queue
+	// Sending a token blocks once this many transfers/chunks are waiting, which slows down their producers
+	workAvailableCh := make(chan struct{}, 4*channelSize)
+
+	if autoTuneConcurrency {
+		concurrentConnections = concurrencyTunerInitialWorkers
+	}
 
 	// Create suicide channel which is used to scale back on the number of workers
-	suicideCh := make(chan SuicideJob, concurrentConnections)
+	suicideCh := make(chan SuicideJob, common.Iffint32(autoTuneConcurrency, concurrencyTunerMaxWorkers, int32(concurrentConnections)))
 
 	ja := &jobsAdmin{
-		logger:        common.NewAppLogger(pipeline.LogInfo),
-		jobIDToJobMgr: newJobIDToJobMgr(),
-		planDir:       azcopyAppPathFolder,
-		pacer:         newPacer(targetRateInMBps * 1024 * 1024),
-		appCtx:        appCtx,
+		logger:         common.NewAppLogger(pipeline.LogInfo),
+		jobIDToJobMgr:  newJobIDToJobMgr(),
+		planDir:        azcopyAppPathFolder,
+		pacer:          newPacer(MbpsToBytesPerSecond(capMbps)),
+		defaultCapMbps: capMbps,
+		appCtx:         appCtx,
+		workQueue:      newFairScheduler(),
 		coordinatorChannels: CoordinatorChannels{
-			partsChannel:     partsCh,
-			normalTransferCh: normalTransferCh,
-			lowTransferCh:    lowTransferCh,
+			partsChannel: partsCh,
+			suicideCh:    suicideCh,
 		},
 		xferChannels: XferChannels{
-			partsChannel:     partsCh,
-			normalTransferCh: normalTransferCh,
-			lowTransferCh:    lowTransferCh,
-			normalChunckCh:   normalChunkCh,
-			lowChunkCh:       lowChunkCh,
-			suicideCh:        suicideCh,
+			partsChannel:    partsCh,
+			suicideCh:       suicideCh,
+			workAvailableCh: workAvailableCh,
 		},
 	}
 	// create new context with the defaultService api version set as value to serviceAPIVersionOverride in the app context.
@@ -150,11 +184,31 @@ func initJobsAdmin(appCtx context.Context, concurrentConnections int, targetRate
 	// the Channel and schedules the transfers of that JobPart.
 	go ja.scheduleJobParts()
 	// Spin up the desired number of executionEngine workers to process transfers/chunks
-	for cc := 0; cc < concurrentConnections; cc++ {
-		go ja.transferAndChunkProcessor(cc)
+	ja.setWorkerCount(concurrentConnections)
+	// The auto-tuner grows and shrinks the pool from there on
+	if autoTuneConcurrency {
+		go newConcurrencyTuner(ja).run()
 	}
 }
 
+// workerCount returns the number of transferAndChunkProcessor workers the pool is sized to
+func (ja *jobsAdmin) workerCount() int {
+	return int(atomic.LoadInt64(&ja.numOfEngineWorker))
+}
+
+// setWorkerCount grows the pool by spinning up new workers, or shrinks it by asking workers to exit
+// it is called by a single goroutine at a time: initJobsAdmin, then the concurrency auto-tuner
+func (ja *jobsAdmin) setWorkerCount(workers int) {
+	current := ja.workerCount()
+	for ; current < workers; current++ {
+		go ja.transferAndChunkProcessor(int(atomic.AddInt64(&ja.nextWorkerID, 1)))
+	}
+	for ; current > workers; current-- {
+		ja.coordinatorChannels.suicideCh <- SuicideJob{}
+	}
+	atomic.StoreInt64(&ja.numOfEngineWorker, int64(workers))
+}
+
 // QueueJobParts puts the given JobPartManager into the partChannel
 // from where this JobPartMgr will be picked by a routine and
 // its transfers will be scheduled
@@ -198,34 +252,40 @@ func (ja *jobsAdmin) transferAndChunkProcessor(workerID int) {
 	}
 
 	for {
-		// We check for suicides first to shrink goroutine pool
-		// Then, we check chunks: normal & low priority
-		// Then, we check transfers: normal & low priority
+		// Block until there's either a suicide or a piece of work to do, so idle workers don't burn CPU
 		select {
 		case <-ja.xferChannels.suicideCh:
 			return
-		default:
+		case <-ja.xferChannels.workAvailableCh:
+		}
+
+		// We check for suicides first to shrink goroutine pool
+		// The work token goes back so that another worker picks up the work, unless the channel has filled up
+		// again in the meantime, in which case this worker does the work before exiting
+		exiting := false
+		select {
+		case <-ja.xferChannels.suicideCh:
 			select {
-			case chunkFunc := <-ja.xferChannels.normalChunckCh:
-				chunkFunc(workerID)
+			case ja.xferChannels.workAvailableCh <- struct{}{}:
+				return
 			default:
-				select {
-				case chunkFunc := <-ja.xferChannels.lowChunkCh:
-					chunkFunc(workerID)
-				default:
-					select {
-					case jptm := <-ja.xferChannels.normalTransferCh:
-						startTransfer(jptm)
-					default:
-						select {
-						case jptm := <-ja.xferChannels.lowTransferCh:
-							startTransfer(jptm)
-						default:
-							time.Sleep(1 * time.Millisecond) // Sleep before looping around
-						}
-					}
-				}
+				exiting = true
 			}
+		default:
+		}
+
+		// Then, we let the work queue pick a chunk or a transfer from the active jobs, according to their priorities
+		// Every token is sent after its work item is queued, so there is guaranteed to be one
+		switch chunkFunc, jptm := ja.workQueue.dequeue(); {
+		case chunkFunc != nil:
+			chunkFunc(workerID)
+		case jptm != nil:
+			startTransfer(jptm)
+		default:
+			panic(fmt.Errorf("worker %d was notified of work but found none", workerID))
+		}
+		if exiting {
+			return
 		}
 	}
 }
@@ -241,27 +301,28 @@ type jobsAdmin struct {
 	planDir             string // Initialize to directory where Job Part Plans are stored
 	coordinatorChannels CoordinatorChannels
 	xferChannels        XferChannels
+	workQueue           *fairScheduler // the transfers and chunks waiting for a worker
 	appCtx              context.Context
 	pacer               *pacer
-	numOfEngineWorker   int
+	defaultCapMbps      uint32 // the bandwidth cap of the engine when no job sets its own
+	bandwidthCapLock    sync.Mutex
+	bandwidthCapJobID   common.JobID // the job which set the current bandwidth cap, if any; guarded by bandwidthCapLock
+	numOfEngineWorker   int64        // the number of workers the pool is sized to, accessed atomically
+	nextWorkerID        int64        // used to give every worker a unique ID, accessed atomically
 	// bytesOverWire defines the total bytes sent from azcopy to the service through sdk.
 	// It is used to calculated the throughput of azcopy.
 	bytesOverWire uint64
 }
 
 type CoordinatorChannels struct {
-	partsChannel     chan<- IJobPartMgr         // Write Only
-	normalTransferCh chan<- IJobPartTransferMgr // Write-only
-	lowTransferCh    chan<- IJobPartTransferMgr // Write-only
+	partsChannel chan<- IJobPartMgr // Write Only
+	suicideCh    chan<- SuicideJob  // Write-only
 }
 
 type XferChannels struct {
-	partsChannel     <-chan IJobPartMgr         // Read only
-	normalTransferCh <-chan IJobPartTransferMgr // Read-only
-	lowTransferCh    <-chan IJobPartTransferMgr // Read-only
-	normalChunckCh   chan chunkFunc             // Read-write
-	lowChunkCh       chan chunkFunc             // Read-write
-	suicideCh        <-chan SuicideJob          // Read-only
+	partsChannel    <-chan IJobPartMgr // Read only
+	suicideCh       <-chan SuicideJob  // Read-only
+	workAvailableCh chan struct{}      // Read-write
 }
 
 type SuicideJob struct{}
@@ -305,34 +366,69 @@ func (ja *jobsAdmin) JobMgrEnsureExists(jobID common.JobID,
 		func() IJobMgr { return newJobMgr(ja.logger, jobID, ja.appCtx, level, commandString) }) // Return existing or new IJobMgr to caller
 }
 
-func (ja *jobsAdmin) ScheduleTransfer(priority common.JobPriority, jptm IJobPartTransferMgr) {
-	switch priority { // priority determines which channel handles the job part's transfers
+// priorityWeight returns the weight the work queue gives to jobs of the given priority
+func (ja *jobsAdmin) priorityWeight(priority common.JobPriority) int {
+	switch priority {
 	case common.EJobPriority.Normal():
-		//jptm.SetChunkChannel(ja.xferChannels.normalChunckCh)
-		ja.coordinatorChannels.normalTransferCh <- jptm
+		return normalPriorityWeight
 	case common.EJobPriority.Low():
-		//jptm.SetChunkChannel(ja.xferChannels.lowChunkCh)
-		ja.coordinatorChannels.lowTransferCh <- jptm
+		return lowPriorityWeight
 	default:
 		ja.Panic(fmt.Errorf("invalid priority: %q", priority))
+		return 0
 	}
 }
 
-func (ja *jobsAdmin) ScheduleChunk(priority common.JobPriority, chunkFunc chunkFunc) {
-	switch priority { // priority determines which channel handles the job part's transfers
-	case common.EJobPriority.Normal():
-		ja.xferChannels.normalChunckCh <- chunkFunc
-	case common.EJobPriority.Low():
-		ja.xferChannels.lowChunkCh <- chunkFunc
-	default:
-		ja.Panic(fmt.Errorf("invalid priority: %q", priority))
-	}
+func (ja *jobsAdmin) ScheduleTransfer(jobID common.JobID, priority common.JobPriority, jptm IJobPartTransferMgr) {
+	// priority determines the job's share of the workers
+	ja.workQueue.enqueueTransfer(jobID, ja.priorityWeight(priority), jptm)
+	// Wake up a worker only once the transfer can be picked up
+	ja.xferChannels.workAvailableCh <- struct{}{}
+}
+
+func (ja *jobsAdmin) ScheduleChunk(jobID common.JobID, priority common.JobPriority, chunkFunc chunkFunc) {
+	// priority determines the job's share of the workers
+	ja.workQueue.enqueueChunk(jobID, ja.priorityWeight(priority), chunkFunc)
+	// Wake up a worker only once the chunk can be picked up
+	ja.xferChannels.workAvailableCh <- struct{}{}
 }
 
 func (ja *jobsAdmin) BytesOverWire() int64 {
 	return atomic.LoadInt64(&ja.pacer.bytesTransferred)
 }
 
+func (ja *jobsAdmin) SetBandwidthCap(jobID common.JobID, capMbps uint32) {
+	ja.bandwidthCapLock.Lock()
+	defer ja.bandwidthCapLock.Unlock()
+	ja.bandwidthCapJobID = jobID
+	ja.pacer.setTargetRate(MbpsToBytesPerSecond(capMbps))
+	if ja.ShouldLog(pipeline.LogInfo) {
+		ja.Log(pipeline.LogInfo, fmt.Sprintf("bandwidth cap set to %d Mbps by Job %v", capMbps, jobID))
+	}
+}
+
+func (ja *jobsAdmin) ResetBandwidthCap(jobID common.JobID) {
+	ja.bandwidthCapLock.Lock()
+	defer ja.bandwidthCapLock.Unlock()
+	// the cap was set again by another job since, or was never set by this one
+	if ja.bandwidthCapJobID != jobID || jobID == (common.JobID{}) {
+		return
+	}
+	ja.bandwidthCapJobID = common.JobID{}
+	ja.pacer.setTargetRate(MbpsToBytesPerSecond(ja.defaultCapMbps))
+	if ja.ShouldLog(pipeline.LogInfo) {
+		ja.Log(pipeline.LogInfo, fmt.Sprintf("Job %v is done, bandwidth cap set back to %d Mbps", jobID, ja.defaultCapMbps))
+	}
+}
+
+func (ja *jobsAdmin) EffectiveCapInMbps() float64 {
+	return ja.pacer.targetRateInMbps()
+}
+
+func (ja *jobsAdmin) ThrottleStats() (int64, time.Duration) {
+	return ja.pacer.throttleStats()
+}
+
 func (ja *jobsAdmin) ResurrectJob(jobId common.JobID, sourceSAS string, destinationSAS string) bool {
 	// Search the existing plan files for the PartPlans for the given jobId
 	// only the files which have JobId has prefix and DataSchemaVersion as Suffix
@@ -351,6 +447,11 @@ func (ja *jobsAdmin) ResurrectJob(jobId common.JobID, sourceSAS string, destinat
 	if len(files) == 0 {
 		return false
 	}
+	// a corrupt plan can't be mapped, the job can't be resurrected until its plan is repaired
+	if err := checkJobPlanFiles(ja.planDir, jobId); err != nil {
+		ja.Log(pipeline.LogError, fmt.Sprintf("cannot resurrect job %v: %v", jobId, err))
+		return false
+	}
 	// sort the JobPartPlan files with respect to Part Number
 	sort.Sort(sortPlanFiles{Files: files})
 	for f := 0; f < len(files); f++ {
@@ -359,9 +460,17 @@ func (ja *jobsAdmin) ResurrectJob(jobId common.JobID, sourceSAS string, destinat
 		if err != nil {
 			continue
 		}
-		mmf := planFile.Map()
+		mmf, err := planFile.Map()
+		if err != nil {
+			ja.Log(pipeline.LogError, fmt.Sprintf("cannot resurrect job %v: %v", jobId, err))
+			return false
+		}
 		jm := ja.JobMgrEnsureExists(jobID, mmf.Plan().LogLevel, "")
-		jm.AddJobPart(partNum, planFile, sourceSAS, destinationSAS, false)
+		mmf.Unmap()
+		if _, err = jm.AddJobPart(partNum, planFile, sourceSAS, destinationSAS, false); err != nil {
+			ja.Log(pipeline.LogError, fmt.Sprintf("cannot resurrect job %v: %v", jobId, err))
+			return false
+		}
 	}
 	return true
 }
@@ -387,52 +496,44 @@ func (ja *jobsAdmin) ResurrectJobParts() {
 		if err != nil {
 			continue
 		}
-		mmf := planFile.Map()
+		mmf, err := planFile.Map()
+		if err != nil {
+			ja.Log(pipeline.LogError, fmt.Sprintf("cannot resurrect part %d of job %v: %v", partNum, jobID, err))
+			continue
+		}
 		//todo : call the compute transfer function here for each job.
 		jm := ja.JobMgrEnsureExists(jobID, mmf.Plan().LogLevel, "")
-		jm.AddJobPart(partNum, planFile, EMPTY_SAS_STRING, EMPTY_SAS_STRING, false)
+		mmf.Unmap()
+		if _, err = jm.AddJobPart(partNum, planFile, EMPTY_SAS_STRING, EMPTY_SAS_STRING, false); err != nil {
+			ja.Log(pipeline.LogError, fmt.Sprintf("cannot resurrect part %d of job %v: %v", partNum, jobID, err))
+		}
 	}
 }
 
-// TODO: I think something is wrong here: I think delete and cleanup should be merged together.
+func (ja *jobsAdmin) MigrateJobParts(jobID common.JobID) []common.JobPartMigration {
+	var migrations []common.JobPartMigration
+	walkJobPartPlanFiles(ja.planDir, func(jpfn JobPartPlanFileName, id common.JobID, partNum common.PartNumber, version common.Version) {
+		if version == DataSchemaVersion || (jobID != common.JobID{} && id != jobID) {
+			return
+		}
+		migration := common.JobPartMigration{JobID: id, PartNum: partNum, FromVersion: version, ToVersion: DataSchemaVersion}
+		if err := migrateJobPartPlan(jpfn); err != nil {
+			migration.ErrorMsg = err.Error()
+			ja.Log(pipeline.LogError, fmt.Sprintf("cannot migrate the plan file %s: %v", jpfn, err))
+		} else if ja.ShouldLog(pipeline.LogInfo) {
+			ja.Log(pipeline.LogInfo, fmt.Sprintf("migrated the plan file %s from data schema version %d to %d", jpfn, version, DataSchemaVersion))
+		}
+		migrations = append(migrations, migration)
+	})
+	return migrations
+}
+
 // DeleteJobInfo api deletes an entry of given JobId the JobsInfo
-// TODO: add the clean up logic for all Jobparts.
+// cleanUpJob deletes the files of the job as well
 func (ja *jobsAdmin) DeleteJob(jobID common.JobID) {
 	ja.jobIDToJobMgr.Delete(jobID)
 }
 
-// cleanUpJob api unmaps all the memory map JobPartFile and deletes the JobPartFile
-/*
-	* Load PartMap for given JobId
-    * Iterate through each part order of given Job and then shutdowns the JobInfo handler
-    * Iterate through each part order of given Job and then shutdowns the JobInfo handler
-	* Delete all the job part files stored on disk
-    * Closes the logger file opened for logging logs related to given job
-	* Removes the entry of given JobId from JobsInfo
-*/
-
-// TODO: take care fo this.
-/*func (ja *jobsAdmin) cleanUpJob(jobID common.JobID) {
-	jm, found := ja.JobMgr(jobID)
-	if !found {
-		ja.Panic(fmt.Errorf("no job found with JobID %v to clean up", jobID))
-	}
-	for p := PartNumber(0); true; p++ {
-		jpm, found := jm.JobPartMgr(p)
-		if !found { // TODO
-		}
-		// TODO: Fix jpm.planMMF.Unmap()	// unmapping the memory map JobPart file
-		err := jpm.filename.Delete()
-		if err != nil {
-			ja.Panic(fmt.Errorf("error removing the job part file %s. Failed with following error %s", jpm.filename, err))
-		}
-		//TODO: jobHandler.shutDownHandler(ji.logger)
-	}
-	ji.closeLogForJob()
-	// deletes the entry for given JobId from Map
-	ja.DeleteJob(jobID)
-}
-*/
 func (ja *jobsAdmin) ShouldLog(level pipeline.LogLevel) bool  { return ja.logger.ShouldLog(level) }
 func (ja *jobsAdmin) Log(level pipeline.LogLevel, msg string) { ja.logger.Log(level, msg) }
 func (ja *jobsAdmin) Panic(err error)                         { ja.logger.Panic(err) }
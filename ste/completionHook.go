@@ -0,0 +1,162 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+const (
+	// completionHookMaxTries is the number of times a completion hook is attempted before giving up on it
+	completionHookMaxTries = 3
+	// completionHookTryTimeout is how long a single attempt may take, e.g. how long the command may run
+	completionHookTryTimeout = 30 * time.Second
+	// completionHookRetryDelay is the delay before the first retry, it doubles with every retry
+	completionHookRetryDelay = 2 * time.Second
+	// completionHookTimeout is the deadline shared by all the tries of all the completion hooks of a job,
+	// the final status of the job is set once they are done, so it waits for them no longer than that
+	completionHookTimeout = 2 * time.Minute
+)
+
+// runCompletionHook notifies the caller of the job that it finished with the given status, as it asked to,
+// and returns once all the hooks are done. The hooks run concurrently, within completionHookTimeout.
+// The hooks are best effort: a hook which keeps failing is logged, but doesn't change the outcome of the job.
+func (jm *jobMgr) runCompletionHook(status common.JobStatus) {
+	hook := jm.getInMemoryTransitJobState().completionHook
+	if hook.Exec == "" && hook.URL == "" {
+		return
+	}
+
+	summary := GetJobSummary(jm.jobID)
+	// the status of the job isn't final yet, but it is the one the summary is about
+	summary.JobStatus = status
+	payload, err := json.Marshal(summary)
+	if err != nil {
+		jm.Log(pipeline.LogError, fmt.Sprintf("cannot run the completion hooks, the job summary cannot be serialized: %v", err))
+		return
+	}
+
+	// the job's own context isn't used, since the hooks must still run once the job is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), completionHookTimeout)
+	defer cancel()
+	wg := sync.WaitGroup{}
+	if hook.Exec != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jm.deliverCompletionHook(ctx, fmt.Sprintf("command %q", hook.Exec), func(ctx context.Context) (bool, error) {
+				return execCompletionHook(ctx, hook.Exec, payload)
+			})
+		}()
+	}
+	if hook.URL != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jm.deliverCompletionHook(ctx, fmt.Sprintf("POST to %s", hook.URL), func(ctx context.Context) (bool, error) {
+				return postCompletionHook(ctx, hook.URL, payload)
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// deliverCompletionHook attempts the given delivery until it succeeds, it fails with an error which isn't worth retrying,
+// it runs out of tries, or ctx is done. Each attempt is given completionHookTryTimeout, or what is left of ctx.
+func (jm *jobMgr) deliverCompletionHook(ctx context.Context, description string, deliver func(ctx context.Context) (retryable bool, err error)) {
+	delay := completionHookRetryDelay
+	for try := 1; ; try++ {
+		tryCtx, cancel := context.WithTimeout(ctx, completionHookTryTimeout)
+		retryable, err := deliver(tryCtx)
+		cancel()
+		if err == nil {
+			if jm.ShouldLog(pipeline.LogInfo) {
+				jm.Log(pipeline.LogInfo, fmt.Sprintf("completion hook %s succeeded", description))
+			}
+			return
+		}
+		if !retryable || try == completionHookMaxTries || ctx.Err() != nil {
+			jm.Log(pipeline.LogError, fmt.Sprintf("completion hook %s failed after %d tries: %v", description, try, err))
+			return
+		}
+		if jm.ShouldLog(pipeline.LogWarning) {
+			jm.Log(pipeline.LogWarning, fmt.Sprintf("completion hook %s failed, retrying in %v: %v", description, delay, err))
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			jm.Log(pipeline.LogError, fmt.Sprintf("completion hook %s failed after %d tries, it ran out of time: %v", description, try, err))
+			return
+		}
+		delay *= 2
+	}
+}
+
+// execCompletionHook runs the command with the shell of the platform, so that it can be given arguments,
+// and writes the payload to its standard input. The command fails if it exits with a non-zero status.
+func execCompletionHook(ctx context.Context, command string, payload []byte) (bool, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(payload)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return true, fmt.Errorf("%v, output: %s", err, bytes.TrimSpace(output))
+	}
+	return false, nil
+}
+
+// postCompletionHook POSTs the payload to the URL. A response with a 4xx status other than
+// 408 (Request Timeout) and 429 (Too Many Requests) is an error which isn't worth retrying.
+func postCompletionHook(ctx context.Context, url string, payload []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+
+	switch status := response.StatusCode; {
+	case status >= 200 && status < 300:
+		return false, nil
+	case status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests:
+		return false, fmt.Errorf("the hook responded with status %s", response.Status)
+	default:
+		return true, fmt.Errorf("the hook responded with status %s", response.Status)
+	}
+}
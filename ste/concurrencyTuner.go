@@ -0,0 +1,197 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+const (
+	// the number of workers the auto-tuner starts with, and the bounds it stays within
+	concurrencyTunerInitialWorkers = 32
+	concurrencyTunerMinWorkers     = 4
+	concurrencyTunerMaxWorkers     = 1000
+
+	// how often the auto-tuner looks at the statistics and resizes the pool
+	concurrencyTunerInterval = 10 * time.Second
+
+	// the fraction of 503/500 responses above which the pool is shrunk
+	concurrencyTunerThrottleThreshold = 0.01
+
+	// the throughput has to improve by this factor for a larger pool to be considered better
+	concurrencyTunerImprovementFactor = 1.05
+
+	// if the latency exceeds the latency at the best level by this factor without improving the throughput,
+	// the pool is considered to be overloading the machine or the link
+	concurrencyTunerLatencyFactor = 2.0
+
+	// once settled, the auto-tuner probes for a better level again after this many intervals,
+	// since the machine and the link can change while the job is running
+	concurrencyTunerIntervalsBeforeProbing = 30
+)
+
+// concurrencyTuner grows and shrinks the pool of transferAndChunkProcessor workers,
+// based on the throughput, latency and 503/500 rates observed by the pacer policy.
+// It climbs up while a larger pool brings more throughput, settles on the best level when it stops doing so,
+// and backs off whenever the service starts throttling.
+type concurrencyTuner struct {
+	ja *jobsAdmin
+
+	// the largest pool size the auto-tuner may grow to, lowered when the service throttles
+	ceiling int
+
+	// the best level found so far, with the throughput and latency measured at that level
+	bestWorkers    int
+	bestThroughput float64
+	bestLatency    time.Duration
+	// whether the throughputs are request rates rather than byte rates, see tune
+	throughputInRequests bool
+
+	// whether the auto-tuner has settled on bestWorkers, and for how many intervals
+	settled          bool
+	intervalsSettled int
+
+	// statistics of the pacer at the end of the last interval
+	lastBytes, lastRequests, lastThrottled, lastLatencyInNs int64
+	lastTimestamp                                           time.Time
+}
+
+func newConcurrencyTuner(ja *jobsAdmin) *concurrencyTuner {
+	return &concurrencyTuner{ja: ja, ceiling: concurrencyTunerMaxWorkers, lastTimestamp: time.Now()}
+}
+
+// run is executed by a single goroutine for as long as the transfer engine is running
+func (ct *concurrencyTuner) run() {
+	for range time.Tick(concurrencyTunerInterval) {
+		ct.tune()
+	}
+}
+
+// tune looks at the statistics of the last interval and decides on the size of the pool
+func (ct *concurrencyTuner) tune() {
+	p := ct.ja.pacer
+	bytes, requests := atomic.LoadInt64(&p.bytesTransferred), atomic.LoadInt64(&p.requestCount)
+	throttled, latencyInNs := atomic.LoadInt64(&p.throttledCount), atomic.LoadInt64(&p.latencyInNs)
+	now := time.Now()
+
+	intervalBytes, intervalRequests := bytes-ct.lastBytes, requests-ct.lastRequests
+	intervalThrottled, intervalLatencyInNs := throttled-ct.lastThrottled, latencyInNs-ct.lastLatencyInNs
+	elapsed := now.Sub(ct.lastTimestamp).Seconds()
+	ct.lastBytes, ct.lastRequests, ct.lastThrottled, ct.lastLatencyInNs, ct.lastTimestamp = bytes, requests, throttled, latencyInNs, now
+
+	// nothing was sent during the interval, so there is nothing to learn from it
+	if intervalRequests == 0 || elapsed <= 0 {
+		return
+	}
+
+	// service side copies don't send bytes through the pacer, the request rate is used as throughput instead
+	throughput := float64(intervalBytes) / elapsed
+	if intervalBytes == 0 {
+		throughput = float64(intervalRequests) / elapsed
+	}
+	throttleRate := float64(intervalThrottled) / float64(intervalRequests)
+	latency := time.Duration(intervalLatencyInNs / intervalRequests)
+	workers := ct.ja.workerCount()
+
+	// a request rate can't be compared with a byte rate: when the throughput changes from one to the other,
+	// so did the workload, and the auto-tuner climbs again from the current level
+	if inRequests := intervalBytes == 0; inRequests != ct.throughputInRequests {
+		ct.throughputInRequests = inRequests
+		ct.settled = false
+		ct.bestWorkers, ct.bestThroughput, ct.bestLatency = workers, 0, 0
+	}
+	stats := fmt.Sprintf("throughput %.0f/s, average latency %v, %.2f%% throttled, %d workers",
+		throughput, latency, throttleRate*100, workers)
+
+	switch {
+	case throttleRate > concurrencyTunerThrottleThreshold:
+		// the service is pushing back, shrink the pool and don't grow back beyond it
+		newWorkers := workers * 3 / 4
+		if newWorkers < concurrencyTunerMinWorkers {
+			newWorkers = concurrencyTunerMinWorkers
+		}
+		ct.ceiling = newWorkers
+		ct.settleOn(newWorkers, 0, 0)
+		ct.resize(newWorkers, "service is throttling ("+stats+")")
+
+	case ct.settled:
+		ct.intervalsSettled++
+		if ct.intervalsSettled < concurrencyTunerIntervalsBeforeProbing {
+			return
+		}
+		// the conditions may have changed since the auto-tuner settled, probe for a better level again
+		// this includes the levels that were throttled before
+		ct.settled, ct.ceiling = false, concurrencyTunerMaxWorkers
+		ct.bestWorkers, ct.bestThroughput, ct.bestLatency = workers, throughput, latency
+		ct.resize(ct.grow(workers), "probing for a better level ("+stats+")")
+
+	case throughput > ct.bestThroughput*concurrencyTunerImprovementFactor:
+		// the current level is the best so far, keep climbing
+		ct.bestWorkers, ct.bestThroughput, ct.bestLatency = workers, throughput, latency
+		if workers >= ct.ceiling {
+			ct.settleOn(workers, throughput, latency)
+			ct.resize(workers, "reached the maximum level ("+stats+")")
+			return
+		}
+		ct.resize(ct.grow(workers), "throughput improved ("+stats+")")
+
+	case ct.bestLatency > 0 && latency > time.Duration(float64(ct.bestLatency)*concurrencyTunerLatencyFactor):
+		// more workers only made the requests slower
+		ct.settleOn(ct.bestWorkers, ct.bestThroughput, ct.bestLatency)
+		ct.resize(ct.bestWorkers, "latency went up without improving throughput ("+stats+")")
+
+	default:
+		// more workers didn't bring more throughput, go back to the best level found
+		ct.settleOn(ct.bestWorkers, ct.bestThroughput, ct.bestLatency)
+		ct.resize(ct.bestWorkers, "throughput stopped improving ("+stats+")")
+	}
+}
+
+// grow returns the next level to try when climbing up
+func (ct *concurrencyTuner) grow(workers int) int {
+	newWorkers := workers * 3 / 2
+	if newWorkers > ct.ceiling {
+		newWorkers = ct.ceiling
+	}
+	return newWorkers
+}
+
+func (ct *concurrencyTuner) settleOn(workers int, throughput float64, latency time.Duration) {
+	ct.settled, ct.intervalsSettled = true, 0
+	ct.bestWorkers, ct.bestThroughput, ct.bestLatency = workers, throughput, latency
+}
+
+// resize grows or shrinks the pool to the given number of workers and logs the decision
+func (ct *concurrencyTuner) resize(workers int, reason string) {
+	current := ct.ja.workerCount()
+	if ct.ja.ShouldLog(pipeline.LogInfo) {
+		if workers == current {
+			ct.ja.Log(pipeline.LogInfo, fmt.Sprintf("concurrency auto-tuner keeps %d workers: %s", current, reason))
+		} else {
+			ct.ja.Log(pipeline.LogInfo, fmt.Sprintf("concurrency auto-tuner changes workers from %d to %d: %s", current, workers, reason))
+		}
+	}
+	ct.ja.setWorkerCount(workers)
+}
@@ -0,0 +1,128 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"sync"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+// The share of the workers a job gets when it runs side by side with other jobs depends on its priority
+// e.g. a Normal job gets 4 turns for every turn of a Low job
+const (
+	normalPriorityWeight = 4
+	lowPriorityWeight    = 1
+)
+
+// jobQueue holds the transfers and chunks a job has waiting for a worker
+type jobQueue struct {
+	jobID     common.JobID
+	weight    int
+	credit    int // used by the smooth weighted round robin in fairScheduler.dequeue
+	chunks    []chunkFunc
+	transfers []IJobPartTransferMgr
+}
+
+func (q *jobQueue) isEmpty() bool { return len(q.chunks) == 0 && len(q.transfers) == 0 }
+
+// fairScheduler shares the workers between the active jobs in proportion to their priority's weight
+// Within a job, chunks are handed out before transfers so that transfers already in progress finish first
+// It's only a queue: the jobsAdmin wakes up the workers through its workAvailableCh
+type fairScheduler struct {
+	lock sync.Mutex
+	// active holds the jobs with work waiting, in the order their work arrived
+	// a job leaves it as soon as its queue is drained so that idle jobs don't build up credit
+	active []*jobQueue
+	queues map[common.JobID]*jobQueue
+}
+
+func newFairScheduler() *fairScheduler {
+	return &fairScheduler{queues: make(map[common.JobID]*jobQueue)}
+}
+
+// queueFor returns the given job's queue, activating it if needed; the caller must hold the lock
+func (fs *fairScheduler) queueFor(jobID common.JobID, weight int) *jobQueue {
+	q, found := fs.queues[jobID]
+	if !found {
+		q = &jobQueue{jobID: jobID}
+		fs.queues[jobID] = q
+		fs.active = append(fs.active, q)
+	}
+	// all the parts of a job share the same priority, so the latest weight is as good as any
+	q.weight = weight
+	return q
+}
+
+func (fs *fairScheduler) enqueueTransfer(jobID common.JobID, weight int, jptm IJobPartTransferMgr) {
+	fs.lock.Lock()
+	q := fs.queueFor(jobID, weight)
+	q.transfers = append(q.transfers, jptm)
+	fs.lock.Unlock()
+}
+
+func (fs *fairScheduler) enqueueChunk(jobID common.JobID, weight int, chunkFunc chunkFunc) {
+	fs.lock.Lock()
+	q := fs.queueFor(jobID, weight)
+	q.chunks = append(q.chunks, chunkFunc)
+	fs.lock.Unlock()
+}
+
+// dequeue picks the next job using smooth weighted round robin, and returns either its oldest chunk or its oldest transfer
+// It returns nil for both if no job has any work waiting
+func (fs *fairScheduler) dequeue() (chunkFunc, IJobPartTransferMgr) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if len(fs.active) == 0 {
+		return nil, nil
+	}
+
+	// every active job earns its weight in credit, and the richest job pays the total back for its turn
+	totalWeight, next := 0, 0
+	for i, q := range fs.active {
+		q.credit += q.weight
+		totalWeight += q.weight
+		if q.credit > fs.active[next].credit {
+			next = i
+		}
+	}
+	q := fs.active[next]
+	q.credit -= totalWeight
+
+	var cf chunkFunc
+	var jptm IJobPartTransferMgr
+	if len(q.chunks) > 0 {
+		cf = q.chunks[0]
+		q.chunks[0] = nil // let the chunk be garbage collected once it's done
+		q.chunks = q.chunks[1:]
+	} else {
+		jptm = q.transfers[0]
+		q.transfers[0] = nil
+		q.transfers = q.transfers[1:]
+	}
+
+	if q.isEmpty() {
+		delete(fs.queues, q.jobID)
+		fs.active = append(fs.active[:next], fs.active[next+1:]...)
+	}
+	return cf, jptm
+}
@@ -27,6 +27,7 @@ import (
 	"io/ioutil"
 	"math"
 	"net/http"
+	"os"
 	"time"
 
 	"strings"
@@ -51,11 +52,15 @@ func ToFixed(num float64, precision int) float64 {
 }
 
 // MainSTE initializes the Storage Transfer Engine
-func MainSTE(concurrentConnections int, targetRateInMBps int64, azcopyAppPathFolder string) error {
+// if autoTuneConcurrency is set, concurrentConnections is ignored and the number of workers is auto-tuned
+// capMbps is the default bandwidth cap in megabits per second, 0 means no cap
+func MainSTE(concurrentConnections int, autoTuneConcurrency bool, capMbps uint32, azcopyAppPathFolder string) error {
 	// Initialize the JobsAdmin, resurrect Job plan files
-	initJobsAdmin(steCtx, concurrentConnections, targetRateInMBps, azcopyAppPathFolder)
+	initJobsAdmin(steCtx, concurrentConnections, autoTuneConcurrency, capMbps, azcopyAppPathFolder)
 	// No need to read the existing JobPartPlan files since Azcopy is running in process
 	//JobsAdmin.ResurrectJobParts()
+	// The jobs which finished longer ago than the TTL of their plan are cleaned up before any other is ordered
+	JobsAdmin.CleanExpiredJobs()
 	JobsAdminInitialized <- true
 	// TODO: We may want to list listen first and terminate if there is already an instance listening
 
@@ -120,6 +125,24 @@ func MainSTE(concurrentConnections int, targetRateInMBps int64, azcopyAppPathFol
 			deserialize(request, &payload)
 			serialize(ResumeJobOrder(payload), writer)
 		})
+	http.HandleFunc(common.ERpcCmd.MigrateJobs().Pattern(),
+		func(writer http.ResponseWriter, request *http.Request) {
+			var payload common.MigrateJobsRequest
+			deserialize(request, &payload)
+			serialize(MigrateJobs(payload), writer)
+		})
+	http.HandleFunc(common.ERpcCmd.CleanJobs().Pattern(),
+		func(writer http.ResponseWriter, request *http.Request) {
+			var payload common.CleanJobsRequest
+			deserialize(request, &payload)
+			serialize(CleanJobs(payload), writer)
+		})
+	http.HandleFunc(common.ERpcCmd.VerifyJob().Pattern(),
+		func(writer http.ResponseWriter, request *http.Request) {
+			var payload common.VerifyJobRequest
+			deserialize(request, &payload)
+			serialize(VerifyJob(payload), writer)
+		})
 
 	// Listen for front-end requests
 	//if err := http.ListenAndServe("localhost:1337", nil); err != nil {
@@ -135,17 +158,42 @@ func MainSTE(concurrentConnections int, targetRateInMBps int64, azcopyAppPathFol
 func ExecuteNewCopyJobPartOrder(order common.CopyJobPartOrderRequest) common.CopyJobPartOrderResponse {
 	// Get the file name for this Job Part's Plan
 	jppfn := JobsAdmin.NewJobPartPlanFileName(order.JobID, order.PartNum)
-	jppfn.Create(order)                                                                   // Convert the order to a plan file
+	if err := createJobPartPlanFile(jppfn, order, time.Now()); err != nil { // Convert the order to a plan file
+		return common.CopyJobPartOrderResponse{ErrorMsg: err.Error()}
+	}
 	jpm := JobsAdmin.JobMgrEnsureExists(order.JobID, order.LogLevel, order.CommandString) // Get a this job part's job manager (create it if it doesn't exist)
+	// The bandwidth cap given by the user overrides the engine's default one until the job is done
+	if order.CapMbps > 0 {
+		JobsAdmin.SetBandwidthCap(order.JobID, order.CapMbps)
+	}
 	// Get credential info from RPC request order, and set in InMemoryTransitJobState.
 	jpm.setInMemoryTransitJobState(
 		InMemoryTransitJobState{
 			credentialInfo: order.CredentialInfo,
+			completionHook: order.CompletionHook,
 		})
-	jpm.AddJobPart(order.PartNum, jppfn, order.SourceSAS, order.DestinationSAS, true) // Add this part to the Job and schedule its transfers
+	// Add this part to the Job and schedule its transfers
+	if _, err := jpm.AddJobPart(order.PartNum, jppfn, order.SourceSAS, order.DestinationSAS, true); err != nil {
+		return common.CopyJobPartOrderResponse{ErrorMsg: err.Error()}
+	}
 	return common.CopyJobPartOrderResponse{JobStarted: true}
 }
 
+// createJobPartPlanFile creates the plan file of the given order. Creating it panics on an order which cannot be
+// turned into a plan, e.g. one with too much metadata, so the panic is turned into an error: the order fails
+// on its own rather than bringing down the jobs which are already running.
+func createJobPartPlanFile(jppfn JobPartPlanFileName, order common.CopyJobPartOrderRequest, startTime time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot create the plan file %s: %v", jppfn, r)
+			// don't leave a partial plan file behind for the job to be resumed from
+			os.Remove(jppfn.GetJobPartPlanPath())
+		}
+	}()
+	jppfn.Create(order, startTime)
+	return nil
+}
+
 // cancelpauseJobOrder api cancel/pause a job with given JobId
 /* A Job cannot be cancelled/paused in following cases
 	* If the Job has not been ordered completely it cannot be cancelled or paused
@@ -285,7 +333,7 @@ func ResumeJobOrder(req common.ResumeJobRequest) common.CancelPauseResumeRespons
 		if !JobsAdmin.ResurrectJob(req.JobID, req.SourceSAS, req.DestinationSAS) {
 			return common.CancelPauseResumeResponse{
 				CancelledPauseResumed: false,
-				ErrorMsg:              fmt.Sprintf("no job with JobId %v exists", req.JobID),
+				ErrorMsg:              jobNotFoundMsg(req.JobID),
 			}
 		}
 		// If the job manager was not found, then Job was resurrected
@@ -340,10 +388,16 @@ func ResumeJobOrder(req common.ResumeJobRequest) common.CancelPauseResumeRespons
 		jm.setInMemoryTransitJobState(
 			InMemoryTransitJobState{
 				credentialInfo: req.CredentialInfo,
+				completionHook: req.CompletionHook,
 			})
 
 		jpp0.SetJobStatus(common.EJobStatus.InProgress())
 
+		// The bandwidth cap given by the user overrides the engine's default one until the job is done
+		if req.CapMbps > 0 {
+			JobsAdmin.SetBandwidthCap(req.JobID, req.CapMbps)
+		}
+
 		if jm.ShouldLog(pipeline.LogInfo) {
 			jm.Log(pipeline.LogInfo, fmt.Sprintf("JobID=%v resumed", req.JobID))
 		}
@@ -376,7 +430,7 @@ func GetJobSummary(jobID common.JobID) common.ListJobSummaryResponse {
 		// and resurrect the Job
 		if !JobsAdmin.ResurrectJob(jobID, EMPTY_SAS_STRING, EMPTY_SAS_STRING) {
 			return common.ListJobSummaryResponse{
-				ErrorMsg: fmt.Sprintf("no job with JobId %v exists", jobID),
+				ErrorMsg: jobNotFoundMsg(jobID),
 			}
 		}
 		// If the job manager was not found, then Job was resurrected
@@ -385,20 +439,23 @@ func GetJobSummary(jobID common.JobID) common.ListJobSummaryResponse {
 	}
 
 	js := common.ListJobSummaryResponse{
-		Timestamp:          time.Now().UTC(),
-		JobID:              jobID,
-		ErrorMsg:           "",
-		JobStatus:          common.EJobStatus.InProgress(), // Default
-		CompleteJobOrdered: false,                          // default to false; returns true if ALL job parts have been ordered
-		FailedTransfers:    []common.TransferDetail{},
+		Timestamp:               time.Now().UTC(),
+		JobID:                   jobID,
+		ErrorMsg:                "",
+		JobStatus:               common.EJobStatus.InProgress(), // Default
+		CompleteJobOrdered:      false,                          // default to false; returns true if ALL job parts have been ordered
+		FailedTransfers:         []common.TransferDetail{},
+		TransfersFailedByStatus: map[string]uint32{},
 	}
 
 	totalBytesToTransfer := int64(0)
 	totalBytesTransferred := int64(0)
+	totalBytesSkipped := int64(0)
 
 	jm.(*jobMgr).jobPartMgrs.Iterate(true, func(partNum common.PartNumber, jpm IJobPartMgr) {
 		totalBytesToTransfer += jpm.BytesToTransfer()
 		totalBytesTransferred += jpm.BytesDone()
+		totalBytesSkipped += jpm.BytesSkipped()
 		jpp := jpm.Plan()
 		js.CompleteJobOrdered = js.CompleteJobOrdered || jpp.IsFinalPart
 		js.TotalTransfers += jpp.NumTransfers
@@ -408,21 +465,24 @@ func GetJobSummary(jobID common.JobID) common.ListJobSummaryResponse {
 			// transferHeader represents the memory map transfer header of transfer at index position for given job and part number
 			jppt := jpp.Transfer(t)
 			// check for all completed transfer to calculate the progress percentage at the end
-			switch jppt.TransferStatus() {
-			case common.ETransferStatus.Success():
+			status := jppt.TransferStatus()
+			switch {
+			case status == common.ETransferStatus.Success():
 				js.TransfersCompleted++
-			case common.ETransferStatus.Failed(),
-				common.ETransferStatus.BlobTierFailure(),
-				common.ETransferStatus.BlobAlreadyExistsFailure():
+			case status.DidFail():
 				js.TransfersFailed++
+				js.TransfersFailedByStatus[status.String()]++
 				// getting the source and destination for failed transfer at position - index
 				src, dst := jpp.TransferSrcDstStrings(t)
+				errorCode, serviceErrorCode := jppt.ErrorCode()
 				// appending to list of failed transfer
 				js.FailedTransfers = append(js.FailedTransfers,
 					common.TransferDetail{
-						Src:            src,
-						Dst:            dst,
-						TransferStatus: common.ETransferStatus.Failed()}) // TODO: Optimize
+						Src:              src,
+						Dst:              dst,
+						TransferStatus:   status,
+						ErrorCode:        errorCode,
+						ServiceErrorCode: serviceErrorCode}) // TODO: Optimize
 			}
 		}
 	})
@@ -436,6 +496,13 @@ func GetJobSummary(jobID common.JobID) common.ListJobSummaryResponse {
 	// calculating the progress of Job and rounding the progress upto 4 decimal.
 	js.JobProgressPercentage = ToFixed(float64(totalBytesTransferred*100)/float64(totalBytesToTransfer), 4)
 	js.BytesOverWire = uint64(JobsAdmin.BytesOverWire())
+	js.TotalBytesEnumerated = uint64(totalBytesToTransfer)
+	js.TotalBytesTransferred = uint64(totalBytesTransferred)
+	js.TotalBytesSkipped = uint64(totalBytesSkipped)
+	js.EffectiveCapInMbps = ToFixed(JobsAdmin.EffectiveCapInMbps(), 4)
+	throttleEvents, backoffTime := JobsAdmin.ThrottleStats()
+	js.ThrottleEvents = throttleEvents
+	js.BackoffTimeInSeconds = ToFixed(backoffTime.Seconds(), 4)
 	// Get the number of active go routines performing the transfer or executing the chunk Func
 	// TODO: added for debugging purpose. remove later
 	js.ActiveConnections = jm.ActiveConnections()
@@ -459,7 +526,7 @@ func ListJobTransfers(r common.ListJobTransfersRequest) common.ListJobTransfersR
 		// and resurrect the Job
 		if !JobsAdmin.ResurrectJob(r.JobID, EMPTY_SAS_STRING, EMPTY_SAS_STRING) {
 			return common.ListJobTransfersResponse{
-				ErrorMsg: fmt.Sprintf("no job with JobId %v exists", r.JobID),
+				ErrorMsg: jobNotFoundMsg(r.JobID),
 			}
 		}
 		// If the job manager was not found, then Job was resurrected
@@ -484,28 +551,59 @@ func ListJobTransfers(r common.ListJobTransfersRequest) common.ListJobTransfersR
 			// getting transfer header of transfer at index index for given jobId and part number
 			transferEntry := jpp.Transfer(t)
 			// if the expected status is not to list all transfer and status of current transfer is not equal to the expected status, then we skip this transfer
-			if r.OfStatus != common.ETransferStatus.All() && transferEntry.TransferStatus() != r.OfStatus {
+			// Failed lists the transfers which failed with any of the failure statuses
+			status := transferEntry.TransferStatus()
+			if r.OfStatus != common.ETransferStatus.All() && status != r.OfStatus &&
+				!(r.OfStatus == common.ETransferStatus.Failed() && status.DidFail()) {
 				continue
 			}
 			// getting source and destination of a transfer at index index for given jobId and part number.
 			src, dst := jpp.TransferSrcDstStrings(t)
+			errorCode, serviceErrorCode := transferEntry.ErrorCode()
 			ljt.Details = append(ljt.Details,
-				common.TransferDetail{Src: src, Dst: dst, TransferStatus: transferEntry.TransferStatus()})
+				common.TransferDetail{Src: src, Dst: dst, TransferStatus: status, ErrorCode: errorCode, ServiceErrorCode: serviceErrorCode})
 		}
 	}
 	return ljt
 }
 
-// listJobs returns the jobId of all the jobs existing in the current instance of azcopy
+// jobNotFoundMsg explains why the job with the given JobId cannot be resurrected from the plan files in Azcopy folder
+func jobNotFoundMsg(jobID common.JobID) string {
+	if err := checkJobPlanFiles(JobsAdmin.AppPathFolder(), jobID); err != nil {
+		return fmt.Sprintf("job with JobId %v cannot be used as %v, run 'azcopy jobs verify %v --repair' to rebuild its plan from the transfers which are intact",
+			jobID, err, jobID)
+	}
+	// the job may have been ordered by a release of azcopy whose plans this one can't use as they are
+	if versions := otherJobPlanVersions(jobID); len(versions) > 0 {
+		return fmt.Sprintf("job with JobId %v was planned by a release of azcopy with data schema version %d, "+
+			"run 'azcopy jobs migrate %v' to use it with this release, data schema version %d", jobID, versions[0], jobID, DataSchemaVersion)
+	}
+	return fmt.Sprintf("no job with JobId %v exists", jobID)
+}
+
+// ListJobs returns every job whose plan files are in Azcopy folder, whichever instance of azcopy ordered it.
+// The plan files are only read, so that listing the jobs doesn't resurrect them.
 func ListJobs() common.ListJobsResponse {
-	// Resurrect all the Jobs from the existing JobPart Plan files
-	JobsAdmin.ResurrectJobParts()
-	// building the ListJobsResponse for sending response back to front-end
-	jobIds := JobsAdmin.JobIDs()
-	if len(jobIds) == 0 {
+	jobs := JobsAdmin.JobHistory()
+	if len(jobs) == 0 {
 		return common.ListJobsResponse{ErrorMessage: "no Jobs exists in Azcopy history"}
 	}
-	return common.ListJobsResponse{ErrorMessage: "", JobIDs: JobsAdmin.JobIDs()}
+	return common.ListJobsResponse{ErrorMessage: "", Jobs: jobs}
+}
+
+// MigrateJobs writes the plans of the jobs ordered by prior releases of azcopy in the current data schema version
+func MigrateJobs(req common.MigrateJobsRequest) common.MigrateJobsResponse {
+	return common.MigrateJobsResponse{Migrations: JobsAdmin.MigrateJobParts(req.JobID)}
+}
+
+// CleanJobs deletes the plan files and the logs of the jobs matching the request
+func CleanJobs(req common.CleanJobsRequest) common.CleanJobsResponse {
+	return common.CleanJobsResponse{CleanedJobs: JobsAdmin.CleanJobs(req)}
+}
+
+// VerifyJob verifies the integrity of the plan files of a job, and rebuilds the corrupt ones if requested
+func VerifyJob(req common.VerifyJobRequest) common.VerifyJobResponse {
+	return JobsAdmin.VerifyJobParts(req)
 }
 
 // todo use this in case of panic
@@ -0,0 +1,135 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+// JobHistory returns the jobs whose plan files are in the plan folder, whichever process ordered them.
+// Their plans are only read: unlike ResurrectJobParts, no job is added to the jobs this process manages.
+func (ja *jobsAdmin) JobHistory() []common.JobDetail {
+	jobs := findJobPlanFiles(ja.planDir)
+	details := make([]common.JobDetail, 0, len(jobs))
+	for _, job := range jobs {
+		details = append(details, job.detail())
+	}
+	return details
+}
+
+// detail sums up the plans of every part of the job; part 0 holds the start time, the command and the status of the job
+func (job *jobPlanFiles) detail() common.JobDetail {
+	d := common.JobDetail{JobID: job.jobID}
+	if _, found := job.paths[0]; !found {
+		d.ErrorMsg = "the plan of part 0 is missing"
+	}
+
+	for _, partNum := range job.partNumbers() {
+		var err error
+		if version := job.versions[partNum]; version == DataSchemaVersion {
+			err = addJobPartPlanToDetail(&d, job.paths[partNum], partNum)
+		} else {
+			err = addOldJobPartPlanToDetail(&d, job.paths[partNum], partNum, version)
+		}
+		if err != nil && d.ErrorMsg == "" {
+			d.ErrorMsg = err.Error()
+		}
+	}
+	return d
+}
+
+// addJobPartPlanToDetail adds the plan of the current data schema version at the given path to the detail of its job.
+// The plan is mapped read-only, and only its header and the size and status of its transfers are read.
+func addJobPartPlanToDetail(d *common.JobDetail, path string, partNum common.PartNumber) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	mmf, err := common.NewMMF(file, false, 0, fileInfo.Size())
+	if err != nil {
+		return fmt.Errorf("cannot map the plan %s: %v", filepath.Base(path), err)
+	}
+	defer mmf.Unmap()
+
+	// the header is verified before the plan is read through it, its transfer table is then known to fit in the plan
+	h, err := checkJobPartPlanHeader(mmf.Slice())
+	if err != nil {
+		return fmt.Errorf("cannot read the plan %s: %v", filepath.Base(path), err)
+	}
+	plan := (*JobPartPlanMMF)(mmf).Plan()
+	if partNum == 0 {
+		if h.StartTime != 0 {
+			d.StartTime = time.Unix(0, h.StartTime)
+		}
+		d.CommandString = string(mmf.Slice()[unsafe.Sizeof(h) : unsafe.Sizeof(h)+uintptr(h.CommandStringLength)])
+		d.JobStatus = plan.JobStatus()
+	}
+	d.CompleteJobOrdered = d.CompleteJobOrdered || h.IsFinalPart
+	d.TotalTransfers += h.NumTransfers
+	for t := uint32(0); t < h.NumTransfers; t++ {
+		transfer := plan.Transfer(t)
+		addTransferToDetail(d, transfer.SourceSize, transfer.TransferStatus())
+	}
+	return nil
+}
+
+// addOldJobPartPlanToDetail adds the plan of a prior data schema version to the detail of its job. The plan is read whole,
+// since its layout isn't the one the plan is mapped as; it's rare, the plans are migrated when their job is resumed.
+func addOldJobPartPlanToDetail(d *common.JobDetail, path string, partNum common.PartNumber, version common.Version) error {
+	contents, err := readJobPartPlanFile(path, version)
+	if err != nil {
+		return err
+	}
+	if partNum == 0 {
+		d.StartTime = contents.startTime
+		d.CommandString = contents.order.CommandString
+		d.JobStatus = contents.jobStatus
+	}
+	d.CompleteJobOrdered = d.CompleteJobOrdered || contents.order.IsFinalPart
+	d.TotalTransfers += uint32(len(contents.order.Transfers))
+	for t, transfer := range contents.order.Transfers {
+		addTransferToDetail(d, transfer.SourceSize, contents.transferStatuses[t])
+	}
+	return nil
+}
+
+// addTransferToDetail adds a transfer of the given size and status to the detail of its job
+func addTransferToDetail(d *common.JobDetail, sourceSize int64, status common.TransferStatus) {
+	d.TotalBytesEnumerated += uint64(sourceSize)
+	switch {
+	case status == common.ETransferStatus.Success():
+		d.TransfersCompleted++
+		d.TotalBytesTransferred += uint64(sourceSize)
+	case status.DidFail():
+		d.TransfersFailed++
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+// jobPlanFiles are the plan files of a job found in the plan folder
+type jobPlanFiles struct {
+	jobID common.JobID
+	// paths holds the path of the plan file of each part
+	paths map[common.PartNumber]string
+	// versions holds the data schema version of each part
+	versions map[common.PartNumber]common.Version
+}
+
+// findJobPlanFiles groups the plan files of the plan folder by job, whichever their data schema version
+func findJobPlanFiles(planDir string) []*jobPlanFiles {
+	var jobs []*jobPlanFiles
+	byJobID := map[common.JobID]*jobPlanFiles{}
+	walkJobPartPlanFiles(planDir, func(jpfn JobPartPlanFileName, jobID common.JobID, partNum common.PartNumber, version common.Version) {
+		job, found := byJobID[jobID]
+		if !found {
+			job = &jobPlanFiles{jobID: jobID, paths: map[common.PartNumber]string{}, versions: map[common.PartNumber]common.Version{}}
+			byJobID[jobID] = job
+			jobs = append(jobs, job)
+		}
+		job.paths[partNum] = filepath.Join(planDir, string(jpfn))
+		job.versions[partNum] = version
+	})
+	return jobs
+}
+
+// partNumbers returns the part numbers of the job's plan files in order
+func (job *jobPlanFiles) partNumbers() []common.PartNumber {
+	partNums := make([]common.PartNumber, 0, len(job.paths))
+	for partNum := range job.paths {
+		partNums = append(partNums, partNum)
+	}
+	sort.Slice(partNums, func(i, j int) bool { return partNums[i] < partNums[j] })
+	return partNums
+}
+
+// statusAndTTL returns the status of the job and the TTL of its plan files in seconds, which part 0 of the job holds
+func (job *jobPlanFiles) statusAndTTL() (common.JobStatus, uint32, error) {
+	path, found := job.paths[0]
+	if !found {
+		return 0, 0, fmt.Errorf("the plan of part 0 of job %s is missing", job.jobID)
+	}
+	if version := job.versions[0]; version != DataSchemaVersion {
+		contents, err := readJobPartPlanFile(path, version)
+		if err != nil {
+			return 0, 0, err
+		}
+		return contents.jobStatus, contents.order.TTLAfterCompletion, nil
+	}
+
+	// only the header of a plan of the current version is needed, not its transfers
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	header := make([]byte, unsafe.Sizeof(JobPartPlanHeader{}))
+	if _, err = io.ReadFull(file, header); err != nil {
+		return 0, 0, fmt.Errorf("cannot read the plan %s: %v", path, err)
+	}
+	var h JobPartPlanHeader
+	if err = copyFromPlan(header, 0, &h); err != nil {
+		return 0, 0, err
+	}
+	return h.atomicJobStatus, h.TTLAfterCompletion, nil
+}
+
+// lastModified returns the last time any plan file of the job was modified, e.g. when the job finished
+func (job *jobPlanFiles) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, path := range job.paths {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fileInfo.ModTime().After(last) {
+			last = fileInfo.ModTime()
+		}
+	}
+	return last, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// CleanJobs deletes the plan files and the logs of the jobs matching the request
+func (ja *jobsAdmin) CleanJobs(req common.CleanJobsRequest) []common.CleanedJob {
+	statuses := req.Statuses
+	if len(statuses) == 0 {
+		statuses = []common.JobStatus{common.EJobStatus.Completed(), common.EJobStatus.Cancelled()}
+	}
+	hasStatus := func(status common.JobStatus) bool {
+		for _, s := range statuses {
+			if s == status {
+				return true
+			}
+		}
+		return false
+	}
+
+	var cleaned []common.CleanedJob
+	for _, job := range findJobPlanFiles(ja.planDir) {
+		if (req.JobID != common.JobID{}) && job.jobID != req.JobID {
+			continue
+		}
+		status, _, err := job.statusAndTTL()
+		if err == nil && !hasStatus(status) {
+			continue
+		}
+		if err == nil {
+			var lastModified time.Time
+			if lastModified, err = job.lastModified(); err == nil && time.Since(lastModified) < req.OlderThan {
+				continue
+			}
+		}
+		if err == nil {
+			err = ja.cleanUpJob(job)
+		}
+		cleanedJob := common.CleanedJob{JobID: job.jobID, Status: status}
+		if err != nil {
+			cleanedJob.ErrorMsg = err.Error()
+		}
+		cleaned = append(cleaned, cleanedJob)
+	}
+	return cleaned
+}
+
+// CleanExpiredJobs deletes the plan files and the logs of the jobs which finished longer ago than the TTL of their plan.
+// The plans without a TTL are kept until they're cleaned by 'azcopy jobs clean'.
+func (ja *jobsAdmin) CleanExpiredJobs() {
+	for _, job := range findJobPlanFiles(ja.planDir) {
+		status, ttl, err := job.statusAndTTL()
+		if err != nil || ttl == 0 || (status != common.EJobStatus.Completed() && status != common.EJobStatus.Cancelled()) {
+			continue
+		}
+		lastModified, err := job.lastModified()
+		if err != nil || time.Since(lastModified) < time.Duration(ttl)*time.Second {
+			continue
+		}
+		if err = ja.cleanUpJob(job); err != nil {
+			ja.Log(pipeline.LogError, fmt.Sprintf("cannot clean up the expired job %s: %v", job.jobID, err))
+		} else if ja.ShouldLog(pipeline.LogInfo) {
+			ja.Log(pipeline.LogInfo, fmt.Sprintf("cleaned up job %s, which finished more than %v ago", job.jobID, time.Duration(ttl)*time.Second))
+		}
+	}
+}
+
+// cleanUpJob unmaps the plan files of a job, deletes them and the job's log, and forgets the job.
+// A job this process is running isn't cleaned up.
+func (ja *jobsAdmin) cleanUpJob(job *jobPlanFiles) error {
+	if jm, found := ja.JobMgr(job.jobID); found {
+		jpm0, found := jm.JobPartMgr(0)
+		if !found {
+			return fmt.Errorf("job %s is being ordered by this process", job.jobID)
+		}
+		if status := jpm0.Plan().JobStatus(); status != common.EJobStatus.Completed() && status != common.EJobStatus.Cancelled() {
+			return fmt.Errorf("job %s is %s in this process", job.jobID, status)
+		}
+		for p := PartNumber(0); true; p++ {
+			jpm, found := jm.JobPartMgr(p)
+			if !found {
+				break
+			}
+			jpm.Close() // unmaps the plan file
+		}
+		jm.CloseLog()
+		ja.DeleteJob(job.jobID)
+	}
+
+	for _, path := range job.paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// the log of the job is written to the folder azcopy runs from
+	logFileName := job.jobID.String() + ".log"
+	for _, logFile := range []string{logFileName, filepath.Join(ja.planDir, logFileName)} {
+		if err := os.Remove(logFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
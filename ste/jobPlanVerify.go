@@ -0,0 +1,162 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+// checkJobPartPlanFile returns an error if the plan file at the given path, of the current data schema version, is corrupt
+func checkJobPartPlanFile(path string) error {
+	plan, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return checkJobPartPlan(plan)
+}
+
+// checkJobPlanFiles returns an error if any plan file of the given job, of the current data schema version, is corrupt
+func checkJobPlanFiles(planDir string, jobID common.JobID) error {
+	var firstErr error
+	walkJobPartPlanFiles(planDir, func(jpfn JobPartPlanFileName, id common.JobID, partNum common.PartNumber, version common.Version) {
+		if firstErr != nil || id != jobID || version != DataSchemaVersion {
+			return
+		}
+		if err := checkJobPartPlanFile(filepath.Join(planDir, string(jpfn))); err != nil {
+			firstErr = fmt.Errorf("the plan of part %d is corrupt: %v", partNum, err)
+		}
+	})
+	return firstErr
+}
+
+// VerifyJobParts verifies the integrity of the plan files of a job, and rebuilds the corrupt ones from their intact transfers if asked to.
+// The plans of a job this process holds aren't rebuilt, since they're mapped.
+func (ja *jobsAdmin) VerifyJobParts(req common.VerifyJobRequest) common.VerifyJobResponse {
+	var job *jobPlanFiles
+	for _, j := range findJobPlanFiles(ja.planDir) {
+		if j.jobID == req.JobID {
+			job = j
+			break
+		}
+	}
+	if job == nil {
+		return common.VerifyJobResponse{ErrorMsg: fmt.Sprintf("no job with JobId %v exists", req.JobID)}
+	}
+	if _, found := ja.JobMgr(req.JobID); found && req.Repair {
+		return common.VerifyJobResponse{ErrorMsg: fmt.Sprintf("job with JobId %v is held by this process, its plans can't be rebuilt", req.JobID)}
+	}
+
+	resp := common.VerifyJobResponse{}
+	for _, partNum := range job.partNumbers() {
+		resp.Parts = append(resp.Parts, ja.verifyJobPart(job, partNum, req.Repair))
+	}
+	return resp
+}
+
+// verifyJobPart verifies the plan file of a job part, and rebuilds it from its intact transfers if some are corrupt and it's asked to
+func (ja *jobsAdmin) verifyJobPart(job *jobPlanFiles, partNum common.PartNumber, repair bool) common.JobPartVerification {
+	v := common.JobPartVerification{PartNum: partNum}
+	if version := job.versions[partNum]; version != DataSchemaVersion {
+		v.ErrorMsg = fmt.Sprintf("the plan has data schema version %d, run 'azcopy jobs migrate %v' to bring it to version %d first",
+			version, job.jobID, DataSchemaVersion)
+		return v
+	}
+
+	path := job.paths[partNum]
+	plan, err := ioutil.ReadFile(path)
+	if err != nil {
+		v.ErrorMsg = err.Error()
+		return v
+	}
+	contents, corruptTransfers, err := readIntactJobPartPlan(plan)
+	if err == nil && (contents.order.JobID != job.jobID || contents.order.PartNum != partNum) {
+		err = fmt.Errorf("the plan is the plan of part %d of job %v", contents.order.PartNum, contents.order.JobID)
+	}
+	if err != nil {
+		v.ErrorMsg = err.Error()
+		return v
+	}
+	v.NumTransfers = uint32(len(contents.order.Transfers) + len(corruptTransfers))
+	v.CorruptTransfers = corruptTransfers
+	transferTable := int64(unsafe.Sizeof(JobPartPlanHeader{})) + int64(len(contents.order.CommandString))
+	for _, t := range corruptTransfers {
+		v.CorruptTransferSources = append(v.CorruptTransferSources,
+			corruptTransferSource(plan, transferTable+int64(t)*int64(unsafe.Sizeof(JobPartPlanTransfer{}))))
+	}
+	// a plan is only rebuilt when the user asks for it, since its corrupt transfers are dropped for good
+	if !repair || len(corruptTransfers) == 0 {
+		return v
+	}
+
+	if len(contents.order.Transfers) == 0 {
+		v.ErrorMsg = "none of the transfers of the plan is intact, it can't be rebuilt"
+		return v
+	}
+	if err = rebuildJobPartPlan(path, contents); err != nil {
+		v.ErrorMsg = fmt.Sprintf("cannot rebuild the plan: %v", err)
+		return v
+	}
+	v.Repaired = true
+	if ja.ShouldLog(pipeline.LogWarning) {
+		dropped := make([]string, len(corruptTransfers))
+		for i, t := range corruptTransfers {
+			dropped[i] = fmt.Sprintf("%d (%q)", t, v.CorruptTransferSources[i])
+		}
+		ja.Log(pipeline.LogWarning, fmt.Sprintf("rebuilt the plan of part %d of job %v without its %d corrupt transfers, which were dropped: %s",
+			partNum, job.jobID, len(corruptTransfers), strings.Join(dropped, ", ")))
+	}
+	return v
+}
+
+// corruptTransferSource returns the source of the corrupt transfer at the given offset of a plan whose header is intact,
+// or "" if it can't be read: the offset and length of the source may be corrupt as well
+func corruptTransferSource(plan []byte, offset int64) string {
+	var t JobPartPlanTransfer
+	if copyFromPlan(plan, offset, &t) != nil {
+		return ""
+	}
+	source, err := stringFromPlan(plan, t.SrcOffset, int64(t.SrcLength))
+	if err != nil || !utf8.ValidString(source) {
+		return ""
+	}
+	return source
+}
+
+// rebuildJobPartPlan writes the plan file at the given path again from the given contents. The new plan is written aside,
+// so that the corrupt one is only replaced once the new one is complete.
+func rebuildJobPartPlan(path string, contents jobPartPlanContents) error {
+	// the name of the new plan doesn't parse as the name of a plan file, so that it's never taken for one
+	rebuilt := JobPartPlanFileName("rebuilt-" + filepath.Base(path))
+	if err := writeJobPartPlan(rebuilt, contents); err != nil {
+		os.Remove(rebuilt.GetJobPartPlanPath())
+		return err
+	}
+	return os.Rename(rebuilt.GetJobPartPlanPath(), path)
+}
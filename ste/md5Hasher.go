@@ -0,0 +1,89 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"crypto/md5"
+	"hash"
+	"sync"
+)
+
+// chunkedMD5Hasher computes the MD5 hash of a memory mapped file whose chunks are uploaded or downloaded in any order,
+// in one pass. MD5 has to consume the data in order, so each chunk is hashed as soon as all the chunks before it are:
+// the worker which completes the hashed prefix of the file hashes all the chunks done so far after it,
+// while the other workers only record the chunks they are done with and move on.
+// A nil hasher hashes nothing, for the transfers which don't need the MD5 hash of their data.
+type chunkedMD5Hasher struct {
+	lock      sync.Mutex
+	hash      hash.Hash
+	data      []byte
+	chunkSize int64
+	chunkDone []bool
+	nextChunk int  // index of the first chunk which wasn't hashed yet
+	hashing   bool // true while a worker is hashing chunks
+}
+
+func newChunkedMD5Hasher(data []byte, chunkSize int64, numChunks uint32) *chunkedMD5Hasher {
+	return &chunkedMD5Hasher{hash: md5.New(), data: data, chunkSize: chunkSize, chunkDone: make([]bool, numChunks)}
+}
+
+// ChunkDone records that the data of the chunk is in the memory map, i.e. that it was read from the source of an upload
+// or written to the destination of a download. It hashes the chunk along with the chunks done after it,
+// unless a chunk before it isn't done yet, or another worker is hashing already.
+// Since it returns only after hashing, all the chunks are hashed once every ChunkDone call has returned.
+func (h *chunkedMD5Hasher) ChunkDone(chunkIndex int32) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.chunkDone[chunkIndex] = true
+	if h.hashing {
+		return
+	}
+	h.hashing = true
+	for h.nextChunk < len(h.chunkDone) && h.chunkDone[h.nextChunk] {
+		start := int64(h.nextChunk) * h.chunkSize
+		end := start + h.chunkSize
+		if end > int64(len(h.data)) {
+			end = int64(len(h.data))
+		}
+		// the lock isn't held while hashing, so that the other workers can record their chunks meanwhile
+		h.lock.Unlock()
+		h.hash.Write(h.data[start:end])
+		h.lock.Lock()
+		h.nextChunk++
+	}
+	h.hashing = false
+}
+
+// Sum returns the MD5 hash of the file, or nil if some of its chunks aren't done.
+func (h *chunkedMD5Hasher) Sum() []byte {
+	if h == nil {
+		return nil
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.nextChunk < len(h.chunkDone) {
+		return nil
+	}
+	return h.hash.Sum(nil)
+}
@@ -23,8 +23,10 @@ package ste
 import (
 	"context"
 	"fmt"
+	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-azcopy/common"
@@ -41,6 +43,8 @@ type PartNumber = common.PartNumber
 // This can be optimized if FE would no more be another module vs STE module.
 type InMemoryTransitJobState struct {
 	credentialInfo common.CredentialInfo
+	// completionHook notifies the caller once the job finishes, it is given again when the job is resumed
+	completionHook common.JobCompletionHook
 }
 
 type IJobMgr interface {
@@ -48,7 +52,7 @@ type IJobMgr interface {
 	JobPartMgr(partNum PartNumber) (IJobPartMgr, bool)
 	//Throughput() XferThroughput
 	AddJobPart(partNum PartNumber, planFile JobPartPlanFileName, sourceSAS string,
-		destinationSAS string, scheduleTransfers bool) IJobPartMgr
+		destinationSAS string, scheduleTransfers bool) (IJobPartMgr, error)
 	ResumeTransfers(appCtx context.Context, includeTransfer map[string]int, excludeTransfer map[string]int)
 	PipelineLogInfo() pipeline.LogOptions
 	ReportJobPartDone() uint32
@@ -149,10 +153,13 @@ func (jm *jobMgr) ActiveConnections() int64 {
 
 // initializeJobPartPlanInfo func initializes the JobPartPlanInfo handler for given JobPartOrder
 func (jm *jobMgr) AddJobPart(partNum PartNumber, planFile JobPartPlanFileName, sourceSAS string,
-	destinationSAS string, scheduleTransfers bool) IJobPartMgr {
+	destinationSAS string, scheduleTransfers bool) (IJobPartMgr, error) {
 	jpm := &jobPartMgr{jobMgr: jm, filename: planFile, sourceSAS: sourceSAS,
 		destinationSAS: destinationSAS, pacer: JobsAdmin.(*jobsAdmin).pacer}
-	jpm.planMMF = jpm.filename.Map()
+	var err error
+	if jpm.planMMF, err = jpm.filename.Map(); err != nil {
+		return nil, err
+	}
 	jm.jobPartMgrs.Set(partNum, jpm)
 	jm.finalPartOrdered = jpm.planMMF.Plan().IsFinalPart
 	if scheduleTransfers {
@@ -163,7 +170,7 @@ func (jm *jobMgr) AddJobPart(partNum PartNumber, planFile JobPartPlanFileName, s
 		//jpm.ScheduleTransfers(jm.ctx, make(map[string]int), make(map[string]int))
 		JobsAdmin.QueueJobParts(jpm)
 	}
-	return jpm
+	return jpm, nil
 }
 
 // ScheduleTransfers schedules this job part's transfers. It is called when a new job part is ordered & is also called to resume a paused Job
@@ -194,20 +201,53 @@ func (jm *jobMgr) ReportJobPartDone() uint32 {
 	if !ok {
 		jm.Panic(fmt.Errorf("Failed to find Job %v, Part #0", jm.jobID))
 	}
+	// the bandwidth cap of the job doesn't outlive it, whether it completed, got cancelled or paused
+	JobsAdmin.ResetBandwidthCap(jm.jobID)
 
 	switch part0Plan := jobPart0Mgr.Plan(); part0Plan.JobStatus() {
 	case common.EJobStatus.Cancelling():
-		part0Plan.SetJobStatus(common.EJobStatus.Cancelled())
+		jm.finishJob(part0Plan, common.EJobStatus.Cancelled())
 		if shouldLog {
 			jm.Log(pipeline.LogInfo, fmt.Sprintf("all parts of Job %v successfully cancelled; cleaning up the Job", jm.jobID))
 		}
 		//jm.jobsInfo.cleanUpJob(jm.jobID)
 	case common.EJobStatus.InProgress():
-		part0Plan.SetJobStatus((common.EJobStatus).Completed())
+		jm.finishJob(part0Plan, common.EJobStatus.Completed())
+	default:
+		jm.recordFinishTime()
 	}
 	return partsDone
 }
 
+// finishJob sets the final status of the job once its completion hooks, if any, are done, since the front end exits
+// as soon as it sees it. The hooks run on a goroutine of their own, so that the worker which finished the last transfer
+// goes back to the other jobs right away; they are given completionHookTimeout in all.
+func (jm *jobMgr) finishJob(part0Plan *JobPartPlanHeader, status common.JobStatus) {
+	setFinalStatus := func() {
+		part0Plan.SetJobStatus(status)
+		jm.recordFinishTime()
+	}
+	if hook := jm.getInMemoryTransitJobState().completionHook; hook.Exec == "" && hook.URL == "" {
+		setFinalStatus()
+		return
+	}
+
+	go func() {
+		jm.runCompletionHook(status)
+		setFinalStatus()
+	}()
+}
+
+// recordFinishTime records the time the job finished in the modification time of the plan of its part 0.
+// The plan files of a job are cleaned up some time after it finished, and the writes through the memory map don't reliably update it.
+func (jm *jobMgr) recordFinishTime() {
+	now := time.Now()
+	part0File := JobsAdmin.NewJobPartPlanFileName(jm.jobID, 0)
+	if err := os.Chtimes(part0File.GetJobPartPlanPath(), now, now); err != nil {
+		jm.Log(pipeline.LogWarning, fmt.Sprintf("cannot record the time Job %v finished in its plan file: %v", jm.jobID, err))
+	}
+}
+
 func (jm *jobMgr) getInMemoryTransitJobState() InMemoryTransitJobState {
 	return jm.inMemoryTransitJobState
 }
@@ -29,10 +29,13 @@ type IJobPartMgr interface {
 	ScheduleChunks(chunkFunc chunkFunc)
 	AddToBytesDone(value int64) int64
 	AddToBytesToTransfer(value int64) int64
+	AddToBytesSkipped(value int64) int64
 	BytesDone() int64
 	BytesToTransfer() int64
+	BytesSkipped() int64
 	RescheduleTransfer(jptm IJobPartTransferMgr)
 	BlobTiers() (blockBlobTier common.BlockBlobTier, pageBlobTier common.PageBlobTier)
+	BlobType() common.BlobType
 	SAS() (string, string)
 	//CancelJob()
 	Close()
@@ -77,10 +80,12 @@ func NewBlobPipeline(c azblob.Credential, o azblob.PipelineOptions, r XferRetryO
 	f := []pipeline.Factory{
 		azblob.NewTelemetryPolicyFactory(o.Telemetry),
 		azblob.NewUniqueRequestIDPolicyFactory(),
+		NewPacerGatePolicyFactory(p),
 		NewBlobXferRetryPolicyFactory(r),
+		NewTransactionalMD5PolicyFactory(),
 		c,
 		pipeline.MethodFactoryMarker(), // indicates at what stage in the pipeline the method factory is invoked
-		//NewPacerPolicyFactory(p),
+		NewPacerPolicyFactory(p),
 		NewVersionPolicyFactory(),
 		azblob.NewRequestLogPolicyFactory(o.RequestLog),
 	}
@@ -98,7 +103,9 @@ func NewBlobFSPipeline(c azbfs.Credential, o azbfs.PipelineOptions, r XferRetryO
 	f := []pipeline.Factory{
 		azbfs.NewTelemetryPolicyFactory(o.Telemetry),
 		azbfs.NewUniqueRequestIDPolicyFactory(),
+		NewPacerGatePolicyFactory(p),
 		NewBFSXferRetryPolicyFactory(r),
+		NewTransactionalMD5PolicyFactory(),
 	}
 
 	f = append(f, c)
@@ -120,7 +127,9 @@ func newFilePipeline(c azfile.Credential, o azfile.PipelineOptions, r azfile.Ret
 	f := []pipeline.Factory{
 		azfile.NewTelemetryPolicyFactory(o.Telemetry),
 		azfile.NewUniqueRequestIDPolicyFactory(),
+		NewPacerGatePolicyFactory(p),
 		azfile.NewRetryPolicyFactory(r),
+		NewTransactionalMD5PolicyFactory(),
 		c,
 		pipeline.MethodFactoryMarker(), // indicates at what stage in the pipeline the method factory is invoked
 		NewPacerPolicyFactory(p),
@@ -159,6 +168,9 @@ type jobPartMgr struct {
 	// Additional data shared by all of this Job Part's transfers; initialized when this jobPartMgr is created
 	pageBlobTier common.PageBlobTier
 
+	// Additional data shared by all of this Job Part's transfers; initialized when this jobPartMgr is created
+	blobType common.BlobType
+
 	blobMetadata azblob.Metadata
 	fileMetadata azfile.Metadata
 
@@ -184,6 +196,10 @@ type jobPartMgr struct {
 	// totalBytesToTransfer defines the total number of bytes of JobPart that needs to uploaded or downloaded.
 	// It is the sum of size of all the transfer of a job part.
 	totalBytesToTransfer int64
+
+	// bytesSkipped is the part of bytesDone which wasn't sent since the destination reads it back as zeros anyway,
+	// e.g. the empty pages of a sparse page blob.
+	bytesSkipped int64
 }
 
 func (jpm *jobPartMgr) Plan() *JobPartPlanHeader { return jpm.planMMF.Plan() }
@@ -205,6 +221,7 @@ func (jpm *jobPartMgr) ScheduleTransfers(jobCtx context.Context, includeTransfer
 
 	jpm.blockBlobTier = dstData.BlockBlobTier
 	jpm.pageBlobTier = dstData.PageBlobTier
+	jpm.blobType = dstData.BlobType
 	jpm.fileHTTPHeaders = azfile.FileHTTPHeaders{
 		ContentType:     string(dstData.ContentType[:dstData.ContentTypeLength]),
 		ContentEncoding: string(dstData.ContentEncoding[:dstData.ContentEncodingLength]),
@@ -229,7 +246,7 @@ func (jpm *jobPartMgr) ScheduleTransfers(jobCtx context.Context, includeTransfer
 
 	jpm.preserveLastModifiedTime = plan.DstLocalData.PreserveLastModifiedTime
 
-	jpm.newJobXfer = computeJobXfer(plan.FromTo)
+	jpm.newJobXfer = computeJobXfer(plan.FromTo, jpm.blobType)
 
 	jpm.priority = plan.Priority
 
@@ -239,6 +256,7 @@ func (jpm *jobPartMgr) ScheduleTransfers(jobCtx context.Context, includeTransfer
 	for t := uint32(0); t < plan.NumTransfers; t++ {
 		jppt := plan.Transfer(t)
 		jpm.AddToBytesToTransfer(jppt.SourceSize)
+		jpm.AddToBytesSkipped(jppt.BytesSkipped()) // the bytes skipped by the previous attempts of the transfer, if the job was resumed
 		ts := jppt.TransferStatus()
 		if ts == common.ETransferStatus.Success() {
 			jpm.ReportTransferDone()            // Don't schedule an already-completed/failed transfer
@@ -277,8 +295,10 @@ func (jpm *jobPartMgr) ScheduleTransfers(jobCtx context.Context, includeTransfer
 		}
 
 		// If the transfer was failed, then while rescheduling the transfer marking it Started.
-		if ts == common.ETransferStatus.Failed() {
+		// The failure it was failed with no longer applies.
+		if ts.DidFail() {
 			jppt.SetTransferStatus(common.ETransferStatus.Started(), true)
+			jppt.resetTransferFailure()
 		}
 
 		// Each transfer gets its own context (so any chunk can cancel the whole transfer) based off the job's context
@@ -295,16 +315,16 @@ func (jpm *jobPartMgr) ScheduleTransfers(jobCtx context.Context, includeTransfer
 		if jpm.ShouldLog(pipeline.LogInfo) {
 			jpm.Log(pipeline.LogInfo, fmt.Sprintf("scheduling JobID=%v, Part#=%d, Transfer#=%d, priority=%v", plan.JobID, plan.PartNum, t, plan.Priority))
 		}
-		JobsAdmin.(*jobsAdmin).ScheduleTransfer(jpm.priority, jptm)
+		JobsAdmin.(*jobsAdmin).ScheduleTransfer(jpm.jobMgr.JobID(), jpm.priority, jptm)
 	}
 }
 
 func (jpm *jobPartMgr) ScheduleChunks(chunkFunc chunkFunc) {
-	JobsAdmin.ScheduleChunk(jpm.priority, chunkFunc)
+	JobsAdmin.ScheduleChunk(jpm.jobMgr.JobID(), jpm.priority, chunkFunc)
 }
 
 func (jpm *jobPartMgr) RescheduleTransfer(jptm IJobPartTransferMgr) {
-	JobsAdmin.(*jobsAdmin).ScheduleTransfer(jpm.priority, jptm)
+	JobsAdmin.(*jobsAdmin).ScheduleTransfer(jpm.jobMgr.JobID(), jpm.priority, jptm)
 }
 
 // refreshToken is a delegate function for token refreshing.
@@ -467,12 +487,7 @@ func (jpm *jobPartMgr) createPipeline(ctx context.Context) {
 						Value: common.UserAgent,
 					},
 				},
-				XferRetryOptions{
-					Policy:        0,
-					MaxTries:      UploadMaxTries,
-					TryTimeout:    UploadTryTimeout,
-					RetryDelay:    UploadRetryDelay,
-					MaxRetryDelay: UploadMaxRetryDelay},
+				GetXferRetryOptions(),
 				jpm.pacer)
 		// Create pipeline for Azure BlobFS.
 		case common.EFromTo.BlobFSLocal(), common.EFromTo.LocalBlobFS():
@@ -485,12 +500,7 @@ func (jpm *jobPartMgr) createPipeline(ctx context.Context) {
 						Value: common.UserAgent,
 					},
 				},
-				XferRetryOptions{
-					Policy:        0,
-					MaxTries:      UploadMaxTries,
-					TryTimeout:    UploadTryTimeout,
-					RetryDelay:    UploadRetryDelay,
-					MaxRetryDelay: UploadMaxRetryDelay},
+				GetXferRetryOptions(),
 				jpm.pacer)
 		// Create pipeline for Azure File.
 		case common.EFromTo.FileTrash(), common.EFromTo.FileLocal(), common.EFromTo.LocalFile():
@@ -504,10 +514,10 @@ func (jpm *jobPartMgr) createPipeline(ctx context.Context) {
 				},
 				azfile.RetryOptions{
 					Policy:        azfile.RetryPolicyExponential,
-					MaxTries:      UploadMaxTries,
-					TryTimeout:    UploadTryTimeout,
-					RetryDelay:    UploadRetryDelay,
-					MaxRetryDelay: UploadMaxRetryDelay,
+					MaxTries:      GetXferRetryOptions().MaxTries,
+					TryTimeout:    GetXferRetryOptions().TryTimeout,
+					RetryDelay:    GetXferRetryOptions().RetryDelay,
+					MaxRetryDelay: GetXferRetryOptions().MaxRetryDelay,
 				},
 				jpm.pacer)
 		default:
@@ -517,6 +527,7 @@ func (jpm *jobPartMgr) createPipeline(ctx context.Context) {
 }
 
 func (jpm *jobPartMgr) StartJobXfer(jptm IJobPartTransferMgr) {
+	defer failTransferOnPanic(jptm, "starting the transfer")
 	//jpm.createPipeline() //TODO: Ensure with @Jeff and @Prateek, as pipeline is created per jobPartMgr, it is moved to ScheduleTransfers
 	jpm.newJobXfer(jptm, jpm.pipeline, jpm.pacer)
 }
@@ -529,6 +540,10 @@ func (jpm *jobPartMgr) AddToBytesToTransfer(value int64) int64 {
 	return atomic.AddInt64(&jpm.totalBytesToTransfer, value)
 }
 
+func (jpm *jobPartMgr) AddToBytesSkipped(value int64) int64 {
+	return atomic.AddInt64(&jpm.bytesSkipped, value)
+}
+
 func (jpm *jobPartMgr) BytesDone() int64 {
 	return atomic.LoadInt64(&jpm.bytesDone)
 }
@@ -537,6 +552,10 @@ func (jpm *jobPartMgr) BytesToTransfer() int64 {
 	return atomic.LoadInt64(&jpm.totalBytesToTransfer)
 }
 
+func (jpm *jobPartMgr) BytesSkipped() int64 {
+	return atomic.LoadInt64(&jpm.bytesSkipped)
+}
+
 func (jpm *jobPartMgr) IsForceWriteTrue() bool {
 	return jpm.Plan().ForceWrite
 }
@@ -559,13 +578,18 @@ func (jpm *jobPartMgr) BlobTiers() (blockBlobTier common.BlockBlobTier, pageBlob
 	return jpm.blockBlobTier, jpm.pageBlobTier
 }
 
+// BlobType returns the type of the blobs created by an upload, or Detect if it depends on each file uploaded
+func (jpm *jobPartMgr) BlobType() common.BlobType {
+	return jpm.blobType
+}
+
 func (jpm *jobPartMgr) SAS() (string, string) {
 	return jpm.sourceSAS, jpm.destinationSAS
 }
 
-func (jpm *jobPartMgr) localDstData() (preserveLastModifiedTime bool) {
+func (jpm *jobPartMgr) localDstData() (preserveLastModifiedTime bool, md5ValidationOption common.HashValidationOption) {
 	dstData := &jpm.Plan().DstLocalData
-	return dstData.PreserveLastModifiedTime
+	return dstData.PreserveLastModifiedTime, dstData.MD5ValidationOption
 }
 
 // Call Done when a transfer has completed its epilog; this method returns the number of transfers completed so far
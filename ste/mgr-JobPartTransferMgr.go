@@ -2,7 +2,11 @@ package ste
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -21,19 +25,31 @@ type IJobPartTransferMgr interface {
 	BlobDstData(dataFileToXfer *common.MMF) (headers azblob.BlobHTTPHeaders, metadata azblob.Metadata)
 	FileDstData(dataFileToXfer *common.MMF) (headers azfile.FileHTTPHeaders, metadata azfile.Metadata)
 	PreserveLastModifiedTime() (time.Time, bool)
+	MD5ValidationOption() common.HashValidationOption
 	BlobTiers() (blockBlobTier common.BlockBlobTier, pageBlobTier common.PageBlobTier)
+	BlobType() common.BlobType
 	//ScheduleChunk(chunkFunc chunkFunc)
 	Context() context.Context
 	StartJobXfer()
 	IsForceWriteTrue() bool
-	ReportChunkDone() (lastChunk bool, chunksDone uint32)
+	ReportChunkDone(workerID int) (lastChunk bool, chunksDone uint32)
+	ChunkReportedDone(workerID int) (reported bool, lastChunk bool)
+	ChunkBlockID(chunkIndex uint32) string
+	ChunkCompleted(chunkIndex uint32) bool
+	SetChunkCompleted(chunkIndex uint32)
+	ResetCompletedChunks()
+	HasCompletedChunks() bool
+	SourceETag() string
+	SetSourceETag(etag string)
 	TransferStatus() common.TransferStatus
 	SetStatus(status common.TransferStatus)
+	SetErrorStatus(err error)
 	SetNumberOfChunks(numChunks uint32)
 	ReportTransferDone() uint32
 	RescheduleTransfer()
 	ScheduleChunks(chunkFunc chunkFunc)
 	AddToBytesDone(value int64) int64
+	AddToBytesSkipped(value int64) int64
 	Cancel()
 	WasCanceled() bool
 	// TODO: added for debugging purpose. remove later
@@ -54,6 +70,10 @@ type TransferInfo struct {
 
 	SrcHTTPHeaders azblob.BlobHTTPHeaders // User for S2S copy, where per transfer's src properties need be set in destination.
 	SrcMetadata    common.Metadata
+	SrcBlobType    common.BlobType
+
+	// SourceLastModifiedTime is the time at which the source was last modified when the job was ordered
+	SourceLastModifiedTime time.Time
 
 	// NumChunks is the number of chunks in which transfer will be split into while uploading the transfer.
 	// NumChunks is not used in case of AppendBlob transfer.
@@ -82,6 +102,15 @@ type jobPartTransferMgr struct {
 	// NumberOfChunksDone determines the final cancellation or completion of a transfer
 	atomicChunksDone uint32
 
+	// chunksReported holds, for each worker running a chunk of this transfer which reported itself done,
+	// whether that chunk was the last one; see failChunkOnPanic
+	chunksReportedLock sync.Mutex
+	chunksReported     map[int]bool
+
+	// atomicTransferDone is set once the transfer has been reported as done, so that it is reported only once
+	// even when a panic fails the transfer while some of its chunks are still being processed
+	atomicTransferDone uint32
+
 	/*
 		@Parteek removed 3/23 morning, as jeff ad equivalent
 		// transfer chunks are put into this channel and execution engine takes chunk out of this channel.
@@ -103,7 +132,6 @@ func (jptm *jobPartTransferMgr) IsForceWriteTrue() bool {
 func (jptm *jobPartTransferMgr) Info() TransferInfo {
 	plan := jptm.jobPartMgr.Plan()
 	src, dst := plan.TransferSrcDstStrings(jptm.transferIndex)
-	dstBlobData := plan.DstBlobData
 
 	srcHTTPHeaders, srcMetadata := plan.TransferSrcHTTPHeadersAndMetadata(jptm.transferIndex)
 	srcSAS, dstSAS := jptm.jobPartMgr.SAS()
@@ -143,12 +171,15 @@ func (jptm *jobPartTransferMgr) Info() TransferInfo {
 		src = sUrl.String()
 	}
 	return TransferInfo{
-		BlockSize:      dstBlobData.BlockSize,
+		BlockSize:      plan.Transfer(jptm.transferIndex).BlockSize,
 		Source:         src,
 		SourceSize:     plan.Transfer(jptm.transferIndex).SourceSize,
 		Destination:    dst,
 		SrcHTTPHeaders: srcHTTPHeaders,
 		SrcMetadata:    srcMetadata,
+		SrcBlobType:    plan.Transfer(jptm.transferIndex).SrcBlobType,
+
+		SourceLastModifiedTime: time.Unix(0, plan.Transfer(jptm.transferIndex).ModifiedTime),
 	}
 }
 
@@ -161,7 +192,11 @@ func (jptm *jobPartTransferMgr) RescheduleTransfer() {
 }
 
 func (jptm *jobPartTransferMgr) ScheduleChunks(chunkFunc chunkFunc) {
-	jptm.jobPartMgr.ScheduleChunks(chunkFunc)
+	jptm.jobPartMgr.ScheduleChunks(func(workerID int) {
+		jptm.startChunk(workerID)
+		defer failChunkOnPanic(jptm, workerID)
+		chunkFunc(workerID)
+	})
 }
 
 func (jptm *jobPartTransferMgr) BlobDstData(dataFileToXfer *common.MMF) (headers azblob.BlobHTTPHeaders, metadata azblob.Metadata) {
@@ -176,28 +211,120 @@ func (jptm *jobPartTransferMgr) AddToBytesDone(value int64) int64 {
 	return jptm.jobPartMgr.AddToBytesDone(value)
 }
 
+// AddToBytesSkipped records bytes which are counted as done without being sent, see jobPartMgr.bytesSkipped
+// They are recorded in the job part plan too, along with the chunks they belong to
+func (jptm *jobPartTransferMgr) AddToBytesSkipped(value int64) int64 {
+	jptm.jobPartPlanTransfer.AddToBytesSkipped(value)
+	return jptm.jobPartMgr.AddToBytesSkipped(value)
+}
+
 // PreserveLastModifiedTime checks for the PreserveLastModifiedTime flag in JobPartPlan of a transfer.
 // If PreserveLastModifiedTime is set to true, it returns the lastModifiedTime of the source.
 func (jptm *jobPartTransferMgr) PreserveLastModifiedTime() (time.Time, bool) {
-	if preserveLastModifiedTime := jptm.jobPartMgr.(*jobPartMgr).localDstData(); preserveLastModifiedTime {
+	if preserveLastModifiedTime, _ := jptm.jobPartMgr.(*jobPartMgr).localDstData(); preserveLastModifiedTime {
 		lastModifiedTime := jptm.jobPartPlanTransfer.ModifiedTime
 		return time.Unix(0, lastModifiedTime), true
 	}
 	return time.Time{}, false
 }
 
+// MD5ValidationOption returns how strictly a download validates the MD5 hash of the data against the content MD5 of its source.
+func (jptm *jobPartTransferMgr) MD5ValidationOption() common.HashValidationOption {
+	_, md5ValidationOption := jptm.jobPartMgr.(*jobPartMgr).localDstData()
+	return md5ValidationOption
+}
+
 func (jptm *jobPartTransferMgr) BlobTiers() (blockBlobTier common.BlockBlobTier, pageBlobTier common.PageBlobTier) {
 	return jptm.jobPartMgr.BlobTiers()
 }
 
+func (jptm *jobPartTransferMgr) BlobType() common.BlobType {
+	return jptm.jobPartMgr.BlobType()
+}
+
 func (jptm *jobPartTransferMgr) SetNumberOfChunks(numChunks uint32) {
 	jptm.numChunks = numChunks
 }
 
 // Call Done when a chunk has completed its transfer; this method returns the number of chunks completed so far
-func (jptm *jobPartTransferMgr) ReportChunkDone() (lastChunk bool, chunksDone uint32) {
+// workerID is the worker running the chunk, which records that the chunk was reported done
+func (jptm *jobPartTransferMgr) ReportChunkDone(workerID int) (lastChunk bool, chunksDone uint32) {
 	chunksDone = atomic.AddUint32(&jptm.atomicChunksDone, 1)
-	return chunksDone == jptm.numChunks, chunksDone
+	lastChunk = chunksDone == jptm.numChunks
+	jptm.chunksReportedLock.Lock()
+	if jptm.chunksReported == nil {
+		jptm.chunksReported = map[int]bool{}
+	}
+	jptm.chunksReported[workerID] = lastChunk
+	jptm.chunksReportedLock.Unlock()
+	return lastChunk, chunksDone
+}
+
+// ChunkReportedDone returns whether the chunk the given worker is running was reported done, and whether it was the last one
+func (jptm *jobPartTransferMgr) ChunkReportedDone(workerID int) (reported bool, lastChunk bool) {
+	jptm.chunksReportedLock.Lock()
+	defer jptm.chunksReportedLock.Unlock()
+	lastChunk, reported = jptm.chunksReported[workerID]
+	return reported, lastChunk
+}
+
+// startChunk forgets whether the previous chunk of this transfer which the given worker ran was reported done,
+// since the worker is about to run another one
+func (jptm *jobPartTransferMgr) startChunk(workerID int) {
+	jptm.chunksReportedLock.Lock()
+	delete(jptm.chunksReported, workerID)
+	jptm.chunksReportedLock.Unlock()
+}
+
+// ChunkBlockID returns the base64 encoded ID of the block which holds the given chunk of this transfer
+// The ID only depends on the job, part, transfer and chunk, so a resumed transfer finds the blocks it staged before
+// All the IDs have the same length, as the service requires for the blocks of a blob
+func (jptm *jobPartTransferMgr) ChunkBlockID(chunkIndex uint32) string {
+	plan := jptm.jobPartMgr.Plan()
+	jobID := strings.Replace(plan.JobID.String(), "-", "", -1)
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s%010d%010d%010d", jobID, plan.PartNum, jptm.transferIndex, chunkIndex)))
+}
+
+// ChunkCompleted returns true if the completed-chunk record of this transfer says the given chunk was transferred
+func (jptm *jobPartTransferMgr) ChunkCompleted(chunkIndex uint32) bool {
+	record := jptm.jobPartMgr.Plan().TransferChunkRecord(jptm.transferIndex)
+	if chunkIndex/32 >= uint32(len(record)) {
+		return false
+	}
+	return atomic.LoadUint32(&record[chunkIndex/32])&(1<<(chunkIndex%32)) != 0
+}
+
+// SetChunkCompleted records in the job part plan that the given chunk of this transfer was transferred
+func (jptm *jobPartTransferMgr) SetChunkCompleted(chunkIndex uint32) {
+	record := jptm.jobPartMgr.Plan().TransferChunkRecord(jptm.transferIndex)
+	if chunkIndex/32 >= uint32(len(record)) {
+		// the record only covers chunks of the job's block size
+		return
+	}
+	common.AtomicMorphUint32(&record[chunkIndex/32], func(startVal uint32) (uint32, interface{}) {
+		return startVal | 1<<(chunkIndex%32), nil
+	})
+}
+
+// HasCompletedChunks returns true if the completed-chunk record of this transfer says any of its chunks was transferred
+func (jptm *jobPartTransferMgr) HasCompletedChunks() bool {
+	record := jptm.jobPartMgr.Plan().TransferChunkRecord(jptm.transferIndex)
+	for i := range record {
+		if atomic.LoadUint32(&record[i]) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetCompletedChunks forgets every chunk this transfer completed before, so that all of them get transferred again,
+// along with the bytes those chunks skipped
+func (jptm *jobPartTransferMgr) ResetCompletedChunks() {
+	record := jptm.jobPartMgr.Plan().TransferChunkRecord(jptm.transferIndex)
+	for i := range record {
+		atomic.StoreUint32(&record[i], 0)
+	}
+	jptm.jobPartMgr.AddToBytesSkipped(-jptm.jobPartPlanTransfer.ResetBytesSkipped())
 }
 
 //
@@ -205,11 +332,32 @@ func (jptm *jobPartTransferMgr) TransferStatus() common.TransferStatus {
 	return jptm.jobPartPlanTransfer.TransferStatus()
 }
 
+// SourceETag returns the ETag which the source had when this transfer started downloading it
+func (jptm *jobPartTransferMgr) SourceETag() string {
+	return jptm.jobPartPlanTransfer.SourceETag()
+}
+
+// SetSourceETag records the ETag of the source in the job part plan
+func (jptm *jobPartTransferMgr) SetSourceETag(etag string) {
+	jptm.jobPartPlanTransfer.SetSourceETag(etag)
+}
+
 // TransferStatus updates the status of given transfer for given jobId and partNumber
 func (jptm *jobPartTransferMgr) SetStatus(status common.TransferStatus) {
 	jptm.jobPartPlanTransfer.SetTransferStatus(status, false)
 }
 
+// SetErrorStatus fails the transfer because of err, with the failure status of the category err falls into,
+// and records the HTTP status and the service error code of err in the job part plan
+func (jptm *jobPartTransferMgr) SetErrorStatus(err error) {
+	status := transferFailureStatus(err)
+	if status == common.ETransferStatus.SourceChangedFailure() && jptm.ShouldLog(pipeline.LogError) {
+		jptm.Log(pipeline.LogError, fmt.Sprintf("the source %s was modified during its transfer", jptm.Info().Source))
+	}
+	errorCode, serviceErrorCode := serviceErrorCode(err)
+	jptm.jobPartPlanTransfer.SetTransferFailure(status, int32(errorCode), serviceErrorCode)
+}
+
 // TODO: Can we kill this method?
 /*func (jptm *jobPartTransferMgr) ChunksDone() uint32 {
 	return atomic.LoadUint32(&jptm.atomicChunksDone)
@@ -271,7 +419,69 @@ func (jptm *jobPartTransferMgr) LogError(resource, context string, err error) {
 func (jptm *jobPartTransferMgr) Panic(err error) { jptm.jobPartMgr.Panic(err) }
 
 // Call ReportTransferDone to report when a Transfer for this Job Part has completed
+// The transfer is only reported the first time, later calls return 0
 // TODO: I feel like this should take the status & we kill SetStatus
 func (jptm *jobPartTransferMgr) ReportTransferDone() uint32 {
+	if !atomic.CompareAndSwapUint32(&jptm.atomicTransferDone, 0, 1) {
+		return 0
+	}
 	return jptm.jobPartMgr.ReportTransferDone()
 }
+
+// failTransferOnPanic turns a panic of the code processing a transfer into a failure of that transfer, so that
+// one bad transfer doesn't bring down the rest of its job, nor the other jobs running in the transfer engine.
+// The panic is logged along with its stack trace. It must be deferred, since it calls recover.
+func failTransferOnPanic(jptm IJobPartTransferMgr, activity string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	failTransferAfterPanic(jptm, activity, r)
+	jptm.ReportTransferDone()
+}
+
+// failChunkOnPanic is failTransferOnPanic for the chunks of a transfer, which run on the worker workerID.
+// If the chunk which panicked didn't report itself done, it's reported done here, otherwise the last chunk of the transfer
+// would never come. If it was the last chunk, it couldn't conclude the transfer, so the transfer is at least reported done,
+// so that its job can finish, even though its memory maps, files and partial destination may not have been released.
+// The other chunks conclude the transfer like any cancelled transfer. It must be deferred, since it calls recover.
+func failChunkOnPanic(jptm IJobPartTransferMgr, workerID int) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	failTransferAfterPanic(jptm, "processing a chunk", r)
+	reported, lastChunk := jptm.ChunkReportedDone(workerID)
+	if !reported {
+		lastChunk, _ = jptm.ReportChunkDone(workerID)
+	}
+	if lastChunk {
+		if jptm.ShouldLog(pipeline.LogError) {
+			jptm.Log(pipeline.LogError, "the last chunk of the transfer panicked, so the transfer couldn't be cleaned up")
+		}
+		jptm.ReportTransferDone()
+	}
+}
+
+// failTransferAfterPanic logs the panic r, which was recovered from while doing activity, and fails the transfer.
+// The other chunks of the transfer only need to wind down, so the transfer is cancelled.
+func failTransferAfterPanic(jptm IJobPartTransferMgr, activity string, r interface{}) {
+	if jptm.ShouldLog(pipeline.LogError) {
+		jptm.Log(pipeline.LogError, fmt.Sprintf("recovered from a panic while %s: %v. Transfer %s\n%s",
+			activity, r, describeTransfer(jptm), debug.Stack()))
+	}
+	jptm.SetStatus(common.ETransferStatus.Failed())
+	jptm.Cancel()
+}
+
+// describeTransfer returns the source and destination of the transfer for the logs. Reading them can panic too,
+// e.g. if the job part plan holding them is corrupted, so the transfer is then left undescribed.
+func describeTransfer(jptm IJobPartTransferMgr) (description string) {
+	defer func() {
+		if r := recover(); r != nil {
+			description = fmt.Sprintf("whose source and destination can't be read: %v", r)
+		}
+	}()
+	info := jptm.Info()
+	return fmt.Sprintf("Src %s Dst %s", info.Source, info.Destination)
+}
@@ -24,7 +24,8 @@ import (
 	"context"
 	"io"
 	"net/http"
-	"runtime"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -36,82 +37,344 @@ import (
 type pacer struct {
 	bytesAvailable          int64
 	availableBytesPerPeriod int64
-	bytesTransferred        int64
-	lastUpdatedTimestamp    int64
+	// maxBytesPerPeriod is the ceiling derived from the user's bandwidth cap.
+	// availableBytesPerPeriod never grows past it, and 0 means the pacer is not capping at all.
+	maxBytesPerPeriod    int64
+	bytesTransferred     int64
+	lastUpdatedTimestamp int64
+
+	// statistics of the responses seen by the pacer policy, used to auto-tune the concurrency
+	requestCount   int64 // number of requests which got a response
+	throttledCount int64 // number of responses whose status code is classified as throttling, see XferRetryOptions
+	latencyInNs    int64 // sum of the time taken by every request, in nanoseconds
+
+	// engine-wide backoff, entered whenever the service throttles any request
+	backoffUntil           int64 // unix time in nanoseconds before which no request is sent
+	lastReductionTimestamp int64 // unix time in nanoseconds of the last time maxRequestsInFlight was halved
+	maxRequestsInFlight    int64 // number of requests allowed in flight at once, 0 means there is no limit
+	requestsInFlight       int64 // only changed while holding inFlightLock
+	successesSinceIncrease int64 // successful responses since maxRequestsInFlight was last raised
+	throttleEvents         int64 // number of throttling responses seen
+	backoffInNs            int64 // total wall clock time spent backing off, in nanoseconds
+
+	// inFlightChanged is closed, then replaced, whenever room is made in flight, to wake up the requests waiting for it
+	inFlightLock    sync.Mutex
+	inFlightChanged chan struct{}
+
+	// done is closed to stop the goroutine which issues the tickets
+	done     chan struct{}
+	doneOnce sync.Once
 }
 
+const (
+	// defaultThrottleBackoff is how long the engine backs off when a throttling response has no Retry-After header
+	defaultThrottleBackoff = 2 * time.Second
+	// maxThrottleBackoff caps the Retry-After honored, so that a bogus header cannot stall the engine
+	maxThrottleBackoff = time.Minute
+	// minRequestsInFlight is the floor under which throttling no longer reduces the concurrency
+	minRequestsInFlight = 4
+)
+
 // this function returns a pacer which limits the number bytes allowed to go out every second
 // it does so by issuing tickets (bytes allowed) periodically
+// a bytesPerSecond of 0 returns a pacer that only counts the bytes and never blocks
 func newPacer(bytesPerSecond int64) (p *pacer) {
 	p = &pacer{bytesAvailable: 0,
-		availableBytesPerPeriod: bytesPerSecond * int64(PacerTimeToWaitInMs) / 1000,
-		lastUpdatedTimestamp:    time.Now().UnixNano()}
+		lastUpdatedTimestamp: time.Now().UnixNano(),
+		done:                 make(chan struct{})}
+	p.setTargetRate(bytesPerSecond)
 
-	// the pace runs in a separate goroutine for as long as the transfer engine is running
+	// the pace runs in a separate goroutine until the pacer is closed,
+	// which the pacer of the transfer engine never is
 	go func() {
+		ticker := time.NewTicker(time.Millisecond * time.Duration(PacerTimeToWaitInMs))
+		defer ticker.Stop()
 		for {
-			// surrender control until time to wait has elapsed
-			for targetTime := time.Now().Add(time.Millisecond * time.Duration(PacerTimeToWaitInMs)); time.Now().Before(targetTime); {
-				runtime.Gosched()
+			select {
+			case <-ticker.C:
+				// the bucket holds at most one period worth of tickets
+				// unused tickets are not carried over, so that an idle period does not turn into a burst
+				atomic.StoreInt64(&p.bytesAvailable, atomic.LoadInt64(&p.availableBytesPerPeriod))
+			case <-p.done:
+				return
 			}
-
-			atomic.StoreInt64(&p.bytesAvailable, p.availableBytesPerPeriod)
-			// if too many tickets were issued (2x the intended), we should scale back
-			//if atomic.AddInt64(&p.bytesAvailable, p.availableBytesPerPeriod) > 2*p.availableBytesPerPeriod {
-			//	atomic.AddInt64(&p.bytesAvailable, -p.availableBytesPerPeriod)
-			//}
 		}
 	}()
 
 	return
 }
 
-// NewPacerPolicyFactory creates a factory that can create telemetry policy objects
-// which add telemetry information to outgoing HTTP requests.
+// close stops the goroutine which issues the tickets of the pacer, it's a no-op if the pacer is already closed.
+// Nothing can be sent through the pacer once it's closed, since it issues no more tickets.
+func (p *pacer) close() {
+	p.doneOnce.Do(func() { close(p.done) })
+}
+
+// setTargetRate changes the bandwidth cap of the pacer, 0 removes the cap
+func (p *pacer) setTargetRate(bytesPerSecond int64) {
+	bytesPerPeriod := bytesPerSecond * int64(PacerTimeToWaitInMs) / 1000
+	if bytesPerSecond > 0 && bytesPerPeriod == 0 {
+		// caps lower than one byte per period still have to let some bytes through
+		bytesPerPeriod = 1
+	}
+	atomic.StoreInt64(&p.maxBytesPerPeriod, bytesPerPeriod)
+	atomic.StoreInt64(&p.availableBytesPerPeriod, bytesPerPeriod)
+}
+
+// targetRateInMbps returns the rate currently enforced by the pacer in megabits per second
+// it can be lower than the user's cap when the service pushed back with throttling responses; 0 means there is no cap
+func (p *pacer) targetRateInMbps() float64 {
+	bytesPerSecond := atomic.LoadInt64(&p.availableBytesPerPeriod) * 1000 / int64(PacerTimeToWaitInMs)
+	return float64(bytesPerSecond*8) / (1000 * 1000)
+}
+
+// NewPacerGatePolicyFactory creates a factory that can create policy objects which hold back outgoing operations
+// while the engine is backing off from throttling, and until they fit under the limit of requests in flight.
+// It goes before the retry policy, so that the wait doesn't use up the timeout of a try;
+// an operation keeps its room in flight across its retries.
+func NewPacerGatePolicyFactory(p *pacer) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if p == nil {
+				return next.Do(ctx, request)
+			}
+			if err := p.waitForRightToRequest(ctx); err != nil {
+				return nil, err
+			}
+			defer p.requestDone()
+			return next.Do(ctx, request)
+		}
+	})
+}
+
+// NewPacerPolicyFactory creates a factory that can create pacer policy objects
+// which record the statistics of the responses to each try, and make the engine back off from throttling.
 func NewPacerPolicyFactory(p *pacer) pipeline.Factory {
 	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
 		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if p == nil {
+				return next.Do(ctx, request)
+			}
+			start := time.Now()
 			resp, err := next.Do(ctx, request)
-			if p != nil && err == nil {
-				// Reducing the pacer's rate limit by 10 s for every 503 error.
-				p.updateTargetRate(
-					(resp.Response().StatusCode != http.StatusServiceUnavailable) &&
-						(resp.Response().StatusCode != http.StatusInternalServerError))
+			if err == nil {
+				throttled := GetXferRetryOptions().retryClass(resp.Response().StatusCode) == common.ERetryClass.Throttling()
+				p.recordResponse(throttled, time.Since(start))
+				if throttled {
+					p.backOff(retryAfter(resp.Response()))
+				} else {
+					p.recordSuccess()
+				}
+				// Reducing the pacer's rate limit by 10 s for every throttling response.
+				p.updateTargetRate(!throttled)
 			}
 			return resp, err
 		}
 	})
 }
 
+// waitForRightToRequest blocks while the engine is backing off, and until the request fits under the
+// limit of requests in flight. On success the request is counted as in flight, until requestDone is called.
+func (p *pacer) waitForRightToRequest(ctx context.Context) error {
+	for {
+		// sleep until the end of the backoff period, then check again since throttling responses may have extended it
+		if wait := time.Until(time.Unix(0, atomic.LoadInt64(&p.backoffUntil))); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+
+		p.inFlightLock.Lock()
+		limit := atomic.LoadInt64(&p.maxRequestsInFlight)
+		if limit == 0 || p.requestsInFlight < limit {
+			atomic.AddInt64(&p.requestsInFlight, 1)
+			p.inFlightLock.Unlock()
+			return nil
+		}
+		if p.inFlightChanged == nil {
+			p.inFlightChanged = make(chan struct{})
+		}
+		inFlightChanged := p.inFlightChanged
+		p.inFlightLock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-inFlightChanged:
+		}
+	}
+}
+
+// requestDone takes a request which was granted the right to be sent out of flight
+func (p *pacer) requestDone() {
+	p.inFlightLock.Lock()
+	atomic.AddInt64(&p.requestsInFlight, -1)
+	p.wakeUpWaitingRequests()
+	p.inFlightLock.Unlock()
+}
+
+// wakeUpWaitingRequests wakes up the requests waiting for room in flight, so that they check again whether they fit.
+// The caller must hold inFlightLock.
+func (p *pacer) wakeUpWaitingRequests() {
+	if p.inFlightChanged != nil {
+		close(p.inFlightChanged)
+		p.inFlightChanged = nil
+	}
+}
+
+// backOff holds back every request of the engine for the given duration, and halves the number of
+// requests allowed in flight, at most once per backoff period so that a burst of throttling
+// responses to the requests already in flight counts as a single push back from the service
+func (p *pacer) backOff(duration time.Duration) {
+	atomic.AddInt64(&p.throttleEvents, 1)
+
+	now := time.Now().UnixNano()
+	until := now + int64(duration)
+	for {
+		current := atomic.LoadInt64(&p.backoffUntil)
+		if until <= current {
+			break
+		}
+		if atomic.CompareAndSwapInt64(&p.backoffUntil, current, until) {
+			// only count the extension of the backoff period, so that overlapping backoffs are not counted twice
+			atomic.AddInt64(&p.backoffInNs, until-common.Iffint64(current > now, current, now))
+			break
+		}
+	}
+
+	lastReduction := atomic.LoadInt64(&p.lastReductionTimestamp)
+	if now-lastReduction < int64(duration) || !atomic.CompareAndSwapInt64(&p.lastReductionTimestamp, lastReduction, now) {
+		return
+	}
+	limit := atomic.LoadInt64(&p.maxRequestsInFlight)
+	if limit == 0 {
+		// start from the concurrency the service just pushed back on
+		limit = atomic.LoadInt64(&p.requestsInFlight) + 1
+	}
+	limit /= 2
+	if limit < minRequestsInFlight {
+		limit = minRequestsInFlight
+	}
+	atomic.StoreInt64(&p.maxRequestsInFlight, limit)
+	atomic.StoreInt64(&p.successesSinceIncrease, 0)
+}
+
+// recordSuccess raises the number of requests allowed in flight by one for every limit's worth of
+// successful responses, so that the concurrency recovers gradually once the service stops throttling
+func (p *pacer) recordSuccess() {
+	limit := atomic.LoadInt64(&p.maxRequestsInFlight)
+	if limit == 0 {
+		return
+	}
+	if atomic.AddInt64(&p.successesSinceIncrease, 1) >= limit {
+		atomic.StoreInt64(&p.successesSinceIncrease, 0)
+		if atomic.CompareAndSwapInt64(&p.maxRequestsInFlight, limit, limit+1) {
+			p.inFlightLock.Lock()
+			p.wakeUpWaitingRequests()
+			p.inFlightLock.Unlock()
+		}
+	}
+}
+
+// throttleStats returns the number of throttling responses seen and the total time spent backing off
+func (p *pacer) throttleStats() (int64, time.Duration) {
+	return atomic.LoadInt64(&p.throttleEvents), time.Duration(atomic.LoadInt64(&p.backoffInNs))
+}
+
+// retryAfter returns how long the service asked to wait before sending requests again
+// The Retry-After header holds either a number of seconds or an HTTP date
+func retryAfter(resp *http.Response) time.Duration {
+	delay := defaultThrottleBackoff
+	if header := resp.Header.Get("Retry-After"); header != "" {
+		if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+			delay = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(header); err == nil {
+			delay = time.Until(date)
+		}
+	}
+	if delay <= 0 {
+		// the service may still be overloaded, so back off a little anyway
+		delay = time.Millisecond * time.Duration(PacerTimeToWaitInMs)
+	} else if delay > maxThrottleBackoff {
+		delay = maxThrottleBackoff
+	}
+	return delay
+}
+
+// recordResponse updates the statistics of the responses seen by the pacer policy
+func (p *pacer) recordResponse(throttled bool, latency time.Duration) {
+	atomic.AddInt64(&p.requestCount, 1)
+	atomic.AddInt64(&p.latencyInNs, int64(latency))
+	if throttled {
+		atomic.AddInt64(&p.throttledCount, 1)
+	}
+}
+
 // this function is called by goroutines to request right to send a certain amount of bytes
-func (p *pacer) requestRightToSend(bytesToSend int64) {
-
-	//// attempt to take off the desired number of tickets until success (total number of tickets is not negative)
-	//for atomic.AddInt64(&p.bytesAvailable, -bytesToSend) < 0 {
-	//
-	//	// put tickets back if attempt was unsuccessful
-	//	atomic.AddInt64(&p.bytesAvailable, bytesToSend)
-	//	time.Sleep(time.Millisecond * 1)
-	//}
-	return
+// it blocks until the pacer has issued enough tickets and returns the number of bytes granted,
+// which is capped to a period's worth of tickets since a larger request could never be granted
+func (p *pacer) requestRightToSend(bytesToSend int64) int64 {
+	for {
+		bytesPerPeriod := atomic.LoadInt64(&p.availableBytesPerPeriod)
+		if bytesPerPeriod == 0 {
+			// there is no cap
+			return bytesToSend
+		}
+		if bytesToSend > bytesPerPeriod {
+			bytesToSend = bytesPerPeriod
+		}
+		// attempt to take off the desired number of tickets (total number of tickets is not negative)
+		if atomic.AddInt64(&p.bytesAvailable, -bytesToSend) >= 0 {
+			return bytesToSend
+		}
+		// put tickets back if attempt was unsuccessful
+		atomic.AddInt64(&p.bytesAvailable, bytesToSend)
+		time.Sleep(time.Millisecond * 1)
+	}
+}
+
+// returnUnusedRight gives back the tickets that were granted but not used
+// e.g. when a read returned less bytes than asked for
+func (p *pacer) returnUnusedRight(unusedBytes int64) {
+	if unusedBytes > 0 && atomic.LoadInt64(&p.availableBytesPerPeriod) > 0 {
+		atomic.AddInt64(&p.bytesAvailable, unusedBytes)
+	}
 }
 
 func (p *pacer) updateTargetRate(increase bool) {
+	maxBytesPerPeriod := atomic.LoadInt64(&p.maxBytesPerPeriod)
+	if maxBytesPerPeriod == 0 {
+		// there is no cap to adjust
+		return
+	}
 	lastCheckedTimestamp := atomic.LoadInt64(&p.lastUpdatedTimestamp)
 	//lastCheckedTime := time.Unix(0,lastCheckedTimestamp)
 	if time.Now().Sub(time.Unix(0, lastCheckedTimestamp)) < (time.Second * 3) {
 		return
 	}
 	if atomic.CompareAndSwapInt64(&p.lastUpdatedTimestamp, lastCheckedTimestamp, time.Now().UnixNano()) {
-		atomic.StoreInt64(&p.availableBytesPerPeriod, int64(common.Iffloat64(increase, 1.1, 0.9)*float64(p.availableBytesPerPeriod)))
+		newBytesPerPeriod := int64(common.Iffloat64(increase, 1.1, 0.9) * float64(atomic.LoadInt64(&p.availableBytesPerPeriod)))
+		// never go above the user's cap, and never drop to 0 which would mean no cap at all
+		if newBytesPerPeriod > maxBytesPerPeriod {
+			newBytesPerPeriod = maxBytesPerPeriod
+		} else if newBytesPerPeriod < 1 {
+			newBytesPerPeriod = 1
+		}
+		atomic.StoreInt64(&p.availableBytesPerPeriod, newBytesPerPeriod)
 	}
 }
 
 // this struct wraps the ReadSeeker which contains the data to be sent over the network
 type bodyPacer struct {
-	body io.Reader // Seeking is required to support retries
-	p    *pacer
-	mmf  *common.MMF
+	body      io.Reader // Seeking is required to support retries
+	p         *pacer
+	mmf       *common.MMF
+	ownsPacer bool // the pacer was created for this body alone, and is closed along with it
 }
 
 // newRequestBodyPacer wraps a response body to the given pacer to control the upload speed and
@@ -132,15 +395,34 @@ func newResponseBodyPacer(responseBody io.ReadCloser, p *pacer, srcMMF *common.M
 	return &bodyPacer{body: responseBody, p: p, mmf: srcMMF}
 }
 
+// NewPacedReader wraps a reader that is transferred outside of the transfer engine, e.g. stdin/stdout redirection,
+// so that it honors the given bandwidth cap in megabits per second. A cap of 0 leaves the reader unpaced.
+// The reader must be closed once the transfer is over, which also closes r if it's an io.Closer.
+func NewPacedReader(r io.Reader, capMbps uint32) io.ReadCloser {
+	return &bodyPacer{body: r, p: newPacer(MbpsToBytesPerSecond(capMbps)), ownsPacer: true}
+}
+
+// MbpsToBytesPerSecond converts a bandwidth cap given in megabits per second to bytes per second
+func MbpsToBytesPerSecond(capMbps uint32) int64 {
+	return int64(capMbps) * 1000 * 1000 / 8
+}
+
 // read blocks until tickets are obtained
 func (rbp *bodyPacer) Read(p []byte) (int, error) {
-	if !rbp.mmf.UseMMF() {
-		return 0, fmt.Errorf("src MMF Unmapped. Cannot read further")
+	// only read as many bytes as the pacer allows to send right now
+	// tickets are obtained before using the mmf, so that waiting on the pacer doesn't hold up the unmapping
+	p = p[:rbp.p.requestRightToSend(int64(len(p)))]
+	// the mmf is nil when the body is not backed by a memory mapped file, e.g. stdin
+	if rbp.mmf != nil {
+		if !rbp.mmf.UseMMF() {
+			rbp.p.returnUnusedRight(int64(len(p)))
+			return 0, fmt.Errorf("src MMF Unmapped. Cannot read further")
+		}
+		defer rbp.mmf.UnuseMMF()
 	}
-	//rbp.p.requestRightToSend(int64(len(p)))
 	n, err := rbp.body.Read(p)
+	rbp.p.returnUnusedRight(int64(len(p) - n))
 	atomic.AddInt64(&rbp.p.bytesTransferred, int64(n))
-	rbp.mmf.UnuseMMF()
 	return n, err
 }
 
@@ -151,6 +433,9 @@ func (rbp *bodyPacer) Seek(offset int64, whence int) (offsetFromStart int64, err
 
 // bytesOverTheWire supports Close but the underlying stream may not; if it does, Close will close it.
 func (rbp *bodyPacer) Close() error {
+	if rbp.ownsPacer {
+		rbp.p.close()
+	}
 	if c, ok := rbp.body.(io.Closer); ok {
 		return c.Close()
 	}
@@ -0,0 +1,108 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/Azure/azure-storage-azcopy/azbfs"
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+	"github.com/Azure/azure-storage-file-go/2017-07-29/azfile"
+)
+
+// quotaServiceCodes are the service error codes of the requests that failed because a limit of the destination was reached
+var quotaServiceCodes = map[string]bool{
+	"BlockCountExceedsLimit":     true, // the block blob already has 100,000 uncommitted blocks
+	"BlockListTooLong":           true, // the block list has more than 50,000 blocks
+	"MaxBlobSizeConditionNotMet": true, // the append blob would grow past its maximum size
+	"ShareSizeLimitReached":      true, // the file share is full
+	"RequestBodyTooLarge":        true,
+}
+
+// serviceErrorCode returns the HTTP status and the service error code of err, if it is an error returned by the service
+func serviceErrorCode(err error) (int, string) {
+	switch e := err.(type) {
+	case azblob.StorageError:
+		return e.Response().StatusCode, string(e.ServiceCode())
+	case azfile.StorageError:
+		return e.Response().StatusCode, string(e.ServiceCode())
+	case azbfs.StorageError:
+		return e.Response().StatusCode, string(e.ServiceCode())
+	default:
+		return 0, ""
+	}
+}
+
+// transferFailureStatus returns the failure status a transfer failed by err is reported with,
+// so that the failures can be triaged by their category rather than by reading the logs
+func transferFailureStatus(err error) common.TransferStatus {
+	if _, ok := err.(transactionalMD5Error); ok {
+		// a chunk was still corrupted on the wire when the retry policy ran out of retries
+		return common.ETransferStatus.MD5MismatchFailure()
+	}
+	if statusCode, serviceCode := serviceErrorCode(err); statusCode != 0 {
+		return serviceFailureStatus(statusCode, serviceCode)
+	}
+
+	// errors of the local file system, including those of the memory maps of the local files
+	var errno error
+	switch e := err.(type) {
+	case *os.PathError:
+		errno = e.Err
+	case *os.LinkError:
+		errno = e.Err
+	case *os.SyscallError:
+		errno = e.Err
+	case syscall.Errno:
+		errno = e
+	default:
+		return common.ETransferStatus.Failed()
+	}
+	if errno == syscall.ENOSPC {
+		return common.ETransferStatus.QuotaExceededFailure()
+	}
+	return common.ETransferStatus.LocalIOFailure()
+}
+
+// serviceFailureStatus returns the failure status of a transfer failed by a request the service answered with
+// the given HTTP status and service error code
+func serviceFailureStatus(statusCode int, serviceCode string) common.TransferStatus {
+	switch {
+	case quotaServiceCodes[serviceCode] || statusCode == http.StatusRequestEntityTooLarge:
+		return common.ETransferStatus.QuotaExceededFailure()
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return common.ETransferStatus.AuthFailure()
+	case statusCode == http.StatusNotFound:
+		return common.ETransferStatus.NotFoundFailure()
+	case statusCode == http.StatusPreconditionFailed && serviceCode == "ConditionNotMet":
+		// only the GETs of the downloads send an If-Match condition, on the source's ETag:
+		// the source was modified after the transfer started.
+		// The other 412s, e.g. LeaseIdMissing or LeaseNotPresentWithBlobOperation, are plain failures
+		return common.ETransferStatus.SourceChangedFailure()
+	case GetXferRetryOptions().retryClass(statusCode) == common.ERetryClass.Throttling():
+		// the retry policy already retried the request as long as it was allowed to
+		return common.ETransferStatus.ThrottledFailure()
+	}
+	return common.ETransferStatus.Failed()
+}
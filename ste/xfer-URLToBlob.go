@@ -97,8 +97,14 @@ func URLToBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 		azblobMetadata = info.SrcMetadata.ToAzBlobMetadata()
 	}
 
+	// an append blob is copied to an append blob, by appending the blocks of the source one after the other
+	if info.SrcBlobType == common.EBlobType.AppendBlob() {
+		urlToAppendBlob(jptm, p, pacer, *srcURL, destBlobURL, azblobMetadata)
+		return
+	}
+
 	// step 3: copy file to blob
-	// Currently only support block blob.
+	// Block blobs are copied to block blobs.
 	if srcSize == 0 {
 		// Create blob and finish.
 		_, err := destBlobURL.ToBlockBlobURL().Upload(jptm.Context(), bytes.NewReader(nil), info.SrcHTTPHeaders, azblobMetadata, azblob.BlobAccessConditions{})
@@ -112,7 +118,7 @@ func URLToBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo, fmt.Sprintf("create blob failed and cancelling the transfer. Failed with error %v", err))
 				}
-				jptm.SetStatus(common.ETransferStatus.Failed())
+				jptm.SetErrorStatus(err)
 			}
 		} else {
 			// if the create blob is a success, updating the transfer status to success
@@ -175,22 +181,6 @@ func (bbc *blockBlobCopy) generateCopyURLToBlockBlobFunc(chunkId int32, startInd
 		// defer the decrement in the number of goroutine performing the transfer / acting on chunks msg by 1
 		defer bbc.jptm.ReleaseAConnection()
 
-		// This function allows routine to manage behavior of unexpected panics.
-		// The panic error along with transfer details are logged.
-		// The transfer is marked as failed and is reported as done.
-		defer func(jptm IJobPartTransferMgr) {
-			r := recover()
-			if r != nil {
-				info := jptm.Info()
-				if jptm.ShouldLog(pipeline.LogError) {
-					jptm.Log(pipeline.LogError, fmt.Sprintf(" recovered from unexpected crash %s. Transfer Src %s Dst %s SrcSize %v startIndex %v chunkSize %v",
-						r, info.Source, info.Destination, info.SourceSize, startIndex, adjustedChunkSize))
-				}
-				jptm.SetStatus(common.ETransferStatus.Failed())
-				jptm.ReportTransferDone()
-			}
-		}(bbc.jptm)
-
 		// and the chunkFunc has been changed to the version without param workId
 		// transfer done is internal function which marks the transfer done.
 		transferDone := func() {
@@ -218,7 +208,7 @@ func (bbc *blockBlobCopy) generateCopyURLToBlockBlobFunc(chunkId int32, startInd
 				bbc.jptm.Log(pipeline.LogInfo, fmt.Sprintf("is cancelled. Hence not picking up chunkId %d", chunkId))
 			}
 			bbc.jptm.AddToBytesDone(adjustedChunkSize)
-			if lastChunk, _ := bbc.jptm.ReportChunkDone(); lastChunk {
+			if lastChunk, _ := bbc.jptm.ReportChunkDone(workerId); lastChunk {
 				if bbc.jptm.ShouldLog(pipeline.LogInfo) {
 					bbc.jptm.Log(pipeline.LogInfo,
 						fmt.Sprintf("has worker %d finalizing cancellation of transfer", workerId))
@@ -254,13 +244,13 @@ func (bbc *blockBlobCopy) generateCopyURLToBlockBlobFunc(chunkId int32, startInd
 							workerId, chunkId, startIndex, adjustedChunkSize, err.Error()))
 				}
 				//updateChunkInfo(jobId, partNum, transferId, uint16(chunkId), ChunkTransferStatusFailed, jobsInfoMap)
-				bbc.jptm.SetStatus(common.ETransferStatus.Failed())
+				bbc.jptm.SetErrorStatus(err)
 			}
 
 			//adding the chunk size to the bytes transferred to report the progress.
 			bbc.jptm.AddToBytesDone(adjustedChunkSize)
 
-			if lastChunk, _ := bbc.jptm.ReportChunkDone(); lastChunk {
+			if lastChunk, _ := bbc.jptm.ReportChunkDone(workerId); lastChunk {
 				if bbc.jptm.ShouldLog(pipeline.LogInfo) {
 					bbc.jptm.Log(pipeline.LogInfo,
 						fmt.Sprintf("has worker %d finalizing cancellation of transfer", workerId))
@@ -274,7 +264,7 @@ func (bbc *blockBlobCopy) generateCopyURLToBlockBlobFunc(chunkId int32, startInd
 		bbc.jptm.AddToBytesDone(adjustedChunkSize)
 
 		// step 4: check if this is the last chunk
-		if lastChunk, _ := bbc.jptm.ReportChunkDone(); lastChunk {
+		if lastChunk, _ := bbc.jptm.ReportChunkDone(workerId); lastChunk {
 			// If the transfer gets cancelled before the putblock list
 			if bbc.jptm.WasCanceled() {
 				transferDone()
@@ -295,7 +285,7 @@ func (bbc *blockBlobCopy) generateCopyURLToBlockBlobFunc(chunkId int32, startInd
 						fmt.Sprintf("copy from URL to block blob failed, worker %d failed to commit blockList with error %s",
 							workerId, err.Error()))
 				}
-				bbc.jptm.SetStatus(common.ETransferStatus.Failed())
+				bbc.jptm.SetErrorStatus(err)
 				transferDone()
 				return
 			}
@@ -0,0 +1,301 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+)
+
+// appendBlobXfer appends the chunks of its source to an append blob. A block can only be appended once the previous one is,
+// so the chunks are scheduled one after the other: each chunk schedules the next one when it is done.
+type appendBlobXfer struct {
+	jptm      IJobPartTransferMgr
+	blobURL   azblob.AppendBlobURL
+	pacer     *pacer
+	blobSize  int64
+	chunkSize int64
+	// srcMmf is the memory map of a local source, nil otherwise
+	srcMmf *common.MMF
+	// md5Hasher computes the MD5 hash of a local source as its blocks are appended, nil otherwise
+	md5Hasher *chunkedMD5Hasher
+	// chunkBytes returns the bytes of the source which make up the block appended at startIndex
+	chunkBytes func(startIndex int64, chunkSize int64) ([]byte, error)
+	// epilogue, if any, runs once all the blocks were appended, and returns false if the transfer failed after all
+	epilogue func() bool
+}
+
+// appendBlobChunkSize returns the size of the blocks appended to an append blob, which can't be larger than 4 MB
+func appendBlobChunkSize(blockSize uint32) int64 {
+	return common.Iffint64(int64(blockSize) > common.DefaultAppendBlobBlockSize || blockSize == 0,
+		common.DefaultAppendBlobBlockSize,
+		int64(blockSize))
+}
+
+func LocalToAppendBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
+	// step 1. Get the transfer Information which include source, destination string, source size and other information.
+	info := jptm.Info()
+	blobSize := info.SourceSize
+	u, _ := url.Parse(info.Destination)
+	blobURL := azblob.NewBlobURL(*u, p)
+
+	if jptm.ShouldLog(pipeline.LogInfo) {
+		jptm.Log(pipeline.LogInfo, fmt.Sprintf("Starting %s\n   Dst: %s", info.Source, info.Destination))
+	}
+
+	// If the transfer was cancelled, then reporting transfer as done and increasing the bytestransferred by the size of the source.
+	if jptm.WasCanceled() {
+		jptm.AddToBytesDone(info.SourceSize)
+		jptm.ReportTransferDone()
+		return
+	}
+
+	// If the force Write flags is set to false
+	// then check the blob exists or not.
+	// If it does, mark transfer as failed.
+	if !jptm.IsForceWriteTrue() {
+		_, err := blobURL.GetProperties(jptm.Context(), azblob.BlobAccessConditions{})
+		if err == nil {
+			jptm.LogUploadError(info.Source, info.Destination, "Blob already exists", 0)
+			jptm.SetStatus(common.ETransferStatus.BlobAlreadyExistsFailure())
+			jptm.AddToBytesDone(info.SourceSize)
+			jptm.ReportTransferDone()
+			return
+		}
+	}
+
+	// step 2: Open and memory map the source file, unless it is empty
+	srcFile, err := os.Open(info.Source)
+	if err != nil {
+		jptm.LogUploadError(info.Source, info.Destination, "Couldn't open source-"+err.Error(), 0)
+		jptm.AddToBytesDone(info.SourceSize)
+		jptm.SetErrorStatus(err)
+		jptm.ReportTransferDone()
+		return
+	}
+	defer srcFile.Close()
+
+	var srcMmf *common.MMF
+	if blobSize > 0 {
+		srcMmf, err = common.NewMMF(srcFile, false, 0, blobSize)
+		if err != nil {
+			jptm.LogUploadError(info.Source, info.Destination, "Memory Map Error-"+err.Error(), 0)
+			jptm.SetErrorStatus(err)
+			jptm.AddToBytesDone(info.SourceSize)
+			jptm.ReportTransferDone()
+			return
+		}
+	}
+
+	// step 3: create the append blob and append the source to it
+	blobHTTPHeaders, metadata := jptm.BlobDstData(srcMmf)
+	abx := &appendBlobXfer{
+		jptm:      jptm,
+		blobURL:   blobURL.ToAppendBlobURL(),
+		pacer:     pacer,
+		blobSize:  blobSize,
+		chunkSize: appendBlobChunkSize(info.BlockSize),
+		srcMmf:    srcMmf,
+	}
+	if blobSize == 0 {
+		emptyMD5 := md5.Sum(nil)
+		blobHTTPHeaders.ContentMD5 = emptyMD5[:]
+		abx.start(blobHTTPHeaders, metadata)
+		return
+	}
+
+	abx.md5Hasher = newChunkedMD5Hasher(srcMmf.Slice(), abx.chunkSize, abx.numChunks())
+	abx.chunkBytes = func(startIndex int64, chunkSize int64) ([]byte, error) {
+		return srcMmf.Slice()[startIndex : startIndex+chunkSize], nil
+	}
+	abx.epilogue = func() bool {
+		// the append blob is only complete if all its blocks were read from the version of the source which was enumerated
+		if localSourceChanged(jptm) {
+			jptm.SetStatus(common.ETransferStatus.SourceChangedFailure())
+			return false
+		}
+		// the MD5 hash of the source is only known once all its blocks were read
+		blobHTTPHeaders.ContentMD5 = abx.md5Hasher.Sum()
+		_, err := abx.blobURL.SetHTTPHeaders(jptm.Context(), blobHTTPHeaders, azblob.BlobAccessConditions{})
+		if err != nil {
+			status, msg := ErrorEx{err}.ErrorCodeAndString()
+			jptm.LogUploadError(info.Source, info.Destination, "AppendBlob SetHTTPHeaders-"+msg, status)
+			jptm.SetErrorStatus(err)
+			return false
+		}
+		return true
+	}
+	abx.start(blobHTTPHeaders, metadata)
+}
+
+// urlToAppendBlob copies an append blob to an append blob, appending the blocks downloaded from the source one after the other
+func urlToAppendBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer, srcURL url.URL, destBlobURL azblob.BlobURL, metadata azblob.Metadata) {
+	info := jptm.Info()
+	srcBlobURL := azblob.NewBlobURL(srcURL, p)
+	abx := &appendBlobXfer{
+		jptm:      jptm,
+		blobURL:   destBlobURL.ToAppendBlobURL(),
+		pacer:     pacer,
+		blobSize:  info.SourceSize,
+		chunkSize: appendBlobChunkSize(info.BlockSize),
+		chunkBytes: func(startIndex int64, chunkSize int64) ([]byte, error) {
+			// the blocks of an append blob don't change once appended, so the range still holds the data
+			// which was enumerated even if more blocks were appended to the source since
+			chunk := make([]byte, chunkSize)
+			for _, downloadRange := range splitForRangeGetContentMD5([]azblob.PageRange{{Start: startIndex, End: startIndex + chunkSize - 1}}) {
+				// the service returns the MD5 of the range, so a corrupted range is retried on its own by the pipeline
+				get, err := srcBlobURL.Download(jptm.Context(), downloadRange.Start, downloadRange.End-downloadRange.Start+1,
+					azblob.BlobAccessConditions{}, true)
+				if err != nil {
+					return nil, err
+				}
+				body := get.Body(azblob.RetryReaderOptions{MaxRetryRequests: MaxRetryPerDownloadBody})
+				_, err = io.ReadFull(body, chunk[downloadRange.Start-startIndex:downloadRange.End-startIndex+1])
+				body.Close()
+				if err != nil {
+					return nil, err
+				}
+			}
+			return chunk, nil
+		},
+	}
+	abx.start(info.SrcHTTPHeaders, metadata)
+}
+
+func (abx *appendBlobXfer) numChunks() uint32 {
+	return common.Iffuint32(abx.blobSize%abx.chunkSize == 0,
+		uint32(abx.blobSize/abx.chunkSize),
+		uint32(abx.blobSize/abx.chunkSize)+1)
+}
+
+// start creates the append blob, and schedules the chunk appending its first block
+func (abx *appendBlobXfer) start(blobHTTPHeaders azblob.BlobHTTPHeaders, metadata azblob.Metadata) {
+	jptm := abx.jptm
+	info := jptm.Info()
+	_, err := abx.blobURL.Create(jptm.Context(), blobHTTPHeaders, metadata, azblob.BlobAccessConditions{})
+	if err != nil {
+		status, msg := ErrorEx{err}.ErrorCodeAndString()
+		jptm.LogUploadError(info.Source, info.Destination, "AppendBlob Create-"+msg, status)
+		jptm.SetErrorStatus(err)
+		jptm.AddToBytesDone(abx.blobSize)
+		abx.transferDone()
+		return
+	}
+
+	if abx.blobSize == 0 {
+		jptm.SetStatus(common.ETransferStatus.Success())
+		abx.transferDone()
+		return
+	}
+
+	jptm.SetNumberOfChunks(abx.numChunks())
+	jptm.ScheduleChunks(abx.appendBlockFunc(0))
+}
+
+func (abx *appendBlobXfer) appendBlockFunc(chunkIndex int32) chunkFunc {
+	return func(workerId int) {
+		jptm := abx.jptm
+		// TODO: added the two operations for debugging purpose. remove later
+		jptm.OccupyAConnection()
+		defer jptm.ReleaseAConnection()
+
+		startIndex := int64(chunkIndex) * abx.chunkSize
+		adjustedChunkSize := common.Iffint64(startIndex+abx.chunkSize > abx.blobSize, abx.blobSize-startIndex, abx.chunkSize)
+
+		// once the transfer is cancelled, the remaining chunks are only reported as done
+		if !jptm.WasCanceled() {
+			err := abx.appendBlock(startIndex, adjustedChunkSize)
+			if err != nil {
+				if !jptm.WasCanceled() {
+					info := jptm.Info()
+					status, msg := ErrorEx{err}.ErrorCodeAndString()
+					jptm.LogUploadError(info.Source, info.Destination, fmt.Sprintf("AppendBlock of chunk %d failed-%s", chunkIndex, msg), status)
+					jptm.Cancel()
+					jptm.SetErrorStatus(err)
+				}
+			} else {
+				abx.md5Hasher.ChunkDone(chunkIndex)
+			}
+		}
+
+		jptm.AddToBytesDone(adjustedChunkSize)
+		if lastChunk, _ := jptm.ReportChunkDone(workerId); !lastChunk {
+			// the next block can only be appended after this one
+			jptm.ScheduleChunks(abx.appendBlockFunc(chunkIndex + 1))
+			return
+		}
+
+		if !jptm.WasCanceled() && (abx.epilogue == nil || abx.epilogue()) {
+			if jptm.ShouldLog(pipeline.LogInfo) {
+				jptm.Log(pipeline.LogInfo, "UPLOAD SUCCESSFUL")
+			}
+			jptm.SetStatus(common.ETransferStatus.Success())
+		}
+		abx.transferDone()
+	}
+}
+
+// appendBlock appends the chunk of the source at startIndex, on the condition that the blob ends right where the chunk starts.
+// That keeps retries from appending a block twice: if the response to an append which succeeded got lost,
+// the retry fails the condition, and the blob already ending with the chunk counts as a success.
+func (abx *appendBlobXfer) appendBlock(startIndex int64, chunkSize int64) error {
+	chunk, err := abx.chunkBytes(startIndex, chunkSize)
+	if err != nil {
+		return err
+	}
+	body := newRequestBodyPacer(bytes.NewReader(chunk), abx.pacer, abx.srcMmf)
+	// IfAppendPositionEqual set to 0 means no condition, -1 is how the condition on an empty blob is set
+	appendPosition := common.Iffint64(startIndex == 0, -1, startIndex)
+	_, err = abx.blobURL.AppendBlock(withTransactionalMD5(abx.jptm.Context(), chunk), body,
+		azblob.BlobAccessConditions{AppendBlobAccessConditions: azblob.AppendBlobAccessConditions{IfAppendPositionEqual: appendPosition}})
+	if stErr, ok := err.(azblob.StorageError); ok && stErr.ServiceCode() == azblob.ServiceCodeAppendPositionConditionNotMet {
+		props, propsErr := abx.blobURL.GetProperties(abx.jptm.Context(), azblob.BlobAccessConditions{})
+		if propsErr == nil && props.ContentLength() == startIndex+chunkSize {
+			return nil
+		}
+	}
+	return err
+}
+
+// transferDone deletes the append blob if the transfer failed or was cancelled, since appending can't resume from
+// the blocks appended so far, then reports the transfer as done
+func (abx *appendBlobXfer) transferDone() {
+	jptm := abx.jptm
+	if jptm.TransferStatus() <= 0 {
+		_, err := abx.blobURL.Delete(context.TODO(), azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+		if err != nil {
+			jptm.LogError(abx.blobURL.String(), "DeleteAppendBlob ", err)
+		}
+	}
+	if abx.srcMmf != nil {
+		abx.srcMmf.Unmap()
+	}
+	jptm.ReportTransferDone()
+}
@@ -14,7 +14,10 @@ import (
 type BlobFSFileDownload struct {
 	jptm       IJobPartTransferMgr
 	srcFileURL azbfs.FileURL
+	srcETag    string
+	srcMD5     []byte
 	destMMF    *common.MMF
+	md5Hasher  *chunkedMD5Hasher
 	pacer      *pacer
 }
 
@@ -28,6 +31,11 @@ func BlobFSToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 	sourceSize := int64(info.SourceSize)
 	downloadChunkSize := int64(info.BlockSize)
 	numChunks := uint32(0)
+	if rem := sourceSize % downloadChunkSize; rem == 0 {
+		numChunks = uint32(sourceSize / downloadChunkSize)
+	} else {
+		numChunks = uint32(sourceSize/downloadChunkSize + 1)
+	}
 
 	// If the transfer was cancelled, then reporting transfer as done and increasing the bytestransferred by the size of the source.
 	if jptm.WasCanceled() {
@@ -36,10 +44,35 @@ func BlobFSToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 		return
 	}
 
+	// The ETag of the source pins every chunk of the download to the version of the file it started from,
+	// and lets a later attempt of the transfer resume from the chunks completed by this one.
+	// It comes with the first range of the file, which is downloaded before the chunks are scheduled
+	sourceETag := ""
+	var sourceMD5 []byte
+	var firstRange []byte
+	if numChunks > 0 {
+		get, err := srcBlobURL.NewFileUrl().Download(jptm.Context(), 0, firstRangeSize(sourceSize, downloadChunkSize), azbfs.FileAccessConditions{})
+		if err == nil {
+			firstRange, err = readFirstRange(get.Body(azbfs.RetryReaderOptions{MaxRetryRequests: DownloadMaxTries}), pacer, firstRangeSize(sourceSize, downloadChunkSize))
+		}
+		if err != nil {
+			if jptm.ShouldLog(pipeline.LogInfo) {
+				jptm.Log(pipeline.LogInfo, "BlobFSDownloadFailed. transfer failed because the first range of the source could not be downloaded. Failed with error "+err.Error())
+			}
+			jptm.SetErrorStatus(err)
+			jptm.AddToBytesDone(info.SourceSize)
+			jptm.ReportTransferDone()
+			return
+		}
+		sourceETag = get.ETag()
+		sourceMD5 = get.FileContentMD5()
+	}
+	resuming := downloadCanResume(jptm, sourceETag)
+
 	// If the force Write flags is set to false
-	// then check the blob exists locally or not.
+	// then check the blob exists locally or not, unless it is the partial file of a resumed download.
 	// If it does, mark transfer as failed.
-	if !jptm.IsForceWriteTrue() {
+	if !jptm.IsForceWriteTrue() && !resuming {
 		_, err := os.Stat(info.Destination)
 		if err == nil {
 			// If the error is nil, then blob exists locally and it doesn't needs to be downloaded.
@@ -65,7 +98,7 @@ func BlobFSToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "BlobFSDownloadFailed because dst file could not be created locally. Failed with error "+err.Error())
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.ReportTransferDone()
 			return
 		}
@@ -76,7 +109,7 @@ func BlobFSToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobFSDownloadFailed while preserving last modified time for destionation %s", info.Destination))
 				}
-				jptm.SetStatus(common.ETransferStatus.Failed())
+				jptm.SetErrorStatus(err)
 				// Since the transfer failed, the file created above should be deleted
 				err = deleteFile(info.Destination)
 				if err != nil {
@@ -97,12 +130,12 @@ func BlobFSToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 		jptm.ReportTransferDone()
 
 	} else { // 3b: source has content
-		dstFile, err := createFileOfSize(info.Destination, sourceSize)
+		dstFile, err := openDownloadDestination(jptm, resuming, sourceETag, false)
 		if err != nil {
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "BlobFSDownloadFailed failed because dst file could not be created locally. Failed with error "+err.Error())
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.ReportTransferDone()
 			return
 		}
@@ -114,7 +147,7 @@ func BlobFSToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "BlobFSDownloadFailed failed because dst file did not memory mapped successfully")
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			// Since the transfer failed, the file created above should be deleted
 			err = deleteFile(info.Destination)
 			if err != nil {
@@ -126,16 +159,14 @@ func BlobFSToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 			jptm.ReportTransferDone()
 			return
 		}
-		if rem := sourceSize % downloadChunkSize; rem == 0 {
-			numChunks = uint32(sourceSize / downloadChunkSize)
-		} else {
-			numChunks = uint32(sourceSize/downloadChunkSize + 1)
-		}
 		jptm.SetNumberOfChunks(numChunks)
 		blockIdCount := int32(0)
 		bffd := &BlobFSFileDownload{jptm: jptm,
 			srcFileURL: srcBlobURL.NewFileUrl(),
+			srcETag:    sourceETag,
+			srcMD5:     sourceMD5,
 			destMMF:    dstMMF,
+			md5Hasher:  newDownloadMD5Hasher(jptm, dstMMF, downloadChunkSize, numChunks),
 			pacer:      pacer}
 		// step 4: go through the blob range and schedule download chunk jobs
 		for startIndex := int64(0); startIndex < sourceSize; startIndex += downloadChunkSize {
@@ -145,33 +176,16 @@ func BlobFSToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 			if startIndex+downloadChunkSize > sourceSize {
 				adjustedChunkSize = sourceSize - startIndex
 			}
-			// schedule the download chunk job
-			jptm.ScheduleChunks(bffd.generateDownloadFileFunc(blockIdCount, startIndex, adjustedChunkSize))
+			// schedule the download chunk job, the first one writes the first range which was already downloaded
+			jptm.ScheduleChunks(bffd.generateDownloadFileFunc(blockIdCount, startIndex, adjustedChunkSize, firstRange))
+			firstRange = nil
 			blockIdCount++
 		}
 	}
 }
 
-func (bffd *BlobFSFileDownload) generateDownloadFileFunc(blockIdCount int32, startIndex int64, adjustedRangeSize int64) chunkFunc {
+func (bffd *BlobFSFileDownload) generateDownloadFileFunc(blockIdCount int32, startIndex int64, adjustedRangeSize int64, firstRange []byte) chunkFunc {
 	return func(workerId int) {
-
-		// This function allows routine to manage behavior of unexpected panics.
-		// The panic error along with transfer details are logged.
-		// The transfer is marked as failed and is reported as done.
-		defer func(jptm IJobPartTransferMgr) {
-			r := recover()
-			if r != nil {
-				// Get the transfer Info and log the details
-				info := jptm.Info()
-				if jptm.ShouldLog(pipeline.LogError) {
-					jptm.Log(pipeline.LogError, fmt.Sprintf(" recovered from unexpected crash %s. Transfer Src %s Dst %s SrcSize %v startIndex %v adjustedRangeSize %v destinationMMF size %v",
-						r, info.Source, info.Destination, info.SourceSize, startIndex, adjustedRangeSize, len(bffd.destMMF.Slice())))
-				}
-				jptm.SetStatus(common.ETransferStatus.Failed())
-				jptm.ReportTransferDone()
-			}
-		}(bffd.jptm)
-
 		info := bffd.jptm.Info()
 		// chunkDone is an internal function which marks a chunkDone
 		// Check if the current chunk is the last Chunk
@@ -182,7 +196,7 @@ func (bffd *BlobFSFileDownload) generateDownloadFileFunc(blockIdCount int32, sta
 		chunkDone := func() {
 			// adding the bytes transferred or skipped of a transfer to determine the progress of transfer.
 			bffd.jptm.AddToBytesDone(adjustedRangeSize)
-			lastChunk, _ := bffd.jptm.ReportChunkDone()
+			lastChunk, _ := bffd.jptm.ReportChunkDone(workerId)
 			if lastChunk {
 				if bffd.jptm.ShouldLog(pipeline.LogInfo) {
 					bffd.jptm.Log(pipeline.LogInfo, fmt.Sprintf(" has worker %d which is finalizing cancellation of the Transfer", workerId))
@@ -191,61 +205,80 @@ func (bffd *BlobFSFileDownload) generateDownloadFileFunc(blockIdCount int32, sta
 				bffd.jptm.ReportTransferDone()
 				// If the status of transfer is less than or equal to 0
 				// then transfer failed or cancelled
-				// the downloaded file needs to be deleted
+				// the downloaded file needs to be deleted, unless the download can resume from it
 				if bffd.jptm.TransferStatus() <= 0 {
-					err := os.Remove(info.Destination)
-					if err != nil {
-						if bffd.jptm.ShouldLog(pipeline.LogError) {
-							bffd.jptm.Log(pipeline.LogError, fmt.Sprintf("error deleting the file %s. Failed with error %s", bffd.jptm.Info().Destination, err.Error()))
-						}
-					}
+					cleanupFailedDownload(bffd.jptm, info.Destination)
 				}
 			}
 		}
 		if bffd.jptm.WasCanceled() {
 			chunkDone()
 		} else {
-			// step 1: Downloading the file from range startIndex till (startIndex + adjustedRangeSize)
-			get, err := bffd.srcFileURL.Download(bffd.jptm.Context(), startIndex, adjustedRangeSize)
-			if err != nil {
-				if !bffd.jptm.WasCanceled() {
-					bffd.jptm.Cancel()
-					if bffd.jptm.ShouldLog(pipeline.LogInfo) {
-						bffd.jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobFSDownloadFailed. worker %d is canceling job because downloading startIndex %v and rangeSize %v has failed with error %s", workerId, startIndex, adjustedRangeSize, err.Error()))
-					}
-					bffd.jptm.SetStatus(common.ETransferStatus.Failed())
+			// a resumed transfer only downloads the chunks which its previous attempt didn't
+			if bffd.jptm.ChunkCompleted(uint32(blockIdCount)) {
+				if bffd.jptm.ShouldLog(pipeline.LogDebug) {
+					bffd.jptm.Log(pipeline.LogDebug, fmt.Sprintf("Chunk %d was downloaded by a previous attempt", blockIdCount))
 				}
-				chunkDone()
-				return
-			}
+			} else {
+				// step 1: Downloading the file from range startIndex till (startIndex + adjustedRangeSize)
+				// the first chunk starts with the first range, which was downloaded along with the ETag of the source
+				copied := copy(bffd.destMMF.Slice()[startIndex:startIndex+adjustedRangeSize], firstRange)
+				rangeStart, rangeEnd := startIndex+int64(copied), startIndex+adjustedRangeSize
+				if rangeStart < rangeEnd {
+					// If-Match fails the request if the file was overwritten since the download started
+					get, err := bffd.srcFileURL.Download(bffd.jptm.Context(), rangeStart, rangeEnd-rangeStart, azbfs.FileAccessConditions{IfMatch: bffd.srcETag})
+					if err != nil {
+						if !bffd.jptm.WasCanceled() {
+							bffd.jptm.Cancel()
+							if bffd.jptm.ShouldLog(pipeline.LogInfo) {
+								bffd.jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobFSDownloadFailed. worker %d is canceling job because downloading startIndex %v and rangeSize %v has failed with error %s", workerId, rangeStart, rangeEnd-rangeStart, err.Error()))
+							}
+							bffd.jptm.SetErrorStatus(err)
+						}
+						chunkDone()
+						return
+					}
 
-			// step 2: write the body into the memory mapped file directly
-			resp := get.Body(azbfs.RetryReaderOptions{MaxRetryRequests: DownloadMaxTries})
-			body := newResponseBodyPacer(resp, bffd.pacer, bffd.destMMF)
-			_, err = io.ReadFull(body, bffd.destMMF.Slice()[startIndex:startIndex+adjustedRangeSize])
-			// reading the response and closing the resp body
-			if resp != nil {
-				io.Copy(ioutil.Discard, resp)
-				resp.Close()
-			}
-			if err != nil {
-				// cancel entire transfer because this chunk has failed
-				if !bffd.jptm.WasCanceled() {
-					bffd.jptm.Cancel()
-					if bffd.jptm.ShouldLog(pipeline.LogInfo) {
-						bffd.jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobFSDownloadFailed. worker %d is canceling job and chunkID %d because reading the downloaded chunk failed. Failed with error %s", workerId, blockIdCount, err.Error()))
+					// step 2: write the body into the memory mapped file directly
+					resp := get.Body(azbfs.RetryReaderOptions{MaxRetryRequests: DownloadMaxTries})
+					body := newResponseBodyPacer(resp, bffd.pacer, bffd.destMMF)
+					_, err = io.ReadFull(body, bffd.destMMF.Slice()[rangeStart:rangeEnd])
+					// reading the response and closing the resp body
+					if resp != nil {
+						io.Copy(ioutil.Discard, resp)
+						resp.Close()
+					}
+					if err != nil {
+						// cancel entire transfer because this chunk has failed
+						if !bffd.jptm.WasCanceled() {
+							bffd.jptm.Cancel()
+							if bffd.jptm.ShouldLog(pipeline.LogInfo) {
+								bffd.jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobFSDownloadFailed. worker %d is canceling job and chunkID %d because reading the downloaded chunk failed. Failed with error %s", workerId, blockIdCount, err.Error()))
+							}
+							bffd.jptm.SetErrorStatus(err)
+						}
+						chunkDone()
+						return
 					}
-					bffd.jptm.SetStatus(common.ETransferStatus.Failed())
 				}
-				chunkDone()
-				return
+
+				// record the chunk as completed in the job part plan, so a resumed transfer doesn't download it again
+				bffd.jptm.SetChunkCompleted(uint32(blockIdCount))
 			}
+			bffd.md5Hasher.ChunkDone(blockIdCount)
 
 			bffd.jptm.AddToBytesDone(adjustedRangeSize)
 
-			lastChunk, _ := bffd.jptm.ReportChunkDone()
+			lastChunk, _ := bffd.jptm.ReportChunkDone(workerId)
 			// step 3: check if this is the last chunk
 			if lastChunk {
+				if !bffd.jptm.TransferStatus().DidFail() && !validateDownloadMD5(bffd.jptm, bffd.srcMD5, bffd.md5Hasher.Sum()) {
+					bffd.jptm.SetStatus(common.ETransferStatus.MD5MismatchFailure())
+					bffd.destMMF.Unmap()
+					cleanupFailedDownload(bffd.jptm, info.Destination)
+					bffd.jptm.ReportTransferDone()
+					return
+				}
 				// step 4: this is the last block, perform EPILOGUE
 				if bffd.jptm.ShouldLog(pipeline.LogInfo) {
 					bffd.jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobFSDownloadFailed. worker %d which is concluding download Transfer of job after processing chunkID %d", workerId, blockIdCount))
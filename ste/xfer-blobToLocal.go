@@ -21,10 +21,13 @@
 package ste
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -43,6 +46,11 @@ func BlobToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 	blobSize := int64(info.SourceSize)
 	downloadChunkSize := int64(info.BlockSize)
 	numChunks := uint32(0)
+	if rem := blobSize % downloadChunkSize; rem == 0 {
+		numChunks = uint32(blobSize / downloadChunkSize)
+	} else {
+		numChunks = uint32(blobSize/downloadChunkSize + 1)
+	}
 
 	// If the transfer was cancelled, then reporting transfer as done and increasing the bytestransferred by the size of the source.
 	if jptm.WasCanceled() {
@@ -51,10 +59,39 @@ func BlobToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 		return
 	}
 
+	// The ETag of the source pins every chunk of the download to the version of the blob it started from,
+	// and lets a later attempt of the transfer resume from the chunks completed by this one.
+	// It comes with the first range of the blob, which is downloaded before the chunks are scheduled
+	sourceETag := ""
+	var sourceMD5 []byte
+	var firstRange []byte
+	// A page blob is downloaded sparse: only its populated page ranges are downloaded,
+	// since the other pages read back as zeros, just like the holes of the sparse destination file
+	sparse := false
+	if numChunks > 0 {
+		get, err := srcBlobURL.Download(jptm.Context(), 0, firstRangeSize(blobSize, downloadChunkSize), azblob.BlobAccessConditions{}, true)
+		if err == nil {
+			firstRange, err = readFirstRange(get.Body(azblob.RetryReaderOptions{MaxRetryRequests: MaxRetryPerDownloadBody}), pacer, firstRangeSize(blobSize, downloadChunkSize))
+		}
+		if err != nil {
+			if jptm.ShouldLog(pipeline.LogInfo) {
+				jptm.Log(pipeline.LogInfo, "BlobDownloadFailed. transfer failed because the first range of the source could not be downloaded. Failed with error "+err.Error())
+			}
+			jptm.SetErrorStatus(err)
+			jptm.AddToBytesDone(info.SourceSize)
+			jptm.ReportTransferDone()
+			return
+		}
+		sourceETag = string(get.ETag())
+		sourceMD5 = get.BlobContentMD5()
+		sparse = get.BlobType() == azblob.BlobPageBlob
+	}
+	resuming := downloadCanResume(jptm, sourceETag)
+
 	// If the force Write flags is set to false
-	// then check the blob exists locally or not.
+	// then check the blob exists locally or not, unless it is the partial file of a resumed download.
 	// If it does, mark transfer as failed.
-	if !jptm.IsForceWriteTrue() {
+	if !jptm.IsForceWriteTrue() && !resuming {
 		_, err := os.Stat(info.Destination)
 		if err == nil {
 			// If the error is nil, then blob exists locally and it doesn't needs to be downloaded.
@@ -76,7 +113,7 @@ func BlobToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "BlobDownloadFailed. transfer failed because dst file could not be created locally. Failed with error "+err.Error())
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.ReportTransferDone()
 			return
 		}
@@ -87,7 +124,7 @@ func BlobToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobDownloadFailed. Failed while preserving last modified time for destionation %s", info.Destination))
 				}
-				jptm.SetStatus(common.ETransferStatus.Failed())
+				jptm.SetErrorStatus(err)
 				// Since the transfer failed, the file created above should be deleted
 				err = deleteFile(info.Destination)
 				if err != nil {
@@ -108,12 +145,12 @@ func BlobToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 		jptm.ReportTransferDone()
 
 	} else { // 3b: source has content
-		dstFile, err := createFileOfSize(info.Destination, blobSize)
+		dstFile, err := openDownloadDestination(jptm, resuming, sourceETag, sparse)
 		if err != nil {
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "BlobDownloadFailed. transfer failed because dst file could not be created locally. Failed with error "+err.Error())
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.ReportTransferDone()
 			return
 		}
@@ -125,7 +162,7 @@ func BlobToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "BlobDownloadFailed. transfer failed because dst file did not memory mapped successfully")
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			// Since the transfer failed, the file created above should be deleted
 			err = deleteFile(info.Destination)
 			if err != nil {
@@ -137,12 +174,8 @@ func BlobToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 			jptm.ReportTransferDone()
 			return
 		}
-		if rem := blobSize % downloadChunkSize; rem == 0 {
-			numChunks = uint32(blobSize / downloadChunkSize)
-		} else {
-			numChunks = uint32(blobSize/downloadChunkSize + 1)
-		}
 		jptm.SetNumberOfChunks(numChunks)
+		md5Hasher := newDownloadMD5Hasher(jptm, dstMMF, downloadChunkSize, numChunks)
 		blockIdCount := int32(0)
 		// step 4: go through the blob range and schedule download chunk jobs
 		for startIndex := int64(0); startIndex < blobSize; startIndex += downloadChunkSize {
@@ -153,14 +186,18 @@ func BlobToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 				adjustedChunkSize = blobSize - startIndex
 			}
 
-			// schedule the download chunk job
-			jptm.ScheduleChunks(generateDownloadBlobFunc(jptm, srcBlobURL, blockIdCount, dstMMF, info.Destination, startIndex, adjustedChunkSize, pacer))
+			// schedule the download chunk job, the first one writes the first range which was already downloaded
+			jptm.ScheduleChunks(generateDownloadBlobFunc(jptm, srcBlobURL, sourceETag, sourceMD5, blockIdCount, dstMMF, md5Hasher, info.Destination, startIndex, adjustedChunkSize,
+				sparse, firstRange, pacer))
+			firstRange = nil
 			blockIdCount++
 		}
 	}
 }
 
-func generateDownloadBlobFunc(jptm IJobPartTransferMgr, transferBlobURL azblob.BlobURL, chunkId int32, destinationMMF *common.MMF, destinationPath string, startIndex int64, adjustedChunkSize int64, p *pacer) chunkFunc {
+func generateDownloadBlobFunc(jptm IJobPartTransferMgr, transferBlobURL azblob.BlobURL, sourceETag string, sourceMD5 []byte, chunkId int32,
+	destinationMMF *common.MMF, md5Hasher *chunkedMD5Hasher, destinationPath string, startIndex int64, adjustedChunkSize int64,
+	sparse bool, firstRange []byte, p *pacer) chunkFunc {
 	return func(workerId int) {
 		// TODO: added the two operations for debugging purpose. remove later
 		// Increment a number of goroutine performing the transfer / acting on chunks msg by 1
@@ -168,26 +205,10 @@ func generateDownloadBlobFunc(jptm IJobPartTransferMgr, transferBlobURL azblob.B
 		// defer the decrement in the number of goroutine performing the transfer / acting on chunks msg by 1
 		defer jptm.ReleaseAConnection()
 
-		// This function allows routine to manage behavior of unexpected panics.
-		// The panic error along with transfer details are logged.
-		// The transfer is marked as failed and is reported as done.
-		//defer func (jptm IJobPartTransferMgr) {
-		//	r := recover()
-		//	if r != nil {
-		//		info := jptm.Info()
-		//		if jptm.ShouldLog(pipeline.LogError) {
-		//			jptm.Log(pipeline.LogError, fmt.Sprintf(" recovered from unexpected crash %s. Transfer Src %s Dst %s SrcSize %v startIndex %v chunkSize %v sourceMMF size %v",
-		//					r, info.Source, info.Destination, info.SourceSize, startIndex, adjustedChunkSize, len(destinationMMF.Slice())))
-		//		}
-		//		jptm.SetStatus(common.ETransferStatus.Failed())
-		//		jptm.ReportTransferDone()
-		//	}
-		//}(jptm)
-
 		chunkDone := func() {
 			// adding the bytes transferred or skipped of a transfer to determine the progress of transfer.
 			jptm.AddToBytesDone(adjustedChunkSize)
-			lastChunk, _ := jptm.ReportChunkDone()
+			lastChunk, _ := jptm.ReportChunkDone(workerId)
 			if lastChunk {
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo, fmt.Sprintf(" has worker %d which is finalizing cancellation of the Transfer", workerId))
@@ -195,15 +216,9 @@ func generateDownloadBlobFunc(jptm IJobPartTransferMgr, transferBlobURL azblob.B
 				destinationMMF.Unmap()
 				// If the current transfer status value is less than or equal to 0
 				// then transfer either failed or was cancelled
-				// the file created locally should be deleted
+				// the file created locally should be deleted, unless the download can resume from it
 				if jptm.TransferStatus() <= 0 {
-					err := deleteFile(destinationPath)
-					if err != nil {
-						// If there was an error deleting the file, log the error
-						if jptm.ShouldLog(pipeline.LogError) {
-							jptm.Log(pipeline.LogError, fmt.Sprintf("error deleting the file %s. Failed with error %s", destinationPath, err.Error()))
-						}
-					}
+					cleanupFailedDownload(jptm, destinationPath)
 				}
 				jptm.ReportTransferDone()
 			}
@@ -211,41 +226,96 @@ func generateDownloadBlobFunc(jptm IJobPartTransferMgr, transferBlobURL azblob.B
 		if jptm.WasCanceled() {
 			chunkDone()
 		} else {
-			// Step 1: Download blob from start Index till startIndex + adjustedChunkSize
-			get, err := transferBlobURL.Download(jptm.Context(), startIndex, adjustedChunkSize, azblob.BlobAccessConditions{}, false)
-			if err != nil {
-				if !jptm.WasCanceled() {
-					jptm.Cancel()
-					if jptm.ShouldLog(pipeline.LogInfo) {
-						jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobDownloadFailed. worker %d is canceling job because writing to file for startIndex of %d has failed", workerId, startIndex))
+			// a resumed transfer only downloads the chunks which its previous attempt didn't
+			if jptm.ChunkCompleted(uint32(chunkId)) {
+				if jptm.ShouldLog(pipeline.LogDebug) {
+					jptm.Log(pipeline.LogDebug, fmt.Sprintf("Chunk %d was downloaded by a previous attempt", chunkId))
+				}
+			} else {
+				// Step 1: Download the ranges of the chunk, which span from start Index till startIndex + adjustedChunkSize
+				// unless the blob is a sparse page blob. Its populated page ranges are then listed one chunk at a time,
+				// since the list of a large fragmented page blob may be too long to be returned at once.
+				var pageRanges []azblob.PageRange
+				if sparse {
+					pageList, err := transferBlobURL.ToPageBlobURL().GetPageRanges(jptm.Context(), startIndex, adjustedChunkSize,
+						azblob.BlobAccessConditions{HTTPAccessConditions: azblob.HTTPAccessConditions{IfMatch: azblob.ETag(sourceETag)}})
+					if err != nil {
+						if !jptm.WasCanceled() {
+							jptm.Cancel()
+							if jptm.ShouldLog(pipeline.LogInfo) {
+								jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobDownloadFailed. worker %d is canceling job because the page ranges from startIndex of %d could not be read. Failed with error %s", workerId, startIndex, err.Error()))
+							}
+							jptm.SetErrorStatus(err)
+						}
+						chunkDone()
+						return
 					}
-					jptm.SetStatus(common.ETransferStatus.Failed())
+					pageRanges = pageList.PageRange
 				}
-				chunkDone()
-				return
-			}
-			// step 2: write the body into the memory mapped file directly
-			body := get.Body(azblob.RetryReaderOptions{MaxRetryRequests: MaxRetryPerDownloadBody})
-			body = newResponseBodyPacer(body, p, destinationMMF)
-			_, err = io.ReadFull(body, destinationMMF.Slice()[startIndex:startIndex+adjustedChunkSize])
-			if err != nil {
-				// cancel entire transfer because this chunk has failed
-				if !jptm.WasCanceled() {
-					jptm.Cancel()
-					if jptm.ShouldLog(pipeline.LogInfo) {
-						jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobDownloadFailed. worker %d is canceling job because reading the downloaded chunk failed. Failed with error %s", workerId, err.Error()))
+				downloadedBytes := int64(0)
+				for _, downloadRange := range splitForRangeGetContentMD5(chunkDownloadRanges(pageRanges, sparse, startIndex, adjustedChunkSize)) {
+					rangeSize := downloadRange.End - downloadRange.Start + 1
+					if downloadRange.End < int64(len(firstRange)) {
+						// the range was downloaded along with the ETag of the source
+						copy(destinationMMF.Slice()[downloadRange.Start:downloadRange.End+1], firstRange[downloadRange.Start:downloadRange.End+1])
+						downloadedBytes += rangeSize
+						continue
+					}
+					// If-Match fails the request if the blob was overwritten since the download started
+					// The service returns the MD5 of the range, so a corrupted range is retried on its own by the pipeline
+					get, err := transferBlobURL.Download(jptm.Context(), downloadRange.Start, rangeSize,
+						azblob.BlobAccessConditions{HTTPAccessConditions: azblob.HTTPAccessConditions{IfMatch: azblob.ETag(sourceETag)}},
+						true)
+					if err != nil {
+						if !jptm.WasCanceled() {
+							jptm.Cancel()
+							if jptm.ShouldLog(pipeline.LogInfo) {
+								jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobDownloadFailed. worker %d is canceling job because writing to file for startIndex of %d has failed", workerId, downloadRange.Start))
+							}
+							jptm.SetErrorStatus(err)
+						}
+						chunkDone()
+						return
+					}
+					// step 2: write the body into the memory mapped file directly
+					body := get.Body(azblob.RetryReaderOptions{MaxRetryRequests: MaxRetryPerDownloadBody})
+					body = newResponseBodyPacer(body, p, destinationMMF)
+					_, err = io.ReadFull(body, destinationMMF.Slice()[downloadRange.Start:downloadRange.End+1])
+					body.Close()
+					if err != nil {
+						// cancel entire transfer because this chunk has failed
+						if !jptm.WasCanceled() {
+							jptm.Cancel()
+							if jptm.ShouldLog(pipeline.LogInfo) {
+								jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobDownloadFailed. worker %d is canceling job because reading the downloaded chunk failed. Failed with error %s", workerId, err.Error()))
+							}
+							jptm.SetErrorStatus(err)
+						}
+						chunkDone()
+						return
 					}
-					jptm.SetStatus(common.ETransferStatus.Failed())
+					downloadedBytes += rangeSize
 				}
-				chunkDone()
-				return
+				// the pages of a sparse page blob which weren't downloaded are holes of the destination file, which read back as zeros
+				jptm.AddToBytesSkipped(adjustedChunkSize - downloadedBytes)
+
+				// record the chunk as completed in the job part plan, so a resumed transfer doesn't download it again
+				jptm.SetChunkCompleted(uint32(chunkId))
 			}
+			md5Hasher.ChunkDone(chunkId)
 
 			jptm.AddToBytesDone(adjustedChunkSize)
 
-			lastChunk, _ := jptm.ReportChunkDone()
+			lastChunk, _ := jptm.ReportChunkDone(workerId)
 			// step 3: check if this is the last chunk
 			if lastChunk {
+				if !jptm.TransferStatus().DidFail() && !validateDownloadMD5(jptm, sourceMD5, md5Hasher.Sum()) {
+					jptm.SetStatus(common.ETransferStatus.MD5MismatchFailure())
+					destinationMMF.Unmap()
+					cleanupFailedDownload(jptm, destinationPath)
+					jptm.ReportTransferDone()
+					return
+				}
 				// step 4: this is the last block, perform EPILOGUE
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo, fmt.Sprintf("BlobDownloadSuccessful. worker %d is concluding download Transfer of job after processing chunkId %d", workerId, chunkId))
@@ -308,17 +378,146 @@ func deleteFile(destinationPath string) error {
 	return os.Remove(destinationPath)
 }
 
-// create a file, given its path and length
+// downloadCanResume returns true if a download can resume from the chunks which a previous attempt of the transfer
+// recorded as completed: the source must still have the ETag recorded back then, and the partially downloaded
+// destination file must still be there with the right size
+func downloadCanResume(jptm IJobPartTransferMgr, sourceETag string) bool {
+	if sourceETag == "" || jptm.SourceETag() != sourceETag || !jptm.HasCompletedChunks() {
+		return false
+	}
+	info := jptm.Info()
+	fileInfo, err := os.Stat(info.Destination)
+	return err == nil && fileInfo.Size() == info.SourceSize
+}
+
+// firstRangeSize returns the size of the first range of a download, which is downloaded before its chunks are scheduled
+// to get the ETag and the MD5 hash of the source. It's no larger than the first chunk, nor than the ranges whose MD5 the services return.
+func firstRangeSize(sourceSize int64, chunkSize int64) int64 {
+	size := common.Iffint64(sourceSize < chunkSize, sourceSize, chunkSize)
+	return common.Iffint64(size < maxRangeGetContentMD5Bytes, size, maxRangeGetContentMD5Bytes)
+}
+
+// readFirstRange reads the body of the first range of a download, which the first chunk writes to the destination
+func readFirstRange(body io.ReadCloser, p *pacer, size int64) ([]byte, error) {
+	defer body.Close()
+	data := make([]byte, size)
+	_, err := io.ReadFull(newResponseBodyPacer(body, p, nil), data)
+	return data, err
+}
+
+// openDownloadDestination reopens the partially downloaded destination file if the download resumes.
+// Otherwise, it creates the destination file, sparse if asked to, and resets the completed-chunk record of the transfer,
+// recording the current ETag of the source instead.
+func openDownloadDestination(jptm IJobPartTransferMgr, resuming bool, sourceETag string, sparse bool) (*os.File, error) {
+	info := jptm.Info()
+	if resuming {
+		if jptm.ShouldLog(pipeline.LogInfo) {
+			jptm.Log(pipeline.LogInfo, "resuming the download from the chunks downloaded by the previous attempt")
+		}
+		return os.OpenFile(info.Destination, os.O_RDWR, 0644)
+	}
+	jptm.ResetCompletedChunks()
+	jptm.SetSourceETag(sourceETag)
+	return createFileOfSize(info.Destination, info.SourceSize, sparse)
+}
 
-func createFileOfSize(destinationPath string, fileSize int64) (*os.File, error) {
+// cleanupFailedDownload deletes the destination file of a failed or cancelled download, unless some of its chunks
+// were downloaded: the partial file is then kept so that resuming the job only downloads the missing chunks.
+// The chunks downloaded before the source changed, or those of a download whose MD5 hash doesn't match,
+// are useless, so that file is deleted regardless.
+func cleanupFailedDownload(jptm IJobPartTransferMgr, destinationPath string) {
+	status := jptm.TransferStatus()
+	if status != common.ETransferStatus.SourceChangedFailure() && status != common.ETransferStatus.MD5MismatchFailure() && jptm.HasCompletedChunks() {
+		if jptm.ShouldLog(pipeline.LogInfo) {
+			jptm.Log(pipeline.LogInfo, fmt.Sprintf("keeping the partially downloaded file %s to resume from", destinationPath))
+		}
+		return
+	}
+	err := deleteFile(destinationPath)
+	if err != nil {
+		// If there was an error deleting the file, log the error
+		if jptm.ShouldLog(pipeline.LogError) {
+			jptm.Log(pipeline.LogError, fmt.Sprintf("error deleting the file %s. Failed with error %s", destinationPath, err.Error()))
+		}
+	}
+}
+
+// newDownloadMD5Hasher returns the hasher computing the MD5 hash of the data downloaded into the memory map,
+// or nil if the job doesn't validate it
+func newDownloadMD5Hasher(jptm IJobPartTransferMgr, destinationMMF *common.MMF, chunkSize int64, numChunks uint32) *chunkedMD5Hasher {
+	if jptm.MD5ValidationOption() == common.EHashValidationOption.NoCheck() {
+		return nil
+	}
+	return newChunkedMD5Hasher(destinationMMF.Slice(), chunkSize, numChunks)
+}
+
+// validateDownloadMD5 compares the MD5 hash of the downloaded data with the content MD5 of the source,
+// and returns false if the transfer has to fail because of the MD5 validation option of the job
+func validateDownloadMD5(jptm IJobPartTransferMgr, sourceMD5 []byte, downloadedMD5 []byte) bool {
+	option := jptm.MD5ValidationOption()
+	if option == common.EHashValidationOption.NoCheck() {
+		return true
+	}
+	if len(sourceMD5) == 0 {
+		if option != common.EHashValidationOption.FailIfDifferentOrMissing() {
+			return true
+		}
+		if jptm.ShouldLog(pipeline.LogError) {
+			jptm.Log(pipeline.LogError, fmt.Sprintf("the source %s has no content MD5 to validate the download against", jptm.Info().Source))
+		}
+		return false
+	}
+	if bytes.Equal(sourceMD5, downloadedMD5) {
+		return true
+	}
+	if jptm.ShouldLog(pipeline.LogError) {
+		jptm.Log(pipeline.LogError, fmt.Sprintf("the MD5 hash %s of the data downloaded from %s doesn't match the content MD5 %s of the source",
+			hex.EncodeToString(downloadedMD5), jptm.Info().Source, hex.EncodeToString(sourceMD5)))
+	}
+	return option == common.EHashValidationOption.LogOnly()
+}
+
+// chunkDownloadRanges returns the ranges to download for the chunk of chunkSize bytes at startIndex.
+// That's the whole chunk, unless the source is sparse: then it's the parts of the chunk which pageRanges,
+// the sorted populated page ranges of the source listed for the chunk, cover.
+func chunkDownloadRanges(pageRanges []azblob.PageRange, sparse bool, startIndex int64, chunkSize int64) []azblob.PageRange {
+	chunkEnd := startIndex + chunkSize - 1
+	if !sparse {
+		return []azblob.PageRange{{Start: startIndex, End: chunkEnd}}
+	}
+
+	ranges := []azblob.PageRange{}
+	// skip the page ranges which end before the chunk
+	first := sort.Search(len(pageRanges), func(i int) bool { return pageRanges[i].End >= startIndex })
+	for _, pageRange := range pageRanges[first:] {
+		if pageRange.Start > chunkEnd {
+			break
+		}
+		ranges = append(ranges, azblob.PageRange{
+			Start: common.Iffint64(pageRange.Start < startIndex, startIndex, pageRange.Start),
+			End:   common.Iffint64(pageRange.End > chunkEnd, chunkEnd, pageRange.End)})
+	}
+	return ranges
+}
+
+// create a file, given its path and length
+// A sparse file only takes disk space for the ranges which get written
+func createFileOfSize(destinationPath string, fileSize int64, sparse bool) (*os.File, error) {
 	createParentDirectoryIfNotExist(destinationPath)
 
 	f, err := os.OpenFile(destinationPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return nil, err
 	}
+	if sparse {
+		if err := common.MakeSparse(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
 	if truncateError := f.Truncate(fileSize); truncateError != nil {
-		return nil, err
+		f.Close()
+		return nil, truncateError
 	}
 	return f, nil
 }
@@ -38,7 +38,11 @@ func DeleteBlobPrologue(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pa
 			}
 			jptm.Log(pipeline.LogInfo, msg)
 		}
-		jptm.SetStatus(status)
+		if status == common.ETransferStatus.Failed() {
+			jptm.SetErrorStatus(err)
+		} else {
+			jptm.SetStatus(status)
+		}
 		jptm.AddToBytesDone(info.SourceSize)
 		jptm.ReportTransferDone()
 	}
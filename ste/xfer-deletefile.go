@@ -39,7 +39,11 @@ func DeleteFilePrologue(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pa
 			}
 			jptm.Log(pipeline.LogInfo, msg)
 		}
-		jptm.SetStatus(status)
+		if status == common.ETransferStatus.Failed() {
+			jptm.SetErrorStatus(err)
+		} else {
+			jptm.SetStatus(status)
+		}
 		jptm.AddToBytesDone(info.SourceSize)
 		jptm.ReportTransferDone()
 	}
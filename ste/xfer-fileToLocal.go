@@ -21,6 +21,7 @@
 package ste
 
 import (
+	"crypto/md5"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
 	"github.com/Azure/azure-storage-file-go/2017-07-29/azfile"
 )
 
@@ -43,6 +45,11 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 	fileSize := int64(info.SourceSize)
 	downloadChunkSize := int64(info.BlockSize)
 	numChunks := uint32(0)
+	if rem := fileSize % downloadChunkSize; rem == 0 {
+		numChunks = uint32(fileSize / downloadChunkSize)
+	} else {
+		numChunks = uint32(fileSize/downloadChunkSize + 1)
+	}
 
 	// If the transfer was cancelled, then reporting transfer as done and increasing the bytestransferred by the size of the source.
 	if jptm.WasCanceled() {
@@ -51,10 +58,38 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 		return
 	}
 
+	// The ETag of the source pins every chunk of the download to the version of the file it started from,
+	// and lets a later attempt of the transfer resume from the chunks completed by this one.
+	// It comes with the first range of the file, which is downloaded before the chunks are scheduled
+	sourceETag := ""
+	var sourceMD5 []byte
+	var firstRange []byte
+	if numChunks > 0 {
+		get, err := srcFileURL.Download(jptm.Context(), 0, firstRangeSize(fileSize, downloadChunkSize), true)
+		if err == nil {
+			firstRange, err = readFirstRange(get.Body(azfile.RetryReaderOptions{MaxRetryRequests: DownloadMaxTries}), pacer, firstRangeSize(fileSize, downloadChunkSize))
+		}
+		if err != nil {
+			if jptm.ShouldLog(pipeline.LogInfo) {
+				jptm.Log(pipeline.LogInfo, "transfer failed because the first range of the source could not be downloaded. Failed with error "+err.Error())
+			}
+			jptm.SetErrorStatus(err)
+			jptm.AddToBytesDone(info.SourceSize)
+			jptm.ReportTransferDone()
+			return
+		}
+		sourceETag = string(get.ETag())
+		// the MD5 hash of the whole file, if it has one
+		if fileMD5 := get.FileContentMD5(); fileMD5 != [md5.Size]byte{} {
+			sourceMD5 = fileMD5[:]
+		}
+	}
+	resuming := downloadCanResume(jptm, sourceETag)
+
 	// If the force Write flags is set to false
-	// then check the file exists locally or not.
+	// then check the file exists locally or not, unless it is the partial file of a resumed download.
 	// If it does, mark transfer as failed.
-	if !jptm.IsForceWriteTrue() {
+	if !jptm.IsForceWriteTrue() && !resuming {
 		_, err := os.Stat(info.Destination)
 		if err == nil {
 			// If the error is nil, then blob exists locally and it doesn't needs to be downloaded.
@@ -76,7 +111,7 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "transfer failed because dst file could not be created locally. Failed with error "+err.Error())
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.ReportTransferDone()
 			return
 		}
@@ -87,6 +122,7 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo, fmt.Sprintf(" failed while preserving last modified time for destionation %s", info.Destination))
 				}
+				jptm.SetErrorStatus(err)
 				//delete the file if transfer failed
 				err := os.Remove(info.Destination)
 				if err != nil {
@@ -94,7 +130,6 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 						jptm.Log(pipeline.LogError, fmt.Sprintf("error deleting the file %s. Failed with error %s", info.Destination, err.Error()))
 					}
 				}
-				jptm.SetStatus(common.ETransferStatus.Failed())
 				return
 			}
 			if jptm.ShouldLog(pipeline.LogInfo) {
@@ -108,12 +143,12 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 		jptm.ReportTransferDone()
 
 	} else { // 3b: source has content
-		dstFile, err := createFileOfSize(info.Destination, fileSize)
+		dstFile, err := openDownloadDestination(jptm, resuming, sourceETag, false)
 		if err != nil {
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "transfer failed because dst file could not be created locally. Failed with error "+err.Error())
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.ReportTransferDone()
 			return
 		}
@@ -123,6 +158,7 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 			if jptm.ShouldLog(pipeline.LogInfo) {
 				jptm.Log(pipeline.LogInfo, "transfer failed because dst file did not memory mapped successfully")
 			}
+			jptm.SetErrorStatus(err)
 			//delete the file if transfer failed
 			err := os.Remove(info.Destination)
 			if err != nil {
@@ -130,16 +166,11 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 					jptm.Log(pipeline.LogError, fmt.Sprintf("error deleting the file %s. Failed with error %s", info.Destination, err.Error()))
 				}
 			}
-			jptm.SetStatus(common.ETransferStatus.Failed())
 			jptm.ReportTransferDone()
 			return
 		}
-		if rem := fileSize % downloadChunkSize; rem == 0 {
-			numChunks = uint32(fileSize / downloadChunkSize)
-		} else {
-			numChunks = uint32(fileSize/downloadChunkSize + 1)
-		}
 		jptm.SetNumberOfChunks(numChunks)
+		md5Hasher := newDownloadMD5Hasher(jptm, dstMMF, downloadChunkSize, numChunks)
 		chunkIDCount := int32(0)
 		// step 4: go through the file range and schedule download chunk jobs
 		for startIndex := int64(0); startIndex < fileSize; startIndex += downloadChunkSize {
@@ -150,19 +181,21 @@ func FileToLocal(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 				adjustedChunkSize = fileSize - startIndex
 			}
 
-			// schedule the download chunk job
-			jptm.ScheduleChunks(generateDownloadFileFunc(jptm, srcFileURL, chunkIDCount, info.Destination, dstFile, dstMMF, startIndex, adjustedChunkSize))
+			// schedule the download chunk job, the first one writes the first range which was already downloaded
+			jptm.ScheduleChunks(generateDownloadFileFunc(jptm, srcFileURL, sourceETag, sourceMD5, chunkIDCount, info.Destination, dstFile, dstMMF, md5Hasher, startIndex, adjustedChunkSize, firstRange, pacer))
+			firstRange = nil
 			chunkIDCount++
 		}
 	}
 }
 
-func generateDownloadFileFunc(jptm IJobPartTransferMgr, transferFileURL azfile.FileURL, chunkID int32, destinationPath string, destinationFile *os.File, destinationMMF *common.MMF, startIndex int64, adjustedChunkSize int64) chunkFunc {
+func generateDownloadFileFunc(jptm IJobPartTransferMgr, transferFileURL azfile.FileURL, sourceETag string, sourceMD5 []byte, chunkID int32, destinationPath string,
+	destinationFile *os.File, destinationMMF *common.MMF, md5Hasher *chunkedMD5Hasher, startIndex int64, adjustedChunkSize int64, firstRange []byte, p *pacer) chunkFunc {
 	return func(workerId int) {
 		chunkDone := func() {
 			// adding the bytes transferred or skipped of a transfer to determine the progress of transfer.
 			jptm.AddToBytesDone(adjustedChunkSize)
-			lastChunk, _ := jptm.ReportChunkDone()
+			lastChunk, _ := jptm.ReportChunkDone(workerId)
 			if lastChunk {
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo, fmt.Sprintf(" has worker %d which is finalizing cancellation of the Transfer", workerId))
@@ -177,57 +210,94 @@ func generateDownloadFileFunc(jptm IJobPartTransferMgr, transferFileURL azfile.F
 				jptm.ReportTransferDone()
 				// If the status of transfer is less than or equal to 0
 				// then transfer failed or cancelled
-				// the downloaded file needs to be deleted
+				// the downloaded file needs to be deleted, unless the download can resume from it
 				if jptm.TransferStatus() <= 0 {
-					err := os.Remove(destinationPath)
-					if err != nil {
-						if jptm.ShouldLog(pipeline.LogError) {
-							jptm.Log(pipeline.LogError, fmt.Sprintf("error deleting the file %s. Failed with error %s", destinationPath, err.Error()))
-						}
-					}
+					cleanupFailedDownload(jptm, destinationPath)
 				}
 			}
 		}
 		if jptm.WasCanceled() {
 			chunkDone()
 		} else {
-			// step 1: Downloading the file from range startIndex till (startIndex + adjustedChunkSize)
-			get, err := transferFileURL.Download(jptm.Context(), startIndex, adjustedChunkSize, false)
-			if err != nil {
-				if !jptm.WasCanceled() {
-					jptm.Cancel()
-					if jptm.ShouldLog(pipeline.LogInfo) {
-						jptm.Log(pipeline.LogInfo, fmt.Sprintf(" has worker %d is canceling job and chunkID %d because writing to file for startIndex of %d has failed", workerId, chunkID, startIndex))
-					}
-					jptm.SetStatus(common.ETransferStatus.Failed())
+			// a resumed transfer only downloads the chunks which its previous attempt didn't
+			if jptm.ChunkCompleted(uint32(chunkID)) {
+				if jptm.ShouldLog(pipeline.LogDebug) {
+					jptm.Log(pipeline.LogDebug, fmt.Sprintf("Chunk %d was downloaded by a previous attempt", chunkID))
 				}
-				chunkDone()
-				return
-			}
+			} else {
+				// step 1: Downloading the file from range startIndex till (startIndex + adjustedChunkSize)
+				for _, downloadRange := range splitForRangeGetContentMD5([]azblob.PageRange{{Start: startIndex, End: startIndex + adjustedChunkSize - 1}}) {
+					if downloadRange.End < int64(len(firstRange)) {
+						// the range was downloaded along with the ETag of the source
+						copy(destinationMMF.Slice()[downloadRange.Start:downloadRange.End+1], firstRange[downloadRange.Start:downloadRange.End+1])
+						continue
+					}
+					// The service returns the MD5 of the range, so a corrupted range is retried on its own by the pipeline
+					get, err := transferFileURL.Download(jptm.Context(), downloadRange.Start, downloadRange.End-downloadRange.Start+1, true)
+					if err != nil {
+						if !jptm.WasCanceled() {
+							jptm.Cancel()
+							if jptm.ShouldLog(pipeline.LogInfo) {
+								jptm.Log(pipeline.LogInfo, fmt.Sprintf(" has worker %d is canceling job and chunkID %d because writing to file for startIndex of %d has failed", workerId, chunkID, downloadRange.Start))
+							}
+							jptm.SetErrorStatus(err)
+						}
+						chunkDone()
+						return
+					}
+					// Azure Files doesn't support conditional GETs, so the ETag of every response is compared
+					// with the one the download started from instead
+					if string(get.ETag()) != sourceETag {
+						get.Response().Body.Close()
+						if !jptm.WasCanceled() {
+							jptm.Cancel()
+							if jptm.ShouldLog(pipeline.LogError) {
+								jptm.Log(pipeline.LogError, fmt.Sprintf("the source %s was modified during its download", jptm.Info().Source))
+							}
+							jptm.SetStatus(common.ETransferStatus.SourceChangedFailure())
+						}
+						chunkDone()
+						return
+					}
 
-			// step 2: write the body into the memory mapped file directly
-			retryReader := get.Body(azfile.RetryReaderOptions{MaxRetryRequests: DownloadMaxTries})
-			_, err = io.ReadFull(retryReader, destinationMMF.Slice()[startIndex:startIndex+adjustedChunkSize])
-			io.Copy(ioutil.Discard, retryReader)
-			retryReader.Close()
-			if err != nil {
-				// cancel entire transfer because this chunk has failed
-				if !jptm.WasCanceled() {
-					jptm.Cancel()
-					if jptm.ShouldLog(pipeline.LogInfo) {
-						jptm.Log(pipeline.LogInfo, fmt.Sprintf(" has worker %d is canceling job and chunkID %d because reading the downloaded chunk failed. Failed with error %s", workerId, chunkID, err.Error()))
+					// step 2: write the body into the memory mapped file directly
+					retryReader := get.Body(azfile.RetryReaderOptions{MaxRetryRequests: DownloadMaxTries})
+					body := newResponseBodyPacer(retryReader, p, destinationMMF)
+					_, err = io.ReadFull(body, destinationMMF.Slice()[downloadRange.Start:downloadRange.End+1])
+					io.Copy(ioutil.Discard, retryReader)
+					retryReader.Close()
+					if err != nil {
+						// cancel entire transfer because this chunk has failed
+						if !jptm.WasCanceled() {
+							jptm.Cancel()
+							if jptm.ShouldLog(pipeline.LogInfo) {
+								jptm.Log(pipeline.LogInfo, fmt.Sprintf(" has worker %d is canceling job and chunkID %d because reading the downloaded chunk failed. Failed with error %s", workerId, chunkID, err.Error()))
+							}
+							jptm.SetErrorStatus(err)
+						}
+						chunkDone()
+						return
 					}
-					jptm.SetStatus(common.ETransferStatus.Failed())
 				}
-				chunkDone()
-				return
+
+				// record the chunk as completed in the job part plan, so a resumed transfer doesn't download it again
+				jptm.SetChunkCompleted(uint32(chunkID))
 			}
+			md5Hasher.ChunkDone(chunkID)
 
 			jptm.AddToBytesDone(adjustedChunkSize)
 
-			lastChunk, _ := jptm.ReportChunkDone()
+			lastChunk, _ := jptm.ReportChunkDone(workerId)
 			// step 3: check if this is the last chunk
 			if lastChunk {
+				if !jptm.TransferStatus().DidFail() && !validateDownloadMD5(jptm, sourceMD5, md5Hasher.Sum()) {
+					jptm.SetStatus(common.ETransferStatus.MD5MismatchFailure())
+					destinationMMF.Unmap()
+					destinationFile.Close()
+					cleanupFailedDownload(jptm, destinationPath)
+					jptm.ReportTransferDone()
+					return
+				}
 				// step 4: this is the last block, perform EPILOGUE
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo, fmt.Sprintf(" has worker %d which is concluding download Transfer of job after processing chunkID %d", workerId, chunkID))
@@ -38,7 +38,8 @@ func LocalToBlobFS(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 		if jptm.ShouldLog(pipeline.LogError) {
 			jptm.Log(pipeline.LogError, fmt.Sprintf("BlobFSUploadFailed. error getting the source info %s", info.Source))
 		}
-		transferDone(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
+		transferDone(jptm.TransferStatus())
 		return
 	}
 	// parse the destination Url
@@ -47,7 +48,8 @@ func LocalToBlobFS(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 		if jptm.ShouldLog(pipeline.LogError) {
 			jptm.Log(pipeline.LogError, fmt.Sprintf("BlobFSUploadFailed. error parsing the destination Url %s", info.Destination))
 		}
-		transferDone(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
+		transferDone(jptm.TransferStatus())
 		return
 	}
 
@@ -65,7 +67,8 @@ func LocalToBlobFS(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 				if jptm.ShouldLog(pipeline.LogError) {
 					jptm.Log(pipeline.LogError, fmt.Sprintf("BlobFSUploadFailed. Creating directory %s failed with error ", err.Error()))
 				}
-				transferDone(common.ETransferStatus.Failed())
+				jptm.SetErrorStatus(err)
+				transferDone(jptm.TransferStatus())
 			}
 			return
 		}
@@ -84,7 +87,8 @@ func LocalToBlobFS(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 			if jptm.ShouldLog(pipeline.LogError) {
 				jptm.Log(pipeline.LogError, fmt.Sprintf("BlobFSUploadFailed. Error creating the file for destination url %s. failed with error %s", info.Destination, err.Error()))
 			}
-			transferDone(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
+			transferDone(jptm.TransferStatus())
 			return
 		}
 		if jptm.ShouldLog(pipeline.LogInfo) {
@@ -100,7 +104,8 @@ func LocalToBlobFS(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 		if jptm.ShouldLog(pipeline.LogError) {
 			jptm.Log(pipeline.LogError, fmt.Sprintf("BlobFSUploadFailed. Error opening the source file %s. Failed with error %s", info.Source, err.Error()))
 		}
-		transferDone(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
+		transferDone(jptm.TransferStatus())
 		return
 	}
 	defer srcfile.Close()
@@ -111,7 +116,8 @@ func LocalToBlobFS(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 		if jptm.ShouldLog(pipeline.LogError) {
 			jptm.Log(pipeline.LogError, fmt.Sprintf("BlobFSUploadFailed. Error mapping the source file %s. failed with error %s", info.Source, err.Error()))
 		}
-		transferDone(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
+		transferDone(jptm.TransferStatus())
 		return
 	}
 
@@ -124,7 +130,8 @@ func LocalToBlobFS(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 		if jptm.ShouldLog(pipeline.LogError) {
 			jptm.Log(pipeline.LogError, fmt.Sprintf("BlobFSUploadFailed. Error creating the file for destination url %s. failed with error %s", info.Destination, err.Error()))
 		}
-		transferDone(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
+		transferDone(jptm.TransferStatus())
 		return
 	}
 	// Calculate the number of file Ranges for the given fileSize.
@@ -156,24 +163,6 @@ func LocalToBlobFS(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer)
 // fileRangeAppend is the api that is used to append the range to a file from range startRange to (startRange + calculatedRangeInterval)
 func (fru *fileRangeAppend) fileRangeAppend(startRange int64, calculatedRangeInterval int64) chunkFunc {
 	return func(workerId int) {
-
-		// This function allows routine to manage behavior of unexpected panics.
-		// The panic error along with transfer details are logged.
-		// The transfer is marked as failed and is reported as done.
-		defer func(jptm IJobPartTransferMgr) {
-			r := recover()
-			if r != nil {
-				// Get the transfer Info and log the details
-				info := jptm.Info()
-				if jptm.ShouldLog(pipeline.LogError) {
-					jptm.Log(pipeline.LogError, fmt.Sprintf(" recovered from unexpected crash %s. Transfer Src %s Dst %s SrcSize %v startRange %v calculatedRangeInterval %v sourceMMF size %v",
-						r, info.Source, info.Destination, info.SourceSize, startRange, calculatedRangeInterval, len(fru.srcMmf.Slice())))
-				}
-				jptm.SetStatus(common.ETransferStatus.Failed())
-				jptm.ReportTransferDone()
-			}
-		}(fru.jptm)
-
 		// transferDone is the internal function which called by the last range append
 		// it unmaps the source file and delete the file in case transfer failed
 		transferDone := func() {
@@ -201,15 +190,16 @@ func (fru *fileRangeAppend) fileRangeAppend(startRange int64, calculatedRangeInt
 			// report the chunk done
 			// if it is the last range that was scheduled to be appended to the file
 			// report transfer done
-			lastRangeDone, _ := fru.jptm.ReportChunkDone()
+			lastRangeDone, _ := fru.jptm.ReportChunkDone(workerId)
 			if lastRangeDone {
 				transferDone()
 			}
 			return
 		}
 
-		body := newRequestBodyPacer(bytes.NewReader(fru.srcMmf.Slice()[startRange:startRange+calculatedRangeInterval]), fru.pacer, fru.srcMmf)
-		_, err := fru.fileUrl.AppendData(fru.jptm.Context(), startRange, body)
+		rangeBytes := fru.srcMmf.Slice()[startRange : startRange+calculatedRangeInterval]
+		body := newRequestBodyPacer(bytes.NewReader(rangeBytes), fru.pacer, fru.srcMmf)
+		_, err := fru.fileUrl.AppendData(withTransactionalMD5(fru.jptm.Context(), rangeBytes), startRange, body)
 		if err != nil {
 			// If the file append range failed, it could be that transfer was cancelled
 			// status of transfer does not change when it is cancelled
@@ -225,12 +215,12 @@ func (fru *fileRangeAppend) fileRangeAppend(startRange int64, calculatedRangeInt
 				}
 				// cancel the transfer
 				fru.jptm.Cancel()
-				fru.jptm.SetStatus(common.ETransferStatus.Failed())
+				fru.jptm.SetErrorStatus(err)
 			}
 			// add range updated to bytes done for progress
 			fru.jptm.AddToBytesDone(calculatedRangeInterval)
 			// report the number of range done
-			lastRangeDone, _ := fru.jptm.ReportChunkDone()
+			lastRangeDone, _ := fru.jptm.ReportChunkDone(workerId)
 			// if the current range is the last range to be appended for the transfer
 			// report transfer done
 			if lastRangeDone {
@@ -248,7 +238,7 @@ func (fru *fileRangeAppend) fileRangeAppend(startRange int64, calculatedRangeInt
 		fru.jptm.AddToBytesDone(calculatedRangeInterval)
 
 		//report the chunkDone
-		lastRangeDone, _ := fru.jptm.ReportChunkDone()
+		lastRangeDone, _ := fru.jptm.ReportChunkDone(workerId)
 		// if this the last range, then transfer needs to be concluded
 		if lastRangeDone {
 			// If the transfer was cancelled before the ranges could be flushed
@@ -256,6 +246,12 @@ func (fru *fileRangeAppend) fileRangeAppend(startRange int64, calculatedRangeInt
 				transferDone()
 				return
 			}
+			// the ranges are only flushed if they were all read from the version of the source which was enumerated
+			if localSourceChanged(fru.jptm) {
+				fru.jptm.SetStatus(common.ETransferStatus.SourceChangedFailure())
+				transferDone()
+				return
+			}
 			_, err = fru.fileUrl.FlushData(fru.jptm.Context(), fru.jptm.Info().SourceSize)
 			if err != nil {
 				if fru.jptm.WasCanceled() {
@@ -268,7 +264,7 @@ func (fru *fileRangeAppend) fileRangeAppend(startRange int64, calculatedRangeInt
 						fru.jptm.Log(pipeline.LogError, fmt.Sprintf("BlobFSUploadFailed while flushing the ranges for file %s failed with error %s", fru.fileUrl, err.Error()))
 					}
 					fru.jptm.Cancel()
-					fru.jptm.SetStatus(common.ETransferStatus.Failed())
+					fru.jptm.SetErrorStatus(err)
 				}
 				transferDone()
 				return
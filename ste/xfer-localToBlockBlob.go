@@ -23,12 +23,10 @@ package ste
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
+	"crypto/md5"
 	"fmt"
-	"net/http"
 	"net/url"
 	"os"
-	"strings"
 	"unsafe"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -44,6 +42,7 @@ type blockBlobUpload struct {
 	blobURL  azblob.BlobURL
 	pacer    *pacer
 	blockIds []string
+	md5Hasher *chunkedMD5Hasher
 }
 
 type pageBlobUpload struct {
@@ -57,10 +56,6 @@ type pageBlobUpload struct {
 
 func LocalToBlockBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 
-	EndsWith := func(s string, t string) bool {
-		return len(s) >= len(t) && strings.EqualFold(s[len(s)-len(t):], t)
-	}
-
 	// step 1. Get the transfer Information which include source, destination string, source size and other information.
 	info := jptm.Info()
 	blobSize := int64(info.SourceSize)
@@ -103,7 +98,7 @@ func LocalToBlockBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pace
 	if err != nil {
 		jptm.LogUploadError(info.Source, info.Destination, "Couldn't open source-" + err.Error(), 0)
 		jptm.AddToBytesDone(info.SourceSize)
-		jptm.SetStatus(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
 		jptm.ReportTransferDone()
 		return
 	}
@@ -117,16 +112,32 @@ func LocalToBlockBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pace
 		srcMmf, err = common.NewMMF(srcFile, false, 0, blobSize)
 		if err != nil {
 			jptm.LogUploadError(info.Source, info.Destination, "Memory Map Error-" + err.Error(), 0)
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.AddToBytesDone(info.SourceSize)
 			jptm.ReportTransferDone()
 			return
 		}
 	}
 
-	if EndsWith(info.Source, ".vhd") && (blobSize%azblob.PageBlobPageBytes == 0) {
-		// step 3.b: If the Source is vhd file and its size is multiple of 512,
-		// then upload the blob as a pageBlob.
+	// step 3.a: The source is uploaded as a page blob if the job asks for page blobs,
+	// or if the blob type is detected and the source is a fixed size VHD disk image.
+	uploadAsPageBlob := jptm.BlobType() == common.EBlobType.PageBlob() ||
+		(jptm.BlobType() == common.EBlobType.Detect() && common.IsFixedVHD(srcMmf.Slice()))
+
+	// Page blobs are written in pages of 512 bytes, so the source can only become a page blob if its size is a multiple of 512.
+	// The enumeration already checked it, but the file may have changed since.
+	if uploadAsPageBlob && blobSize%azblob.PageBlobPageBytes != 0 {
+		jptm.LogUploadError(info.Source, info.Destination,
+			fmt.Sprintf("Cannot upload as a page blob-the size %d is not a multiple of %d bytes", blobSize, azblob.PageBlobPageBytes), 0)
+		jptm.SetStatus(common.ETransferStatus.Failed())
+		jptm.AddToBytesDone(info.SourceSize)
+		jptm.ReportTransferDone()
+		srcMmf.Unmap()
+		return
+	}
+
+	if uploadAsPageBlob {
+		// step 3.b: upload the source as a pageBlob.
 		pageBlobUrl := blobUrl.ToPageBlobURL()
 
 		// If the given chunk Size for the Job is greater than maximum page size i.e 4 MB
@@ -146,9 +157,11 @@ func LocalToBlockBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pace
 			status, msg := ErrorEx{err}.ErrorCodeAndString()
 			jptm.LogUploadError(info.Source, info.Destination, "PageBlob Create-" +msg, status)
 			jptm.Cancel()
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.ReportTransferDone()
-			srcMmf.Unmap()
+			if blobSize > 0 {
+				srcMmf.Unmap()
+			}
 			return
 		}
 
@@ -173,17 +186,28 @@ func LocalToBlockBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pace
 
 				}
 				jptm.ReportTransferDone()
-				srcMmf.Unmap()
+				if blobSize > 0 {
+					srcMmf.Unmap()
+				}
 				return
 			}
 		}
 
+		// an empty page blob is complete once created
+		if blobSize == 0 {
+			jptm.SetStatus(common.ETransferStatus.Success())
+			jptm.ReportTransferDone()
+			return
+		}
+
 		// Calculate the number of Page Ranges for the given PageSize.
 		numPages := common.Iffuint32(blobSize%chunkSize == 0,
 			uint32(blobSize/chunkSize),
 			uint32(blobSize/chunkSize)+1)
 
 		jptm.SetNumberOfChunks(numPages)
+		// the pages are all uploaded again, so the zero bytes which a previous attempt skipped mustn't be counted twice
+		jptm.ResetCompletedChunks()
 
 		pbu := &pageBlobUpload{
 			jptm:    jptm,
@@ -204,12 +228,12 @@ func LocalToBlockBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pace
 			jptm.ScheduleChunks(pbu.pageBlobUploadFunc(startIndex, adjustedPageSize))
 		}
 	} else if blobSize == 0 || blobSize <= chunkSize {
-		// step 3.b: if blob size is smaller than chunk size and it is not a vhd file
+		// step 3.b: if blob size is smaller than chunk size and it is not a page blob
 		// we should do a put blob instead of chunk up the file
 		PutBlobUploadFunc(jptm, srcMmf, blobUrl.ToBlockBlobURL(), pacer)
 		return
 	} else {
-		// step 3.c: If the source is not a vhd and size is greater than chunk Size,
+		// step 3.c: If the source is not a page blob and size is greater than chunk Size,
 		// then uploading the source as block Blob.
 		// calculating num of chunks using the source size and chunkSize.
 		numChunks := common.Iffuint32(
@@ -233,7 +257,11 @@ func LocalToBlockBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pace
 			destination:info.Destination,
 			blobURL:  blobUrl,
 			pacer:    pacer,
-			blockIds: blockIds}
+			blockIds: blockIds,
+			md5Hasher: newChunkedMD5Hasher(srcMmf.Slice(), chunkSize, numChunks)}
+
+		// If this transfer is resumed, then the chunks uploaded by its previous attempt don't need to be uploaded again
+		bbu.checkCompletedChunks(srcFile, numChunks, chunkSize, blobSize)
 
 		// go through the file and schedule chunk messages to upload each chunk
 		for startIndex := int64(0); startIndex < blobSize; startIndex += chunkSize {
@@ -256,6 +284,60 @@ func LocalToBlockBlob(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pace
 	}
 }
 
+// checkCompletedChunks verifies the chunks which a previous attempt of this transfer recorded as completed in the job part plan.
+// Only the chunks whose block is still among the uncommitted blocks of the blob are kept, the others get uploaded again.
+// None of them is kept if the source was modified since the job was ordered.
+func (bbu *blockBlobUpload) checkCompletedChunks(srcFile *os.File, numChunks uint32, chunkSize int64, blobSize int64) {
+	jptm := bbu.jptm
+	if !jptm.HasCompletedChunks() {
+		return
+	}
+
+	fileInfo, err := srcFile.Stat()
+	if err != nil || !fileInfo.ModTime().Equal(jptm.Info().SourceLastModifiedTime) {
+		if jptm.ShouldLog(pipeline.LogInfo) {
+			jptm.Log(pipeline.LogInfo, "source was modified since the previous attempt, uploading all the chunks again")
+		}
+		jptm.ResetCompletedChunks()
+		return
+	}
+
+	blockList, err := bbu.blobURL.ToBlockBlobURL().GetBlockList(jptm.Context(), azblob.BlockListUncommitted, azblob.LeaseAccessConditions{})
+	if err != nil {
+		// If the block list couldn't be read, e.g. because the uncommitted blocks have expired along with the blob,
+		// then none of the chunks can be trusted
+		if jptm.ShouldLog(pipeline.LogInfo) {
+			status, msg := ErrorEx{err}.ErrorCodeAndString()
+			jptm.Log(pipeline.LogInfo, fmt.Sprintf("couldn't get the uncommitted blocks, uploading all the chunks again: %03d : %s", status, msg))
+		}
+		jptm.ResetCompletedChunks()
+		return
+	}
+	stagedBlocks := make(map[string]int64, len(blockList.UncommittedBlocks))
+	for _, block := range blockList.UncommittedBlocks {
+		stagedBlocks[block.Name] = int64(block.Size)
+	}
+
+	// Keep the chunks whose block was staged with the expected size
+	var stagedChunks []uint32
+	for chunkIndex := uint32(0); chunkIndex < numChunks; chunkIndex++ {
+		if !jptm.ChunkCompleted(chunkIndex) {
+			continue
+		}
+		expectedSize := common.Iffint64(int64(chunkIndex+1)*chunkSize > blobSize, blobSize-int64(chunkIndex)*chunkSize, chunkSize)
+		if size, staged := stagedBlocks[jptm.ChunkBlockID(chunkIndex)]; staged && size == expectedSize {
+			stagedChunks = append(stagedChunks, chunkIndex)
+		}
+	}
+	jptm.ResetCompletedChunks()
+	for _, chunkIndex := range stagedChunks {
+		jptm.SetChunkCompleted(chunkIndex)
+	}
+	if jptm.ShouldLog(pipeline.LogInfo) {
+		jptm.Log(pipeline.LogInfo, fmt.Sprintf("resuming the upload with %d of %d chunks already uploaded", len(stagedChunks), numChunks))
+	}
+}
+
 // This method blockBlobUploadFunc uploads the block of src data from given startIndex till the given chunkSize.
 func (bbu *blockBlobUpload) blockBlobUploadFunc(chunkId int32, startIndex int64, adjustedChunkSize int64) chunkFunc {
 	return func(workerId int) {
@@ -266,39 +348,13 @@ func (bbu *blockBlobUpload) blockBlobUploadFunc(chunkId int32, startIndex int64,
 		// defer the decrement in the number of goroutine performing the transfer / acting on chunks msg by 1
 		defer bbu.jptm.ReleaseAConnection()
 
-		// This function allows routine to manage behavior of unexpected panics.
-		// The panic error along with transfer details are logged.
-		// The transfer is marked as failed and is reported as done.
-		//defer func(jptm IJobPartTransferMgr) {
-		//	r := recover()
-		//	if r != nil {
-		//		info := jptm.Info()
-		//		if jptm.ShouldLog(pipeline.LogError) {
-		//			jptm.Log(pipeline.LogError, fmt.Sprintf(" recovered from unexpected crash %s. Transfer Src %s Dst %s SrcSize %v startIndex %v chunkSize %v sourceMMF size %v",
-		//				r, info.Source, info.Destination, info.SourceSize, startIndex, adjustedChunkSize, len(bbu.srcMmf.Slice())))
-		//		}
-		//		jptm.SetStatus(common.ETransferStatus.Failed())
-		//		jptm.ReportTransferDone()
-		//	}
-		//}(bbu.jptm)
-
 		// and the chunkFunc has been changed to the version without param workId
 		// transfer done is internal function which marks the transfer done, unmaps the src file and close the  source file.
 		transferDone := func() {
 			bbu.jptm.Log(pipeline.LogInfo, "Transfer done")
 			bbu.srcMmf.Unmap()
-			// Get the Status of the transfer
-			// If the transfer status value < 0, then transfer failed with some failure
-			// there is a possibility that some uncommitted blocks will be there
-			// Delete the uncommitted blobs
-			if bbu.jptm.TransferStatus() <= 0 {
-				_, err := bbu.blobURL.ToBlockBlobURL().Delete(context.TODO(), azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
-				if stErr, ok := err.(azblob.StorageError); ok && stErr.Response().StatusCode != http.StatusNotFound {
-					// If the delete failed with Status Not Found, then it means there were no uncommitted blocks.
-					// Other errors report that uncommitted blocks are there
-					bbu.jptm.LogError(bbu.blobURL.String(), "Deleting uncommitted blocks", err)
-				}
-			}
+			// If the transfer failed, the uncommitted blocks are kept so that resuming the job only uploads the missing chunks
+			// The service discards them if the transfer isn't resumed within a week
 			bbu.jptm.ReportTransferDone()
 		}
 
@@ -307,7 +363,7 @@ func (bbu *blockBlobUpload) blockBlobUploadFunc(chunkId int32, startIndex int64,
 				bbu.jptm.Log(pipeline.LogDebug, fmt.Sprintf("Transfer cancelled; skipping chunk %d", chunkId))
 			}
 			bbu.jptm.AddToBytesDone(adjustedChunkSize)
-			if lastChunk, _ := bbu.jptm.ReportChunkDone(); lastChunk {
+			if lastChunk, _ := bbu.jptm.ReportChunkDone(workerId); lastChunk {
 				if bbu.jptm.ShouldLog(pipeline.LogDebug) {
 					bbu.jptm.Log(pipeline.LogDebug,
 						fmt.Sprintf("Finalizing transfer cancellation"))
@@ -316,17 +372,25 @@ func (bbu *blockBlobUpload) blockBlobUploadFunc(chunkId int32, startIndex int64,
 			}
 			return
 		}
-		// step 1: generate block ID
-		blockId := common.NewUUID().String()
-		encodedBlockId := base64.StdEncoding.EncodeToString([]byte(blockId))
+		// step 1: generate block ID, which is the same every time this chunk of the transfer is uploaded
+		encodedBlockId := bbu.jptm.ChunkBlockID(uint32(chunkId))
 
 		// step 2: save the block ID into the list of block IDs
 		(bbu.blockIds)[chunkId] = encodedBlockId
 
-		// step 3: perform put block
+		// step 3: perform put block, unless a previous attempt of the transfer staged this block already
 		blockBlobUrl := bbu.blobURL.ToBlockBlobURL()
-		body := newRequestBodyPacer(bytes.NewReader(bbu.srcMmf.Slice()[startIndex:startIndex+adjustedChunkSize]), bbu.pacer, bbu.srcMmf)
-		_, err := blockBlobUrl.StageBlock(bbu.jptm.Context(), encodedBlockId, body, azblob.LeaseAccessConditions{})
+		var err error
+		if bbu.jptm.ChunkCompleted(uint32(chunkId)) {
+			if bbu.jptm.ShouldLog(pipeline.LogDebug) {
+				bbu.jptm.Log(pipeline.LogDebug, fmt.Sprintf("Chunk %d was uploaded by a previous attempt", chunkId))
+			}
+		} else {
+			blockBytes := bbu.srcMmf.Slice()[startIndex : startIndex+adjustedChunkSize]
+			body := newRequestBodyPacer(bytes.NewReader(blockBytes), bbu.pacer, bbu.srcMmf)
+			// the block carries its own MD5 so that a block corrupted on the wire is rejected and retried on its own
+			_, err = blockBlobUrl.StageBlock(withTransactionalMD5(bbu.jptm.Context(), blockBytes), encodedBlockId, body, azblob.LeaseAccessConditions{})
+		}
 		if err != nil {
 			// check if the transfer was cancelled while Stage Block was in process.
 			if bbu.jptm.WasCanceled() {
@@ -341,13 +405,13 @@ func (bbu *blockBlobUpload) blockBlobUploadFunc(chunkId int32, startIndex int64,
 				status, msg := ErrorEx{err}.ErrorCodeAndString()
 				bbu.jptm.LogUploadError(bbu.source, bbu.destination, "Chunk Upload Failed " + msg, status)
 				//updateChunkInfo(jobId, partNum, transferId, uint16(chunkId), ChunkTransferStatusFailed, jobsInfoMap)
-				bbu.jptm.SetStatus(common.ETransferStatus.Failed())
+				bbu.jptm.SetErrorStatus(err)
 			}
 
 			//adding the chunk size to the bytes transferred to report the progress.
 			bbu.jptm.AddToBytesDone(adjustedChunkSize)
 
-			if lastChunk, _ := bbu.jptm.ReportChunkDone(); lastChunk {
+			if lastChunk, _ := bbu.jptm.ReportChunkDone(workerId); lastChunk {
 				if bbu.jptm.ShouldLog(pipeline.LogDebug) {
 					bbu.jptm.Log(pipeline.LogDebug,
 						fmt.Sprintf(" Finalizing transfer cancellation"))
@@ -357,11 +421,17 @@ func (bbu *blockBlobUpload) blockBlobUploadFunc(chunkId int32, startIndex int64,
 			return
 		}
 
+		// record the chunk as completed in the job part plan, so a resumed transfer doesn't upload it again
+		bbu.jptm.SetChunkCompleted(uint32(chunkId))
+
+		// add the chunk to the MD5 hash of the blob, which is only known once all the chunks were read
+		bbu.md5Hasher.ChunkDone(chunkId)
+
 		//adding the chunk size to the bytes transferred to report the progress.
 		bbu.jptm.AddToBytesDone(adjustedChunkSize)
 
 		// step 4: check if this is the last chunk
-		if lastChunk, _ := bbu.jptm.ReportChunkDone(); lastChunk {
+		if lastChunk, _ := bbu.jptm.ReportChunkDone(workerId); lastChunk {
 			// If the transfer gets cancelled before the putblock list
 			if bbu.jptm.WasCanceled() {
 				transferDone()
@@ -376,13 +446,21 @@ func (bbu *blockBlobUpload) blockBlobUploadFunc(chunkId int32, startIndex int64,
 			// fetching the blob http headers with content-type, content-encoding attributes
 			// fetching the metadata passed with the JobPartOrder
 			blobHttpHeader, metaData := bbu.jptm.BlobDstData(bbu.srcMmf)
+			blobHttpHeader.ContentMD5 = bbu.md5Hasher.Sum()
+
+			// the blocks are only committed if they were all read from the version of the source which was enumerated
+			if localSourceChanged(bbu.jptm) {
+				bbu.jptm.SetStatus(common.ETransferStatus.SourceChangedFailure())
+				transferDone()
+				return
+			}
 
 			// commit the blocks.
 			_, err := blockBlobUrl.CommitBlockList(bbu.jptm.Context(), bbu.blockIds, blobHttpHeader, metaData, azblob.BlobAccessConditions{})
 			if err != nil {
 				status, msg := ErrorEx{err}.ErrorCodeAndString()
 				bbu.jptm.LogUploadError(bbu.source, bbu.destination, "Commit block list failed " + msg, status)
-				bbu.jptm.SetStatus(common.ETransferStatus.Failed())
+				bbu.jptm.SetErrorStatus(err)
 				transferDone()
 				return
 			}
@@ -416,32 +494,25 @@ func PutBlobUploadFunc(jptm IJobPartTransferMgr, srcMmf *common.MMF, blockBlobUr
 	// defer the decrement in the number of goroutine performing the transfer / acting on chunks msg by 1
 	defer jptm.ReleaseAConnection()
 
-	// This function allows routine to manage behavior of unexpected panics.
-	// The panic error along with transfer details are logged.
-	// The transfer is marked as failed and is reported as done.
-	//defer func(jptm IJobPartTransferMgr) {
-	//	r := recover()
-	//	if r != nil {
-	//		info := jptm.Info()
-	//		lenSrcMmf := 0
-	//		if info.SourceSize != 0 {
-	//			lenSrcMmf = len(srcMmf.Slice())
-	//		}
-	//		if jptm.ShouldLog(pipeline.LogError) {
-	//			jptm.Log(pipeline.LogError, fmt.Sprintf(" recovered from unexpected crash %s. Transfer Src %s Dst %s SrcSize %v sourceMMF size %v",
-	//				r, info.Source, info.Destination, info.SourceSize, lenSrcMmf))
-	//		}
-	//		jptm.SetStatus(common.ETransferStatus.Failed())
-	//		jptm.ReportTransferDone()
-	//	}
-	//}(jptm)
-
 	// Get blob http headers and metadata.
 	blobHttpHeader, metaData := jptm.BlobDstData(srcMmf)
+	contentMD5 := md5.Sum(srcMmf.Slice())
+	blobHttpHeader.ContentMD5 = contentMD5[:]
 
 	var err error
 
 	tInfo := jptm.Info()
+	// a put blob commits the blob as it uploads it, so the source is checked beforehand, and checked again once it's uploaded
+	if localSourceChanged(jptm) {
+		jptm.SetStatus(common.ETransferStatus.SourceChangedFailure())
+		jptm.AddToBytesDone(tInfo.SourceSize)
+		jptm.ReportTransferDone()
+		if tInfo.SourceSize != 0 {
+			srcMmf.Unmap()
+		}
+		return
+	}
+
 	// take care of empty blobs
 	if tInfo.SourceSize == 0 {
 		_, err = blockBlobUrl.Upload(jptm.Context(), bytes.NewReader(nil), blobHttpHeader, metaData, azblob.BlobAccessConditions{})
@@ -455,7 +526,14 @@ func PutBlobUploadFunc(jptm IJobPartTransferMgr, srcMmf *common.MMF, blockBlobUr
 		status, msg := ErrorEx{err}.ErrorCodeAndString()
 		jptm.LogUploadError(tInfo.Source, tInfo.Destination, "PutBlob Failed " + msg, status)
 		if !jptm.WasCanceled() {
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
+		}
+	} else if localSourceChanged(jptm) {
+		// the source changed while the put blob read it, so the blob committed may be a torn snapshot of it
+		jptm.SetStatus(common.ETransferStatus.SourceChangedFailure())
+		_, err := blockBlobUrl.Delete(context.TODO(), azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+		if err != nil {
+			jptm.LogError(blockBlobUrl.String(), "DeleteBlobFailed", err)
 		}
 	} else {
 		// if the put blob is a success, updating the transfer status to success
@@ -503,33 +581,21 @@ func (pbu *pageBlobUpload) pageBlobUploadFunc(startPage int64, calculatedPageSiz
 		// defer the decrement in the number of goroutine performing the transfer / acting on chunks msg by 1
 		defer pbu.jptm.ReleaseAConnection()
 
-		// This function allows routine to manage behavior of unexpected panics.
-		// The panic error along with transfer details are logged.
-		// The transfer is marked as failed and is reported as done.
-		//defer func(jptm IJobPartTransferMgr) {
-		//	r := recover()
-		//	if r != nil {
-		//		info := jptm.Info()
-		//		if jptm.ShouldLog(pipeline.LogError) {
-		//			jptm.Log(pipeline.LogError, fmt.Sprintf(" recovered from unexpected crash %s. Transfer Src %s Dst %s SrcSize %v startPage %v calculatedPageSize %v sourceMMF size %v",
-		//				r, info.Source, info.Destination, info.SourceSize, startPage, calculatedPageSize, len(pbu.srcMmf.Slice())))
-		//		}
-		//		jptm.SetStatus(common.ETransferStatus.Failed())
-		//		jptm.ReportTransferDone()
-		//	}
-		//}(pbu.jptm)
-
 		// pageDone is the function called after success / failure of each page.
 		// If the calling page is the last page of transfer, then it updates the transfer status,
 		// mark transfer done, unmap the source memory map and close the source file descriptor.
 		pageDone := func() {
 			// adding the page size to the bytes transferred.
 			pbu.jptm.AddToBytesDone(calculatedPageSize)
-			if lastPage, _ := pbu.jptm.ReportChunkDone(); lastPage {
+			if lastPage, _ := pbu.jptm.ReportChunkDone(workerId); lastPage {
 				if pbu.jptm.ShouldLog(pipeline.LogDebug) {
 					pbu.jptm.Log(pipeline.LogDebug,
 						fmt.Sprintf("Finalizing transfer"))
 				}
+				// the page blob is only complete if all its pages were read from the version of the source which was enumerated
+				if !pbu.jptm.WasCanceled() && localSourceChanged(pbu.jptm) {
+					pbu.jptm.SetStatus(common.ETransferStatus.SourceChangedFailure())
+				}
 				pbu.jptm.SetStatus(common.ETransferStatus.Success())
 				pbu.srcMmf.Unmap()
 				// If the value of transfer Status is less than 0
@@ -554,54 +620,92 @@ func (pbu *pageBlobUpload) pageBlobUploadFunc(startPage int64, calculatedPageSiz
 		} else {
 			// pageBytes is the byte slice of Page for the given page range
 			pageBytes := pbu.srcMmf.Slice()[startPage : startPage+calculatedPageSize]
-			// converted the bytes slice to int64 array.
-			// converting each of 8 bytes of byteSlice to an integer.
-			int64Slice := (*(*[]int64)(unsafe.Pointer(&pageBytes)))[:len(pageBytes)/8]
-
-			allBytesZero := true
-			// Iterating though each integer of in64 array to check if any of the number is greater than 0 or not.
-			// If any no is greater than 0, it means that the 8 bytes slice represented by that integer has atleast one byte greater than 0
-			// If all integers are 0, it means that the 8 bytes slice represented by each integer has no byte greater than 0
-			for index := 0; index < len(int64Slice); index++ {
-				if int64Slice[index] != 0 {
-					// If one number is greater than 0, then we need to perform the PutPage update.
-					allBytesZero = false
-					break
-				}
+			// the pages which only hold zeros don't need to be sent, since the page blob reads them back as zeros anyway
+			// they are counted as done all the same, and the bytes skipped are reported in the job summary
+			pageRanges := nonZeroPageRanges(pageBytes)
+			nonZeroBytes := int64(0)
+			for _, pageRange := range pageRanges {
+				nonZeroBytes += pageRange.End - pageRange.Start + 1
+			}
+			if len(pageRanges) == 0 && pbu.jptm.ShouldLog(pipeline.LogDebug) {
+				pbu.jptm.Log(pipeline.LogDebug,
+					fmt.Sprintf("All zero bytes. No Page Upload for range from %d to %d", startPage, startPage+calculatedPageSize))
 			}
 
-			// If all the bytes in the pageBytes is 0, then we do not need to perform the PutPage
-			// Updating number of chunks done.
-			if allBytesZero {
+			pageBlobUrl := pbu.blobUrl.ToPageBlobURL()
+			for _, pageRange := range pageRanges {
+				rangeBytes := pageBytes[pageRange.Start : pageRange.End+1]
+				body := newRequestBodyPacer(bytes.NewReader(rangeBytes), pbu.pacer, pbu.srcMmf)
+				_, err := pageBlobUrl.UploadPages(withTransactionalMD5(pbu.jptm.Context(), rangeBytes), startPage+pageRange.Start, body, azblob.BlobAccessConditions{})
+				if err != nil {
+					if pbu.jptm.WasCanceled() {
+						pbu.jptm.LogError(pageBlobUrl.String(), "PutPageFailed ", err)
+					} else {
+						status, msg := ErrorEx{err}.ErrorCodeAndString()
+						pbu.jptm.LogUploadError(pbu.source, pbu.destination, "UploadPages " + msg, status)
+						// cancelling the transfer
+						pbu.jptm.Cancel()
+						pbu.jptm.SetErrorStatus(err)
+					}
+					pageDone()
+					return
+				}
 				if pbu.jptm.ShouldLog(pipeline.LogDebug) {
 					pbu.jptm.Log(pipeline.LogDebug,
-						fmt.Sprintf("All zero bytes. No Page Upload for range from %d to %d", startPage, startPage+calculatedPageSize))
+						fmt.Sprintf("PUT page request successful: range %d to %d", startPage+pageRange.Start, startPage+pageRange.End+1))
 				}
-				pageDone()
-				return
 			}
+			// the zero bytes are only skipped once the rest of the chunk was uploaded
+			pbu.jptm.AddToBytesSkipped(calculatedPageSize - nonZeroBytes)
+			pageDone()
+		}
+	}
+}
 
-			body := newRequestBodyPacer(bytes.NewReader(pageBytes), pbu.pacer, pbu.srcMmf)
-			pageBlobUrl := pbu.blobUrl.ToPageBlobURL()
-			_, err := pageBlobUrl.UploadPages(pbu.jptm.Context(), startPage, body, azblob.BlobAccessConditions{})
-			if err != nil {
-				if pbu.jptm.WasCanceled() {
-					pbu.jptm.LogError(pageBlobUrl.String(), "PutPageFailed ", err)
-				} else {
-					status, msg := ErrorEx{err}.ErrorCodeAndString()
-					pbu.jptm.LogUploadError(pbu.source, pbu.destination, "UploadPages " + msg, status)
-					// cancelling the transfer
-					pbu.jptm.Cancel()
-					pbu.jptm.SetStatus(common.ETransferStatus.Failed())
-				}
-				pageDone()
-				return
-			}
-			if pbu.jptm.ShouldLog(pipeline.LogDebug) {
-				pbu.jptm.Log(pipeline.LogDebug,
-					fmt.Sprintf("PUT page request successful: range %d to %d", startPage, startPage+calculatedPageSize))
+// localSourceChanged stats the local source of an upload again before the upload is committed, and reports whether
+// its size or last modified time changed since it was enumerated: the data uploaded may then be a torn snapshot of it
+func localSourceChanged(jptm IJobPartTransferMgr) bool {
+	info := jptm.Info()
+	fileInfo, err := os.Stat(info.Source)
+	if err == nil && fileInfo.Size() == info.SourceSize && fileInfo.ModTime().Equal(info.SourceLastModifiedTime) {
+		return false
+	}
+	if jptm.ShouldLog(pipeline.LogError) {
+		if err != nil {
+			jptm.Log(pipeline.LogError, fmt.Sprintf("couldn't stat the source %s before committing its upload. Failed with error %s", info.Source, err.Error()))
+		} else {
+			jptm.Log(pipeline.LogError, fmt.Sprintf("the source %s was modified during its upload: its size and last modified time went from %d and %v to %d and %v",
+				info.Source, info.SourceSize, info.SourceLastModifiedTime, fileInfo.Size(), fileInfo.ModTime()))
+		}
+	}
+	return true
+}
+
+// nonZeroPageRanges returns the runs of consecutive pages of pageBytes which hold a non-zero byte, as ranges of offsets
+// in pageBytes; it's empty when all the bytes of pageBytes are zero. pageBytes has to be made of whole pages.
+func nonZeroPageRanges(pageBytes []byte) []azblob.PageRange {
+	// converting each of 8 bytes of pageBytes to an integer, so that 8 bytes are checked at once
+	int64Slice := (*(*[]int64)(unsafe.Pointer(&pageBytes)))[:len(pageBytes)/8]
+	const int64sPerPage = azblob.PageBlobPageBytes / 8
+	pageRanges := []azblob.PageRange{}
+	for page := 0; page*int64sPerPage < len(int64Slice); page++ {
+		nonZero := false
+		for _, value := range int64Slice[page*int64sPerPage : (page+1)*int64sPerPage] {
+			if value != 0 {
+				nonZero = true
+				break
 			}
-			pageDone()
+		}
+		if !nonZero {
+			continue
+		}
+		start, end := int64(page*azblob.PageBlobPageBytes), int64((page+1)*azblob.PageBlobPageBytes-1)
+		if last := len(pageRanges) - 1; last >= 0 && pageRanges[last].End+1 == start {
+			// the page extends the run of the previous one
+			pageRanges[last].End = end
+		} else {
+			pageRanges = append(pageRanges, azblob.PageRange{Start: start, End: end})
 		}
 	}
+	return pageRanges
 }
@@ -23,6 +23,7 @@ package ste
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"fmt"
 	"net"
 	"net/http"
@@ -90,7 +91,7 @@ func LocalToFile(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 		if jptm.ShouldLog(pipeline.LogInfo) {
 			jptm.Log(pipeline.LogInfo, fmt.Sprintf("error opening the source file %d", info.SourceSize))
 		}
-		jptm.SetStatus(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
 		jptm.AddToBytesDone(info.SourceSize)
 		jptm.ReportTransferDone()
 		return
@@ -100,7 +101,7 @@ func LocalToFile(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 		if jptm.ShouldLog(pipeline.LogInfo) {
 			jptm.Log(pipeline.LogInfo, fmt.Sprintf("error getting the source file Info of file %d", info.SourceSize))
 		}
-		jptm.SetStatus(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
 		jptm.AddToBytesDone(info.SourceSize)
 		jptm.ReportTransferDone()
 		return
@@ -115,7 +116,7 @@ func LocalToFile(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 				jptm.Log(pipeline.LogInfo, fmt.Sprintf("error memory mapping the source file %s. Failed with error %s", srcFile.Name(), err.Error()))
 			}
 			srcFile.Close()
-			jptm.SetStatus(common.ETransferStatus.Failed())
+			jptm.SetErrorStatus(err)
 			jptm.AddToBytesDone(info.SourceSize)
 			jptm.ReportTransferDone()
 			return
@@ -134,7 +135,7 @@ func LocalToFile(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 				fmt.Sprintf("failed since Create parent directory for file failed due to %s", err.Error()))
 		}
 		jptm.Cancel()
-		jptm.SetStatus(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
 		jptm.ReportTransferDone()
 		// Unmap only if the source size is > 0
 		if info.SourceSize > 0 {
@@ -151,6 +152,10 @@ func LocalToFile(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 	}
 
 	// 3b: Create Azure file with the source size.
+	// The MD5 hash of an empty file is known upfront, the one of a file with content is set once all its ranges are uploaded
+	if info.SourceSize == 0 {
+		fileHTTPHeaders.ContentMD5 = md5.Sum(nil)
+	}
 	_, err = fileURL.Create(jptm.Context(), fileSize, fileHTTPHeaders, metaData)
 	if err != nil {
 		if jptm.ShouldLog(pipeline.LogInfo) {
@@ -158,7 +163,7 @@ func LocalToFile(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 				fmt.Sprintf("failed since Create failed due to %s", err.Error()))
 		}
 		jptm.Cancel()
-		jptm.SetStatus(common.ETransferStatus.Failed())
+		jptm.SetErrorStatus(err)
 		jptm.ReportTransferDone()
 		// Unmap only if the source size > 0
 		if info.SourceSize > 0 {
@@ -197,6 +202,8 @@ func LocalToFile(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 	}
 
 	jptm.SetNumberOfChunks(numChunks)
+	md5Hasher := newChunkedMD5Hasher(srcMmf.Slice(), chunkSize, numChunks)
+	chunkIndex := int32(0)
 	// step 4: Scheduling range update to the object created in Step 3
 	for startIndex := int64(0); startIndex < fileSize; startIndex += chunkSize {
 		adjustedChunkSize := chunkSize
@@ -207,11 +214,13 @@ func LocalToFile(jptm IJobPartTransferMgr, p pipeline.Pipeline, pacer *pacer) {
 		}
 
 		// schedule the chunk job/msg
-		jptm.ScheduleChunks(fileUploadFunc(jptm, srcFile, srcMmf, fileURL, pacer, startIndex, adjustedChunkSize))
+		jptm.ScheduleChunks(fileUploadFunc(jptm, srcFile, srcMmf, fileURL, fileHTTPHeaders, md5Hasher, pacer, chunkIndex, startIndex, adjustedChunkSize))
+		chunkIndex++
 	}
 }
 
-func fileUploadFunc(jptm IJobPartTransferMgr, srcFile *os.File, srcMmf *common.MMF, fileURL azfile.FileURL, pacer *pacer, startRange int64, pageSize int64) chunkFunc {
+func fileUploadFunc(jptm IJobPartTransferMgr, srcFile *os.File, srcMmf *common.MMF, fileURL azfile.FileURL, fileHTTPHeaders azfile.FileHTTPHeaders,
+	md5Hasher *chunkedMD5Hasher, pacer *pacer, chunkIndex int32, startRange int64, pageSize int64) chunkFunc {
 	return func(workerId int) {
 		// rangeDone is the function called after success / failure of each range.
 		// If the calling range is the last range of transfer, then it updates the transfer status,
@@ -219,11 +228,27 @@ func fileUploadFunc(jptm IJobPartTransferMgr, srcFile *os.File, srcMmf *common.M
 		rangeDone := func() {
 			// adding the range size to the bytes transferred.
 			jptm.AddToBytesDone(pageSize)
-			if lastPage, _ := jptm.ReportChunkDone(); lastPage {
+			if lastPage, _ := jptm.ReportChunkDone(workerId); lastPage {
 				if jptm.ShouldLog(pipeline.LogInfo) {
 					jptm.Log(pipeline.LogInfo,
 						fmt.Sprintf("has worker %d which is finalizing transfer", workerId))
 				}
+				// the file is only complete if all its ranges were read from the version of the source which was enumerated
+				if !jptm.WasCanceled() && localSourceChanged(jptm) {
+					jptm.SetStatus(common.ETransferStatus.SourceChangedFailure())
+				}
+				// the MD5 hash of the file is only known once all its ranges were read, so it's set last
+				if !jptm.WasCanceled() && !jptm.TransferStatus().DidFail() {
+					copy(fileHTTPHeaders.ContentMD5[:], md5Hasher.Sum())
+					_, err := fileURL.SetHTTPHeaders(jptm.Context(), fileHTTPHeaders)
+					if err != nil {
+						if jptm.ShouldLog(pipeline.LogInfo) {
+							jptm.Log(pipeline.LogInfo,
+								fmt.Sprintf("has worker %d which failed to set the http headers of the file because of following error %s", workerId, err.Error()))
+						}
+						jptm.SetErrorStatus(err)
+					}
+				}
 				jptm.SetStatus(common.ETransferStatus.Success())
 				srcMmf.Unmap()
 				err := srcFile.Close()
@@ -280,12 +305,13 @@ func fileUploadFunc(jptm IJobPartTransferMgr, srcFile *os.File, srcMmf *common.M
 					jptm.Log(pipeline.LogInfo,
 						fmt.Sprintf("has worker %d which is not performing UploadRange for range from %d to %d since all the bytes are zero", workerId, startRange, startRange+pageSize))
 				}
+				md5Hasher.ChunkDone(chunkIndex)
 				rangeDone()
 				return
 			}
 
 			body := newRequestBodyPacer(bytes.NewReader(rangeBytes), pacer, srcMmf)
-			_, err := fileURL.UploadRange(jptm.Context(), startRange, body)
+			_, err := fileURL.UploadRange(withTransactionalMD5(jptm.Context(), rangeBytes), startRange, body)
 			if err != nil {
 				if jptm.WasCanceled() {
 					if jptm.ShouldLog(pipeline.LogInfo) {
@@ -299,7 +325,7 @@ func fileUploadFunc(jptm IJobPartTransferMgr, srcFile *os.File, srcMmf *common.M
 					}
 					// cancelling the transfer
 					jptm.Cancel()
-					jptm.SetStatus(common.ETransferStatus.Failed())
+					jptm.SetErrorStatus(err)
 				}
 				rangeDone()
 				return
@@ -308,6 +334,8 @@ func fileUploadFunc(jptm IJobPartTransferMgr, srcFile *os.File, srcMmf *common.M
 				jptm.Log(pipeline.LogInfo,
 					fmt.Sprintf("has workedId %d which successfully complete PUT range request from range %d to %d", workerId, startRange, startRange+pageSize))
 			}
+			// add the range to the MD5 hash of the file, which is only known once all the ranges were read
+			md5Hasher.ChunkDone(chunkIndex)
 			rangeDone()
 		}
 	}
@@ -0,0 +1,124 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+)
+
+// maxRangeGetContentMD5Bytes is the largest range for which the Blob and File services return the MD5 of the range on a ranged GET
+const maxRangeGetContentMD5Bytes = 4 * 1024 * 1024
+
+// splitForRangeGetContentMD5 splits the given ranges into ranges of at most maxRangeGetContentMD5Bytes,
+// so that the service returns the MD5 of each of them and a chunk is checked whatever its size.
+func splitForRangeGetContentMD5(ranges []azblob.PageRange) []azblob.PageRange {
+	split := make([]azblob.PageRange, 0, len(ranges))
+	for _, r := range ranges {
+		for start := r.Start; start <= r.End; start += maxRangeGetContentMD5Bytes {
+			end := start + maxRangeGetContentMD5Bytes - 1
+			if end > r.End {
+				end = r.End
+			}
+			split = append(split, azblob.PageRange{Start: start, End: end})
+		}
+	}
+	return split
+}
+
+type transactionalMD5Key struct{}
+
+// withTransactionalMD5 returns a copy of ctx which makes the transactional MD5 policy send the MD5 of body as the Content-MD5 of the request.
+// The service then rejects the request if the data it received was corrupted on the wire.
+func withTransactionalMD5(ctx context.Context, body []byte) context.Context {
+	sum := md5.Sum(body)
+	return context.WithValue(ctx, transactionalMD5Key{}, sum[:])
+}
+
+// transactionalMD5Error is returned when the data of a single request was corrupted on the wire.
+// It implements net.Error and reports itself as temporary, so that the retry policies retry the request on their own
+// instead of failing the whole transfer.
+type transactionalMD5Error struct {
+	msg string
+}
+
+func (e transactionalMD5Error) Error() string   { return e.msg }
+func (e transactionalMD5Error) Timeout() bool   { return false }
+func (e transactionalMD5Error) Temporary() bool { return true }
+
+// NewTransactionalMD5PolicyFactory creates a factory whose policies check the integrity of every chunk sent or received.
+// An upload sends the Content-MD5 given by withTransactionalMD5, and the body of a ranged download which asked for
+// x-ms-range-get-content-md5 is checked against the Content-MD5 returned by the service.
+// It has to sit between the retry policy and the credential, so that every try is checked and the Content-MD5 header is signed.
+func NewTransactionalMD5PolicyFactory() pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if value := ctx.Value(transactionalMD5Key{}); value != nil {
+				request.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(value.([]byte)))
+			}
+			resp, err := next.Do(ctx, request)
+			if err != nil {
+				// the services fail the request with 400 Md5Mismatch when the body they received doesn't match the Content-MD5
+				if respErr, ok := err.(interface{ Response() *http.Response }); ok && resp != nil && respErr.Response() != nil &&
+					respErr.Response().Header.Get("x-ms-error-code") == string(azblob.ServiceCodeMd5Mismatch) {
+					return resp, transactionalMD5Error{msg: fmt.Sprintf("the service received corrupted data for %s: %s", request.URL.Path, err.Error())}
+				}
+				return resp, err
+			}
+			if request.Header.Get("x-ms-range-get-content-md5") == "true" {
+				return resp, verifyRangeMD5(request, resp)
+			}
+			return resp, nil
+		}
+	})
+}
+
+// verifyRangeMD5 reads the body of a ranged download and checks it against the Content-MD5 returned by the service.
+// The body is buffered, which is fine since the services only return the MD5 of ranges up to maxRangeGetContentMD5Bytes.
+func verifyRangeMD5(request pipeline.Request, resp pipeline.Response) error {
+	response := resp.Response()
+	expectedMD5, err := base64.StdEncoding.DecodeString(response.Header.Get("Content-MD5"))
+	if err != nil || len(expectedMD5) == 0 {
+		// nothing to check the range against
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		// reading the range is part of the request, so the failure is retried like any other network error
+		return transactionalMD5Error{msg: fmt.Sprintf("failed to read the range of %s: %s", request.URL.Path, err.Error())}
+	}
+	if actualMD5 := md5.Sum(data); !bytes.Equal(actualMD5[:], expectedMD5) {
+		return transactionalMD5Error{msg: fmt.Sprintf("the range of %s was corrupted on the wire: expected MD5 %s, got %s",
+			request.URL.Path, base64.StdEncoding.EncodeToString(expectedMD5), base64.StdEncoding.EncodeToString(actualMD5[:]))}
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-azcopy/azbfs"
+	"github.com/Azure/azure-storage-azcopy/common"
 	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
 )
 
@@ -60,6 +61,76 @@ type XferRetryOptions struct {
 	// NOTE: Before setting this field, make sure you understand the issues around reading stale & potentially-inconsistent
 	// data at this webpage: https://docs.microsoft.com/en-us/azure/storage/common/storage-designing-ha-apps-with-ragrs
 	RetryReadsFromSecondaryHost string // Comment this our for non-Blob SDKs
+
+	// StatusCodeRetryClasses overrides how the requests which fail with the given HTTP status codes are retried.
+	// The status codes it doesn't hold are retried as DefaultStatusCodeRetryClasses says, or not at all if it doesn't hold them either.
+	StatusCodeRetryClasses map[int]common.RetryClass
+}
+
+// DefaultStatusCodeRetryClasses tells which HTTP status codes of the failed requests are worth retrying
+var DefaultStatusCodeRetryClasses = map[int]common.RetryClass{
+	http.StatusRequestTimeout:      common.ERetryClass.Retryable(),
+	http.StatusTooManyRequests:     common.ERetryClass.Throttling(),
+	http.StatusInternalServerError: common.ERetryClass.Retryable(),
+	http.StatusBadGateway:          common.ERetryClass.Retryable(),
+	http.StatusServiceUnavailable:  common.ERetryClass.Throttling(),
+	http.StatusGatewayTimeout:      common.ERetryClass.Retryable(),
+}
+
+// xferRetryOptions are the retry options of the pipelines created by AzCopy, see SetXferRetryOptions
+var xferRetryOptions = XferRetryOptions{
+	Policy:        RetryPolicyExponential,
+	MaxTries:      UploadMaxTries,
+	TryTimeout:    UploadTryTimeout,
+	RetryDelay:    UploadRetryDelay,
+	MaxRetryDelay: UploadMaxRetryDelay,
+}
+
+// SetXferRetryOptions changes the retry options of the pipelines created from then on, by the transfer engine
+// as well as by the front-end. It is meant to be called once, with the settings given to the command, before any pipeline is created.
+func SetXferRetryOptions(o XferRetryOptions) {
+	xferRetryOptions = o
+}
+
+// GetXferRetryOptions returns the retry options of the pipelines created by AzCopy
+func GetXferRetryOptions() XferRetryOptions {
+	return xferRetryOptions
+}
+
+// retryClass returns how a request which failed with the given HTTP status code is retried
+func (o XferRetryOptions) retryClass(statusCode int) common.RetryClass {
+	if retryClass, ok := o.StatusCodeRetryClasses[statusCode]; ok {
+		return retryClass
+	}
+	if retryClass, ok := DefaultStatusCodeRetryClasses[statusCode]; ok {
+		return retryClass
+	}
+	return common.ERetryClass.Fatal()
+}
+
+// storageErrorAction returns the action taken on a request which failed with a storage error of the given response
+func (o XferRetryOptions) storageErrorAction(response *http.Response, temporary bool) string {
+	if response == nil {
+		return common.IffString(temporary, "Retry: StorageError and Temporary()", "NoRetry: expected storage error")
+	}
+	switch o.retryClass(response.StatusCode) {
+	case common.ERetryClass.Throttling():
+		return "Retry: StorageError and throttling status code"
+	case common.ERetryClass.Retryable():
+		return "Retry: StorageError and retryable status code"
+	default:
+		return "NoRetry: expected storage error"
+	}
+}
+
+// throttleDelay returns how long the service asked to wait before retrying a request it answered with the given response,
+// if the response means throttling. The retry waits for it rather than for the delay of the retry policy,
+// when the latter is shorter: MaxRetryDelay may be much lower than the backoff the service asks for.
+func (o XferRetryOptions) throttleDelay(response *http.Response) time.Duration {
+	if response == nil || o.retryClass(response.StatusCode) != common.ERetryClass.Throttling() {
+		return 0
+	}
+	return retryAfter(response)
 }
 
 func (o XferRetryOptions) retryReadsFromSecondaryHost() string {
@@ -128,12 +199,17 @@ func (o XferRetryOptions) calcDelay(try int32) time.Duration { // try is >=1; ne
 		}
 	}
 
-	// Introduce some jitter:  [0.0, 1.0) / 2 = [0.0, 0.5) + 0.8 = [0.8, 1.3)
-	delay = time.Duration(int64(float32(delay.Nanoseconds())*rand.Float32()/2 + 0.8)) // NOTE: We want math/rand; not crypto/rand
 	if delay > o.MaxRetryDelay {
 		delay = o.MaxRetryDelay
 	}
-	return delay
+	// Introduce some jitter, so that the requests which failed together aren't all retried at the same time:
+	// the delay is randomized between half and all of itself, which keeps it under MaxRetryDelay
+	return withJitter(delay)
+}
+
+// withJitter returns a random delay in [delay/2, delay]
+func withJitter(delay time.Duration) time.Duration {
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)) // NOTE: We want math/rand; not crypto/rand
 }
 
 // TODO fix the separate retry policies
@@ -147,30 +223,38 @@ func NewBFSXferRetryPolicyFactory(o XferRetryOptions) pipeline.Factory {
 
 			// We only consider retrying against a secondary if we have a read request (GET/HEAD) AND this policy has a Secondary URL it can use
 			considerSecondary := (request.Method == http.MethodGet || request.Method == http.MethodHead) && o.retryReadsFromSecondaryHost() != ""
+			// retryAfterDelay is how long the service asked to wait when it throttled the previous try, see throttleDelay
+			retryAfterDelay := time.Duration(0)
 
-			// Exponential retry algorithm: ((2 ^ attempt) - 1) * delay * random(0.8, 1.2)
-			// When to retry: connection failure or temporary/timeout. NOTE: StorageError considers HTTP 500/503 as temporary & is therefore retryable
+			// Exponential retry algorithm: min(((2 ^ attempt) - 1) * delay, maxDelay) * random(0.5, 1.0)
+			// When to retry: connection failure, or a status code which StatusCodeRetryClasses/DefaultStatusCodeRetryClasses says is retryable or throttling
 			// If using a secondary:
 			//    Even tries go against primary; odd tries go against the secondary
-			//    For a primary wait ((2 ^ primaryTries - 1) * delay * random(0.8, 1.2)
+			//    For a primary wait min((2 ^ primaryTries - 1) * delay, maxDelay) * random(0.5, 1.0)
 			//    If secondary gets a 404, don't fail, retry but future retries are only against the primary
-			//    When retrying against a secondary, ignore the retry count and wait (.1 second * random(0.8, 1.2))
+			//    When retrying against a secondary, ignore the retry count and wait (1 second * random(0.5, 1.0))
 			for try := int32(1); try <= o.MaxTries; try++ {
 				logf("\n=====> Try=%d\n", try)
 
 				// Determine which endpoint to try. It's primary if there is no secondary or if it is an add # attempt.
 				tryingPrimary := !considerSecondary || (try%2 == 1)
 				// Select the correct host and delay
+				var delay time.Duration
 				if tryingPrimary {
 					primaryTry++
-					delay := o.calcDelay(primaryTry)
+					delay = o.calcDelay(primaryTry) // The 1st try returns 0 delay
 					logf("Primary try=%d, Delay=%v\n", primaryTry, delay)
-					time.Sleep(delay) // The 1st try returns 0 delay
 				} else {
-					delay := time.Second * time.Duration(rand.Float32()/2+0.8)
+					delay = withJitter(time.Second) // Delay with some jitter before trying secondary
 					logf("Secondary try=%d, Delay=%v\n", try-primaryTry, delay)
-					time.Sleep(delay) // Delay with some jitter before trying secondary
 				}
+				// a throttled try isn't retried before the service said it could be
+				if delay < retryAfterDelay {
+					delay = retryAfterDelay
+					logf("Throttled, Delay=%v\n", delay)
+				}
+				retryAfterDelay = 0
+				time.Sleep(delay)
 
 				// Clone the original request to ensure that each try starts with the original (unmutated) request.
 				requestCopy := request.Copy()
@@ -237,12 +321,9 @@ func NewBFSXferRetryPolicyFactory(o XferRetryOptions) pipeline.Factory {
 
 					// TODO make sure Storage error can be cast to different package's error object
 					if stErr, ok := err.(azbfs.StorageError); ok {
-						// retry only in case of temporary storage errors.
-						if stErr.Temporary() {
-							action = "Retry: StorageError and Temporary()"
-						} else {
-							action = "NoRetry: expected storage error"
-						}
+						// retry only the storage errors whose status code is retryable or means throttling
+						action = o.storageErrorAction(stErr.Response(), stErr.Temporary())
+						retryAfterDelay = o.throttleDelay(stErr.Response())
 					} else if _, ok := err.(net.Error); ok {
 						action = "Retry: net.Error and Temporary() or Timeout()"
 					} else {
@@ -290,30 +371,38 @@ func NewBlobXferRetryPolicyFactory(o XferRetryOptions) pipeline.Factory {
 
 			// We only consider retrying against a secondary if we have a read request (GET/HEAD) AND this policy has a Secondary URL it can use
 			considerSecondary := (request.Method == http.MethodGet || request.Method == http.MethodHead) && o.retryReadsFromSecondaryHost() != ""
+			// retryAfterDelay is how long the service asked to wait when it throttled the previous try, see throttleDelay
+			retryAfterDelay := time.Duration(0)
 
-			// Exponential retry algorithm: ((2 ^ attempt) - 1) * delay * random(0.8, 1.2)
-			// When to retry: connection failure or temporary/timeout. NOTE: StorageError considers HTTP 500/503 as temporary & is therefore retryable
+			// Exponential retry algorithm: min(((2 ^ attempt) - 1) * delay, maxDelay) * random(0.5, 1.0)
+			// When to retry: connection failure, or a status code which StatusCodeRetryClasses/DefaultStatusCodeRetryClasses says is retryable or throttling
 			// If using a secondary:
 			//    Even tries go against primary; odd tries go against the secondary
-			//    For a primary wait ((2 ^ primaryTries - 1) * delay * random(0.8, 1.2)
+			//    For a primary wait min((2 ^ primaryTries - 1) * delay, maxDelay) * random(0.5, 1.0)
 			//    If secondary gets a 404, don't fail, retry but future retries are only against the primary
-			//    When retrying against a secondary, ignore the retry count and wait (.1 second * random(0.8, 1.2))
+			//    When retrying against a secondary, ignore the retry count and wait (1 second * random(0.5, 1.0))
 			for try := int32(1); try <= o.MaxTries; try++ {
 				logf("\n=====> Try=%d\n", try)
 
 				// Determine which endpoint to try. It's primary if there is no secondary or if it is an add # attempt.
 				tryingPrimary := !considerSecondary || (try%2 == 1)
 				// Select the correct host and delay
+				var delay time.Duration
 				if tryingPrimary {
 					primaryTry++
-					delay := o.calcDelay(primaryTry)
+					delay = o.calcDelay(primaryTry) // The 1st try returns 0 delay
 					logf("Primary try=%d, Delay=%v\n", primaryTry, delay)
-					time.Sleep(delay) // The 1st try returns 0 delay
 				} else {
-					delay := time.Second * time.Duration(rand.Float32()/2+0.8)
+					delay = withJitter(time.Second) // Delay with some jitter before trying secondary
 					logf("Secondary try=%d, Delay=%v\n", try-primaryTry, delay)
-					time.Sleep(delay) // Delay with some jitter before trying secondary
 				}
+				// a throttled try isn't retried before the service said it could be
+				if delay < retryAfterDelay {
+					delay = retryAfterDelay
+					logf("Throttled, Delay=%v\n", delay)
+				}
+				retryAfterDelay = 0
+				time.Sleep(delay)
 
 				// Clone the original request to ensure that each try starts with the original (unmutated) request.
 				requestCopy := request.Copy()
@@ -380,12 +469,9 @@ func NewBlobXferRetryPolicyFactory(o XferRetryOptions) pipeline.Factory {
 
 					// TODO make sure Storage error can be cast to different package's error object
 					if stErr, ok := err.(azblob.StorageError); ok {
-						// retry only in case of temporary storage errors.
-						if stErr.Temporary() {
-							action = "Retry: StorageError and Temporary()"
-						} else {
-							action = "NoRetry: expected storage error"
-						}
+						// retry only the storage errors whose status code is retryable or means throttling
+						action = o.storageErrorAction(stErr.Response(), stErr.Temporary())
+						retryAfterDelay = o.throttleDelay(stErr.Response())
 					} else if _, ok := err.(net.Error); ok {
 						action = "Retry: net.Error and Temporary() or Timeout()"
 					} else {
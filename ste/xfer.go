@@ -49,12 +49,15 @@ const PacerTimeToWaitInMs = 50
 // These types are define the STE Coordinator
 type newJobXfer func(jptm IJobPartTransferMgr, pipeline pipeline.Pipeline, pacer *pacer)
 
-// the xfer factory is generated based on the type of source and destination
-func computeJobXfer(fromTo common.FromTo) newJobXfer {
+// the xfer factory is generated based on the type of source and destination, and the type of the blobs created by an upload
+func computeJobXfer(fromTo common.FromTo, blobType common.BlobType) newJobXfer {
 	switch fromTo {
 	case common.EFromTo.BlobLocal(): // download from Azure Blob to local file system
 		return BlobToLocal
 	case common.EFromTo.LocalBlob(): // upload from local file system to Azure blob
+		if blobType == common.EBlobType.AppendBlob() {
+			return LocalToAppendBlob
+		}
 		return LocalToBlockBlob
 	case common.EFromTo.BlobTrash():
 		return DeleteBlobPrologue
@@ -0,0 +1,209 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package ste
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+	chk "gopkg.in/check.v1"
+)
+
+type appendBlobTestSuite struct{}
+
+var _ = chk.Suite(&appendBlobTestSuite{})
+
+// fakeAppendBlobService keeps an append blob in memory, and answers the requests an appendBlobXfer sends for it
+type fakeAppendBlobService struct {
+	blob            []byte
+	appendPositions []int64
+	// appendsToLose is the number of the next appends which succeed without their response making it back
+	appendsToLose int
+}
+
+func (s *fakeAppendBlobService) sender() pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			status, header, body := s.serve(request)
+			return pipeline.NewHTTPResponse(&http.Response{StatusCode: status, Header: header, Request: request.Request,
+				Body: ioutil.NopCloser(strings.NewReader(body))}), nil
+		}
+	})
+}
+
+func (s *fakeAppendBlobService) serve(request pipeline.Request) (int, http.Header, string) {
+	header := http.Header{}
+	switch {
+	case request.Method == http.MethodHead:
+		header.Set("Content-Length", strconv.Itoa(len(s.blob)))
+		return http.StatusOK, header, ""
+	case request.Method == http.MethodDelete:
+		s.blob = nil
+		return http.StatusAccepted, header, ""
+	case request.URL.Query().Get("comp") == "appendblock":
+		position, _ := strconv.ParseInt(request.Header.Get("x-ms-blob-condition-appendpos"), 10, 64)
+		s.appendPositions = append(s.appendPositions, position)
+		if position != int64(len(s.blob)) {
+			header.Set("x-ms-error-code", "AppendPositionConditionNotMet")
+			return http.StatusPreconditionFailed, header,
+				"<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>AppendPositionConditionNotMet</Code><Message>The append position condition specified was not met.</Message></Error>"
+		}
+		block, _ := ioutil.ReadAll(request.Body)
+		s.blob = append(s.blob, block...)
+		if s.appendsToLose > 0 {
+			s.appendsToLose--
+			header.Set("x-ms-error-code", "InternalError")
+			return http.StatusInternalServerError, header,
+				"<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>InternalError</Code><Message>The response got lost.</Message></Error>"
+		}
+		return http.StatusCreated, header, ""
+	default:
+		// creates the blob
+		s.blob = []byte{}
+		return http.StatusCreated, header, ""
+	}
+}
+
+// appendBlobTransferMgr runs the chunks it's given to schedule one at a time, in the order they were scheduled
+type appendBlobTransferMgr struct {
+	IJobPartTransferMgr
+	status         common.TransferStatus
+	canceled       bool
+	numChunks      uint32
+	chunksDone     uint32
+	scheduled      []chunkFunc
+	maxScheduled   int
+	transferIsDone bool
+}
+
+func (t *appendBlobTransferMgr) Info() TransferInfo {
+	return TransferInfo{Source: "src", Destination: "https://account.blob.core.windows.net/container/blob"}
+}
+func (t *appendBlobTransferMgr) Context() context.Context                                   { return context.Background() }
+func (t *appendBlobTransferMgr) ShouldLog(level pipeline.LogLevel) bool                     { return false }
+func (t *appendBlobTransferMgr) Log(level pipeline.LogLevel, msg string)                    {}
+func (t *appendBlobTransferMgr) LogUploadError(source, destination, msg string, status int) {}
+func (t *appendBlobTransferMgr) LogError(resource, context string, err error)               {}
+func (t *appendBlobTransferMgr) OccupyAConnection()                                         {}
+func (t *appendBlobTransferMgr) ReleaseAConnection()                                        {}
+func (t *appendBlobTransferMgr) AddToBytesDone(value int64) int64                           { return 0 }
+func (t *appendBlobTransferMgr) SetNumberOfChunks(numChunks uint32)                         { t.numChunks = numChunks }
+func (t *appendBlobTransferMgr) SetStatus(status common.TransferStatus)                     { t.status = status }
+func (t *appendBlobTransferMgr) SetErrorStatus(err error)                                   { t.status = common.ETransferStatus.Failed() }
+func (t *appendBlobTransferMgr) TransferStatus() common.TransferStatus                      { return t.status }
+func (t *appendBlobTransferMgr) Cancel()                                                    { t.canceled = true }
+func (t *appendBlobTransferMgr) WasCanceled() bool                                          { return t.canceled }
+func (t *appendBlobTransferMgr) ReportTransferDone() uint32                                 { t.transferIsDone = true; return 1 }
+func (t *appendBlobTransferMgr) ReportChunkDone(workerID int) (bool, uint32) {
+	t.chunksDone++
+	return t.chunksDone == t.numChunks, t.chunksDone
+}
+func (t *appendBlobTransferMgr) ScheduleChunks(chunkFunc chunkFunc) {
+	t.scheduled = append(t.scheduled, chunkFunc)
+	if len(t.scheduled) > t.maxScheduled {
+		t.maxScheduled = len(t.scheduled)
+	}
+}
+
+// runChunks runs the scheduled chunks until there are none left
+func (t *appendBlobTransferMgr) runChunks() {
+	for len(t.scheduled) > 0 {
+		chunk := t.scheduled[0]
+		t.scheduled = t.scheduled[1:]
+		chunk(0)
+	}
+}
+
+func newTestAppendBlobXfer(jptm IJobPartTransferMgr, service *fakeAppendBlobService, source []byte, chunkSize int64) *appendBlobXfer {
+	u, _ := url.Parse(jptm.Info().Destination)
+	p := pipeline.NewPipeline([]pipeline.Factory{pipeline.MethodFactoryMarker()}, pipeline.Options{HTTPSender: service.sender()})
+	return &appendBlobXfer{
+		jptm:      jptm,
+		blobURL:   azblob.NewAppendBlobURL(*u, p),
+		pacer:     &pacer{},
+		blobSize:  int64(len(source)),
+		chunkSize: chunkSize,
+		chunkBytes: func(startIndex int64, chunkSize int64) ([]byte, error) {
+			return source[startIndex : startIndex+chunkSize], nil
+		},
+	}
+}
+
+func (s *appendBlobTestSuite) TestAppendBlobChunkSize(c *chk.C) {
+	c.Assert(appendBlobChunkSize(0), chk.Equals, int64(common.DefaultAppendBlobBlockSize))
+	c.Assert(appendBlobChunkSize(1024*1024), chk.Equals, int64(1024*1024))
+	c.Assert(appendBlobChunkSize(uint32(common.DefaultAppendBlobBlockSize)), chk.Equals, int64(common.DefaultAppendBlobBlockSize))
+	// the blocks of an append blob can't be larger than 4 MB
+	c.Assert(appendBlobChunkSize(8*1024*1024), chk.Equals, int64(common.DefaultAppendBlobBlockSize))
+}
+
+func (s *appendBlobTestSuite) TestBlocksAreAppendedInOrder(c *chk.C) {
+	jptm := &appendBlobTransferMgr{}
+	service := &fakeAppendBlobService{}
+	source := []byte("0123456789")
+	abx := newTestAppendBlobXfer(jptm, service, source, 4)
+
+	abx.start(azblob.BlobHTTPHeaders{}, azblob.Metadata{})
+	jptm.runChunks()
+
+	// each block is only scheduled once the previous one was appended, right where it ended
+	c.Assert(jptm.maxScheduled, chk.Equals, 1)
+	c.Assert(service.appendPositions, chk.DeepEquals, []int64{0, 4, 8})
+	c.Assert(service.blob, chk.DeepEquals, source)
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.Success())
+	c.Assert(jptm.transferIsDone, chk.Equals, true)
+}
+
+func (s *appendBlobTestSuite) TestAppendWhoseResponseGotLostCountsAsSuccess(c *chk.C) {
+	jptm := &appendBlobTransferMgr{}
+	source := []byte("0123456789")
+	// the first append of the block reaches the blob but fails, so the retry doesn't meet the append position condition
+	service := &fakeAppendBlobService{blob: []byte("0123"), appendsToLose: 1}
+	abx := newTestAppendBlobXfer(jptm, service, source, 4)
+	c.Assert(abx.appendBlock(4, 4), chk.NotNil)
+	c.Assert(service.blob, chk.DeepEquals, []byte("01234567"))
+
+	// the blob already ends with the block
+	c.Assert(abx.appendBlock(4, 4), chk.IsNil)
+	c.Assert(service.blob, chk.DeepEquals, []byte("01234567"))
+	c.Assert(service.appendPositions, chk.DeepEquals, []int64{4, 4})
+}
+
+func (s *appendBlobTestSuite) TestAppendPositionConditionNotMetFailsOtherwise(c *chk.C) {
+	jptm := &appendBlobTransferMgr{}
+	source := []byte("0123456789")
+	// something else was appended to the blob
+	service := &fakeAppendBlobService{blob: []byte("0123ab")}
+	abx := newTestAppendBlobXfer(jptm, service, source, 4)
+
+	err := abx.appendBlock(4, 4)
+	c.Assert(err, chk.NotNil)
+	stErr, ok := err.(azblob.StorageError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(stErr.ServiceCode(), chk.Equals, azblob.ServiceCodeAppendPositionConditionNotMet)
+	c.Assert(service.blob, chk.DeepEquals, []byte("0123ab"))
+}
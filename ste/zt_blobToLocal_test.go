@@ -0,0 +1,161 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package ste
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+	chk "gopkg.in/check.v1"
+)
+
+type blobToLocalTestSuite struct{}
+
+var _ = chk.Suite(&blobToLocalTestSuite{})
+
+func (s *blobToLocalTestSuite) TestFirstRangeSize(c *chk.C) {
+	const mb = 1024 * 1024
+	// the first range is the whole source when it's small
+	c.Assert(firstRangeSize(100, 8*mb), chk.Equals, int64(100))
+	// it's no larger than the first chunk
+	c.Assert(firstRangeSize(10*mb, 2*mb), chk.Equals, int64(2*mb))
+	// nor than the ranges whose MD5 the services return
+	c.Assert(firstRangeSize(100*mb, 8*mb), chk.Equals, int64(maxRangeGetContentMD5Bytes))
+}
+
+func (s *blobToLocalTestSuite) TestChunkDownloadRanges(c *chk.C) {
+	// the populated page ranges of a sparse source of 4 chunks of 1024 bytes: the second chunk is empty,
+	// a page range spans the end of the third chunk and the start of the fourth one, and the first chunk is fully populated
+	pageRanges := []azblob.PageRange{{Start: 0, End: 1023}, {Start: 2048, End: 2559}, {Start: 2560, End: 3583}}
+	testCases := []struct {
+		name       string
+		sparse     bool
+		startIndex int64
+		expected   []azblob.PageRange
+	}{
+		{"a fully populated chunk", true, 0, []azblob.PageRange{{Start: 0, End: 1023}}},
+		{"an empty chunk", true, 1024, []azblob.PageRange{}},
+		{"the chunk where a range starts", true, 2048, []azblob.PageRange{{Start: 2048, End: 2559}, {Start: 2560, End: 3071}}},
+		{"the chunk where a range ends", true, 3072, []azblob.PageRange{{Start: 3072, End: 3583}}},
+		{"a chunk past the page ranges", true, 4096, []azblob.PageRange{}},
+		{"a chunk of a source which isn't sparse", false, 1024, []azblob.PageRange{{Start: 1024, End: 2047}}},
+	}
+
+	for _, testCase := range testCases {
+		c.Assert(chunkDownloadRanges(pageRanges, testCase.sparse, testCase.startIndex, 1024), chk.DeepEquals,
+			testCase.expected, chk.Commentf("%s", testCase.name))
+	}
+}
+
+// newTestDownload returns the transfer manager of the download of a source of 10 bytes, in chunks of 4 bytes,
+// to a destination in a folder of its own, along with the function which deletes the plan and that folder
+func newTestDownload(c *chk.C) (*plannedTransferMgr, func()) {
+	dir, err := ioutil.TempDir("", "azcopy-download")
+	c.Assert(err, chk.IsNil)
+	jptms, cleanup := newPlannedTransferMgrs(c, 4, common.CopyTransfer{Source: "https://account.blob.core.windows.net/container/blob",
+		Destination: filepath.Join(dir, "blob"), SourceSize: 10})
+	return jptms[0], func() {
+		cleanup()
+		os.RemoveAll(dir)
+	}
+}
+
+func (s *blobToLocalTestSuite) TestDownloadCanResume(c *chk.C) {
+	jptm, cleanup := newTestDownload(c)
+	defer cleanup()
+	destination := jptm.Info().Destination
+	jptm.SetSourceETag("etag")
+	c.Assert(ioutil.WriteFile(destination, make([]byte, 10), 0644), chk.IsNil)
+	// the previous attempt didn't complete any chunk
+	c.Assert(downloadCanResume(jptm, "etag"), chk.Equals, false)
+
+	jptm.SetChunkCompleted(1)
+	c.Assert(downloadCanResume(jptm, "etag"), chk.Equals, true)
+	// the source changed since the previous attempt, or its ETag is unknown
+	c.Assert(downloadCanResume(jptm, "other etag"), chk.Equals, false)
+	c.Assert(downloadCanResume(jptm, ""), chk.Equals, false)
+
+	// the partially downloaded file was truncated, or deleted
+	c.Assert(ioutil.WriteFile(destination, make([]byte, 4), 0644), chk.IsNil)
+	c.Assert(downloadCanResume(jptm, "etag"), chk.Equals, false)
+	c.Assert(os.Remove(destination), chk.IsNil)
+	c.Assert(downloadCanResume(jptm, "etag"), chk.Equals, false)
+}
+
+func (s *blobToLocalTestSuite) TestOpenDownloadDestinationToResume(c *chk.C) {
+	jptm, cleanup := newTestDownload(c)
+	defer cleanup()
+	destination := jptm.Info().Destination
+	jptm.SetSourceETag("etag")
+	jptm.SetChunkCompleted(1)
+	c.Assert(ioutil.WriteFile(destination, []byte("\x00\x00\x00\x004567\x00\x00"), 0644), chk.IsNil)
+
+	// the chunks downloaded by the previous attempt are kept, along with their record
+	f, err := openDownloadDestination(jptm, true, "etag", false)
+	c.Assert(err, chk.IsNil)
+	c.Assert(f.Close(), chk.IsNil)
+	data, err := ioutil.ReadFile(destination)
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(data), chk.Equals, "\x00\x00\x00\x004567\x00\x00")
+	c.Assert(jptm.ChunkCompleted(1), chk.Equals, true)
+	c.Assert(jptm.SourceETag(), chk.Equals, "etag")
+}
+
+func (s *blobToLocalTestSuite) TestOpenDownloadDestinationToStartOver(c *chk.C) {
+	jptm, cleanup := newTestDownload(c)
+	defer cleanup()
+	destination := jptm.Info().Destination
+	jptm.SetSourceETag("etag")
+	jptm.SetChunkCompleted(1)
+	c.Assert(ioutil.WriteFile(destination, []byte("a previous version of the source"), 0644), chk.IsNil)
+
+	// the destination is created again with the size of the source, and the ETag of the source is recorded instead of the chunks
+	f, err := openDownloadDestination(jptm, false, "new etag", false)
+	c.Assert(err, chk.IsNil)
+	c.Assert(f.Close(), chk.IsNil)
+	data, err := ioutil.ReadFile(destination)
+	c.Assert(err, chk.IsNil)
+	c.Assert(data, chk.DeepEquals, make([]byte, 10))
+	c.Assert(jptm.HasCompletedChunks(), chk.Equals, false)
+	c.Assert(jptm.SourceETag(), chk.Equals, "new etag")
+}
+
+func (s *blobToLocalTestSuite) TestCreateFileOfSize(c *chk.C) {
+	dir, err := ioutil.TempDir("", "azcopy-download")
+	c.Assert(err, chk.IsNil)
+	defer os.RemoveAll(dir)
+	destination := filepath.Join(dir, "folder", "blob")
+
+	for _, sparse := range []bool{false, true} {
+		f, err := createFileOfSize(destination, 1024, sparse)
+		c.Assert(err, chk.IsNil)
+		c.Assert(f.Close(), chk.IsNil)
+		fileInfo, err := os.Stat(destination)
+		c.Assert(err, chk.IsNil)
+		c.Assert(fileInfo.Size(), chk.Equals, int64(1024))
+	}
+
+	// the file can't be given its size
+	_, err = createFileOfSize(destination, -1, false)
+	c.Assert(err, chk.NotNil)
+}
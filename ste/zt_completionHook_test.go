@@ -0,0 +1,95 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type completionHookSuite struct{}
+
+var _ = chk.Suite(&completionHookSuite{})
+
+func (s *completionHookSuite) TestPostCompletionHook(c *chk.C) {
+	status := http.StatusOK
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, chk.Equals, http.MethodPost)
+		c.Check(r.Header.Get("Content-Type"), chk.Equals, "application/json")
+		received, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	retryable, err := postCompletionHook(context.Background(), server.URL, []byte(`{"JobStatus":"Completed"}`))
+	c.Assert(err, chk.IsNil)
+	c.Assert(retryable, chk.Equals, false)
+	c.Assert(string(received), chk.Equals, `{"JobStatus":"Completed"}`)
+
+	// the hook doesn't want the payload, asking again won't change that
+	status = http.StatusBadRequest
+	retryable, err = postCompletionHook(context.Background(), server.URL, nil)
+	c.Assert(err, chk.NotNil)
+	c.Assert(retryable, chk.Equals, false)
+
+	for _, status = range []int{http.StatusTooManyRequests, http.StatusRequestTimeout, http.StatusServiceUnavailable} {
+		retryable, err = postCompletionHook(context.Background(), server.URL, nil)
+		c.Assert(err, chk.NotNil)
+		c.Assert(retryable, chk.Equals, true)
+	}
+}
+
+// hookLogger is the logger of a job whose completion hooks are delivered, it keeps what they log
+type hookLogger struct {
+	common.ILoggerResetable
+	logs []string
+}
+
+func (l *hookLogger) ShouldLog(level pipeline.LogLevel) bool  { return true }
+func (l *hookLogger) Log(level pipeline.LogLevel, msg string) { l.logs = append(l.logs, msg) }
+
+func (s *completionHookSuite) TestDeliverCompletionHookStopsAtTheDeadline(c *chk.C) {
+	logger := &hookLogger{}
+	jm := &jobMgr{logger: logger}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	tries := 0
+	start := time.Now()
+	jm.deliverCompletionHook(ctx, "test", func(ctx context.Context) (bool, error) {
+		tries++
+		return true, errors.New("connection refused")
+	})
+
+	// the hook isn't retried once the deadline shared by the hooks has passed, though it had tries left
+	c.Assert(tries, chk.Equals, 1)
+	c.Assert(time.Since(start) < completionHookRetryDelay, chk.Equals, true)
+	c.Assert(logger.logs, chk.HasLen, 2)
+}
@@ -0,0 +1,101 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package ste
+
+import (
+	"time"
+
+	chk "gopkg.in/check.v1"
+)
+
+type concurrencyTunerTestSuite struct{}
+
+var _ = chk.Suite(&concurrencyTunerTestSuite{})
+
+// newTunerTestJobsAdmin builds just enough of a jobsAdmin for the auto-tuner to resize its pool, which starts at the initial level
+func newTunerTestJobsAdmin() *jobsAdmin {
+	suicideCh := make(chan SuicideJob, concurrencyTunerMaxWorkers)
+	ja := &jobsAdmin{
+		logger:              discardingLogger{},
+		pacer:               &pacer{},
+		coordinatorChannels: CoordinatorChannels{suicideCh: suicideCh},
+		xferChannels:        XferChannels{suicideCh: suicideCh},
+	}
+	ja.setWorkerCount(concurrencyTunerInitialWorkers)
+	return ja
+}
+
+// tuneAfter makes the pacer of the auto-tuner count the given traffic over one interval, then tunes the pool
+func tuneAfter(ct *concurrencyTuner, bytes, requests, throttled int64) {
+	p := ct.ja.pacer
+	p.bytesTransferred += bytes
+	p.requestCount += requests
+	p.throttledCount += throttled
+	p.latencyInNs += requests * int64(20*time.Millisecond)
+	ct.lastTimestamp = time.Now().Add(-concurrencyTunerInterval)
+	ct.tune()
+}
+
+func (s *concurrencyTunerTestSuite) TestClimbsWhileThroughputImproves(c *chk.C) {
+	ja := newTunerTestJobsAdmin()
+	ct := newConcurrencyTuner(ja)
+
+	tuneAfter(ct, 100*1024*1024, 1000, 0)
+	c.Assert(ja.workerCount(), chk.Equals, 48)
+	tuneAfter(ct, 200*1024*1024, 2000, 0)
+	c.Assert(ja.workerCount(), chk.Equals, 72)
+
+	// more workers didn't bring more throughput, back to the best level
+	tuneAfter(ct, 200*1024*1024, 2000, 0)
+	c.Assert(ja.workerCount(), chk.Equals, 48)
+	c.Assert(ct.settled, chk.Equals, true)
+}
+
+func (s *concurrencyTunerTestSuite) TestBacksOffWhenThrottled(c *chk.C) {
+	ja := newTunerTestJobsAdmin()
+	ct := newConcurrencyTuner(ja)
+
+	tuneAfter(ct, 100*1024*1024, 1000, 50)
+	c.Assert(ja.workerCount(), chk.Equals, 24)
+	c.Assert(ct.ceiling, chk.Equals, 24)
+}
+
+func (s *concurrencyTunerTestSuite) TestRequestRateIsNotComparedWithByteRate(c *chk.C) {
+	ja := newTunerTestJobsAdmin()
+	ct := newConcurrencyTuner(ja)
+
+	tuneAfter(ct, 100*1024*1024, 1000, 0)
+	c.Assert(ja.workerCount(), chk.Equals, 48)
+
+	// service side copies only count requests, whose rate is far below the byte rate of the first interval
+	tuneAfter(ct, 0, 1000, 0)
+	c.Assert(ct.throughputInRequests, chk.Equals, true)
+	c.Assert(ct.bestThroughput < 1000, chk.Equals, true)
+	c.Assert(ja.workerCount(), chk.Equals, 72)
+	tuneAfter(ct, 0, 2000, 0)
+	c.Assert(ja.workerCount(), chk.Equals, 108)
+
+	// and the other way around
+	tuneAfter(ct, 1024*1024, 10, 0)
+	c.Assert(ct.throughputInRequests, chk.Equals, false)
+	c.Assert(ja.workerCount(), chk.Equals, 162)
+	tuneAfter(ct, 512*1024, 10, 0)
+	c.Assert(ja.workerCount(), chk.Equals, 108)
+}
@@ -0,0 +1,82 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+// Hookup to the testing framework
+func Test(t *testing.T) { chk.TestingT(t) }
+
+type fairSchedulerTestSuite struct{}
+
+var _ = chk.Suite(&fairSchedulerTestSuite{})
+
+func (s *fairSchedulerTestSuite) TestWeightedShare(c *chk.C) {
+	fs := newFairScheduler()
+	normalJob, lowJob := common.NewJobID(), common.NewJobID()
+
+	ran := map[common.JobID]int{}
+	for i := 0; i < 100; i++ {
+		fs.enqueueChunk(lowJob, lowPriorityWeight, func(int) { ran[lowJob]++ })
+		fs.enqueueChunk(normalJob, normalPriorityWeight, func(int) { ran[normalJob]++ })
+	}
+
+	// over 50 turns, the jobs should get 4 turns for the Normal job for every turn of the Low job
+	for i := 0; i < 50; i++ {
+		chunkFunc, jptm := fs.dequeue()
+		c.Assert(chunkFunc, chk.NotNil)
+		c.Assert(jptm, chk.IsNil)
+		chunkFunc(0)
+	}
+	c.Assert(ran[normalJob], chk.Equals, 40)
+	c.Assert(ran[lowJob], chk.Equals, 10)
+}
+
+func (s *fairSchedulerTestSuite) TestChunksBeforeTransfers(c *chk.C) {
+	fs := newFairScheduler()
+	jobID := common.NewJobID()
+
+	fs.enqueueTransfer(jobID, normalPriorityWeight, &jobPartTransferMgr{})
+	ranChunk := false
+	fs.enqueueChunk(jobID, normalPriorityWeight, func(int) { ranChunk = true })
+
+	chunkFunc, jptm := fs.dequeue()
+	c.Assert(chunkFunc, chk.NotNil)
+	c.Assert(jptm, chk.IsNil)
+	chunkFunc(0)
+	c.Assert(ranChunk, chk.Equals, true)
+
+	chunkFunc, jptm = fs.dequeue()
+	c.Assert(chunkFunc, chk.IsNil)
+	c.Assert(jptm, chk.NotNil)
+
+	// the drained job leaves the scheduler
+	chunkFunc, jptm = fs.dequeue()
+	c.Assert(chunkFunc, chk.IsNil)
+	c.Assert(jptm, chk.IsNil)
+	c.Assert(fs.active, chk.HasLen, 0)
+	c.Assert(fs.queues, chk.HasLen, 0)
+}
@@ -0,0 +1,77 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type jobHistorySuite struct{}
+
+var _ = chk.Suite(&jobHistorySuite{})
+
+func (s *jobHistorySuite) TestJobHistory(c *chk.C) {
+	ja := newCleanupTestJobsAdmin(c)
+	defer os.RemoveAll(ja.planDir)
+
+	// the golden plan of the current version, and the one of version 0 as the plan of another job
+	jpfn, plan := readGoldenJobPartPlan(c, DataSchemaVersion)
+	c.Assert(ioutil.WriteFile(filepath.Join(ja.planDir, string(jpfn)), plan, 0644), chk.IsNil)
+	_, plan = readGoldenJobPartPlan(c, 0)
+	oldJobID := common.NewJobID()
+	c.Assert(ioutil.WriteFile(filepath.Join(ja.planDir, oldJobID.String()+"--00000.steV0"), plan, 0644), chk.IsNil)
+	// the part of a job whose part 0 is missing
+	partialJobID := common.NewJobID()
+	c.Assert(ioutil.WriteFile(filepath.Join(ja.planDir, string(ja.NewJobPartPlanFileName(partialJobID, 1))), plan[:100], 0644), chk.IsNil)
+
+	details := map[common.JobID]common.JobDetail{}
+	for _, d := range ja.JobHistory() {
+		details[d.JobID] = d
+	}
+	c.Assert(details, chk.HasLen, 3)
+	order := goldenJobPartOrder(c)
+
+	d := details[order.JobID]
+	c.Assert(d.ErrorMsg, chk.Equals, "")
+	c.Assert(d.StartTime.Equal(time.Unix(0, 1535767200000000000)), chk.Equals, true)
+	c.Assert(d.CommandString, chk.Equals, order.CommandString)
+	c.Assert(d.CompleteJobOrdered, chk.Equals, true)
+	c.Assert(d.JobStatus, chk.Equals, common.EJobStatus.Paused())
+	c.Assert(d.TotalTransfers, chk.Equals, uint32(2))
+	c.Assert(d.TransfersCompleted, chk.Equals, uint32(1))
+	c.Assert(d.TransfersFailed, chk.Equals, uint32(1))
+	c.Assert(d.TotalBytesEnumerated, chk.Equals, uint64(order.Transfers[0].SourceSize+order.Transfers[1].SourceSize))
+	c.Assert(d.TotalBytesTransferred, chk.Equals, uint64(order.Transfers[0].SourceSize))
+
+	// version 0 didn't record the start time of the job
+	d = details[oldJobID]
+	c.Assert(d.ErrorMsg, chk.Equals, "")
+	c.Assert(d.StartTime.IsZero(), chk.Equals, true)
+	c.Assert(d.TotalTransfers, chk.Equals, uint32(2))
+
+	c.Assert(details[partialJobID].ErrorMsg, chk.Not(chk.Equals), "")
+}
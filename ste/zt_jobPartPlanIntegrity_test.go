@@ -0,0 +1,143 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type jobPartPlanIntegritySuite struct{}
+
+var _ = chk.Suite(&jobPartPlanIntegritySuite{})
+
+// goldenTransferOffset returns the offset of the given transfer of the golden plan of the current version
+func goldenTransferOffset(c *chk.C, transfer int) int64 {
+	return int64(unsafe.Sizeof(JobPartPlanHeader{})) + int64(len(goldenJobPartOrder(c).CommandString)) +
+		int64(transfer)*int64(unsafe.Sizeof(JobPartPlanTransfer{}))
+}
+
+func (s *jobPartPlanIntegritySuite) TestVerifyJobPartPlan(c *chk.C) {
+	_, golden := readGoldenJobPartPlan(c, DataSchemaVersion)
+	corrupt, err := verifyJobPartPlan(golden)
+	c.Assert(err, chk.IsNil)
+	c.Assert(corrupt, chk.HasLen, 0)
+
+	var h JobPartPlanHeader
+	var t JobPartPlanTransfer
+	corruptAt := func(offset int64) []byte {
+		plan := append([]byte(nil), golden...)
+		plan[offset] ^= 0xff
+		return plan
+	}
+
+	// a change to a constant field of the header, or to the command string, corrupts the whole plan
+	for _, offset := range []int64{int64(unsafe.Offsetof(h.TTLAfterCompletion)), int64(unsafe.Sizeof(h)) + 10} {
+		_, err = verifyJobPartPlan(corruptAt(offset))
+		c.Assert(err, chk.NotNil, chk.Commentf("offset %d", offset))
+	}
+
+	// a change to a constant field of a transfer, or to its strings, corrupts only that transfer
+	second := goldenTransferOffset(c, 1)
+	for _, offset := range []int64{second + int64(unsafe.Offsetof(t.SourceSize)), int64(binary.LittleEndian.Uint64(golden[second:]))} {
+		corrupt, err = verifyJobPartPlan(corruptAt(offset))
+		c.Assert(err, chk.IsNil)
+		c.Assert(corrupt, chk.DeepEquals, []uint32{1}, chk.Commentf("offset %d", offset))
+	}
+
+	// the fields which change as the job part is processed aren't checksummed
+	plan := append([]byte(nil), golden...)
+	binary.LittleEndian.PutUint32(plan[unsafe.Offsetof(h.atomicJobStatus):], uint32(common.EJobStatus.Completed()))
+	binary.LittleEndian.PutUint32(plan[second+int64(unsafe.Offsetof(t.atomicTransferStatus)):], uint32(common.ETransferStatus.Success()))
+	c.Assert(checkJobPartPlan(plan), chk.IsNil)
+	// but their lengths are still checked
+	plan[second+int64(unsafe.Offsetof(t.srcETagLength))] = ETagMaxBytes + 1
+	c.Assert(checkJobPartPlan(plan), chk.ErrorMatches, "1 of the transfers are corrupt: 1")
+}
+
+func (s *jobPartPlanIntegritySuite) TestReadIntactJobPartPlan(c *chk.C) {
+	_, plan := readGoldenJobPartPlan(c, DataSchemaVersion)
+	var t JobPartPlanTransfer
+	plan[goldenTransferOffset(c, 0)+int64(unsafe.Offsetof(t.SourceSize))] ^= 0xff
+
+	_, err := readJobPartPlanV1(plan)
+	c.Assert(err, chk.NotNil)
+
+	contents, corrupt, err := readIntactJobPartPlan(plan)
+	c.Assert(err, chk.IsNil)
+	c.Assert(corrupt, chk.DeepEquals, []uint32{0})
+	c.Assert(contents.order.Transfers, chk.DeepEquals, goldenJobPartOrderV1(c).Transfers[1:])
+	c.Assert(contents.transferStatuses, chk.DeepEquals, []common.TransferStatus{common.ETransferStatus.Failed()})
+}
+
+func (s *jobPartPlanIntegritySuite) TestVerifyJobPartsRepair(c *chk.C) {
+	ja := newCleanupTestJobsAdmin(c)
+	defer os.RemoveAll(ja.planDir)
+	// the rebuilt plan is written through the plan folder of the jobs admin
+	previous := JobsAdmin
+	JobsAdmin = ja
+	defer func() { JobsAdmin = previous }()
+
+	jobID, err := common.ParseJobID(goldenJobID)
+	c.Assert(err, chk.IsNil)
+	_, plan := readGoldenJobPartPlan(c, DataSchemaVersion)
+	var t JobPartPlanTransfer
+	plan[goldenTransferOffset(c, 0)+int64(unsafe.Offsetof(t.SourceSize))] ^= 0xff
+	path := filepath.Join(ja.planDir, string(ja.NewJobPartPlanFileName(jobID, 0)))
+	c.Assert(ioutil.WriteFile(path, plan, 0644), chk.IsNil)
+	c.Assert(checkJobPlanFiles(ja.planDir, jobID), chk.NotNil)
+
+	// without repair, the corrupt transfers are only reported
+	resp := ja.VerifyJobParts(common.VerifyJobRequest{JobID: jobID})
+	c.Assert(resp.ErrorMsg, chk.Equals, "")
+	c.Assert(resp.Parts, chk.HasLen, 1)
+	c.Assert(resp.Parts[0].NumTransfers, chk.Equals, uint32(2))
+	c.Assert(resp.Parts[0].CorruptTransfers, chk.DeepEquals, []uint32{0})
+	// only the size of the transfer is corrupt, its source can still be read
+	c.Assert(resp.Parts[0].CorruptTransferSources, chk.DeepEquals, []string{goldenJobPartOrderV1(c).Transfers[0].Source})
+	c.Assert(resp.Parts[0].Repaired, chk.Equals, false)
+	// nor is the plan rebuilt
+	unrepaired, err := ioutil.ReadFile(path)
+	c.Assert(err, chk.IsNil)
+	c.Assert(unrepaired, chk.DeepEquals, plan)
+
+	resp = ja.VerifyJobParts(common.VerifyJobRequest{JobID: jobID, Repair: true})
+	c.Assert(resp.Parts, chk.HasLen, 1)
+	c.Assert(resp.Parts[0].ErrorMsg, chk.Equals, "")
+	c.Assert(resp.Parts[0].Repaired, chk.Equals, true)
+
+	// the rebuilt plan holds the intact transfer only, and is the only plan in the folder
+	c.Assert(checkJobPlanFiles(ja.planDir, jobID), chk.IsNil)
+	rebuilt, err := ioutil.ReadFile(path)
+	c.Assert(err, chk.IsNil)
+	contents, err := readJobPartPlanV1(rebuilt)
+	c.Assert(err, chk.IsNil)
+	c.Assert(contents.order.Transfers, chk.DeepEquals, goldenJobPartOrderV1(c).Transfers[1:])
+	files, err := ioutil.ReadDir(ja.planDir)
+	c.Assert(err, chk.IsNil)
+	c.Assert(files, chk.HasLen, 1)
+}
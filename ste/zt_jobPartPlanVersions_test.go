@@ -0,0 +1,218 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type jobPartPlanVersionsSuite struct{}
+
+var _ = chk.Suite(&jobPartPlanVersionsSuite{})
+
+// The golden plan files in testdata hold the same job part, written by each data schema version.
+// When the layout of the plan changes, the golden file of the current version stops being readable:
+// DataSchemaVersion must then be incremented, as explained by jobPartPlanReaders.
+const goldenJobID = "1b0f4d6e-3b7a-4c1e-9a52-6f0c2d8e7a13"
+
+func goldenJobPartOrder(c *chk.C) common.CopyJobPartOrderRequest {
+	jobID, err := common.ParseJobID(goldenJobID)
+	c.Assert(err, chk.IsNil)
+	return common.CopyJobPartOrderRequest{
+		JobID:         jobID,
+		PartNum:       0,
+		IsFinalPart:   true,
+		Priority:      common.EJobPriority.Normal(),
+		FromTo:        common.EFromTo.BlobBlob(),
+		LogLevel:      common.ELogLevel.Info(),
+		CommandString: "copy https://src.blob.core.windows.net/c https://dst.blob.core.windows.net/c --recursive",
+		BlobAttributes: common.BlobTransferAttributes{
+			BlobType:         common.EBlobType.Detect(),
+			BlockBlobTier:    common.EBlockBlobTier.Hot(),
+			BlockSizeInBytes: 4 * 1024 * 1024,
+		},
+		Transfers: []common.CopyTransfer{
+			{
+				Source:             "https://src.blob.core.windows.net/c/a.txt",
+				Destination:        "https://dst.blob.core.windows.net/c/a.txt",
+				LastModifiedTime:   time.Unix(0, 1535760000000000000),
+				SourceSize:         1024,
+				ContentType:        "text/plain",
+				ContentEncoding:    "gzip",
+				ContentLanguage:    "en-US",
+				ContentDisposition: "inline",
+				CacheControl:       "no-cache",
+				ContentMD5:         []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+				Metadata:           common.Metadata{"author": "jdoe", "project": "azcopy"},
+			},
+			{
+				Source:           "https://src.blob.core.windows.net/c/dir/b.bin",
+				Destination:      "https://dst.blob.core.windows.net/c/dir/b.bin",
+				LastModifiedTime: time.Unix(0, 1535763600000000000),
+				SourceSize:       10 * 1024 * 1024,
+			},
+		},
+	}
+}
+
+func readGoldenJobPartPlan(c *chk.C, version common.Version) (JobPartPlanFileName, []byte) {
+	matches, err := filepath.Glob(filepath.Join("testdata", goldenJobID+"--*.steV*"))
+	c.Assert(err, chk.IsNil)
+	for _, match := range matches {
+		jpfn := JobPartPlanFileName(filepath.Base(match))
+		_, _, v, err := jpfn.parseWithVersion()
+		c.Assert(err, chk.IsNil)
+		if v == version {
+			plan, err := ioutil.ReadFile(match)
+			c.Assert(err, chk.IsNil)
+			return jpfn, plan
+		}
+	}
+	c.Fatalf("there is no golden plan file of data schema version %d", version)
+	return "", nil
+}
+
+func (s *jobPartPlanVersionsSuite) TestEveryVersionHasAReader(c *chk.C) {
+	c.Assert(jobPartPlanReaders, chk.HasLen, int(DataSchemaVersion)+1)
+	for v := common.Version(0); v <= DataSchemaVersion; v++ {
+		_, found := jobPartPlanReaders[v]
+		c.Assert(found, chk.Equals, true, chk.Commentf("data schema version %d", v))
+		readGoldenJobPartPlan(c, v)
+	}
+}
+
+func (s *jobPartPlanVersionsSuite) TestReadGoldenJobPartPlanV0(c *chk.C) {
+	jpfn, plan := readGoldenJobPartPlan(c, 0)
+	_, _, err := jpfn.Parse()
+	c.Assert(err, chk.NotNil) // not the current version
+
+	contents, err := readJobPartPlanV0(plan)
+	c.Assert(err, chk.IsNil)
+	c.Assert(contents.order, chk.DeepEquals, goldenJobPartOrder(c))
+	c.Assert(contents.jobStatus, chk.Equals, common.EJobStatus.Paused())
+	c.Assert(contents.transferStatuses, chk.DeepEquals,
+		[]common.TransferStatus{common.ETransferStatus.Success(), common.ETransferStatus.Failed()})
+}
+
+// version 1 records the type of the source blobs and the block size planned for each transfer
+func goldenJobPartOrderV1(c *chk.C) common.CopyJobPartOrderRequest {
+	order := goldenJobPartOrder(c)
+	order.Transfers[0].BlobType = common.EBlobType.BlockBlob()
+	order.Transfers[0].BlockSize = 4 * 1024 * 1024
+	order.Transfers[1].BlobType = common.EBlobType.PageBlob()
+	order.Transfers[1].BlockSize = 8 * 1024 * 1024
+	return order
+}
+
+func (s *jobPartPlanVersionsSuite) TestReadGoldenJobPartPlanV1(c *chk.C) {
+	jpfn, plan := readGoldenJobPartPlan(c, 1)
+	c.Assert(checkJobPartPlan(plan), chk.IsNil)
+	contents, err := readJobPartPlanV1(plan)
+	c.Assert(err, chk.IsNil)
+
+	// version 1 also records the time the job part was ordered
+	expected := goldenJobPartOrderV1(c)
+	c.Assert(contents.order, chk.DeepEquals, expected)
+	c.Assert(contents.startTime.Equal(time.Unix(0, 1535767200000000000)), chk.Equals, true)
+	c.Assert(contents.jobStatus, chk.Equals, common.EJobStatus.Paused())
+	c.Assert(contents.transferStatuses, chk.DeepEquals,
+		[]common.TransferStatus{common.ETransferStatus.Success(), common.ETransferStatus.Failed()})
+
+	if DataSchemaVersion == 1 {
+		jobID, partNum, err := jpfn.Parse()
+		c.Assert(err, chk.IsNil)
+		c.Assert(jobID, chk.Equals, expected.JobID)
+		c.Assert(partNum, chk.Equals, expected.PartNum)
+	}
+}
+
+func (s *jobPartPlanVersionsSuite) TestReadCorruptJobPartPlan(c *chk.C) {
+	for v := common.Version(0); v <= DataSchemaVersion; v++ {
+		_, plan := readGoldenJobPartPlan(c, v)
+		read := jobPartPlanReaders[v]
+
+		// a plan cut short, e.g. by a crash while it was written, is an error rather than a panic
+		for _, length := range []int{0, 100, len(plan) / 2} {
+			_, err := read(plan[:length])
+			c.Assert(err, chk.NotNil, chk.Commentf("data schema version %d, %d bytes", v, length))
+		}
+		// as is a plan of another version
+		for other, otherRead := range jobPartPlanReaders {
+			if other != v {
+				_, err := otherRead(plan)
+				c.Assert(err, chk.NotNil, chk.Commentf("data schema version %d read as %d", v, other))
+			}
+		}
+	}
+}
+
+func (s *jobPartPlanVersionsSuite) TestMigrateGoldenJobPartPlans(c *chk.C) {
+	ja := newCleanupTestJobsAdmin(c)
+	defer os.RemoveAll(ja.planDir)
+	// the migrated plans are written through the plan folder of the jobs admin
+	previous := JobsAdmin
+	JobsAdmin = ja
+	defer func() { JobsAdmin = previous }()
+
+	jobID, err := common.ParseJobID(goldenJobID)
+	c.Assert(err, chk.IsNil)
+	current := filepath.Join(ja.planDir, string(ja.NewJobPartPlanFileName(jobID, 0)))
+	for v := common.Version(0); v < DataSchemaVersion; v++ {
+		jpfn, plan := readGoldenJobPartPlan(c, v)
+		c.Assert(ioutil.WriteFile(filepath.Join(ja.planDir, string(jpfn)), plan, 0644), chk.IsNil)
+		expected, err := jobPartPlanReaders[v](plan)
+		c.Assert(err, chk.IsNil)
+
+		migrations := ja.MigrateJobParts(jobID)
+		c.Assert(migrations, chk.HasLen, 1)
+		c.Assert(migrations[0].ErrorMsg, chk.Equals, "", chk.Commentf("data schema version %d", v))
+
+		// the migrated plan verifies, so that the job can be resumed from it, and holds what the prior one did
+		c.Assert(checkJobPartPlanFile(current), chk.IsNil, chk.Commentf("data schema version %d", v))
+		contents, err := readJobPartPlanFile(current, DataSchemaVersion)
+		c.Assert(err, chk.IsNil)
+		c.Assert(contents.order.CommandString, chk.Equals, expected.order.CommandString)
+		c.Assert(contents.order.Transfers, chk.HasLen, len(expected.order.Transfers))
+		c.Assert(contents.startTime.Equal(expected.startTime), chk.Equals, true)
+		c.Assert(contents.jobStatus, chk.Equals, expected.jobStatus)
+		c.Assert(contents.transferStatuses, chk.DeepEquals, expected.transferStatuses)
+		c.Assert(os.Remove(current), chk.IsNil)
+	}
+}
+
+func (s *jobPartPlanVersionsSuite) TestParseJobPartPlanFileName(c *chk.C) {
+	jobID, partNum, version, err := JobPartPlanFileName(goldenJobID + "--00012.steV0").parseWithVersion()
+	c.Assert(err, chk.IsNil)
+	c.Assert(jobID.String(), chk.Equals, goldenJobID)
+	c.Assert(partNum, chk.Equals, common.PartNumber(12))
+	c.Assert(version, chk.Equals, common.Version(0))
+
+	for _, name := range []string{goldenJobID + ".log", "not-a-job--00000.steV0", goldenJobID + "--part.steV1"} {
+		_, _, _, err = JobPartPlanFileName(name).parseWithVersion()
+		c.Assert(err, chk.NotNil, chk.Commentf(name))
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type jobPartTransferMgrTestSuite struct{}
+
+var _ = chk.Suite(&jobPartTransferMgrTestSuite{})
+
+// plannedTransferMgr is the transfer manager of a transfer of a job part plan created and memory mapped by the test,
+// which doesn't log
+type plannedTransferMgr struct {
+	*jobPartTransferMgr
+}
+
+func (t *plannedTransferMgr) ShouldLog(level pipeline.LogLevel) bool  { return false }
+func (t *plannedTransferMgr) Log(level pipeline.LogLevel, msg string) {}
+
+// newPlannedTransferMgrs creates the plan of a job part made of the given transfers, in a plan folder of its own,
+// and returns the transfer managers of its transfers, along with the function which deletes the plan
+func newPlannedTransferMgrs(c *chk.C, blockSize uint32, transfers ...common.CopyTransfer) ([]*plannedTransferMgr, func()) {
+	ja := newCleanupTestJobsAdmin(c)
+	// the plan is created and mapped through the plan folder of the jobs admin
+	previous := JobsAdmin
+	JobsAdmin = ja
+
+	order := common.CopyJobPartOrderRequest{
+		JobID:          common.NewJobID(),
+		FromTo:         common.EFromTo.LocalBlob(),
+		CommandString:  "copy src dst",
+		BlobAttributes: common.BlobTransferAttributes{BlockSizeInBytes: blockSize},
+		Transfers:      transfers,
+	}
+	jpfn := ja.NewJobPartPlanFileName(order.JobID, 0)
+	jpfn.Create(order, time.Now())
+	planMMF, err := jpfn.Map()
+	c.Assert(err, chk.IsNil)
+	jpm := &jobPartMgr{filename: jpfn, planMMF: planMMF}
+
+	jptms := make([]*plannedTransferMgr, len(transfers))
+	for t := range transfers {
+		jptms[t] = &plannedTransferMgr{&jobPartTransferMgr{jobPartMgr: jpm, jobPartPlanTransfer: jpm.Plan().Transfer(uint32(t)),
+			transferIndex: uint32(t), ctx: context.Background()}}
+	}
+	return jptms, func() {
+		jpm.planMMF.Unmap()
+		JobsAdmin = previous
+		os.RemoveAll(ja.planDir)
+	}
+}
+
+func (s *jobPartTransferMgrTestSuite) TestChunkBlockID(c *chk.C) {
+	jptms, cleanup := newPlannedTransferMgrs(c, 4,
+		common.CopyTransfer{Source: "a", Destination: "a", SourceSize: 10},
+		common.CopyTransfer{Source: "b", Destination: "b", SourceSize: 10})
+	defer cleanup()
+
+	// the ID is made of the job, part, transfer and chunk, so a resumed transfer finds the blocks it staged before
+	id, err := base64.StdEncoding.DecodeString(jptms[1].ChunkBlockID(2))
+	c.Assert(err, chk.IsNil)
+	jobID := strings.Replace(jptms[1].jobPartMgr.Plan().JobID.String(), "-", "", -1)
+	c.Assert(string(id), chk.Equals, jobID+"0000000000"+"0000000001"+"0000000002")
+
+	// the IDs of the chunks of a transfer differ from each other and from those of the other transfers,
+	// but all of them have the same length, as the service requires for the blocks of a blob
+	c.Assert(jptms[0].ChunkBlockID(2), chk.Not(chk.Equals), jptms[1].ChunkBlockID(2))
+	c.Assert(jptms[1].ChunkBlockID(1), chk.Not(chk.Equals), jptms[1].ChunkBlockID(2))
+	c.Assert(jptms[1].ChunkBlockID(123456), chk.HasLen, len(jptms[1].ChunkBlockID(2)))
+}
+
+func (s *jobPartTransferMgrTestSuite) TestChunkRecord(c *chk.C) {
+	// the first transfer has 3 chunks, the second has 40, so its record takes 2 words
+	jptms, cleanup := newPlannedTransferMgrs(c, 4,
+		common.CopyTransfer{Source: "a", Destination: "a", SourceSize: 10},
+		common.CopyTransfer{Source: "b", Destination: "b", SourceSize: 160})
+	defer cleanup()
+	c.Assert(jptms[0].HasCompletedChunks(), chk.Equals, false)
+
+	jptms[1].SetChunkCompleted(0)
+	jptms[1].SetChunkCompleted(39)
+	// the chunks past the end of the record are never recorded
+	jptms[1].SetChunkCompleted(64)
+	for chunkIndex := uint32(0); chunkIndex < 65; chunkIndex++ {
+		c.Assert(jptms[1].ChunkCompleted(chunkIndex), chk.Equals, chunkIndex == 0 || chunkIndex == 39, chk.Commentf("chunk %d", chunkIndex))
+	}
+	// the records of the transfers don't overlap
+	c.Assert(jptms[0].HasCompletedChunks(), chk.Equals, false)
+	c.Assert(jptms[1].HasCompletedChunks(), chk.Equals, true)
+
+	jptms[1].ResetCompletedChunks()
+	c.Assert(jptms[1].HasCompletedChunks(), chk.Equals, false)
+	c.Assert(jptms[1].ChunkCompleted(39), chk.Equals, false)
+}
+
+func (s *jobPartTransferMgrTestSuite) TestBytesSkippedAreRecordedInThePlan(c *chk.C) {
+	jptms, cleanup := newPlannedTransferMgrs(c, 4,
+		common.CopyTransfer{Source: "a", Destination: "a", SourceSize: 10},
+		common.CopyTransfer{Source: "b", Destination: "b", SourceSize: 10})
+	defer cleanup()
+	jptms[0].AddToBytesSkipped(4)
+	jptms[1].AddToBytesSkipped(2)
+	jptms[1].AddToBytesSkipped(4)
+	c.Assert(jptms[0].jobPartMgr.BytesSkipped(), chk.Equals, int64(10))
+
+	// the bytes skipped by each transfer are in the plan, so a resumed job still counts them
+	plan := jptms[0].jobPartMgr.Plan()
+	c.Assert(plan.Transfer(0).BytesSkipped(), chk.Equals, int64(4))
+	c.Assert(plan.Transfer(1).BytesSkipped(), chk.Equals, int64(6))
+
+	// they're forgotten along with the chunks they belong to
+	jptms[1].ResetCompletedChunks()
+	c.Assert(plan.Transfer(1).BytesSkipped(), chk.Equals, int64(0))
+	c.Assert(jptms[0].jobPartMgr.BytesSkipped(), chk.Equals, int64(4))
+}
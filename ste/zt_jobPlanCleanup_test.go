@@ -0,0 +1,124 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type jobPlanCleanupSuite struct{}
+
+var _ = chk.Suite(&jobPlanCleanupSuite{})
+
+type discardingLogger struct{}
+
+func (discardingLogger) ShouldLog(level pipeline.LogLevel) bool  { return false }
+func (discardingLogger) Log(level pipeline.LogLevel, msg string) {}
+func (discardingLogger) Panic(err error)                         { panic(err) }
+func (discardingLogger) CloseLog()                               {}
+
+// writeCleanupTestPlan writes the golden plan of the current version as part 0 of a new job,
+// with the given status and TTL, last modified the given time ago
+func writeCleanupTestPlan(c *chk.C, ja *jobsAdmin, status common.JobStatus, ttl uint32, age time.Duration) common.JobID {
+	_, plan := readGoldenJobPartPlan(c, DataSchemaVersion)
+	var h JobPartPlanHeader
+	binary.LittleEndian.PutUint32(plan[unsafe.Offsetof(h.atomicJobStatus):], uint32(status))
+	binary.LittleEndian.PutUint32(plan[unsafe.Offsetof(h.TTLAfterCompletion):], ttl)
+	// the TTL is checksummed along with the rest of the header and the command string
+	commandStringEnd := int(unsafe.Sizeof(h)) + len(goldenJobPartOrder(c).CommandString)
+	binary.LittleEndian.PutUint32(plan[unsafe.Offsetof(h.Checksum):],
+		planChecksum(plan[:unsafe.Offsetof(h.Checksum)], plan[unsafe.Sizeof(h):commandStringEnd]))
+
+	jobID := common.NewJobID()
+	path := filepath.Join(ja.planDir, string(ja.NewJobPartPlanFileName(jobID, 0)))
+	c.Assert(ioutil.WriteFile(path, plan, 0644), chk.IsNil)
+	modTime := time.Now().Add(-age)
+	c.Assert(os.Chtimes(path, modTime, modTime), chk.IsNil)
+	return jobID
+}
+
+func newCleanupTestJobsAdmin(c *chk.C) *jobsAdmin {
+	planDir, err := ioutil.TempDir("", "azcopy-plans")
+	c.Assert(err, chk.IsNil)
+	return &jobsAdmin{logger: discardingLogger{}, jobIDToJobMgr: newJobIDToJobMgr(), planDir: planDir}
+}
+
+func planExists(c *chk.C, ja *jobsAdmin, jobID common.JobID) bool {
+	_, err := os.Stat(filepath.Join(ja.planDir, string(ja.NewJobPartPlanFileName(jobID, 0))))
+	if os.IsNotExist(err) {
+		return false
+	}
+	c.Assert(err, chk.IsNil)
+	return true
+}
+
+func (s *jobPlanCleanupSuite) TestCleanExpiredJobs(c *chk.C) {
+	ja := newCleanupTestJobsAdmin(c)
+	defer os.RemoveAll(ja.planDir)
+
+	expired := writeCleanupTestPlan(c, ja, common.EJobStatus.Completed(), 60, 2*time.Minute)
+	expiredCancelled := writeCleanupTestPlan(c, ja, common.EJobStatus.Cancelled(), 60, 2*time.Minute)
+	notExpired := writeCleanupTestPlan(c, ja, common.EJobStatus.Completed(), 600, 2*time.Minute)
+	noTTL := writeCleanupTestPlan(c, ja, common.EJobStatus.Completed(), 0, 24*time.Hour)
+	paused := writeCleanupTestPlan(c, ja, common.EJobStatus.Paused(), 60, 2*time.Minute)
+
+	ja.CleanExpiredJobs()
+
+	c.Assert(planExists(c, ja, expired), chk.Equals, false)
+	c.Assert(planExists(c, ja, expiredCancelled), chk.Equals, false)
+	c.Assert(planExists(c, ja, notExpired), chk.Equals, true)
+	c.Assert(planExists(c, ja, noTTL), chk.Equals, true)
+	c.Assert(planExists(c, ja, paused), chk.Equals, true)
+}
+
+func (s *jobPlanCleanupSuite) TestCleanJobs(c *chk.C) {
+	ja := newCleanupTestJobsAdmin(c)
+	defer os.RemoveAll(ja.planDir)
+
+	completed := writeCleanupTestPlan(c, ja, common.EJobStatus.Completed(), 0, time.Hour)
+	recent := writeCleanupTestPlan(c, ja, common.EJobStatus.Completed(), 0, time.Minute)
+	paused := writeCleanupTestPlan(c, ja, common.EJobStatus.Paused(), 0, time.Hour)
+
+	// only the finished jobs are cleaned by default, and the older-than filter spares the recent ones
+	cleaned := ja.CleanJobs(common.CleanJobsRequest{OlderThan: 30 * time.Minute})
+	c.Assert(cleaned, chk.HasLen, 1)
+	c.Assert(cleaned[0].JobID, chk.Equals, completed)
+	c.Assert(cleaned[0].ErrorMsg, chk.Equals, "")
+	c.Assert(planExists(c, ja, completed), chk.Equals, false)
+	c.Assert(planExists(c, ja, recent), chk.Equals, true)
+	c.Assert(planExists(c, ja, paused), chk.Equals, true)
+
+	// a paused job is cleaned only when asked for by its status
+	cleaned = ja.CleanJobs(common.CleanJobsRequest{JobID: paused, Statuses: []common.JobStatus{common.EJobStatus.Paused()}})
+	c.Assert(cleaned, chk.HasLen, 1)
+	c.Assert(cleaned[0].Status, chk.Equals, common.EJobStatus.Paused())
+	c.Assert(planExists(c, ja, paused), chk.Equals, false)
+	c.Assert(planExists(c, ja, recent), chk.Equals, true)
+}
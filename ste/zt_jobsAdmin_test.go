@@ -0,0 +1,100 @@
+// +build linux darwin
+
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+const benchmarkWorkerCount = 300
+
+// newBenchmarkJobsAdmin builds just enough of a jobsAdmin to run the worker pool, without touching the JobsAdmin singleton
+func newBenchmarkJobsAdmin() *jobsAdmin {
+	suicideCh := make(chan SuicideJob, benchmarkWorkerCount)
+	workAvailableCh := make(chan struct{}, 400000)
+
+	return &jobsAdmin{
+		workQueue:           newFairScheduler(),
+		coordinatorChannels: CoordinatorChannels{suicideCh: suicideCh},
+		xferChannels: XferChannels{
+			suicideCh:       suicideCh,
+			workAvailableCh: workAvailableCh,
+		},
+	}
+}
+
+// processCPUTime returns the user+system CPU time consumed by this process so far
+func processCPUTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// BenchmarkIdleWorkers measures how much CPU an idle worker pool burns while waiting for work
+// With the workers blocked on the work notification channel, this should be close to 0% of a core
+func BenchmarkIdleWorkers(b *testing.B) {
+	ja := newBenchmarkJobsAdmin()
+	ja.setWorkerCount(benchmarkWorkerCount)
+	defer ja.setWorkerCount(0)
+
+	const idlePeriod = 10 * time.Millisecond
+	b.ResetTimer()
+	cpuBefore, wallBefore := processCPUTime(b), time.Now()
+	for i := 0; i < b.N; i++ {
+		time.Sleep(idlePeriod)
+	}
+	cpu, wall := processCPUTime(b)-cpuBefore, time.Since(wallBefore)
+	b.StopTimer()
+
+	b.Logf("%d idle workers used %v of CPU over %v (%.2f%% of one core)",
+		benchmarkWorkerCount, cpu, wall, 100*float64(cpu)/float64(wall))
+}
+
+// BenchmarkScheduleChunk measures the cost of dispatching a chunk to the worker pool and having it run
+func BenchmarkScheduleChunk(b *testing.B) {
+	ja := newBenchmarkJobsAdmin()
+	ja.setWorkerCount(benchmarkWorkerCount)
+	defer ja.setWorkerCount(0)
+
+	wg := &sync.WaitGroup{}
+	chunk := func(workerID int) { wg.Done() }
+
+	jobID := common.NewJobID()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		priority := common.EJobPriority.Normal()
+		if i%2 == 1 {
+			priority = common.EJobPriority.Low()
+		}
+		ja.ScheduleChunk(jobID, priority, chunk)
+	}
+	wg.Wait()
+}
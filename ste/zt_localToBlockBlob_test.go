@@ -0,0 +1,251 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+	chk "gopkg.in/check.v1"
+)
+
+type localToBlockBlobTestSuite struct{}
+
+var _ = chk.Suite(&localToBlockBlobTestSuite{})
+
+// uncommittedBlocksSender answers the requests for the block list of a blob with the given uncommitted blocks,
+// or with a BlobNotFound error if there are none
+func uncommittedBlocksSender(blocks ...azblob.Block) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			header := http.Header{}
+			status, body := http.StatusOK, "<?xml version=\"1.0\" encoding=\"utf-8\"?><BlockList><CommittedBlocks /><UncommittedBlocks>"
+			for _, block := range blocks {
+				body += fmt.Sprintf("<Block><Name>%s</Name><Size>%d</Size></Block>", block.Name, block.Size)
+			}
+			body += "</UncommittedBlocks></BlockList>"
+			if len(blocks) == 0 {
+				header.Set("x-ms-error-code", "BlobNotFound")
+				status, body = http.StatusNotFound,
+					"<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>BlobNotFound</Code><Message>The specified blob does not exist.</Message></Error>"
+			}
+			return pipeline.NewHTTPResponse(&http.Response{StatusCode: status, Header: header, Request: request.Request,
+				Body: ioutil.NopCloser(strings.NewReader(body))}), nil
+		}
+	})
+}
+
+// newTestSourceFile creates a local source file holding the given data
+func newTestSourceFile(c *chk.C, data string) *os.File {
+	f, err := ioutil.TempFile("", "azcopy-source")
+	c.Assert(err, chk.IsNil)
+	_, err = f.WriteString(data)
+	c.Assert(err, chk.IsNil)
+	return f
+}
+
+// newResumedBlockBlobUpload returns the upload of a source of 10 bytes in chunks of 4 bytes, whose previous attempt
+// recorded all its chunks as completed. The blob answers with the given uncommitted blocks.
+func newResumedBlockBlobUpload(c *chk.C, srcFile *os.File, blocks func(jptm IJobPartTransferMgr) []azblob.Block) (*blockBlobUpload, func()) {
+	fileInfo, err := srcFile.Stat()
+	c.Assert(err, chk.IsNil)
+	jptms, cleanup := newPlannedTransferMgrs(c, 4, common.CopyTransfer{Source: srcFile.Name(),
+		Destination: "https://account.blob.core.windows.net/container/blob", SourceSize: 10, LastModifiedTime: fileInfo.ModTime()})
+	jptm := jptms[0]
+	for chunkIndex := uint32(0); chunkIndex < 3; chunkIndex++ {
+		jptm.SetChunkCompleted(chunkIndex)
+	}
+
+	u, _ := url.Parse(jptm.Info().Destination)
+	p := pipeline.NewPipeline([]pipeline.Factory{pipeline.MethodFactoryMarker()}, pipeline.Options{HTTPSender: uncommittedBlocksSender(blocks(jptm)...)})
+	return &blockBlobUpload{jptm: jptm, blobURL: azblob.NewBlobURL(*u, p), pacer: &pacer{}}, cleanup
+}
+
+// completedChunks returns the chunks which the completed-chunk record of the transfer holds, out of its first numChunks
+func completedChunks(jptm IJobPartTransferMgr, numChunks uint32) []uint32 {
+	chunks := []uint32{}
+	for chunkIndex := uint32(0); chunkIndex < numChunks; chunkIndex++ {
+		if jptm.ChunkCompleted(chunkIndex) {
+			chunks = append(chunks, chunkIndex)
+		}
+	}
+	return chunks
+}
+
+func (s *localToBlockBlobTestSuite) TestCheckCompletedChunksKeepsTheStagedChunks(c *chk.C) {
+	srcFile := newTestSourceFile(c, "0123456789")
+	defer os.Remove(srcFile.Name())
+	defer srcFile.Close()
+	bbu, cleanup := newResumedBlockBlobUpload(c, srcFile, func(jptm IJobPartTransferMgr) []azblob.Block {
+		// the first chunk was staged, the second was staged with another size, and the third wasn't staged
+		return []azblob.Block{{Name: jptm.ChunkBlockID(0), Size: 4}, {Name: jptm.ChunkBlockID(1), Size: 2}}
+	})
+	defer cleanup()
+
+	bbu.checkCompletedChunks(srcFile, 3, 4, 10)
+	c.Assert(completedChunks(bbu.jptm, 3), chk.DeepEquals, []uint32{0})
+}
+
+func (s *localToBlockBlobTestSuite) TestCheckCompletedChunksExpectsTheSizeOfTheLastChunk(c *chk.C) {
+	srcFile := newTestSourceFile(c, "0123456789")
+	defer os.Remove(srcFile.Name())
+	defer srcFile.Close()
+	bbu, cleanup := newResumedBlockBlobUpload(c, srcFile, func(jptm IJobPartTransferMgr) []azblob.Block {
+		return []azblob.Block{{Name: jptm.ChunkBlockID(0), Size: 4}, {Name: jptm.ChunkBlockID(1), Size: 4}, {Name: jptm.ChunkBlockID(2), Size: 2}}
+	})
+	defer cleanup()
+
+	bbu.checkCompletedChunks(srcFile, 3, 4, 10)
+	c.Assert(completedChunks(bbu.jptm, 3), chk.DeepEquals, []uint32{0, 1, 2})
+}
+
+func (s *localToBlockBlobTestSuite) TestCheckCompletedChunksWithoutBlockList(c *chk.C) {
+	srcFile := newTestSourceFile(c, "0123456789")
+	defer os.Remove(srcFile.Name())
+	defer srcFile.Close()
+	// the uncommitted blocks expired along with the blob
+	bbu, cleanup := newResumedBlockBlobUpload(c, srcFile, func(jptm IJobPartTransferMgr) []azblob.Block { return nil })
+	defer cleanup()
+
+	bbu.checkCompletedChunks(srcFile, 3, 4, 10)
+	c.Assert(bbu.jptm.HasCompletedChunks(), chk.Equals, false)
+}
+
+func (s *localToBlockBlobTestSuite) TestCheckCompletedChunksOfAModifiedSource(c *chk.C) {
+	srcFile := newTestSourceFile(c, "0123456789")
+	defer os.Remove(srcFile.Name())
+	defer srcFile.Close()
+	bbu, cleanup := newResumedBlockBlobUpload(c, srcFile, func(jptm IJobPartTransferMgr) []azblob.Block {
+		return []azblob.Block{{Name: jptm.ChunkBlockID(0), Size: 4}, {Name: jptm.ChunkBlockID(1), Size: 4}, {Name: jptm.ChunkBlockID(2), Size: 2}}
+	})
+	defer cleanup()
+
+	// the source was modified since the job was ordered, so the staged blocks may hold its previous data
+	modTime := bbu.jptm.Info().SourceLastModifiedTime.Add(time.Minute)
+	c.Assert(os.Chtimes(srcFile.Name(), modTime, modTime), chk.IsNil)
+	bbu.checkCompletedChunks(srcFile, 3, 4, 10)
+	c.Assert(bbu.jptm.HasCompletedChunks(), chk.Equals, false)
+}
+
+// sourceTransferMgr is the transfer manager of an upload of the given local source, whose chunks are run as they are scheduled
+type sourceTransferMgr struct {
+	appendBlobTransferMgr
+	info TransferInfo
+}
+
+func (t *sourceTransferMgr) Info() TransferInfo { return t.info }
+func (t *sourceTransferMgr) BlobDstData(dataFileToXfer *common.MMF) (azblob.BlobHTTPHeaders, azblob.Metadata) {
+	return azblob.BlobHTTPHeaders{}, azblob.Metadata{}
+}
+func (t *sourceTransferMgr) BlobTiers() (common.BlockBlobTier, common.PageBlobTier) {
+	return common.EBlockBlobTier.None(), common.EPageBlobTier.None()
+}
+
+// newSourceTransferMgr returns the transfer manager of the upload of srcFile, as it was when the upload was ordered
+func newSourceTransferMgr(c *chk.C, srcFile *os.File) *sourceTransferMgr {
+	fileInfo, err := srcFile.Stat()
+	c.Assert(err, chk.IsNil)
+	return &sourceTransferMgr{info: TransferInfo{Source: srcFile.Name(), Destination: "https://account.blob.core.windows.net/container/blob",
+		SourceSize: fileInfo.Size(), SourceLastModifiedTime: fileInfo.ModTime()}}
+}
+
+func (s *localToBlockBlobTestSuite) TestLocalSourceChanged(c *chk.C) {
+	srcFile := newTestSourceFile(c, "0123456789")
+	defer os.Remove(srcFile.Name())
+	defer srcFile.Close()
+	jptm := newSourceTransferMgr(c, srcFile)
+	c.Assert(localSourceChanged(jptm), chk.Equals, false)
+
+	// the source was written to without changing its size
+	modTime := jptm.info.SourceLastModifiedTime.Add(time.Second)
+	c.Assert(os.Chtimes(srcFile.Name(), modTime, modTime), chk.IsNil)
+	c.Assert(localSourceChanged(jptm), chk.Equals, true)
+
+	// the source was truncated, even if its last modified time was restored since
+	c.Assert(srcFile.Truncate(4), chk.IsNil)
+	c.Assert(os.Chtimes(srcFile.Name(), jptm.info.SourceLastModifiedTime, jptm.info.SourceLastModifiedTime), chk.IsNil)
+	c.Assert(localSourceChanged(jptm), chk.Equals, true)
+
+	// the source was deleted
+	c.Assert(os.Remove(srcFile.Name()), chk.IsNil)
+	c.Assert(localSourceChanged(jptm), chk.Equals, true)
+}
+
+// putBlob uploads srcFile with a put blob to a blob which calls uploaded when it's uploaded,
+// and returns whether the blob was deleted afterwards
+func putBlob(c *chk.C, jptm IJobPartTransferMgr, srcFile *os.File, uploaded func()) (deleted bool) {
+	sender := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			status := http.StatusCreated
+			if request.Method == http.MethodDelete {
+				deleted, status = true, http.StatusAccepted
+			} else {
+				ioutil.ReadAll(request.Body)
+				uploaded()
+			}
+			return pipeline.NewHTTPResponse(&http.Response{StatusCode: status, Header: http.Header{}, Request: request.Request,
+				Body: ioutil.NopCloser(strings.NewReader(""))}), nil
+		}
+	})
+	u, _ := url.Parse(jptm.Info().Destination)
+	p := pipeline.NewPipeline([]pipeline.Factory{pipeline.MethodFactoryMarker()}, pipeline.Options{HTTPSender: sender})
+	srcMmf, err := common.NewMMF(srcFile, false, 0, jptm.Info().SourceSize)
+	c.Assert(err, chk.IsNil)
+
+	PutBlobUploadFunc(jptm, srcMmf, azblob.NewBlockBlobURL(*u, p), &pacer{})
+	return deleted
+}
+
+func (s *localToBlockBlobTestSuite) TestPutBlob(c *chk.C) {
+	srcFile := newTestSourceFile(c, "0123456789")
+	defer os.Remove(srcFile.Name())
+	defer srcFile.Close()
+	jptm := newSourceTransferMgr(c, srcFile)
+
+	c.Assert(putBlob(c, jptm, srcFile, func() {}), chk.Equals, false)
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.Success())
+	c.Assert(jptm.transferIsDone, chk.Equals, true)
+}
+
+func (s *localToBlockBlobTestSuite) TestPutBlobOfASourceChangedDuringTheUpload(c *chk.C) {
+	srcFile := newTestSourceFile(c, "0123456789")
+	defer os.Remove(srcFile.Name())
+	defer srcFile.Close()
+	jptm := newSourceTransferMgr(c, srcFile)
+
+	// the blob committed by the put blob may hold a torn snapshot of the source, so it's deleted
+	deleted := putBlob(c, jptm, srcFile, func() {
+		modTime := jptm.info.SourceLastModifiedTime.Add(time.Second)
+		c.Assert(os.Chtimes(srcFile.Name(), modTime, modTime), chk.IsNil)
+	})
+	c.Assert(deleted, chk.Equals, true)
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.SourceChangedFailure())
+	c.Assert(jptm.transferIsDone, chk.Equals, true)
+}
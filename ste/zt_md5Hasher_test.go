@@ -0,0 +1,62 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"crypto/md5"
+	"math/rand"
+	"sync"
+
+	chk "gopkg.in/check.v1"
+)
+
+type md5HasherTestSuite struct{}
+
+var _ = chk.Suite(&md5HasherTestSuite{})
+
+func (s *md5HasherTestSuite) TestChunksReadOutOfOrder(c *chk.C) {
+	data := make([]byte, 10*1024+100)
+	rand.Read(data)
+	chunkSize := int64(1024)
+	numChunks := uint32(11)
+	expected := md5.Sum(data)
+
+	h := newChunkedMD5Hasher(data, chunkSize, numChunks)
+	var wg sync.WaitGroup
+	for _, chunkIndex := range rand.Perm(int(numChunks)) {
+		c.Assert(h.Sum(), chk.IsNil)
+		wg.Add(1)
+		go func(chunkIndex int32) {
+			defer wg.Done()
+			h.ChunkDone(chunkIndex)
+		}(int32(chunkIndex))
+	}
+	wg.Wait()
+	c.Assert(h.Sum(), chk.DeepEquals, expected[:])
+}
+
+func (s *md5HasherTestSuite) TestMissingChunk(c *chk.C) {
+	data := make([]byte, 3*1024)
+	h := newChunkedMD5Hasher(data, 1024, 3)
+	h.ChunkDone(0)
+	h.ChunkDone(2)
+	c.Assert(h.Sum(), chk.IsNil)
+}
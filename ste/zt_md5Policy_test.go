@@ -0,0 +1,114 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package ste
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+	chk "gopkg.in/check.v1"
+)
+
+type md5PolicyTestSuite struct{}
+
+var _ = chk.Suite(&md5PolicyTestSuite{})
+
+// rangeGetPipeline returns a pipeline which retries through the transactional MD5 policy, and whose sender returns data
+// with the given Content-MD5 headers, one per try; it records the number of tries in tries
+func rangeGetPipeline(data []byte, contentMD5s []string, tries *int) pipeline.Pipeline {
+	sender := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			header := http.Header{}
+			if contentMD5 := contentMD5s[*tries]; contentMD5 != "" {
+				header.Set("Content-MD5", contentMD5)
+			}
+			*tries++
+			return pipeline.NewHTTPResponse(&http.Response{StatusCode: http.StatusPartialContent, Header: header,
+				Body: ioutil.NopCloser(bytes.NewReader(data))}), nil
+		}
+	})
+	return pipeline.NewPipeline([]pipeline.Factory{
+		azblob.NewRetryPolicyFactory(azblob.RetryOptions{MaxTries: 3, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}),
+		NewTransactionalMD5PolicyFactory(),
+	}, pipeline.Options{HTTPSender: sender})
+}
+
+func rangeGet(c *chk.C, p pipeline.Pipeline) (pipeline.Response, error) {
+	u, _ := url.Parse("https://account.blob.core.windows.net/container/blob")
+	request, err := pipeline.NewRequest(http.MethodGet, *u, nil)
+	c.Assert(err, chk.IsNil)
+	request.Header.Set("x-ms-range-get-content-md5", "true")
+	return p.Do(context.Background(), nil, request)
+}
+
+func (s *md5PolicyTestSuite) TestMismatchedRangeIsRetried(c *chk.C) {
+	data := []byte("the data of the range")
+	sum := md5.Sum(data)
+	corrupted := md5.Sum([]byte("some other data"))
+	tries := 0
+	p := rangeGetPipeline(data, []string{base64.StdEncoding.EncodeToString(corrupted[:]), base64.StdEncoding.EncodeToString(sum[:])}, &tries)
+
+	resp, err := rangeGet(c, p)
+	c.Assert(err, chk.IsNil)
+	c.Assert(tries, chk.Equals, 2)
+	body, err := ioutil.ReadAll(resp.Response().Body)
+	c.Assert(err, chk.IsNil)
+	c.Assert(body, chk.DeepEquals, data)
+}
+
+func (s *md5PolicyTestSuite) TestRangeKeepsMismatchingAfterAllTries(c *chk.C) {
+	corrupted := base64.StdEncoding.EncodeToString(make([]byte, md5.Size))
+	tries := 0
+	p := rangeGetPipeline([]byte("the data of the range"), []string{corrupted, corrupted, corrupted}, &tries)
+
+	_, err := rangeGet(c, p)
+	c.Assert(err, chk.FitsTypeOf, transactionalMD5Error{})
+	c.Assert(tries, chk.Equals, 3)
+}
+
+func (s *md5PolicyTestSuite) TestRangeWithoutMD5PassesThrough(c *chk.C) {
+	data := []byte("the data of the range")
+	tries := 0
+	p := rangeGetPipeline(data, []string{""}, &tries)
+
+	resp, err := rangeGet(c, p)
+	c.Assert(err, chk.IsNil)
+	c.Assert(tries, chk.Equals, 1)
+	body, err := ioutil.ReadAll(resp.Response().Body)
+	c.Assert(err, chk.IsNil)
+	c.Assert(body, chk.DeepEquals, data)
+}
+
+func (s *md5PolicyTestSuite) TestSplitForRangeGetContentMD5(c *chk.C) {
+	const mb = 1024 * 1024
+	c.Assert(splitForRangeGetContentMD5([]azblob.PageRange{{Start: 8 * mb, End: 16*mb - 1}}), chk.DeepEquals,
+		[]azblob.PageRange{{Start: 8 * mb, End: 12*mb - 1}, {Start: 12 * mb, End: 16*mb - 1}})
+	c.Assert(splitForRangeGetContentMD5([]azblob.PageRange{{Start: 0, End: 511}, {Start: 1024, End: 5*mb + 1023}}), chk.DeepEquals,
+		[]azblob.PageRange{{Start: 0, End: 511}, {Start: 1024, End: 4*mb + 1023}, {Start: 4*mb + 1024, End: 5*mb + 1023}})
+	c.Assert(splitForRangeGetContentMD5([]azblob.PageRange{}), chk.HasLen, 0)
+}
@@ -0,0 +1,188 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+	chk "gopkg.in/check.v1"
+)
+
+type pacerTestSuite struct{}
+
+var _ = chk.Suite(&pacerTestSuite{})
+
+func (s *pacerTestSuite) TestRetryAfter(c *chk.C) {
+	resp := &http.Response{Header: http.Header{}}
+	c.Assert(retryAfter(resp), chk.Equals, defaultThrottleBackoff)
+
+	resp.Header.Set("Retry-After", "5")
+	c.Assert(retryAfter(resp), chk.Equals, 5*time.Second)
+
+	// a bogus delay is capped
+	resp.Header.Set("Retry-After", "86400")
+	c.Assert(retryAfter(resp), chk.Equals, maxThrottleBackoff)
+
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	c.Assert(retryAfter(resp), chk.Equals, time.Millisecond*time.Duration(PacerTimeToWaitInMs))
+}
+
+func (s *pacerTestSuite) TestThrottleDelay(c *chk.C) {
+	o := XferRetryOptions{StatusCodeRetryClasses: map[int]common.RetryClass{http.StatusNotFound: common.ERetryClass.Throttling()}}
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "5")
+	c.Assert(o.throttleDelay(resp), chk.Equals, 5*time.Second)
+
+	// only the responses which mean throttling hold the retry back
+	resp.StatusCode = http.StatusInternalServerError
+	c.Assert(o.throttleDelay(resp), chk.Equals, time.Duration(0))
+	c.Assert(o.throttleDelay(nil), chk.Equals, time.Duration(0))
+	resp.StatusCode = http.StatusNotFound
+	c.Assert(o.throttleDelay(resp), chk.Equals, 5*time.Second)
+}
+
+func (s *pacerTestSuite) TestThrottledRetryWaitsForRetryAfter(c *chk.C) {
+	tries := 0
+	sender := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			tries++
+			status, header := http.StatusOK, http.Header{}
+			if tries == 1 {
+				status = http.StatusServiceUnavailable
+				header.Set("Retry-After", "1")
+				header.Set("x-ms-error-code", "ServerBusy")
+			}
+			return pipeline.NewHTTPResponse(&http.Response{StatusCode: status, Header: header, Request: request.Request,
+				Body: ioutil.NopCloser(strings.NewReader(""))}), nil
+		}
+	})
+	// the retry policy alone would retry after a few milliseconds at most
+	o := XferRetryOptions{Policy: RetryPolicyExponential, MaxTries: 2, RetryDelay: time.Millisecond, MaxRetryDelay: 2 * time.Millisecond}
+	p := pipeline.NewPipeline([]pipeline.Factory{NewBlobXferRetryPolicyFactory(o), pipeline.MethodFactoryMarker()}, pipeline.Options{HTTPSender: sender})
+	u, _ := url.Parse("https://account.blob.core.windows.net/container/blob")
+
+	start := time.Now()
+	_, err := azblob.NewBlobURL(*u, p).GetProperties(context.Background(), azblob.BlobAccessConditions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(tries, chk.Equals, 2)
+	c.Assert(time.Since(start) >= time.Second, chk.Equals, true)
+}
+
+func (s *pacerTestSuite) TestBackOffReducesConcurrencyOncePerPeriod(c *chk.C) {
+	p := &pacer{requestsInFlight: 99}
+
+	p.backOff(time.Second)
+	c.Assert(p.maxRequestsInFlight, chk.Equals, int64(50))
+	// the requests already in flight being throttled too doesn't reduce the concurrency again
+	p.backOff(time.Second)
+	c.Assert(p.maxRequestsInFlight, chk.Equals, int64(50))
+
+	events, backoffTime := p.throttleStats()
+	c.Assert(events, chk.Equals, int64(2))
+	// overlapping backoffs are only counted once
+	c.Assert(backoffTime >= time.Second && backoffTime < 2*time.Second, chk.Equals, true)
+
+	// the concurrency recovers by one for every limit's worth of successes
+	for i := 0; i < 50; i++ {
+		p.recordSuccess()
+	}
+	c.Assert(p.maxRequestsInFlight, chk.Equals, int64(51))
+}
+
+func (s *pacerTestSuite) TestWaitForRightToRequest(c *chk.C) {
+	p := &pacer{maxRequestsInFlight: 1}
+	c.Assert(p.waitForRightToRequest(context.Background()), chk.IsNil)
+
+	// there's no room in flight until the request in flight is done
+	granted := make(chan error, 1)
+	go func() { granted <- p.waitForRightToRequest(context.Background()) }()
+	select {
+	case <-granted:
+		c.Fatal("the request was let through while the limit was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.requestDone()
+	select {
+	case err := <-granted:
+		c.Assert(err, chk.IsNil)
+	case <-time.After(time.Second):
+		c.Fatal("the request wasn't let through once there was room in flight")
+	}
+
+	// a request can give up waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(p.waitForRightToRequest(ctx), chk.Equals, context.DeadlineExceeded)
+	c.Assert(atomic.LoadInt64(&p.requestsInFlight), chk.Equals, int64(1))
+}
+
+func (s *pacerTestSuite) TestWaitForRightToRequestWaitsForTheBackoff(c *chk.C) {
+	p := &pacer{}
+	backoffUntil := time.Now().Add(100 * time.Millisecond)
+	p.backoffUntil = backoffUntil.UnixNano()
+
+	c.Assert(p.waitForRightToRequest(context.Background()), chk.IsNil)
+	c.Assert(time.Now().Before(backoffUntil), chk.Equals, false)
+}
+
+func (s *pacerTestSuite) TestBandwidthCapOfJobIsResetOnceItIsDone(c *chk.C) {
+	ja := &jobsAdmin{logger: discardingLogger{}, pacer: &pacer{}, defaultCapMbps: 100}
+	ja.pacer.setTargetRate(MbpsToBytesPerSecond(ja.defaultCapMbps))
+	firstJob, secondJob := common.NewJobID(), common.NewJobID()
+
+	ja.SetBandwidthCap(firstJob, 8)
+	c.Assert(ja.EffectiveCapInMbps(), chk.Equals, float64(8))
+	ja.ResetBandwidthCap(firstJob)
+	c.Assert(ja.EffectiveCapInMbps(), chk.Equals, float64(100))
+
+	// a job finishing doesn't reset the cap another job set after it
+	ja.SetBandwidthCap(firstJob, 8)
+	ja.SetBandwidthCap(secondJob, 16)
+	ja.ResetBandwidthCap(firstJob)
+	c.Assert(ja.EffectiveCapInMbps(), chk.Equals, float64(16))
+	ja.ResetBandwidthCap(secondJob)
+	c.Assert(ja.EffectiveCapInMbps(), chk.Equals, float64(100))
+}
+
+func (s *pacerTestSuite) TestClosingPacedReaderStopsItsPacer(c *chk.C) {
+	r := NewPacedReader(strings.NewReader("data"), 8)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(data), chk.Equals, "data")
+
+	c.Assert(r.Close(), chk.IsNil)
+	select {
+	case <-r.(*bodyPacer).p.done:
+	default:
+		c.Fatal("the pacer of the reader is still issuing tickets")
+	}
+	// closing again is harmless
+	c.Assert(r.Close(), chk.IsNil)
+}
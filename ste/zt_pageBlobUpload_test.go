@@ -0,0 +1,65 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package ste
+
+import (
+	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+	chk "gopkg.in/check.v1"
+)
+
+type pageBlobUploadTestSuite struct{}
+
+var _ = chk.Suite(&pageBlobUploadTestSuite{})
+
+// pagesWithData returns numPages pages of zeros, with a non-zero byte at the given offsets
+func pagesWithData(numPages int, nonZeroOffsets ...int) []byte {
+	pageBytes := make([]byte, numPages*azblob.PageBlobPageBytes)
+	for _, offset := range nonZeroOffsets {
+		pageBytes[offset] = 1
+	}
+	return pageBytes
+}
+
+func (s *pageBlobUploadTestSuite) TestAllZeroChunkHasNoRanges(c *chk.C) {
+	c.Assert(nonZeroPageRanges(pagesWithData(4)), chk.HasLen, 0)
+}
+
+func (s *pageBlobUploadTestSuite) TestFullChunkIsOneRange(c *chk.C) {
+	pageBytes := pagesWithData(4)
+	for index := range pageBytes {
+		pageBytes[index] = 0xff
+	}
+	c.Assert(nonZeroPageRanges(pageBytes), chk.DeepEquals, []azblob.PageRange{{Start: 0, End: 4*512 - 1}})
+}
+
+func (s *pageBlobUploadTestSuite) TestLeadingAndTrailingZeroPagesAreTrimmed(c *chk.C) {
+	// the data is in the second and third page, and only touches the last byte of the third one
+	pageBytes := pagesWithData(5, 512+7, 3*512-1)
+	c.Assert(nonZeroPageRanges(pageBytes), chk.DeepEquals, []azblob.PageRange{{Start: 512, End: 3*512 - 1}})
+}
+
+func (s *pageBlobUploadTestSuite) TestZeroPagesInTheMiddleSplitTheRanges(c *chk.C) {
+	pageBytes := pagesWithData(8, 0, 2*512+100, 3*512, 7*512+511)
+	c.Assert(nonZeroPageRanges(pageBytes), chk.DeepEquals, []azblob.PageRange{
+		{Start: 0, End: 512 - 1},
+		{Start: 2 * 512, End: 4*512 - 1},
+		{Start: 7 * 512, End: 8*512 - 1},
+	})
+}
@@ -0,0 +1,171 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type panicRecoveryTestSuite struct{}
+
+var _ = chk.Suite(&panicRecoveryTestSuite{})
+
+// panickingTransferMgr records what the recovery does to the transfer, the methods it doesn't override are never called
+type panickingTransferMgr struct {
+	IJobPartTransferMgr
+	status        common.TransferStatus
+	canceled      bool
+	chunksDone    uint32
+	numChunks     uint32
+	reported      map[int]bool
+	transfersDone int
+	logs          []string
+	infoPanics    bool
+}
+
+func (t *panickingTransferMgr) Info() TransferInfo {
+	if t.infoPanics {
+		panic("corrupted job part plan")
+	}
+	return TransferInfo{Source: "src", Destination: "dst"}
+}
+func (t *panickingTransferMgr) WasCanceled() bool { return t.canceled }
+func (t *panickingTransferMgr) ReportChunkDone(workerID int) (bool, uint32) {
+	t.chunksDone++
+	if t.reported == nil {
+		t.reported = map[int]bool{}
+	}
+	t.reported[workerID] = t.chunksDone == t.numChunks
+	return t.chunksDone == t.numChunks, t.chunksDone
+}
+func (t *panickingTransferMgr) ChunkReportedDone(workerID int) (bool, bool) {
+	lastChunk, reported := t.reported[workerID]
+	return reported, lastChunk
+}
+func (t *panickingTransferMgr) ShouldLog(level pipeline.LogLevel) bool  { return true }
+func (t *panickingTransferMgr) Log(level pipeline.LogLevel, msg string) { t.logs = append(t.logs, msg) }
+func (t *panickingTransferMgr) SetStatus(status common.TransferStatus)  { t.status = status }
+func (t *panickingTransferMgr) Cancel()                                 { t.canceled = true }
+func (t *panickingTransferMgr) ReportTransferDone() uint32 {
+	t.transfersDone++
+	return uint32(t.transfersDone)
+}
+
+func (s *panicRecoveryTestSuite) TestPanicFailsTheTransfer(c *chk.C) {
+	jptm := &panickingTransferMgr{}
+	func() {
+		defer failTransferOnPanic(jptm, "processing a chunk")
+		panic("metadata string it too large")
+	}()
+
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.Failed())
+	c.Assert(jptm.canceled, chk.Equals, true)
+	c.Assert(jptm.transfersDone, chk.Equals, 1)
+	c.Assert(jptm.logs, chk.HasLen, 1)
+	c.Assert(strings.Contains(jptm.logs[0], "metadata string it too large"), chk.Equals, true)
+	// the stack trace points at the code which panicked
+	c.Assert(strings.Contains(jptm.logs[0], "TestPanicFailsTheTransfer"), chk.Equals, true)
+}
+
+func (s *panicRecoveryTestSuite) TestNoPanicLeavesTheTransferAlone(c *chk.C) {
+	jptm := &panickingTransferMgr{}
+	func() {
+		defer failTransferOnPanic(jptm, "processing a chunk")
+	}()
+
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.NotStarted())
+	c.Assert(jptm.transfersDone, chk.Equals, 0)
+}
+
+func (s *panicRecoveryTestSuite) TestPanickingLastChunkIsReportedDone(c *chk.C) {
+	jptm := &panickingTransferMgr{numChunks: 2, chunksDone: 1}
+	func() {
+		defer failChunkOnPanic(jptm, 0)
+		panic("metadata string it too large")
+	}()
+
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.Failed())
+	c.Assert(jptm.chunksDone, chk.Equals, uint32(2))
+	c.Assert(jptm.transfersDone, chk.Equals, 1)
+	c.Assert(jptm.logs, chk.HasLen, 2)
+}
+
+func (s *panicRecoveryTestSuite) TestPanickingChunkLeavesTheTransferToTheLastChunk(c *chk.C) {
+	jptm := &panickingTransferMgr{numChunks: 3}
+	func() {
+		defer failChunkOnPanic(jptm, 0)
+		panic("metadata string it too large")
+	}()
+
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.Failed())
+	c.Assert(jptm.chunksDone, chk.Equals, uint32(1))
+	c.Assert(jptm.transfersDone, chk.Equals, 0)
+
+	// the other chunks of the transfer wind down, the last one concludes the transfer
+	jptm.ReportChunkDone(1)
+	if lastChunk, _ := jptm.ReportChunkDone(2); lastChunk {
+		jptm.ReportTransferDone()
+	}
+	c.Assert(jptm.transfersDone, chk.Equals, 1)
+}
+
+func (s *panicRecoveryTestSuite) TestChunkPanickingWhileConcludingTheTransfer(c *chk.C) {
+	jptm := &panickingTransferMgr{numChunks: 2, chunksDone: 1}
+	func() {
+		defer failChunkOnPanic(jptm, 0)
+		if lastChunk, _ := jptm.ReportChunkDone(0); lastChunk {
+			panic("metadata string it too large")
+		}
+	}()
+
+	// the chunk isn't reported done twice
+	c.Assert(jptm.chunksDone, chk.Equals, uint32(2))
+	c.Assert(jptm.transfersDone, chk.Equals, 1)
+}
+
+func (s *panicRecoveryTestSuite) TestChunkPanickingAfterReportingItselfDone(c *chk.C) {
+	jptm := &panickingTransferMgr{numChunks: 3}
+	func() {
+		defer failChunkOnPanic(jptm, 0)
+		jptm.ReportChunkDone(0)
+		panic("metadata string it too large")
+	}()
+
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.Failed())
+	c.Assert(jptm.chunksDone, chk.Equals, uint32(1))
+	c.Assert(jptm.transfersDone, chk.Equals, 0)
+}
+
+func (s *panicRecoveryTestSuite) TestUnreadableTransferIsStillFailed(c *chk.C) {
+	jptm := &panickingTransferMgr{infoPanics: true}
+	func() {
+		defer failTransferOnPanic(jptm, "starting the transfer")
+		panic("metadata string it too large")
+	}()
+
+	c.Assert(jptm.status, chk.Equals, common.ETransferStatus.Failed())
+	c.Assert(jptm.transfersDone, chk.Equals, 1)
+	c.Assert(strings.Contains(jptm.logs[0], "corrupted job part plan"), chk.Equals, true)
+}
@@ -0,0 +1,75 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	chk "gopkg.in/check.v1"
+)
+
+type transferFailureTestSuite struct{}
+
+var _ = chk.Suite(&transferFailureTestSuite{})
+
+func (s *transferFailureTestSuite) TestLocalErrorsAreClassified(c *chk.C) {
+	c.Assert(transferFailureStatus(&os.PathError{Op: "open", Path: "a", Err: syscall.EACCES}), chk.Equals, common.ETransferStatus.LocalIOFailure())
+	c.Assert(transferFailureStatus(syscall.ENOMEM), chk.Equals, common.ETransferStatus.LocalIOFailure())
+	c.Assert(transferFailureStatus(&os.PathError{Op: "write", Path: "a", Err: syscall.ENOSPC}), chk.Equals, common.ETransferStatus.QuotaExceededFailure())
+	c.Assert(transferFailureStatus(errors.New("unknown")), chk.Equals, common.ETransferStatus.Failed())
+}
+
+func (s *transferFailureTestSuite) TestCorruptedChunksAreClassified(c *chk.C) {
+	// the retries of a chunk corrupted on the wire ran out
+	err := transactionalMD5Error{msg: "the range of /container/blob was corrupted on the wire"}
+	c.Assert(transferFailureStatus(err), chk.Equals, common.ETransferStatus.MD5MismatchFailure())
+}
+
+func (s *transferFailureTestSuite) TestServiceErrorsAreClassified(c *chk.C) {
+	c.Assert(serviceFailureStatus(412, "ConditionNotMet"), chk.Equals, common.ETransferStatus.SourceChangedFailure())
+	c.Assert(serviceFailureStatus(412, "LeaseIdMissing"), chk.Equals, common.ETransferStatus.Failed())
+	c.Assert(serviceFailureStatus(412, "LeaseNotPresentWithBlobOperation"), chk.Equals, common.ETransferStatus.Failed())
+	c.Assert(serviceFailureStatus(412, "AppendPositionConditionNotMet"), chk.Equals, common.ETransferStatus.Failed())
+	c.Assert(serviceFailureStatus(412, "MaxBlobSizeConditionNotMet"), chk.Equals, common.ETransferStatus.QuotaExceededFailure())
+	c.Assert(serviceFailureStatus(403, "AuthorizationPermissionMismatch"), chk.Equals, common.ETransferStatus.AuthFailure())
+	c.Assert(serviceFailureStatus(404, "BlobNotFound"), chk.Equals, common.ETransferStatus.NotFoundFailure())
+	c.Assert(serviceFailureStatus(503, "ServerBusy"), chk.Equals, common.ETransferStatus.ThrottledFailure())
+}
+
+func (s *transferFailureTestSuite) TestFirstFailureIsRecorded(c *chk.C) {
+	jppt := &JobPartPlanTransfer{}
+	jppt.SetTransferFailure(common.ETransferStatus.AuthFailure(), 403, "AuthorizationPermissionMismatch")
+	jppt.SetTransferFailure(common.ETransferStatus.NotFoundFailure(), 404, "BlobNotFound")
+
+	c.Assert(jppt.TransferStatus(), chk.Equals, common.ETransferStatus.AuthFailure())
+	errorCode, serviceErrorCode := jppt.ErrorCode()
+	c.Assert(errorCode, chk.Equals, int32(403))
+	c.Assert(serviceErrorCode, chk.Equals, "AuthorizationPermissionMismatch")
+
+	jppt.SetTransferStatus(common.ETransferStatus.Started(), true)
+	jppt.resetTransferFailure()
+	errorCode, serviceErrorCode = jppt.ErrorCode()
+	c.Assert(errorCode, chk.Equals, int32(0))
+	c.Assert(serviceErrorCode, chk.Equals, "")
+}
@@ -90,7 +90,7 @@ func (tbfsc TestBlobFSCommand) verifyRemoteFile() {
 
 	// create the file url and download the file Url
 	fileUrl := azbfs.NewFileURL(*subjectUrl, p)
-	dResp, err := fileUrl.Download(context.Background(), 0, 0)
+	dResp, err := fileUrl.Download(context.Background(), 0, 0, azbfs.FileAccessConditions{})
 	if err != nil {
 		fmt.Println(fmt.Sprintf("error downloading the subject %s. Failed with error %s", fileUrl.String(), err.Error()))
 		os.Exit(1)
@@ -260,7 +260,7 @@ func (tbfsc TestBlobFSCommand) verifyRemoteDir() {
 			tempUrlParts := urlParts
 			tempUrlParts.DirectoryOrFilePath = *file.Name
 			fileUrl := azbfs.NewFileURL(tempUrlParts.URL(), p)
-			fResp, err := fileUrl.Download(context.Background(), 0, 0)
+			fResp, err := fileUrl.Download(context.Background(), 0, 0, azbfs.FileAccessConditions{})
 			if err != nil {
 				fmt.Println(fmt.Sprintf("error downloading the file %s. failed with error %s", fileUrl.String(), err.Error()))
 				os.Exit(1)